@@ -0,0 +1,143 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Azure/azure-container-networking/log"
+
+	cniTypesCurr "github.com/containernetworking/cni/pkg/types/current"
+)
+
+const (
+	// Kind of cached results, versioned like the libcni cached result.
+	cachedResultKind = "azureCniCacheV1"
+)
+
+// CachedResult is the result of a successful ADD command persisted per container and interface.
+// It lets DEL release the resources allocated by ADD even when the plugin state is lost.
+type CachedResult struct {
+	Kind        string               `json:"kind"`
+	ContainerID string               `json:"containerId"`
+	IfName      string               `json:"ifName"`
+	NetworkName string               `json:"networkName"`
+	Config      json.RawMessage      `json:"config,omitempty"`
+	Result      *cniTypesCurr.Result `json:"result,omitempty"`
+	Data        json.RawMessage      `json:"data,omitempty"`
+}
+
+// ResultCache stores cached results as one JSON file per container and interface.
+type ResultCache struct {
+	dir string
+}
+
+// NewResultCache creates a new result cache backed by the given directory.
+func NewResultCache(dir string) *ResultCache {
+	return &ResultCache{dir: dir}
+}
+
+// getFileName returns the cache file name for the given network, container and interface.
+func (cache *ResultCache) getFileName(networkName, containerID, ifName string) string {
+	return filepath.Join(cache.dir, fmt.Sprintf("%s-%s-%s", networkName, containerID, ifName))
+}
+
+// Save persists the given cached result, replacing any previous one.
+func (cache *ResultCache) Save(cachedResult *CachedResult) error {
+	cachedResult.Kind = cachedResultKind
+
+	bytes, err := json.Marshal(cachedResult)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(cache.dir, 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a crash never leaves a truncated cache file.
+	fileName := cache.getFileName(cachedResult.NetworkName, cachedResult.ContainerID, cachedResult.IfName)
+	tmpFileName := fileName + ".tmp"
+	if err = ioutil.WriteFile(tmpFileName, bytes, 0600); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmpFileName, fileName); err != nil {
+		os.Remove(tmpFileName)
+		return err
+	}
+
+	log.Printf("[cni] Cached result for container %v interface %v.", cachedResult.ContainerID, cachedResult.IfName)
+
+	return nil
+}
+
+// Get returns the cached result for the given network, container and interface.
+func (cache *ResultCache) Get(networkName, containerID, ifName string) (*CachedResult, error) {
+	bytes, err := ioutil.ReadFile(cache.getFileName(networkName, containerID, ifName))
+	if err != nil {
+		return nil, err
+	}
+
+	var cachedResult CachedResult
+	if err = json.Unmarshal(bytes, &cachedResult); err != nil {
+		return nil, err
+	}
+
+	if cachedResult.Kind != cachedResultKind {
+		return nil, fmt.Errorf("Unsupported cached result kind %q", cachedResult.Kind)
+	}
+
+	return &cachedResult, nil
+}
+
+// List returns all cached results. Files that cannot be parsed are skipped.
+func (cache *ResultCache) List() ([]*CachedResult, error) {
+	var cachedResults []*CachedResult
+
+	files, err := ioutil.ReadDir(cache.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) == ".tmp" {
+			continue
+		}
+
+		bytes, err := ioutil.ReadFile(filepath.Join(cache.dir, file.Name()))
+		if err != nil {
+			log.Printf("[cni] Failed to read cached result %v: %v", file.Name(), err)
+			continue
+		}
+
+		var cachedResult CachedResult
+		if err = json.Unmarshal(bytes, &cachedResult); err != nil || cachedResult.Kind != cachedResultKind {
+			log.Printf("[cni] Skipping invalid cached result %v: %v", file.Name(), err)
+			continue
+		}
+
+		cachedResults = append(cachedResults, &cachedResult)
+	}
+
+	return cachedResults, nil
+}
+
+// Delete removes the cached result for the given network, container and interface.
+// Deleting a result that does not exist is not an error.
+func (cache *ResultCache) Delete(networkName, containerID, ifName string) error {
+	err := os.Remove(cache.getFileName(networkName, containerID, ifName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
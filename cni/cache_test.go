@@ -0,0 +1,70 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package cni
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	cniTypesCurr "github.com/containernetworking/cni/pkg/types/current"
+)
+
+func TestResultCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azure-vnet-results")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := NewResultCache(dir)
+
+	_, addr, _ := net.ParseCIDR("10.240.0.5/16")
+	cachedResult := &CachedResult{
+		ContainerID: "12345678abcd",
+		IfName:      "eth0",
+		NetworkName: "azure",
+		Config:      []byte(`{"name":"azure"}`),
+		Result: &cniTypesCurr.Result{
+			CNIVersion: "0.3.0",
+			IPs:        []*cniTypesCurr.IPConfig{{Version: "4", Address: *addr}},
+		},
+		Data: []byte(`{"NetworkID":"azure"}`),
+	}
+
+	if err = cache.Save(cachedResult); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	got, err := cache.Get("azure", "12345678abcd", "eth0")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if got.Kind != cachedResultKind || got.ContainerID != cachedResult.ContainerID || string(got.Data) != string(cachedResult.Data) {
+		t.Errorf("Unexpected cached result %+v", got)
+	}
+
+	if len(got.Result.IPs) != 1 || got.Result.IPs[0].Address.String() != addr.String() {
+		t.Errorf("Unexpected cached IPs %+v", got.Result.IPs)
+	}
+
+	if _, err = cache.Get("azure", "12345678abcd", "eth1"); err == nil {
+		t.Errorf("Get should fail for an interface that was not cached")
+	}
+
+	if err = cache.Delete("azure", "12345678abcd", "eth0"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+
+	if _, err = cache.Get("azure", "12345678abcd", "eth0"); !os.IsNotExist(err) {
+		t.Errorf("Expected cached result to be deleted but got %v", err)
+	}
+
+	// Deleting again is not an error, which keeps DEL idempotent.
+	if err = cache.Delete("azure", "12345678abcd", "eth0"); err != nil {
+		t.Errorf("Second delete failed: %v", err)
+	}
+}
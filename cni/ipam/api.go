@@ -0,0 +1,108 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"github.com/Azure/azure-container-networking/ipam"
+)
+
+const (
+	// IPAM daemon remote API paths
+	GetAddressSpacesPath = "/ipam/addressspaces"
+	RequestPoolPath      = "/ipam/pool/request"
+	ReleasePoolPath      = "/ipam/pool/release"
+	GetPoolInfoPath      = "/ipam/pool/info"
+	RequestAddressPath   = "/ipam/address/request"
+	ReleaseAddressPath   = "/ipam/address/release"
+	GetPoolStatusPath    = "/ipam/status"
+	GetConfigPath        = "/ipam/config"
+)
+
+// Response sent by the daemon when returning the default address space names.
+type GetAddressSpacesResponse struct {
+	Err                       string
+	LocalDefaultAddressSpace  string
+	GlobalDefaultAddressSpace string
+}
+
+// Request sent by the client when reserving an address pool.
+type RequestPoolRequest struct {
+	AddressSpace string
+	Pool         string
+	SubPool      string
+	Options      map[string]string
+	V6           bool
+}
+
+// Response sent by the daemon when an address pool is successfully reserved.
+type RequestPoolResponse struct {
+	Err    string
+	PoolID string
+	Subnet string
+}
+
+// Request sent by the client when releasing an address pool.
+type ReleasePoolRequest struct {
+	AddressSpace string
+	PoolID       string
+}
+
+// Response sent by the daemon when an address pool is successfully released.
+type ReleasePoolResponse struct {
+	Err string
+}
+
+// Request sent by the client when querying address pool information.
+type GetPoolInfoRequest struct {
+	AddressSpace string
+	PoolID       string
+}
+
+// Response sent by the daemon when returning address pool information.
+type GetPoolInfoResponse struct {
+	Err  string
+	Info *ipam.AddressPoolInfo
+}
+
+// Request sent by the client when reserving an address.
+type RequestAddressRequest struct {
+	AddressSpace string
+	PoolID       string
+	Address      string
+	Options      map[string]string
+}
+
+// Response sent by the daemon when an address is successfully reserved.
+type RequestAddressResponse struct {
+	Err       string
+	Address   string
+	AddressV6 string
+}
+
+// Request sent by the client when releasing an address.
+type ReleaseAddressRequest struct {
+	AddressSpace string
+	PoolID       string
+	Address      string
+	Options      map[string]string
+}
+
+// Response sent by the daemon when an address is successfully released.
+type ReleaseAddressResponse struct {
+	Err string
+}
+
+// Response sent by the daemon when returning the status of all address pools.
+type GetPoolStatusResponse struct {
+	Err    string
+	Status []*ipam.PoolStatus
+}
+
+// Response sent by the daemon when returning the IPAM configuration it serves requests with.
+type GetConfigResponse struct {
+	Err                       string
+	Environment               string
+	AllocationStrategy        string
+	DuplicateAddressDetection bool
+}
@@ -0,0 +1,422 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/ipam"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Base URL of requests to the IPAM daemon. The host is ignored by the unix socket dialer.
+	daemonURL = "http://unix"
+
+	// Timeouts of requests to the IPAM daemon.
+	daemonDialTimeout    = 1 * time.Second
+	daemonRequestTimeout = 30 * time.Second
+)
+
+// RemoteAddressManager forwards address manager requests to the IPAM daemon.
+// The daemon owns the address source and the state store, so the source and store calls are no-ops.
+// If a request does not reach the daemon, e.g. because it exited, the requests of the invocation are served by an
+// in-process address manager instead.
+type remoteAddressManager struct {
+	socketPath string
+	client     *http.Client
+	// Locks the state store for the in-process address manager, and returns a function that unlocks it.
+	lockStore   func(config *common.PluginConfig) (func(), error)
+	config      *common.PluginConfig
+	options     map[string]interface{}
+	local       ipam.AddressManager
+	unlockStore func()
+}
+
+// DaemonRequestError is the error of a request that did not reach the IPAM daemon or got no response.
+type daemonRequestError struct {
+	path string
+	err  error
+}
+
+func (e *daemonRequestError) Error() string {
+	return fmt.Sprintf("IPAM daemon request %v failed: %v", e.path, e.err)
+}
+
+// Creates a new remote address manager that connects to the IPAM daemon on the given unix socket.
+func newRemoteAddressManager(socketPath string, lockStore func(config *common.PluginConfig) (func(), error)) *remoteAddressManager {
+	dialer := &net.Dialer{Timeout: daemonDialTimeout}
+
+	return &remoteAddressManager{
+		socketPath: socketPath,
+		client: &http.Client{
+			Timeout: daemonRequestTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+		lockStore: lockStore,
+	}
+}
+
+// isDaemonRunning returns whether the IPAM daemon accepts connections on the given unix socket.
+func isDaemonRunning(socketPath string) bool {
+	conn, err := net.DialTimeout("unix", socketPath, daemonDialTimeout)
+	if err != nil {
+		return false
+	}
+
+	conn.Close()
+	return true
+}
+
+// ConnectDaemon switches the plugin to forward requests to the IPAM daemon if it is running.
+// It returns false if the daemon is not running, in which case the plugin manages the state in-process.
+func (plugin *ipamPlugin) ConnectDaemon(socketPath string) bool {
+	if !isDaemonRunning(socketPath) {
+		return false
+	}
+
+	log.Printf("[cni-ipam] Forwarding requests to IPAM daemon on %v.", socketPath)
+	plugin.am = newRemoteAddressManager(socketPath, plugin.lockStore)
+
+	return true
+}
+
+// Locks the state store of the plugin, removing the lock left behind by a daemon that exited.
+// Returns a function that unlocks the store.
+func (plugin *ipamPlugin) lockStore(config *common.PluginConfig) (func(), error) {
+	err := plugin.Plugin.InitializeKeyValueStore(config)
+	if err != nil {
+		if isSafe, _ := plugin.Plugin.IsSafeToRemoveLock(plugin.Plugin.Name); isSafe {
+			log.Printf("[cni-ipam] Removing lock file as process holding lock exited.")
+			if err = plugin.Plugin.UninitializeKeyValueStore(true); err == nil {
+				err = plugin.Plugin.InitializeKeyValueStore(config)
+			}
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := plugin.Plugin.UninitializeKeyValueStore(false); err != nil {
+			log.Printf("[cni-ipam] Failed to unlock store, err:%v.", err)
+		}
+	}, nil
+}
+
+// Sends a request to the daemon and decodes its response.
+func (am *remoteAddressManager) call(path string, request interface{}, response interface{}) error {
+	var body bytes.Buffer
+
+	if request != nil {
+		if err := json.NewEncoder(&body).Encode(request); err != nil {
+			return err
+		}
+	}
+
+	res, err := am.client.Post(daemonURL+path, "application/json", &body)
+	if err != nil {
+		log.Printf("[cni-ipam] IPAM daemon request %v failed, err:%v.", path, err)
+		return &daemonRequestError{path: path, err: err}
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("IPAM daemon request %v failed with status %v", path, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(response)
+}
+
+// Returns the in-process address manager that serves the requests of the invocation after a request did not reach
+// the daemon. Returns nil if the error is not such a failure, or if the in-process address manager cannot start.
+func (am *remoteAddressManager) fallBack(err error) ipam.AddressManager {
+	if _, ok := err.(*daemonRequestError); !ok || am.lockStore == nil || am.config == nil {
+		return nil
+	}
+
+	log.Printf("[cni-ipam] Managing the state in-process since the IPAM daemon is not reachable.")
+
+	unlockStore, err := am.lockStore(am.config)
+	if err != nil {
+		log.Printf("[cni-ipam] Failed to lock store, err:%v.", err)
+		return nil
+	}
+
+	local, err := ipam.NewAddressManager()
+	if err == nil {
+		err = local.Initialize(am.config, am.options)
+	}
+
+	if err != nil {
+		log.Printf("[cni-ipam] Failed to initialize address manager, err:%v.", err)
+		unlockStore()
+		return nil
+	}
+
+	am.local, am.unlockStore = local, unlockStore
+
+	return local
+}
+
+// Logs the IPAM configuration of the invocation that differs from the configuration of the daemon, which the
+// daemon serves all requests with.
+func (am *remoteAddressManager) checkConfig(options map[string]interface{}) error {
+	var resp GetConfigResponse
+
+	if err := am.call(GetConfigPath, nil, &resp); err != nil {
+		return err
+	}
+
+	mismatch := func(name string, value interface{}, daemonValue interface{}) {
+		log.Printf("[cni-ipam] Ignoring %v %v of the network configuration, IPAM daemon uses %v.", name, value, daemonValue)
+	}
+
+	if environment, _ := options[common.OptEnvironment].(string); environment != "" && environment != resp.Environment {
+		mismatch("environment", environment, resp.Environment)
+	}
+
+	if strategy, _ := options[common.OptIpamAllocationStrategy].(string); strategy != "" && strategy != resp.AllocationStrategy {
+		mismatch("allocation strategy", strategy, resp.AllocationStrategy)
+	}
+
+	if dad, _ := options[common.OptIpamDAD].(bool); dad != resp.DuplicateAddressDetection {
+		mismatch("duplicate address detection", dad, resp.DuplicateAddressDetection)
+	}
+
+	return nil
+}
+
+// Returns the error carried in a daemon response.
+func responseError(errStr string) error {
+	if errStr == "" {
+		return nil
+	}
+
+	return fmt.Errorf(errStr)
+}
+
+//
+// AddressManager API
+//
+
+// Initialize keeps the configuration for the in-process address manager, since the daemon owns the state.
+func (am *remoteAddressManager) Initialize(config *common.PluginConfig, options map[string]interface{}) error {
+	am.config = config
+	am.options = options
+	return nil
+}
+
+// Uninitialize stops the in-process address manager, if any, since the daemon owns the state.
+func (am *remoteAddressManager) Uninitialize() {
+	if am.local != nil {
+		am.local.Uninitialize()
+		am.unlockStore()
+		am.local = nil
+	}
+}
+
+// StartSource checks the configuration of the invocation against the daemon, which configures and refreshes its
+// own source.
+func (am *remoteAddressManager) StartSource(options map[string]interface{}) error {
+	am.options = options
+
+	if am.local != nil {
+		return am.local.StartSource(options)
+	}
+
+	if err := am.checkConfig(options); err != nil {
+		if local := am.fallBack(err); local != nil {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// StopSource is a no-op since the daemon configures and refreshes its own source.
+func (am *remoteAddressManager) StopSource() {
+	if am.local != nil {
+		am.local.StopSource()
+	}
+}
+
+// StartBackgroundRefresh is a no-op since the daemon configures and refreshes its own source.
+func (am *remoteAddressManager) StartBackgroundRefresh(interval time.Duration) {
+}
+
+// SubscribePoolChanges returns a channel that never receives, since pool changes are published in the daemon.
+func (am *remoteAddressManager) SubscribePoolChanges() (<-chan *ipam.PoolChangeEvent, func()) {
+	return nil, func() {}
+}
+
+// GetDefaultAddressSpaces returns the default local and global address space IDs.
+func (am *remoteAddressManager) GetDefaultAddressSpaces() (string, string) {
+	if am.local != nil {
+		return am.local.GetDefaultAddressSpaces()
+	}
+
+	var resp GetAddressSpacesResponse
+
+	if err := am.call(GetAddressSpacesPath, nil, &resp); err != nil {
+		if local := am.fallBack(err); local != nil {
+			return local.GetDefaultAddressSpaces()
+		}
+		return "", ""
+	}
+
+	return resp.LocalDefaultAddressSpace, resp.GlobalDefaultAddressSpace
+}
+
+// RequestPool reserves an address pool.
+func (am *remoteAddressManager) RequestPool(asId, poolId, subPoolId string, options map[string]string, v6 bool) (string, string, error) {
+	if am.local != nil {
+		return am.local.RequestPool(asId, poolId, subPoolId, options, v6)
+	}
+
+	var resp RequestPoolResponse
+
+	req := RequestPoolRequest{
+		AddressSpace: asId,
+		Pool:         poolId,
+		SubPool:      subPoolId,
+		Options:      options,
+		V6:           v6,
+	}
+
+	if err := am.call(RequestPoolPath, &req, &resp); err != nil {
+		if local := am.fallBack(err); local != nil {
+			return local.RequestPool(asId, poolId, subPoolId, options, v6)
+		}
+		return "", "", err
+	}
+
+	return resp.PoolID, resp.Subnet, responseError(resp.Err)
+}
+
+// ReleasePool releases a previously reserved address pool.
+func (am *remoteAddressManager) ReleasePool(asId, poolId string) error {
+	if am.local != nil {
+		return am.local.ReleasePool(asId, poolId)
+	}
+
+	var resp ReleasePoolResponse
+
+	req := ReleasePoolRequest{AddressSpace: asId, PoolID: poolId}
+
+	if err := am.call(ReleasePoolPath, &req, &resp); err != nil {
+		if local := am.fallBack(err); local != nil {
+			return local.ReleasePool(asId, poolId)
+		}
+		return err
+	}
+
+	return responseError(resp.Err)
+}
+
+// GetPoolInfo returns information about the given address pool.
+func (am *remoteAddressManager) GetPoolInfo(asId, poolId string) (*ipam.AddressPoolInfo, error) {
+	if am.local != nil {
+		return am.local.GetPoolInfo(asId, poolId)
+	}
+
+	var resp GetPoolInfoResponse
+
+	req := GetPoolInfoRequest{AddressSpace: asId, PoolID: poolId}
+
+	if err := am.call(GetPoolInfoPath, &req, &resp); err != nil {
+		if local := am.fallBack(err); local != nil {
+			return local.GetPoolInfo(asId, poolId)
+		}
+		return nil, err
+	}
+
+	if err := responseError(resp.Err); err != nil {
+		return nil, err
+	}
+
+	return resp.Info, nil
+}
+
+// RequestAddress reserves a new address from the address pool.
+func (am *remoteAddressManager) RequestAddress(asId, poolId, address string, options map[string]string) (string, string, error) {
+	if am.local != nil {
+		return am.local.RequestAddress(asId, poolId, address, options)
+	}
+
+	var resp RequestAddressResponse
+
+	req := RequestAddressRequest{
+		AddressSpace: asId,
+		PoolID:       poolId,
+		Address:      address,
+		Options:      options,
+	}
+
+	if err := am.call(RequestAddressPath, &req, &resp); err != nil {
+		if local := am.fallBack(err); local != nil {
+			return local.RequestAddress(asId, poolId, address, options)
+		}
+		return "", "", err
+	}
+
+	return resp.Address, resp.AddressV6, responseError(resp.Err)
+}
+
+// ReleaseAddress releases a previously reserved address.
+func (am *remoteAddressManager) ReleaseAddress(asId, poolId, address string, options map[string]string) error {
+	if am.local != nil {
+		return am.local.ReleaseAddress(asId, poolId, address, options)
+	}
+
+	var resp ReleaseAddressResponse
+
+	req := ReleaseAddressRequest{
+		AddressSpace: asId,
+		PoolID:       poolId,
+		Address:      address,
+		Options:      options,
+	}
+
+	if err := am.call(ReleaseAddressPath, &req, &resp); err != nil {
+		if local := am.fallBack(err); local != nil {
+			return local.ReleaseAddress(asId, poolId, address, options)
+		}
+		return err
+	}
+
+	return responseError(resp.Err)
+}
+
+// GetPoolStatus returns the status of all address pools.
+func (am *remoteAddressManager) GetPoolStatus() []*ipam.PoolStatus {
+	if am.local != nil {
+		return am.local.GetPoolStatus()
+	}
+
+	var resp GetPoolStatusResponse
+
+	if err := am.call(GetPoolStatusPath, nil, &resp); err != nil {
+		if local := am.fallBack(err); local != nil {
+			return local.GetPoolStatus()
+		}
+		return nil
+	}
+
+	return resp.Status
+}
@@ -0,0 +1,233 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/platform"
+)
+
+const (
+	// Unix socket of the IPAM daemon.
+	DaemonSocketPath = platform.CNIRuntimePath + "azure-vnet-ipam.sock"
+
+	// Default interval at which the daemon refreshes the address source.
+	DefaultDaemonRefreshInterval = 10 * time.Second
+)
+
+// StartDaemon starts serving the address manager of the plugin on the given unix socket.
+// The address source is refreshed in the background instead of on every request.
+func (plugin *ipamPlugin) StartDaemon(socketPath string, refreshInterval time.Duration) error {
+	err := plugin.am.StartSource(plugin.Options)
+	if err != nil {
+		log.Printf("[cni-ipam] Failed to start address source, err:%v.", err)
+		return err
+	}
+
+	plugin.am.StartBackgroundRefresh(refreshInterval)
+
+	// Remove the socket left behind by a daemon that did not exit cleanly.
+	// Holding the store lock guarantees that no other daemon is running.
+	if err = os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	plugin.listener, err = common.NewListener(&url.URL{Scheme: "unix", Path: socketPath})
+	if err != nil {
+		return err
+	}
+
+	plugin.listener.AddHandler(GetAddressSpacesPath, plugin.handleGetAddressSpaces)
+	plugin.listener.AddHandler(RequestPoolPath, plugin.handleRequestPool)
+	plugin.listener.AddHandler(ReleasePoolPath, plugin.handleReleasePool)
+	plugin.listener.AddHandler(GetPoolInfoPath, plugin.handleGetPoolInfo)
+	plugin.listener.AddHandler(RequestAddressPath, plugin.handleRequestAddress)
+	plugin.listener.AddHandler(ReleaseAddressPath, plugin.handleReleaseAddress)
+	plugin.listener.AddHandler(GetPoolStatusPath, plugin.handleGetPoolStatus)
+	plugin.listener.AddHandler(GetConfigPath, plugin.handleGetConfig)
+
+	plugin.daemonErrChan = make(chan error, 1)
+	err = plugin.listener.Start(plugin.daemonErrChan)
+	if err != nil {
+		log.Printf("[cni-ipam] Failed to start daemon listener, err:%v.", err)
+		return err
+	}
+
+	log.Printf("[cni-ipam] Daemon started on %v.", socketPath)
+
+	return nil
+}
+
+// StopDaemon stops serving requests and stops the address source.
+func (plugin *ipamPlugin) StopDaemon() {
+	if plugin.listener != nil {
+		plugin.listener.Stop()
+		plugin.listener = nil
+	}
+
+	plugin.am.StopSource()
+
+	log.Printf("[cni-ipam] Daemon stopped.")
+}
+
+// DaemonErrors returns the channel on which the daemon listener reports a fatal error.
+func (plugin *ipamPlugin) DaemonErrors() <-chan error {
+	return plugin.daemonErrChan
+}
+
+//
+// IPAM daemon remote API implementation
+//
+
+// Handles GetAddressSpaces requests.
+func (plugin *ipamPlugin) handleGetAddressSpaces(w http.ResponseWriter, r *http.Request) {
+	log.Request(plugin.Name, r.URL.Path, nil)
+
+	localId, globalId := plugin.am.GetDefaultAddressSpaces()
+
+	resp := GetAddressSpacesResponse{
+		LocalDefaultAddressSpace:  localId,
+		GlobalDefaultAddressSpace: globalId,
+	}
+
+	err := plugin.listener.Encode(w, &resp)
+
+	log.Response(plugin.Name, &resp, 0, "Success", err)
+}
+
+// Handles RequestPool requests.
+func (plugin *ipamPlugin) handleRequestPool(w http.ResponseWriter, r *http.Request) {
+	var req RequestPoolRequest
+	var resp RequestPoolResponse
+
+	err := plugin.listener.Decode(w, r, &req)
+	log.Request(plugin.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	resp.PoolID, resp.Subnet, err = plugin.am.RequestPool(req.AddressSpace, req.Pool, req.SubPool, req.Options, req.V6)
+	if err != nil {
+		resp.Err = err.Error()
+	}
+
+	err = plugin.listener.Encode(w, &resp)
+
+	log.Response(plugin.Name, &resp, 0, "Success", err)
+}
+
+// Handles ReleasePool requests.
+func (plugin *ipamPlugin) handleReleasePool(w http.ResponseWriter, r *http.Request) {
+	var req ReleasePoolRequest
+	var resp ReleasePoolResponse
+
+	err := plugin.listener.Decode(w, r, &req)
+	log.Request(plugin.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	err = plugin.am.ReleasePool(req.AddressSpace, req.PoolID)
+	if err != nil {
+		resp.Err = err.Error()
+	}
+
+	err = plugin.listener.Encode(w, &resp)
+
+	log.Response(plugin.Name, &resp, 0, "Success", err)
+}
+
+// Handles GetPoolInfo requests.
+func (plugin *ipamPlugin) handleGetPoolInfo(w http.ResponseWriter, r *http.Request) {
+	var req GetPoolInfoRequest
+	var resp GetPoolInfoResponse
+
+	err := plugin.listener.Decode(w, r, &req)
+	log.Request(plugin.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	resp.Info, err = plugin.am.GetPoolInfo(req.AddressSpace, req.PoolID)
+	if err != nil {
+		resp.Err = err.Error()
+	}
+
+	err = plugin.listener.Encode(w, &resp)
+
+	log.Response(plugin.Name, &resp, 0, "Success", err)
+}
+
+// Handles RequestAddress requests.
+func (plugin *ipamPlugin) handleRequestAddress(w http.ResponseWriter, r *http.Request) {
+	var req RequestAddressRequest
+	var resp RequestAddressResponse
+
+	err := plugin.listener.Decode(w, r, &req)
+	log.Request(plugin.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	resp.Address, resp.AddressV6, err = plugin.am.RequestAddress(req.AddressSpace, req.PoolID, req.Address, req.Options)
+	if err != nil {
+		resp.Err = err.Error()
+	}
+
+	err = plugin.listener.Encode(w, &resp)
+
+	log.Response(plugin.Name, &resp, 0, "Success", err)
+}
+
+// Handles ReleaseAddress requests.
+func (plugin *ipamPlugin) handleReleaseAddress(w http.ResponseWriter, r *http.Request) {
+	var req ReleaseAddressRequest
+	var resp ReleaseAddressResponse
+
+	err := plugin.listener.Decode(w, r, &req)
+	log.Request(plugin.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	err = plugin.am.ReleaseAddress(req.AddressSpace, req.PoolID, req.Address, req.Options)
+	if err != nil {
+		resp.Err = err.Error()
+	}
+
+	err = plugin.listener.Encode(w, &resp)
+
+	log.Response(plugin.Name, &resp, 0, "Success", err)
+}
+
+// Handles GetPoolStatus requests.
+func (plugin *ipamPlugin) handleGetPoolStatus(w http.ResponseWriter, r *http.Request) {
+	log.Request(plugin.Name, r.URL.Path, nil)
+
+	resp := GetPoolStatusResponse{Status: plugin.am.GetPoolStatus()}
+
+	err := plugin.listener.Encode(w, &resp)
+
+	log.Response(plugin.Name, r.URL.Path, 0, "Success", err)
+}
+
+// Handles GetConfig requests.
+func (plugin *ipamPlugin) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	log.Request(plugin.Name, r.URL.Path, nil)
+
+	resp := GetConfigResponse{}
+	resp.Environment, _ = plugin.GetOption(common.OptEnvironment).(string)
+	resp.AllocationStrategy, _ = plugin.GetOption(common.OptIpamAllocationStrategy).(string)
+	resp.DuplicateAddressDetection, _ = plugin.GetOption(common.OptIpamDAD).(bool)
+
+	err := plugin.listener.Encode(w, &resp)
+
+	log.Response(plugin.Name, &resp, 0, "Success", err)
+}
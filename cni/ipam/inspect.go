@@ -0,0 +1,109 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/ipam"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Output formats of the inspect command.
+	InspectFormatTable = "table"
+	InspectFormatJSON  = "json"
+)
+
+// Inspect writes the status of all address pools and the owners of their addresses in the given format.
+// If an environment is given, pools are refreshed from its address source first to detect unhealthy addresses.
+func (plugin *ipamPlugin) Inspect(w io.Writer, environment string, format string) error {
+	if format != InspectFormatTable && format != InspectFormatJSON {
+		return fmt.Errorf("Invalid output format %v", format)
+	}
+
+	if environment != "" {
+		plugin.SetOption(common.OptEnvironment, environment)
+		if err := plugin.am.StartSource(plugin.Options); err != nil {
+			log.Printf("[cni-ipam] Failed to start address source, showing persisted state only, err:%v.", err)
+		}
+	}
+
+	status := plugin.am.GetPoolStatus()
+
+	if format == InspectFormatJSON {
+		return writePoolStatusJSON(w, status)
+	}
+
+	return writePoolStatusTable(w, status)
+}
+
+// writePoolStatusJSON writes the pool status as indented JSON.
+func writePoolStatusJSON(w io.Writer, status []*ipam.PoolStatus) error {
+	if status == nil {
+		status = []*ipam.PoolStatus{}
+	}
+
+	buf, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", buf)
+	return err
+}
+
+// writePoolStatusTable writes a summary table of pools followed by a table of allocated and unhealthy addresses.
+func writePoolStatusTable(w io.Writer, status []*ipam.PoolStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "ADDRESS SPACE\tPOOL\tINTERFACE\tREFCOUNT\tCAPACITY\tIN USE\tUNHEALTHY")
+	for _, pool := range status {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\n",
+			pool.AddressSpace, pool.Subnet, pool.IfName, pool.RefCount, pool.Capacity, pool.InUse, pool.Unhealthy)
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "ADDRESS\tPOOL\tSTATE\tCONTAINER\tNETNS\tIFNAME\tPOD\tALLOCATED")
+	for _, pool := range status {
+		for _, addr := range pool.Addresses {
+			state := "in-use"
+			if addr.Unhealthy {
+				state = "unhealthy"
+			}
+
+			owner := addr.ID
+			var netns, ifName, pod, allocated string
+			if addr.Lease != nil {
+				if addr.Lease.ContainerID != "" {
+					owner = addr.Lease.ContainerID
+				}
+				netns = addr.Lease.NetNs
+				ifName = addr.Lease.IfName
+				if addr.Lease.PodName != "" {
+					pod = addr.Lease.PodNamespace + "/" + addr.Lease.PodName
+				}
+				allocated = addr.Lease.AllocatedAt.Format(time.RFC3339)
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				addr.Address, pool.Subnet, state, orNone(owner), orNone(netns), orNone(ifName), orNone(pod), orNone(allocated))
+		}
+	}
+
+	return tw.Flush()
+}
+
+// orNone returns a placeholder for empty table cells.
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
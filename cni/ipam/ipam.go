@@ -12,6 +12,7 @@ import (
 	"github.com/Azure/azure-container-networking/common"
 	"github.com/Azure/azure-container-networking/ipam"
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/network"
 	"github.com/Azure/azure-container-networking/platform"
 
 	cniSkel "github.com/containernetworking/cni/pkg/skel"
@@ -31,6 +32,10 @@ var (
 type ipamPlugin struct {
 	*cni.Plugin
 	am ipam.AddressManager
+
+	// Listener and fatal error channel of the daemon mode.
+	listener      *common.Listener
+	daemonErrChan chan error
 }
 
 // NewPlugin creates a new ipamPlugin object.
@@ -114,6 +119,24 @@ func (plugin *ipamPlugin) Configure(stdinData []byte) (*cni.NetworkConfig, error
 		plugin.SetOption(common.OptIpamQueryInterval, i)
 	}
 
+	// Set address allocation strategy.
+	if nwCfg.Ipam.AllocationStrategy != "" {
+		plugin.SetOption(common.OptIpamAllocationStrategy, nwCfg.Ipam.AllocationStrategy)
+	}
+
+	if nwCfg.Ipam.QuarantinePeriod != "" {
+		i, _ := strconv.Atoi(nwCfg.Ipam.QuarantinePeriod)
+		plugin.SetOption(common.OptIpamQuarantinePeriod, i)
+	}
+
+	// Set duplicate address detection.
+	plugin.SetOption(common.OptIpamDAD, nwCfg.Ipam.DuplicateAddressDetection)
+
+	// Set range configuration file.
+	if nwCfg.Ipam.RangeFile != "" {
+		plugin.SetOption(common.OptIpamRangeFile, nwCfg.Ipam.RangeFile)
+	}
+
 	err = plugin.am.StartSource(plugin.Options)
 	if err != nil {
 		return nil, err
@@ -163,6 +186,11 @@ func (plugin *ipamPlugin) Add(args *cniSkel.CmdArgs) error {
 			isIpv6 = true
 		}
 
+		// Reserve an IPv6 pool along with the IPv4 pool for dual-stack networks.
+		if nwCfg.IPV6Mode == network.IPV6DualStack && !isIpv6 {
+			options[ipam.OptDualStack] = "true"
+		}
+
 		// Allocate an address pool.
 		poolID, subnet, err = plugin.am.RequestPool(nwCfg.Ipam.AddrSpace, "", "", options, isIpv6)
 		if err != nil {
@@ -183,18 +211,23 @@ func (plugin *ipamPlugin) Add(args *cniSkel.CmdArgs) error {
 	}
 
 	// Allocate an address for the endpoint.
-	address, err := plugin.am.RequestAddress(nwCfg.Ipam.AddrSpace, nwCfg.Ipam.Subnet, nwCfg.Ipam.Address, nil)
+	address, addressV6, err := plugin.am.RequestAddress(nwCfg.Ipam.AddrSpace, nwCfg.Ipam.Subnet, nwCfg.Ipam.Address, getLeaseOptions(args))
 	if err != nil {
 		err = plugin.Errorf("Failed to allocate address: %v", err)
 		return err
 	}
 
-	// On failure, release the address.
+	// On failure, release the addresses.
 	defer func() {
 		if err != nil && address != "" {
 			log.Printf("[cni-ipam] Releasing address %v.", address)
 			plugin.am.ReleaseAddress(nwCfg.Ipam.AddrSpace, nwCfg.Ipam.Subnet, address, nil)
 		}
+
+		if err != nil && addressV6 != "" {
+			log.Printf("[cni-ipam] Releasing address %v.", addressV6)
+			plugin.releaseAddress(nwCfg.Ipam.AddrSpace, addressV6)
+		}
 	}()
 
 	log.Printf("[cni-ipam] Allocated address %v.", address)
@@ -235,6 +268,20 @@ func (plugin *ipamPlugin) Add(args *cniSkel.CmdArgs) error {
 		},
 	}
 
+	// Add the IPv6 address of a dual-stack pool. Its routes are set up natively by the network plugin.
+	if addressV6 != "" {
+		log.Printf("[cni-ipam] Allocated address %v.", addressV6)
+
+		var ipConfigV6 *cniTypesCurr.IPConfig
+		ipConfigV6, err = plugin.getIPConfig(nwCfg.Ipam.AddrSpace, addressV6)
+		if err != nil {
+			err = plugin.Errorf("Failed to get IPv6 address configuration: %v", err)
+			return err
+		}
+
+		result.IPs = append(result.IPs, ipConfigV6)
+	}
+
 	// Populate DNS servers.
 	for _, dnsServer := range apInfo.DnsServers {
 		result.DNS.Nameservers = append(result.DNS.Nameservers, dnsServer.String())
@@ -259,6 +306,59 @@ func (plugin *ipamPlugin) Add(args *cniSkel.CmdArgs) error {
 	return nil
 }
 
+// getLeaseOptions returns the address request options recording the owner of the lease.
+func getLeaseOptions(args *cniSkel.CmdArgs) map[string]string {
+	options := map[string]string{
+		ipam.OptLeaseContainerID: args.ContainerID,
+		ipam.OptLeaseNetNs:       args.Netns,
+		ipam.OptLeaseIfName:      args.IfName,
+	}
+
+	// Pod information is only available when invoked by Kubernetes.
+	if podCfg, err := cni.ParseCniArgs(args.Args); err == nil {
+		options[ipam.OptLeasePodName] = string(podCfg.K8S_POD_NAME)
+		options[ipam.OptLeasePodNamespace] = string(podCfg.K8S_POD_NAMESPACE)
+	}
+
+	return options
+}
+
+// getIPConfig returns the result IP configuration of an address in CIDR notation.
+// The pool of the address is the subnet it belongs to.
+func (plugin *ipamPlugin) getIPConfig(asId, address string) (*cniTypesCurr.IPConfig, error) {
+	ipAddress, err := platform.ConvertStringToIPNet(address)
+	if err != nil {
+		return nil, err
+	}
+
+	subnet := net.IPNet{IP: ipAddress.IP.Mask(ipAddress.Mask), Mask: ipAddress.Mask}
+	apInfo, err := plugin.am.GetPoolInfo(asId, subnet.String())
+	if err != nil {
+		return nil, err
+	}
+
+	version := "4"
+	if ipAddress.IP.To4() == nil {
+		version = "6"
+	}
+
+	return &cniTypesCurr.IPConfig{
+		Version: version,
+		Address: *ipAddress,
+		Gateway: apInfo.Gateway,
+	}, nil
+}
+
+// releaseAddress releases an address in CIDR notation back to the pool of its subnet.
+func (plugin *ipamPlugin) releaseAddress(asId, address string) error {
+	ip, subnet, err := net.ParseCIDR(address)
+	if err != nil {
+		return err
+	}
+
+	return plugin.am.ReleaseAddress(asId, subnet.String(), ip.String(), nil)
+}
+
 // Get handles CNI Get commands.
 func (plugin *ipamPlugin) Get(args *cniSkel.CmdArgs) error {
 	return nil
@@ -4,11 +4,15 @@
 package ipam
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	cniSkel "github.com/containernetworking/cni/pkg/skel"
 	cniTypesCurr "github.com/containernetworking/cni/pkg/types/current"
@@ -16,6 +20,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/ipam"
 	"github.com/Azure/azure-container-networking/platform"
 )
 
@@ -80,7 +85,12 @@ var (
 		err = testAgent.Start(make(chan error, 1))
 		Expect(err).NotTo(HaveOccurred())
 
-		arg = &cniSkel.CmdArgs{}
+		arg = &cniSkel.CmdArgs{
+			ContainerID: "container1",
+			Netns:       "/var/run/netns/ns1",
+			IfName:      "eth0",
+			Args:        "K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod1",
+		}
 	})
 
 	_ = AfterSuite(func() {
@@ -176,6 +186,43 @@ var (
 			})
 		})
 
+		Describe("Test IPAM inspect", func() {
+
+			Context("When addresses are allocated", func() {
+				It("Print the owners as JSON", func() {
+					var buf bytes.Buffer
+					err = plugin.Inspect(&buf, "", InspectFormatJSON)
+					Expect(err).ShouldNot(HaveOccurred())
+
+					var status []*ipam.PoolStatus
+					err = json.Unmarshal(buf.Bytes(), &status)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(status).To(HaveLen(1))
+					Expect(status[0].Subnet).To(Equal("10.0.0.0/16"))
+					Expect(status[0].InUse).To(Equal(2))
+					Expect(status[0].Addresses).To(HaveLen(2))
+					Expect(status[0].Addresses[0].Lease.ContainerID).To(Equal("container1"))
+					Expect(status[0].Addresses[0].Lease.PodName).To(Equal("pod1"))
+					Expect(status[0].Addresses[0].Lease.PodNamespace).To(Equal("default"))
+				})
+
+				It("Print the owners as a table", func() {
+					var buf bytes.Buffer
+					err = plugin.Inspect(&buf, "", InspectFormatTable)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(buf.String()).To(ContainSubstring("10.0.0.5"))
+					Expect(buf.String()).To(ContainSubstring("default/pod1"))
+				})
+			})
+
+			Context("When the format is invalid", func() {
+				It("Fail to inspect", func() {
+					err = plugin.Inspect(&bytes.Buffer{}, "", "yaml")
+					Expect(err).Should(HaveOccurred())
+				})
+			})
+		})
+
 		Describe("Test IPAM DELETE", func() {
 
 			Context("When address and subnet is given", func() {
@@ -202,5 +249,101 @@ var (
 				})
 			})
 		})
+
+		Describe("Test IPAM daemon", func() {
+
+			var (
+				config       common.PluginConfig
+				clientConfig common.PluginConfig
+				daemonPlugin *ipamPlugin
+				clientPlugin *ipamPlugin
+				socketPath   = filepath.Join(os.TempDir(), "azure-vnet-ipam-test.sock")
+			)
+
+			Context("When the daemon is not running", func() {
+				It("Manage the state in-process", func() {
+					clientPlugin, err = NewPlugin("ipamclienttest", &clientConfig)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(clientPlugin.ConnectDaemon(socketPath)).To(BeFalse())
+				})
+			})
+
+			Context("When the daemon is running", func() {
+				It("Start the daemon", func() {
+					daemonPlugin, err = NewPlugin("ipamdaemontest", &config)
+					Expect(err).NotTo(HaveOccurred())
+
+					daemonPlugin.SetOption(common.OptEnvironment, common.OptEnvironmentAzure)
+					daemonPlugin.SetOption(common.OptIpamQueryUrl, "http://"+ipamQueryUrl)
+					err = daemonPlugin.Start(&config)
+					Expect(err).NotTo(HaveOccurred())
+
+					err = daemonPlugin.StartDaemon(socketPath, time.Minute)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("Forward ADD and DELETE to the daemon", func() {
+					Expect(clientPlugin.ConnectDaemon(socketPath)).To(BeTrue())
+					err = clientPlugin.Start(&clientConfig)
+					Expect(err).NotTo(HaveOccurred())
+
+					arg.StdinData = getStdinData("0.4.0", "", "10.0.0.5")
+					err = clientPlugin.Add(arg)
+					Expect(err).ShouldNot(HaveOccurred())
+					result, err := parseResult(arg.StdinData)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(result.IPs[0].Address.String()).To(Equal("10.0.0.5/16"))
+
+					status := daemonPlugin.am.GetPoolStatus()
+					Expect(status).To(HaveLen(1))
+					Expect(status[0].InUse).To(Equal(1))
+
+					arg.StdinData = getStdinData("0.4.0", "10.0.0.0/16", "10.0.0.5")
+					err = clientPlugin.Delete(arg)
+					Expect(err).ShouldNot(HaveOccurred())
+
+					arg.StdinData = getStdinData("0.4.0", "10.0.0.0/16", "")
+					err = clientPlugin.Delete(arg)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(daemonPlugin.am.GetPoolStatus()[0].RefCount).To(Equal(0))
+				})
+
+				It("Return errors of the daemon", func() {
+					arg.StdinData = getStdinData("0.4.0", "10.1.0.0/16", "")
+					err = clientPlugin.Add(arg)
+					Expect(err).Should(HaveOccurred())
+				})
+
+				It("Manage the state in-process when the daemon exits", func() {
+					daemonPlugin.StopDaemon()
+					daemonPlugin.Stop()
+
+					_, err = os.Stat(socketPath)
+					Expect(os.IsNotExist(err)).To(BeTrue())
+
+					// The connections to the daemon close when its process exits.
+					clientPlugin.am.(*remoteAddressManager).client.CloseIdleConnections()
+					clientPlugin.SetOption(common.OptIpamQueryUrl, "http://"+ipamQueryUrl)
+
+					arg.StdinData = getStdinData("0.4.0", "", "10.0.0.6")
+					err = clientPlugin.Add(arg)
+					Expect(err).ShouldNot(HaveOccurred())
+					result, err := parseResult(arg.StdinData)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(result.IPs[0].Address.String()).To(Equal("10.0.0.6/16"))
+					Expect(clientPlugin.am.(*remoteAddressManager).local).NotTo(BeNil())
+
+					arg.StdinData = getStdinData("0.4.0", "10.0.0.0/16", "10.0.0.6")
+					err = clientPlugin.Delete(arg)
+					Expect(err).ShouldNot(HaveOccurred())
+				})
+
+				It("Stop the client", func() {
+					clientPlugin.Stop()
+					Expect(clientPlugin.am.(*remoteAddressManager).local).To(BeNil())
+					os.Remove(platform.CNIRuntimePath + "ipamclienttest.json")
+				})
+			})
+		})
 	})
 )
@@ -4,8 +4,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/Azure/azure-container-networking/cni"
 	"github.com/Azure/azure-container-networking/cni/ipam"
@@ -14,7 +19,9 @@ import (
 )
 
 const (
-	name = "azure-vnet-ipam"
+	name           = "azure-vnet-ipam"
+	inspectCommand = "inspect"
+	daemonCommand  = "daemon"
 )
 
 // Version is populated by make during build.
@@ -26,6 +33,11 @@ func main() {
 	config.Version = version
 	logDirectory := "" // Sets the current location as log directory
 
+	var command string
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
 	log.SetName(name)
 	log.SetLevel(log.LevelInfo)
 	if err := log.SetTargetLogDirectory(log.TargetLogfile, logDirectory); err != nil {
@@ -41,28 +53,33 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := ipamPlugin.Plugin.InitializeKeyValueStore(&config); err != nil {
-		fmt.Printf("Failed to initialize key-value store of ipam plugin, err:%v.\n", err)
-
-		if isSafe, _ := ipamPlugin.Plugin.IsSafeToRemoveLock(ipamPlugin.Plugin.Name); isSafe {
-			log.Printf("[IPAM] Removing lock file as process holding lock exited")
-			if errUninit := ipamPlugin.Plugin.UninitializeKeyValueStore(true); errUninit != nil {
-				log.Errorf("Failed to uninitialize key-value store of network plugin, err:%v.\n", errUninit)
-			}
+	defer func() {
+		if recover() != nil {
+			os.Exit(1)
 		}
+	}()
 
-		os.Exit(1)
-	}
+	// Forward requests to the IPAM daemon if it is running. Otherwise, manage the state in-process.
+	if command == daemonCommand || !ipamPlugin.ConnectDaemon(ipam.DaemonSocketPath) {
+		if err := ipamPlugin.Plugin.InitializeKeyValueStore(&config); err != nil {
+			fmt.Printf("Failed to initialize key-value store of ipam plugin, err:%v.\n", err)
 
-	defer func() {
-		if errUninit := ipamPlugin.Plugin.UninitializeKeyValueStore(false); errUninit != nil {
-			fmt.Printf("Failed to uninitialize key-value store of ipam plugin, err:%v.\n", err)
-		}
+			if isSafe, _ := ipamPlugin.Plugin.IsSafeToRemoveLock(ipamPlugin.Plugin.Name); isSafe {
+				log.Printf("[IPAM] Removing lock file as process holding lock exited")
+				if errUninit := ipamPlugin.Plugin.UninitializeKeyValueStore(true); errUninit != nil {
+					log.Errorf("Failed to uninitialize key-value store of network plugin, err:%v.\n", errUninit)
+				}
+			}
 
-		if recover() != nil {
 			os.Exit(1)
 		}
-	}()
+
+		defer func() {
+			if errUninit := ipamPlugin.Plugin.UninitializeKeyValueStore(false); errUninit != nil {
+				fmt.Printf("Failed to uninitialize key-value store of ipam plugin, err:%v.\n", err)
+			}
+		}()
+	}
 
 	err = ipamPlugin.Start(&config)
 	if err != nil {
@@ -70,7 +87,22 @@ func main() {
 		panic("ipam plugin fatal error")
 	}
 
-	err = ipamPlugin.Execute(cni.PluginApi(ipamPlugin))
+	switch command {
+	case inspectCommand:
+		err = inspect(ipamPlugin, os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to inspect IPAM state, err:%v.\n", err)
+		}
+
+	case daemonCommand:
+		err = runDaemon(ipamPlugin, os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "IPAM daemon failed, err:%v.\n", err)
+		}
+
+	default:
+		err = ipamPlugin.Execute(cni.PluginApi(ipamPlugin))
+	}
 
 	ipamPlugin.Stop()
 
@@ -78,3 +110,68 @@ func main() {
 		panic("ipam plugin fatal error")
 	}
 }
+
+// ipamCommands are the plugin operations used by the commands other than the CNI commands.
+type ipamCommands interface {
+	SetOption(key string, value interface{})
+	Inspect(w io.Writer, environment string, format string) error
+	StartDaemon(socketPath string, refreshInterval time.Duration) error
+	StopDaemon()
+	DaemonErrors() <-chan error
+}
+
+// inspect prints the address pools and leases instead of processing a CNI command.
+func inspect(ipamPlugin ipamCommands, args []string) error {
+	flags := flag.NewFlagSet(name+" "+inspectCommand, flag.ContinueOnError)
+	format := flags.String("o", ipam.InspectFormatTable, "Output format {table,json}")
+	environment := flags.String("e", "", "Refresh pools from the address source of the environment {azure,mas} to detect unhealthy addresses")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return ipamPlugin.Inspect(os.Stdout, *environment, *format)
+}
+
+// runDaemon serves IPAM requests of CNI invocations on a unix socket until it is signaled to exit.
+func runDaemon(ipamPlugin ipamCommands, args []string) error {
+	flags := flag.NewFlagSet(name+" "+daemonCommand, flag.ContinueOnError)
+	environment := flags.String("e", common.OptEnvironmentAzure, "Set the operating environment {azure,imds,mas,rangeIpam}")
+	refreshInterval := flags.Duration("i", ipam.DefaultDaemonRefreshInterval, "Set the address source refresh interval")
+	strategy := flags.String("s", common.OptIpamAllocationLRR, "Set the address allocation strategy {least-recently-released,sequential}")
+	quarantinePeriod := flags.Int("q", 0, "Set the number of seconds a released address is not reused")
+	dad := flags.Bool("dad", false, "Enable duplicate address detection before handing out addresses")
+	rangeFile := flags.String("range-file", "", "Set the range configuration file of the rangeIpam environment")
+	socketPath := flags.String("socket", ipam.DaemonSocketPath, "Set the unix socket to serve requests on")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ipamPlugin.SetOption(common.OptEnvironment, *environment)
+	ipamPlugin.SetOption(common.OptIpamQueryInterval, int(refreshInterval.Seconds()))
+	ipamPlugin.SetOption(common.OptIpamAllocationStrategy, *strategy)
+	ipamPlugin.SetOption(common.OptIpamQuarantinePeriod, *quarantinePeriod)
+	ipamPlugin.SetOption(common.OptIpamDAD, *dad)
+	ipamPlugin.SetOption(common.OptIpamRangeFile, *rangeFile)
+
+	if err := ipamPlugin.StartDaemon(*socketPath, *refreshInterval); err != nil {
+		return err
+	}
+
+	defer ipamPlugin.StopDaemon()
+
+	// Relay these incoming signals to OS signal channel.
+	osSignalChannel := make(chan os.Signal, 1)
+	signal.Notify(osSignalChannel, os.Interrupt, os.Kill, syscall.SIGTERM)
+
+	// Wait until receiving a signal.
+	select {
+	case sig := <-osSignalChannel:
+		log.Printf("[cni-ipam] Received OS signal <" + sig.String() + ">, shutting down.")
+		return nil
+	case err := <-ipamPlugin.DaemonErrors():
+		log.Printf("[cni-ipam] Received unexpected error %v, shutting down.", err)
+		return err
+	}
+}
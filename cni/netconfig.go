@@ -5,15 +5,22 @@ package cni
 
 import (
 	"encoding/json"
+	"fmt"
 	"strings"
 
 	"github.com/Azure/azure-container-networking/network/policy"
 
 	cniTypes "github.com/containernetworking/cni/pkg/types"
+	cniTypesCurr "github.com/containernetworking/cni/pkg/types/current"
+	cniVers "github.com/containernetworking/cni/pkg/version"
 )
 
 const (
 	PolicyStr string = "Policy"
+
+	// Well-known capabilities passed to post-plugins through runtimeConfig.
+	capabilityPortMappings = "portMappings"
+	capabilityDNS          = "dns"
 )
 
 // KVPair represents a K-V pair of a json object.
@@ -40,6 +47,24 @@ type RuntimeDNSConfig struct {
 	Options  []string `json:"options,omitempty"`
 }
 
+// OutboundNATConfig describes how traffic leaving pods is translated on Linux.
+type OutboundNATConfig struct {
+	// Destinations reached without NAT, for example peered VNets.
+	NonMasqueradeCIDRs []string `json:"nonMasqueradeCIDRs,omitempty"`
+	// Fixed source address to SNAT to. Traffic is masqueraded if empty.
+	SnatIP             string                       `json:"snatIP,omitempty"`
+	NamespaceOverrides []OutboundNATNamespaceConfig `json:"namespaceOverrides,omitempty"`
+}
+
+// OutboundNATNamespaceConfig overrides the outbound NAT of the pods in a namespace.
+// Its non-masquerade CIDRs are added to the network ones and its SNAT IP replaces the network one.
+type OutboundNATNamespaceConfig struct {
+	Namespace          string   `json:"namespace"`
+	NonMasqueradeCIDRs []string `json:"nonMasqueradeCIDRs,omitempty"`
+	SnatIP             string   `json:"snatIP,omitempty"`
+	DisableSnat        bool     `json:"disableSnat,omitempty"`
+}
+
 // NetworkConfig represents Azure CNI plugin network configuration.
 type NetworkConfig struct {
 	CNIVersion                    string   `json:"cniVersion"`
@@ -62,6 +87,7 @@ type NetworkConfig struct {
 	DisableHairpinOnHostInterface bool     `json:"disableHairpinOnHostInterface,omitempty"`
 	DisableIPTableLock            bool     `json:"disableIPTableLock,omitempty"`
 	CNSUrl                        string   `json:"cnsurl,omitempty"`
+	NCProgrammedTimeout           int      `json:"ncProgrammedTimeout,omitempty"`
 	Ipam                          struct {
 		Type          string `json:"type"`
 		Environment   string `json:"environment,omitempty"`
@@ -69,10 +95,22 @@ type NetworkConfig struct {
 		Subnet        string `json:"subnet,omitempty"`
 		Address       string `json:"ipAddress,omitempty"`
 		QueryInterval string `json:"queryInterval,omitempty"`
+		// Address allocation strategy and quarantine period of released addresses in seconds.
+		AllocationStrategy string `json:"allocationStrategy,omitempty"`
+		QuarantinePeriod   string `json:"quarantinePeriod,omitempty"`
+		// Probe addresses on the master interface before handing them out.
+		DuplicateAddressDetection bool `json:"duplicateAddressDetection,omitempty"`
+		// Range configuration file of the rangeIpam environment.
+		RangeFile string `json:"rangeFile,omitempty"`
 	}
-	DNS            cniTypes.DNS  `json:"dns"`
-	RuntimeConfig  RuntimeConfig `json:"runtimeConfig"`
+	DNS            cniTypes.DNS       `json:"dns"`
+	RuntimeConfig  RuntimeConfig      `json:"runtimeConfig"`
+	OutboundNAT    *OutboundNATConfig `json:"outboundNAT,omitempty"`
 	AdditionalArgs []KVPair
+	// PostPlugins are plugin configurations invoked by azure-vnet after its own ADD, in order.
+	PostPlugins   []json.RawMessage    `json:"postPlugins,omitempty"`
+	RawPrevResult *json.RawMessage     `json:"prevResult,omitempty"`
+	PrevResult    *cniTypesCurr.Result `json:"-"`
 }
 
 type K8SPodEnvArgs struct {
@@ -106,9 +144,81 @@ func ParseNetworkConfig(b []byte) (*NetworkConfig, error) {
 		nwCfg.CNIVersion = defaultVersion
 	}
 
+	// Parse the result of the previous plugin in the chain, if any.
+	if nwCfg.RawPrevResult != nil {
+		nwCfg.PrevResult, err = parsePrevResult(nwCfg.CNIVersion, *nwCfg.RawPrevResult)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &nwCfg, nil
 }
 
+// parsePrevResult converts a prevResult of the given CNI version to the current result type.
+func parsePrevResult(version string, rawPrevResult []byte) (*cniTypesCurr.Result, error) {
+	res, err := cniVers.NewResult(version, rawPrevResult)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse prevResult: %v", err)
+	}
+
+	prevResult, err := cniTypesCurr.NewResultFromResult(res)
+	if err != nil {
+		return nil, fmt.Errorf("could not convert prevResult: %v", err)
+	}
+
+	return prevResult, nil
+}
+
+// GetPostPluginConfig builds the network configuration of the post-plugin at the given index.
+// Like libcni, it injects the network name, CNI version, prevResult and the runtime
+// configuration for the capabilities the post-plugin declares. Returns the plugin type and config.
+func (nwcfg *NetworkConfig) GetPostPluginConfig(index int, prevResult cniTypes.Result) (string, []byte, error) {
+	var conf map[string]interface{}
+
+	if err := json.Unmarshal(nwcfg.PostPlugins[index], &conf); err != nil {
+		return "", nil, fmt.Errorf("Failed to parse post-plugin config: %v", err)
+	}
+
+	pluginType, _ := conf["type"].(string)
+	if pluginType == "" {
+		return "", nil, fmt.Errorf("Post-plugin config at index %d has no type", index)
+	}
+
+	conf["name"] = nwcfg.Name
+	conf["cniVersion"] = nwcfg.CNIVersion
+	delete(conf, "prevResult")
+
+	if prevResult != nil {
+		res, err := prevResult.GetAsVersion(nwcfg.CNIVersion)
+		if err != nil {
+			return "", nil, fmt.Errorf("Failed to convert prevResult: %v", err)
+		}
+		conf["prevResult"] = res
+	}
+
+	// Pass only the runtime configuration the post-plugin asked for.
+	if capabilities, ok := conf["capabilities"].(map[string]interface{}); ok {
+		runtimeConfig := make(map[string]interface{})
+		if enabled, _ := capabilities[capabilityPortMappings].(bool); enabled && len(nwcfg.RuntimeConfig.PortMappings) > 0 {
+			runtimeConfig[capabilityPortMappings] = nwcfg.RuntimeConfig.PortMappings
+		}
+		if enabled, _ := capabilities[capabilityDNS].(bool); enabled {
+			runtimeConfig[capabilityDNS] = nwcfg.RuntimeConfig.DNS
+		}
+		if len(runtimeConfig) > 0 {
+			conf["runtimeConfig"] = runtimeConfig
+		}
+	}
+
+	bytes, err := json.Marshal(conf)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return pluginType, bytes, nil
+}
+
 // GetPoliciesFromNwCfg returns network policies from network config.
 func GetPoliciesFromNwCfg(kvp []KVPair) []policy.Policy {
 	var policies []policy.Policy
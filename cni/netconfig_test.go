@@ -0,0 +1,106 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package cni
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var chainedNwCfg = []byte(`{
+	"cniVersion": "0.3.1",
+	"name": "azure",
+	"type": "azure-vnet",
+	"runtimeConfig": {
+		"portMappings": [{"hostPort": 8080, "containerPort": 80, "protocol": "tcp"}]
+	},
+	"postPlugins": [
+		{"type": "portmap", "capabilities": {"portMappings": true}, "snat": true},
+		{"type": "tuning", "sysctl": {"net.core.somaxconn": "500"}}
+	],
+	"prevResult": {
+		"cniVersion": "0.3.1",
+		"interfaces": [{"name": "eth1", "sandbox": "/var/run/netns/test"}],
+		"ips": [{"version": "4", "address": "192.168.1.5/24", "interface": 0}]
+	}
+}`)
+
+func TestParseNetworkConfigPrevResult(t *testing.T) {
+	nwCfg, err := ParseNetworkConfig(chainedNwCfg)
+	if err != nil {
+		t.Fatalf("ParseNetworkConfig failed: %v", err)
+	}
+
+	if nwCfg.PrevResult == nil {
+		t.Fatalf("prevResult was not parsed")
+	}
+
+	if len(nwCfg.PrevResult.Interfaces) != 1 || nwCfg.PrevResult.Interfaces[0].Name != "eth1" {
+		t.Errorf("Unexpected prevResult interfaces %+v", nwCfg.PrevResult.Interfaces)
+	}
+
+	if len(nwCfg.PrevResult.IPs) != 1 || nwCfg.PrevResult.IPs[0].Address.String() != "192.168.1.5/24" {
+		t.Errorf("Unexpected prevResult IPs %+v", nwCfg.PrevResult.IPs)
+	}
+
+	if len(nwCfg.PostPlugins) != 2 {
+		t.Errorf("Expected 2 post-plugins but got %d", len(nwCfg.PostPlugins))
+	}
+}
+
+func TestParseNetworkConfigInvalidPrevResult(t *testing.T) {
+	_, err := ParseNetworkConfig([]byte(`{"cniVersion": "0.3.1", "prevResult": {"ips": "invalid"}}`))
+	if err == nil {
+		t.Errorf("Expected invalid prevResult to fail parsing")
+	}
+}
+
+func TestGetPostPluginConfig(t *testing.T) {
+	nwCfg, err := ParseNetworkConfig(chainedNwCfg)
+	if err != nil {
+		t.Fatalf("ParseNetworkConfig failed: %v", err)
+	}
+
+	pluginType, netConf, err := nwCfg.GetPostPluginConfig(0, nwCfg.PrevResult)
+	if err != nil {
+		t.Fatalf("GetPostPluginConfig failed: %v", err)
+	}
+
+	if pluginType != "portmap" {
+		t.Errorf("Expected plugin type portmap but got %s", pluginType)
+	}
+
+	var conf map[string]interface{}
+	if err = json.Unmarshal(netConf, &conf); err != nil {
+		t.Fatalf("Failed to unmarshal post-plugin config: %v", err)
+	}
+
+	if conf["name"] != "azure" || conf["cniVersion"] != "0.3.1" {
+		t.Errorf("Network name and version were not injected: %s", netConf)
+	}
+
+	if _, ok := conf["prevResult"]; !ok {
+		t.Errorf("prevResult was not injected: %s", netConf)
+	}
+
+	runtimeConfig, ok := conf["runtimeConfig"].(map[string]interface{})
+	if !ok || runtimeConfig["portMappings"] == nil {
+		t.Errorf("portMappings were not injected: %s", netConf)
+	}
+
+	// The tuning plugin does not declare any capability.
+	pluginType, netConf, err = nwCfg.GetPostPluginConfig(1, nil)
+	if err != nil {
+		t.Fatalf("GetPostPluginConfig failed: %v", err)
+	}
+
+	conf = nil
+	if err = json.Unmarshal(netConf, &conf); err != nil {
+		t.Fatalf("Failed to unmarshal post-plugin config: %v", err)
+	}
+
+	if pluginType != "tuning" || conf["runtimeConfig"] != nil || conf["prevResult"] != nil {
+		t.Errorf("Unexpected tuning config %s", netConf)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Azure/azure-container-networking/cni"
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/network"
+	cniSkel "github.com/containernetworking/cni/pkg/skel"
+	cniTypesCurr "github.com/containernetworking/cni/pkg/types/current"
+)
+
+// cachedEndpoint is the azure-vnet specific data cached along with the result of ADD.
+type cachedEndpoint struct {
+	NetworkID     string
+	EndpointState *network.EndpointState
+	Addresses     []cachedAddress
+}
+
+// cachedAddress is an address allocated through an IPAM plugin, with what is needed to release it.
+type cachedAddress struct {
+	IpamType    string
+	Environment string
+	Subnet      string
+	Address     string
+}
+
+// getAllocatedAddresses returns the IPAM allocations held by the given endpoint.
+func getAllocatedAddresses(nwCfg *cni.NetworkConfig, nwInfo *network.NetworkInfo, epInfo *network.EndpointInfo) []cachedAddress {
+	var addresses []cachedAddress
+
+	if !nwCfg.MultiTenancy {
+		for _, address := range epInfo.IPAddresses {
+			if address.IP.To4() != nil || nwCfg.IPV6Mode == network.IPV6DualStack {
+				// Dual-stack addresses are released to the pool of their own subnet.
+				subnet := nwInfo.Subnets[0].Prefix.String()
+				if address.IP.To4() == nil && len(nwInfo.Subnets) > 1 {
+					subnet = nwInfo.Subnets[1].Prefix.String()
+				}
+
+				addresses = append(addresses, cachedAddress{
+					IpamType:    nwCfg.Ipam.Type,
+					Environment: nwCfg.Ipam.Environment,
+					Subnet:      subnet,
+					Address:     address.IP.String(),
+				})
+			} else {
+				addr := cachedAddress{
+					IpamType:    ipamV6,
+					Environment: common.OptEnvironmentIPv6NodeIpam,
+					Address:     address.IP.String(),
+				}
+
+				if len(nwInfo.Subnets) > 1 {
+					addr.Subnet = nwInfo.Subnets[1].Prefix.String()
+				}
+
+				addresses = append(addresses, addr)
+			}
+		}
+	} else if epInfo.EnableInfraVnet {
+		addresses = append(addresses, cachedAddress{
+			IpamType:    nwCfg.Ipam.Type,
+			Environment: nwCfg.Ipam.Environment,
+			Subnet:      nwInfo.Subnets[0].Prefix.String(),
+			Address:     epInfo.InfraVnetIP.IP.String(),
+		})
+	}
+
+	return addresses
+}
+
+// releaseAddresses calls into the IPAM plugins to release the given addresses.
+// It attempts to release every address and returns the last error encountered.
+func (plugin *netPlugin) releaseAddresses(nwCfg *cni.NetworkConfig, addresses []cachedAddress) error {
+	var err error
+
+	for _, address := range addresses {
+		ipamCfg := *nwCfg
+		ipamCfg.Ipam.Type = address.IpamType
+		ipamCfg.Ipam.Environment = address.Environment
+		ipamCfg.Ipam.Subnet = address.Subnet
+		ipamCfg.Ipam.Address = address.Address
+
+		log.Printf("Releasing address :%s pool: %s", address.Address, address.Subnet)
+		if delErr := plugin.DelegateDel(ipamCfg.Ipam.Type, &ipamCfg); delErr != nil {
+			log.Printf("Failed to release address: %v", delErr)
+			err = plugin.Errorf("Failed to release address: %v", delErr)
+		}
+	}
+
+	return err
+}
+
+// saveCachedResult persists the result of a successful ADD along with what DEL needs to clean up.
+// Failing to cache the result does not fail ADD.
+func (plugin *netPlugin) saveCachedResult(
+	args *cniSkel.CmdArgs,
+	nwCfg *cni.NetworkConfig,
+	nwInfo *network.NetworkInfo,
+	epInfo *network.EndpointInfo,
+	networkId string,
+	result *cniTypesCurr.Result) {
+
+	epState, err := plugin.nm.GetEndpointState(networkId, epInfo.Id)
+	if err != nil {
+		log.Printf("[cni-net] Failed to get endpoint state for cache: %v", err)
+		return
+	}
+
+	data, err := json.Marshal(cachedEndpoint{
+		NetworkID:     networkId,
+		EndpointState: epState,
+		Addresses:     getAllocatedAddresses(nwCfg, nwInfo, epInfo),
+	})
+	if err != nil {
+		log.Printf("[cni-net] Failed to marshal cached endpoint: %v", err)
+		return
+	}
+
+	cachedResult := &cni.CachedResult{
+		ContainerID: args.ContainerID,
+		IfName:      args.IfName,
+		NetworkName: nwCfg.Name,
+		Config:      args.StdinData,
+		Result:      result,
+		Data:        data,
+	}
+
+	if err = plugin.resultCache.Save(cachedResult); err != nil {
+		log.Printf("[cni-net] Failed to cache result: %v", err)
+	}
+}
+
+// deleteUsingCachedResult removes the endpoint and releases the addresses recorded in a cached ADD result.
+// It is used when the network manager state no longer knows about the endpoint.
+func (plugin *netPlugin) deleteUsingCachedResult(nwCfg *cni.NetworkConfig, cachedResult *cni.CachedResult) error {
+	var cachedEp cachedEndpoint
+
+	if cachedResult == nil {
+		return nil
+	}
+
+	log.Printf("[cni-net] Deleting endpoint using cached result for container %v interface %v.",
+		cachedResult.ContainerID, cachedResult.IfName)
+
+	if err := json.Unmarshal(cachedResult.Data, &cachedEp); err != nil {
+		return plugin.Errorf("Failed to parse cached result: %v", err)
+	}
+
+	if cachedEp.EndpointState != nil {
+		if err := plugin.nm.DeleteEndpointUsingState(cachedEp.EndpointState); err != nil {
+			return plugin.Errorf("Failed to delete endpoint using cached result: %v", err)
+		}
+	}
+
+	if err := plugin.releaseAddresses(nwCfg, cachedEp.Addresses); err != nil {
+		return err
+	}
+
+	if err := plugin.resultCache.Delete(cachedResult.NetworkName, cachedResult.ContainerID, cachedResult.IfName); err != nil {
+		log.Printf("[cni-net] Failed to delete cached result: %v", err)
+	}
+
+	return nil
+}
+
+// formatAddresses returns the addresses as a string for logs and reports.
+func formatAddresses(addresses []cachedAddress) string {
+	var ips []string
+	for _, address := range addresses {
+		ips = append(ips, address.Address)
+	}
+
+	return fmt.Sprintf("%v", ips)
+}
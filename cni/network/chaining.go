@@ -0,0 +1,119 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"github.com/Azure/azure-container-networking/cni"
+	"github.com/Azure/azure-container-networking/log"
+	cniTypesCurr "github.com/containernetworking/cni/pkg/types/current"
+)
+
+// mergePrevResult merges the result of azure-vnet into the result of the previous plugin in the chain.
+// Interfaces and IPs declared by the previous plugin are kept first, followed by azure-vnet's own.
+// Interfaces with the same name are declared only once, and the interface indices of
+// azure-vnet's IPs are rebased onto the merged interface list.
+func mergePrevResult(prevResult *cniTypesCurr.Result, result *cniTypesCurr.Result) *cniTypesCurr.Result {
+	if prevResult == nil {
+		return result
+	}
+
+	merged := &cniTypesCurr.Result{
+		CNIVersion: result.CNIVersion,
+		DNS:        result.DNS,
+	}
+
+	merged.Interfaces = append(merged.Interfaces, prevResult.Interfaces...)
+
+	// Reuse interfaces already declared by the previous plugin.
+	ifIndexMap := make(map[int]int)
+	for i, iface := range result.Interfaces {
+		found := false
+		for j, prevIface := range prevResult.Interfaces {
+			if prevIface.Name == iface.Name {
+				ifIndexMap[i] = j
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			ifIndexMap[i] = len(merged.Interfaces)
+			merged.Interfaces = append(merged.Interfaces, iface)
+		}
+	}
+
+	merged.IPs = append(merged.IPs, prevResult.IPs...)
+	for _, ipConfig := range result.IPs {
+		ip := *ipConfig
+
+		// IPs without an interface index belong to azure-vnet's container interface.
+		ifIndex := 0
+		if ip.Interface != nil {
+			ifIndex = *ip.Interface
+		}
+
+		if mergedIndex, ok := ifIndexMap[ifIndex]; ok {
+			ip.Interface = &mergedIndex
+		}
+
+		merged.IPs = append(merged.IPs, &ip)
+	}
+
+	merged.Routes = append(merged.Routes, prevResult.Routes...)
+	merged.Routes = append(merged.Routes, result.Routes...)
+
+	if len(merged.DNS.Nameservers) == 0 {
+		merged.DNS = prevResult.DNS
+	}
+
+	return merged
+}
+
+// invokePostPluginsAdd calls ADD on the post-plugins declared in the network configuration, in order.
+// Each post-plugin receives the result of the previous one as prevResult. Returns the final result.
+func (plugin *netPlugin) invokePostPluginsAdd(nwCfg *cni.NetworkConfig, result *cniTypesCurr.Result) (*cniTypesCurr.Result, error) {
+	for i := range nwCfg.PostPlugins {
+		pluginType, netConf, err := nwCfg.GetPostPluginConfig(i, result)
+		if err != nil {
+			return nil, plugin.Errorf("Failed to build post-plugin config: %v", err)
+		}
+
+		postResult, err := plugin.DelegateAddConfig(pluginType, netConf)
+		if err != nil {
+			// Undo the post-plugins that already succeeded.
+			plugin.invokePostPluginsDel(nwCfg, result, i)
+			return nil, plugin.Errorf("Failed to invoke post-plugin %v: %v", pluginType, err)
+		}
+
+		result = postResult
+	}
+
+	return result, nil
+}
+
+// invokePostPluginsDel calls DEL on the first count post-plugins declared in the network configuration,
+// in reverse order. Failures are logged and do not stop the remaining post-plugins from being called.
+func (plugin *netPlugin) invokePostPluginsDel(nwCfg *cni.NetworkConfig, prevResult *cniTypesCurr.Result, count int) {
+	for i := count - 1; i >= 0; i-- {
+		var pluginType string
+		var netConf []byte
+		var err error
+
+		// Avoid passing a typed nil as the prevResult interface.
+		if prevResult != nil {
+			pluginType, netConf, err = nwCfg.GetPostPluginConfig(i, prevResult)
+		} else {
+			pluginType, netConf, err = nwCfg.GetPostPluginConfig(i, nil)
+		}
+
+		if err != nil {
+			log.Printf("[cni-net] Failed to build post-plugin config: %v", err)
+			continue
+		}
+
+		if err = plugin.DelegateDelConfig(pluginType, netConf); err != nil {
+			log.Printf("[cni-net] Failed to invoke post-plugin %v DEL: %v", pluginType, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/cni"
+	cniTypesCurr "github.com/containernetworking/cni/pkg/types/current"
+)
+
+func TestBuildAddResultWithPrevResult(t *testing.T) {
+	prevIfIndex := 0
+	_, prevAddr, _ := net.ParseCIDR("192.168.1.5/24")
+	_, addr, _ := net.ParseCIDR("10.240.0.5/16")
+
+	nwCfg := &cni.NetworkConfig{
+		CNIVersion: "0.3.1",
+		PrevResult: &cniTypesCurr.Result{
+			Interfaces: []*cniTypesCurr.Interface{{Name: "eth1", Sandbox: "/var/run/netns/test"}},
+			IPs:        []*cniTypesCurr.IPConfig{{Version: "4", Address: *prevAddr, Interface: &prevIfIndex}},
+		},
+	}
+
+	result := &cniTypesCurr.Result{
+		IPs: []*cniTypesCurr.IPConfig{{Version: "4", Address: *addr}},
+	}
+
+	addResult := buildAddResult("eth0", nwCfg, result, nil)
+
+	if len(addResult.Interfaces) != 2 || addResult.Interfaces[0].Name != "eth1" || addResult.Interfaces[1].Name != "eth0" {
+		t.Fatalf("Unexpected interfaces %+v", addResult.Interfaces)
+	}
+
+	if len(addResult.IPs) != 2 {
+		t.Fatalf("Expected 2 IPs but got %d", len(addResult.IPs))
+	}
+
+	if *addResult.IPs[0].Interface != 0 || addResult.IPs[0].Address.String() != prevAddr.String() {
+		t.Errorf("prevResult IP was not kept %+v", addResult.IPs[0])
+	}
+
+	if addResult.IPs[1].Interface == nil || *addResult.IPs[1].Interface != 1 {
+		t.Errorf("azure-vnet IP does not point at its interface %+v", addResult.IPs[1])
+	}
+
+	// The IPAM result must not be modified.
+	if result.IPs[0].Interface != nil || len(result.Interfaces) != 0 {
+		t.Errorf("IPAM result was modified %+v", result)
+	}
+}
+
+func TestBuildAddResultReusesPrevInterface(t *testing.T) {
+	_, addr, _ := net.ParseCIDR("10.240.0.5/16")
+
+	nwCfg := &cni.NetworkConfig{
+		PrevResult: &cniTypesCurr.Result{
+			Interfaces: []*cniTypesCurr.Interface{{Name: "lo"}, {Name: "eth0"}},
+		},
+	}
+
+	result := &cniTypesCurr.Result{
+		IPs: []*cniTypesCurr.IPConfig{{Version: "4", Address: *addr}},
+	}
+
+	addResult := buildAddResult("eth0", nwCfg, result, nil)
+
+	if len(addResult.Interfaces) != 2 {
+		t.Fatalf("Expected eth0 to be reused but got %+v", addResult.Interfaces)
+	}
+
+	if *addResult.IPs[0].Interface != 1 {
+		t.Errorf("Expected IP to point at eth0 but got %d", *addResult.IPs[0].Interface)
+	}
+}
+
+func TestBuildAddResultWithoutPrevResult(t *testing.T) {
+	_, addr, _ := net.ParseCIDR("10.240.0.5/16")
+
+	result := &cniTypesCurr.Result{
+		IPs: []*cniTypesCurr.IPConfig{{Version: "4", Address: *addr}},
+	}
+
+	addResult := buildAddResult("eth0", &cni.NetworkConfig{}, result, nil)
+
+	if len(addResult.Interfaces) != 1 || addResult.Interfaces[0].Name != "eth0" {
+		t.Errorf("Unexpected interfaces %+v", addResult.Interfaces)
+	}
+
+	if addResult.IPs[0].Interface != nil {
+		t.Errorf("Unchained result should not set an interface index")
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cni"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/network"
+	"github.com/Azure/azure-container-networking/platform"
+)
+
+const (
+	// IPAM plugin assumed for endpoints that have no cached result.
+	defaultIpamType = "azure-vnet-ipam"
+
+	// Environment variables the IPAM plugin expects on DEL.
+	envContainerID = "CNI_CONTAINERID"
+	envIfName      = "CNI_IFNAME"
+	envPath        = "CNI_PATH"
+)
+
+// SandboxSource lists the pod sandboxes that currently exist on the node.
+type SandboxSource interface {
+	ListSandboxes() (map[string]bool, error)
+}
+
+// podSandboxList is the subset of the CRI ListPodSandbox response printed by "crictl pods -o json".
+type podSandboxList struct {
+	Items []struct {
+		ID string `json:"id"`
+	} `json:"items"`
+}
+
+// parsePodSandboxList returns the set of sandbox IDs in a pod sandbox list.
+func parsePodSandboxList(bytes []byte) (map[string]bool, error) {
+	var list podSandboxList
+
+	if err := json.Unmarshal(bytes, &list); err != nil {
+		return nil, fmt.Errorf("Failed to parse pod sandbox list: %v", err)
+	}
+
+	sandboxes := make(map[string]bool)
+	for _, item := range list.Items {
+		sandboxes[item.ID] = true
+	}
+
+	return sandboxes, nil
+}
+
+// criSandboxSource lists pod sandboxes from the container runtime through its CRI socket.
+type criSandboxSource struct {
+	runtimeEndpoint string
+}
+
+// NewCRISandboxSource creates a sandbox source that queries the CRI socket at the given endpoint.
+func NewCRISandboxSource(runtimeEndpoint string) SandboxSource {
+	return &criSandboxSource{runtimeEndpoint: runtimeEndpoint}
+}
+
+// ListSandboxes returns the IDs of all pod sandboxes known to the container runtime, in any state.
+func (source *criSandboxSource) ListSandboxes() (map[string]bool, error) {
+	out, err := platform.ExecuteCommand(fmt.Sprintf("crictl --runtime-endpoint %s pods -o json", source.runtimeEndpoint))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list pod sandboxes: %v", err)
+	}
+
+	return parsePodSandboxList([]byte(out))
+}
+
+// fileSandboxSource lists pod sandboxes from a file in the "crictl pods -o json" format.
+// It stands in for the CRI socket on nodes where crictl is not available.
+type fileSandboxSource struct {
+	fileName string
+}
+
+// NewFileSandboxSource creates a sandbox source that reads the given file.
+func NewFileSandboxSource(fileName string) SandboxSource {
+	return &fileSandboxSource{fileName: fileName}
+}
+
+// ListSandboxes returns the IDs of the pod sandboxes listed in the file.
+func (source *fileSandboxSource) ListSandboxes() (map[string]bool, error) {
+	bytes, err := ioutil.ReadFile(source.fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePodSandboxList(bytes)
+}
+
+// GarbageCollector deletes endpoints whose pod sandbox no longer exists, for example because
+// kubelet crashed or DEL never arrived. An endpoint is deleted only after it was found orphaned
+// on consecutive runs spanning at least the grace period.
+type GarbageCollector struct {
+	source      SandboxSource
+	gracePeriod time.Duration
+	dryRun      bool
+	orphanedAt  map[string]time.Time
+}
+
+// orphanedEndpoint is an endpoint whose grace period has expired.
+type orphanedEndpoint struct {
+	networkID string
+	epInfo    *network.EndpointInfo
+}
+
+// NewGarbageCollector creates a new garbage collector. In dry-run mode, orphaned endpoints are only logged.
+func NewGarbageCollector(source SandboxSource, gracePeriod time.Duration, dryRun bool) *GarbageCollector {
+	return &GarbageCollector{
+		source:      source,
+		gracePeriod: gracePeriod,
+		dryRun:      dryRun,
+		orphanedAt:  make(map[string]time.Time),
+	}
+}
+
+// findOrphans returns the endpoints whose sandbox has been gone for longer than the grace period.
+// Endpoints seen orphaned for the first time start their grace period.
+func (gc *GarbageCollector) findOrphans(
+	endpoints map[string][]*network.EndpointInfo,
+	sandboxes map[string]bool,
+	now time.Time) []orphanedEndpoint {
+
+	var orphans []orphanedEndpoint
+	orphanedNow := make(map[string]bool)
+
+	for networkID, epInfos := range endpoints {
+		for _, epInfo := range epInfos {
+			// Endpoints without a container ID were not created by CNI.
+			if epInfo.ContainerID == "" || sandboxes[epInfo.ContainerID] {
+				continue
+			}
+
+			key := networkID + "/" + epInfo.Id
+			orphanedNow[key] = true
+
+			orphanedAt, ok := gc.orphanedAt[key]
+			if !ok {
+				log.Printf("[cni-gc] Endpoint %v of container %v has no sandbox, starting grace period.",
+					epInfo.Id, epInfo.ContainerID)
+				gc.orphanedAt[key] = now
+				continue
+			}
+
+			if now.Sub(orphanedAt) >= gc.gracePeriod {
+				orphans = append(orphans, orphanedEndpoint{networkID: networkID, epInfo: epInfo})
+			}
+		}
+	}
+
+	// Forget endpoints that were deleted or whose sandbox came back.
+	for key := range gc.orphanedAt {
+		if !orphanedNow[key] {
+			delete(gc.orphanedAt, key)
+		}
+	}
+
+	return orphans
+}
+
+// CollectGarbage deletes the orphaned endpoints in the plugin state and releases their addresses.
+// The caller must hold the plugin store lock.
+func (plugin *netPlugin) CollectGarbage(gc *GarbageCollector) error {
+	sandboxes, err := gc.source.ListSandboxes()
+	if err != nil {
+		// Never delete endpoints without an authoritative list of sandboxes.
+		log.Printf("[cni-gc] Failed to list sandboxes, skipping collection: %v", err)
+		return err
+	}
+
+	orphans := gc.findOrphans(plugin.nm.GetAllEndpoints(), sandboxes, time.Now())
+	if len(orphans) == 0 {
+		return nil
+	}
+
+	cachedResults, err := plugin.resultCache.List()
+	if err != nil {
+		log.Printf("[cni-gc] Failed to list cached results: %v", err)
+	}
+
+	for _, orphan := range orphans {
+		if gc.dryRun {
+			log.Printf("[cni-gc] Dry run: would delete endpoint %v of container %v in network %v with addresses %v.",
+				orphan.epInfo.Id, orphan.epInfo.ContainerID, orphan.networkID, orphan.epInfo.IPAddresses)
+			continue
+		}
+
+		if err = plugin.deleteOrphanedEndpoint(orphan, cachedResults); err != nil {
+			log.Printf("[cni-gc] Failed to delete endpoint %v: %v", orphan.epInfo.Id, err)
+			continue
+		}
+
+		delete(gc.orphanedAt, orphan.networkID+"/"+orphan.epInfo.Id)
+	}
+
+	return nil
+}
+
+// deleteOrphanedEndpoint deletes an orphaned endpoint and releases its addresses.
+// The network configuration and addresses come from the cached ADD result when there is one.
+func (plugin *netPlugin) deleteOrphanedEndpoint(orphan orphanedEndpoint, cachedResults []*cni.CachedResult) error {
+	var (
+		cachedResult *cni.CachedResult
+		cachedEp     cachedEndpoint
+		nwCfg        *cni.NetworkConfig
+		addresses    []cachedAddress
+		err          error
+	)
+
+	for _, cr := range cachedResults {
+		if cr.ContainerID != orphan.epInfo.ContainerID {
+			continue
+		}
+
+		if err = json.Unmarshal(cr.Data, &cachedEp); err == nil &&
+			cachedEp.EndpointState != nil && cachedEp.EndpointState.Endpoint.Id == orphan.epInfo.Id {
+			cachedResult = cr
+			break
+		}
+	}
+
+	if cachedResult != nil {
+		if nwCfg, err = cni.ParseNetworkConfig(cachedResult.Config); err != nil {
+			return err
+		}
+		addresses = cachedEp.Addresses
+	} else {
+		nwCfg = &cni.NetworkConfig{
+			Name:         orphan.networkID,
+			MultiTenancy: orphan.epInfo.EnableMultiTenancy,
+		}
+		nwCfg.Ipam.Type = defaultIpamType
+
+		nwInfo, err := plugin.nm.GetNetworkInfo(orphan.networkID)
+		if err != nil {
+			return err
+		}
+		addresses = getAllocatedAddresses(nwCfg, &nwInfo, orphan.epInfo)
+	}
+
+	log.Printf("[cni-gc] Deleting endpoint %v of container %v in network %v.",
+		orphan.epInfo.Id, orphan.epInfo.ContainerID, orphan.networkID)
+
+	if err = plugin.nm.DeleteEndpoint(orphan.networkID, orphan.epInfo.Id); err != nil {
+		return err
+	}
+
+	// The IPAM plugin reads the container from the environment, as if invoked by the runtime.
+	os.Setenv(envContainerID, orphan.epInfo.ContainerID)
+	if cachedResult != nil {
+		os.Setenv(envIfName, cachedResult.IfName)
+	} else {
+		os.Setenv(envIfName, orphan.epInfo.IfName)
+	}
+	if os.Getenv(envPath) == "" {
+		os.Setenv(envPath, platform.K8SCNIRuntimePath)
+	}
+
+	if err = plugin.releaseAddresses(nwCfg, addresses); err != nil {
+		return err
+	}
+
+	if cachedResult != nil {
+		if err = plugin.resultCache.Delete(cachedResult.NetworkName, cachedResult.ContainerID, cachedResult.IfName); err != nil {
+			log.Printf("[cni-gc] Failed to delete cached result: %v", err)
+		}
+	}
+
+	return nil
+}
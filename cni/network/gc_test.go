@@ -0,0 +1,83 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-container-networking/network"
+)
+
+var podSandboxes = []byte(`{
+	"items": [
+		{"id": "aaaaaaaa1111", "metadata": {"name": "pod1", "namespace": "default"}, "state": "SANDBOX_READY"},
+		{"id": "bbbbbbbb2222", "metadata": {"name": "pod2", "namespace": "default"}, "state": "SANDBOX_NOTREADY"}
+	]
+}`)
+
+func TestFileSandboxSource(t *testing.T) {
+	file, err := ioutil.TempFile("", "sandboxes")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	file.Write(podSandboxes)
+	file.Close()
+
+	sandboxes, err := NewFileSandboxSource(file.Name()).ListSandboxes()
+	if err != nil {
+		t.Fatalf("ListSandboxes failed: %v", err)
+	}
+
+	if len(sandboxes) != 2 || !sandboxes["aaaaaaaa1111"] || !sandboxes["bbbbbbbb2222"] {
+		t.Errorf("Unexpected sandboxes %v", sandboxes)
+	}
+
+	if _, err = NewFileSandboxSource(file.Name() + ".missing").ListSandboxes(); err == nil {
+		t.Errorf("ListSandboxes should fail for a missing file")
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	gc := NewGarbageCollector(nil, time.Minute, false)
+
+	endpoints := map[string][]*network.EndpointInfo{
+		"azure": {
+			{Id: "aaaaaaaa-eth0", ContainerID: "aaaaaaaa1111"},
+			{Id: "cccccccc-eth0", ContainerID: "cccccccc3333"},
+			{Id: "cnm-endpoint"},
+		},
+	}
+	sandboxes := map[string]bool{"aaaaaaaa1111": true}
+	start := time.Now()
+
+	// The first run only starts the grace period.
+	if orphans := gc.findOrphans(endpoints, sandboxes, start); len(orphans) != 0 {
+		t.Fatalf("Expected no orphans on first run but got %+v", orphans)
+	}
+
+	if orphans := gc.findOrphans(endpoints, sandboxes, start.Add(30*time.Second)); len(orphans) != 0 {
+		t.Fatalf("Expected no orphans within the grace period but got %+v", orphans)
+	}
+
+	orphans := gc.findOrphans(endpoints, sandboxes, start.Add(time.Minute))
+	if len(orphans) != 1 || orphans[0].epInfo.Id != "cccccccc-eth0" || orphans[0].networkID != "azure" {
+		t.Fatalf("Expected cccccccc-eth0 to be orphaned but got %+v", orphans)
+	}
+
+	// A sandbox that comes back resets the grace period.
+	sandboxes["cccccccc3333"] = true
+	if orphans = gc.findOrphans(endpoints, sandboxes, start.Add(2*time.Minute)); len(orphans) != 0 {
+		t.Fatalf("Expected no orphans after sandbox came back but got %+v", orphans)
+	}
+
+	delete(sandboxes, "cccccccc3333")
+	if orphans = gc.findOrphans(endpoints, sandboxes, start.Add(3*time.Minute)); len(orphans) != 0 {
+		t.Fatalf("Expected grace period to restart but got %+v", orphans)
+	}
+}
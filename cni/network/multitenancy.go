@@ -1,12 +1,15 @@
 package network
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-container-networking/cni"
 	"github.com/Azure/azure-container-networking/cns"
@@ -14,10 +17,20 @@ import (
 	"github.com/Azure/azure-container-networking/common"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/network"
+	"github.com/Azure/azure-container-networking/platform"
+	cniSkel "github.com/containernetworking/cni/pkg/skel"
 	cniTypes "github.com/containernetworking/cni/pkg/types"
 	cniTypesCurr "github.com/containernetworking/cni/pkg/types/current"
 )
 
+const (
+	// Prefix of the container interface names of secondary network containers without an interface name.
+	secondaryInterfacePrefix = "nc"
+
+	// Interval at which CNS is polled while waiting for network containers to be programmed.
+	ncProgrammedPollInterval = 500 * time.Millisecond
+)
+
 func SetupRoutingForMultitenancy(
 	nwCfg *cni.NetworkConfig,
 	cnsNetworkConfig *cns.GetNetworkContainerResponse,
@@ -51,53 +64,121 @@ func getContainerNetworkConfiguration(
 	nwCfg *cni.NetworkConfig,
 	podName string,
 	podNamespace string,
-	ifName string) (*cniTypesCurr.Result, *cns.GetNetworkContainerResponse, net.IPNet, error) {
-	var podNameWithoutSuffix string
+	ifName string) (*cniTypesCurr.Result, *cns.GetNetworkContainerResponse, net.IPNet, []cns.GetNetworkContainerResponse, error) {
+	podNameWithoutSuffix := getOrchestratorPodName(nwCfg, podName)
+	log.Printf("Podname without suffix %v", podNameWithoutSuffix)
+	return getContainerNetworkConfigurationInternal(nwCfg.CNSUrl, podNamespace, podNameWithoutSuffix, ifName)
+}
 
+// getOrchestratorPodName returns the pod name that network containers are registered with in CNS.
+func getOrchestratorPodName(nwCfg *cni.NetworkConfig, podName string) string {
 	if !nwCfg.EnableExactMatchForPodName {
-		podNameWithoutSuffix = network.GetPodNameWithoutSuffix(podName)
-	} else {
-		podNameWithoutSuffix = podName
+		return network.GetPodNameWithoutSuffix(podName)
 	}
 
-	log.Printf("Podname without suffix %v", podNameWithoutSuffix)
-	return getContainerNetworkConfigurationInternal(nwCfg.CNSUrl, podNamespace, podNameWithoutSuffix, ifName)
+	return podName
+}
+
+// waitForNetworkContainersProgrammed waits until the host has programmed all network containers of a pod,
+// for at most the timeout of the network configuration. It does not wait if no timeout is configured.
+func waitForNetworkContainersProgrammed(nwCfg *cni.NetworkConfig, podName string, podNamespace string) error {
+	if nwCfg.NCProgrammedTimeout <= 0 {
+		return nil
+	}
+
+	timeout := time.Duration(nwCfg.NCProgrammedTimeout) * time.Second
+	deadline := time.Now().Add(timeout)
+	orchestratorPodName := getOrchestratorPodName(nwCfg, podName)
+
+	for {
+		networkConfigs, err := getNetworkContainerConfigurations(podNamespace, orchestratorPodName)
+		if err != nil {
+			return err
+		}
+
+		var pending []string
+		for _, networkConfig := range networkConfigs {
+			if !networkConfig.Programmed {
+				pending = append(pending, networkConfig.NetworkContainerID)
+			}
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Network containers %v of pod %v namespace %v were not programmed within %v",
+				pending, podName, podNamespace, timeout)
+		}
+
+		log.Printf("Waiting for network containers %v to be programmed", pending)
+		time.Sleep(ncProgrammedPollInterval)
+	}
 }
 
 func getContainerNetworkConfigurationInternal(
 	address string,
 	namespace string,
 	podName string,
-	ifName string) (*cniTypesCurr.Result, *cns.GetNetworkContainerResponse, net.IPNet, error) {
+	ifName string) (*cniTypesCurr.Result, *cns.GetNetworkContainerResponse, net.IPNet, []cns.GetNetworkContainerResponse, error) {
+	networkConfigs, err := getNetworkContainerConfigurations(namespace, podName)
+	if err != nil {
+		return nil, nil, net.IPNet{}, nil, err
+	}
+
+	// The first network container is the primary one, the others get secondary container interfaces.
+	networkConfig := &networkConfigs[0]
+	log.Printf("Network config received from cns %+v", networkConfig)
+
+	subnetPrefix := common.GetInterfaceSubnetWithSpecificIp(networkConfig.PrimaryInterfaceIdentifier)
+	if subnetPrefix == nil {
+		errBuf := fmt.Sprintf("Interface not found for this ip %v", networkConfig.PrimaryInterfaceIdentifier)
+		log.Printf(errBuf)
+		return nil, nil, net.IPNet{}, nil, fmt.Errorf(errBuf)
+	}
+
+	return convertToCniResult(networkConfig, ifName), networkConfig, *subnetPrefix, networkConfigs[1:], nil
+}
+
+// getNetworkContainerConfigurations returns the network configurations of all network containers of a pod from CNS.
+func getNetworkContainerConfigurations(namespace string, podName string) ([]cns.GetNetworkContainerResponse, error) {
 	cnsClient, err := cnsclient.GetCnsClient()
 	if err != nil {
 		log.Printf("Failed to get CNS client. Error: %v", err)
-		return nil, nil, net.IPNet{}, err
+		return nil, err
 	}
 
 	podInfo := cns.KubernetesPodInfo{PodName: podName, PodNamespace: namespace}
 	orchestratorContext, err := json.Marshal(podInfo)
 	if err != nil {
 		log.Printf("Marshalling KubernetesPodInfo failed with %v", err)
-		return nil, nil, net.IPNet{}, err
+		return nil, err
 	}
 
-	networkConfig, err := cnsClient.GetNetworkConfiguration(orchestratorContext)
-	if err != nil {
-		log.Printf("GetNetworkConfiguration failed with %v", err)
-		return nil, nil, net.IPNet{}, err
+	networkConfigs, err := cnsClient.GetNetworkConfigurations(context.TODO(), orchestratorContext)
+
+	// CNS versions without the network containers API only support a single network container per pod.
+	var httpErr *cnsclient.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+		log.Printf("CNS does not support GetNetworkConfigurations, falling back to GetNetworkConfiguration")
+
+		var networkConfig *cns.GetNetworkContainerResponse
+		if networkConfig, err = cnsClient.GetNetworkConfiguration(context.TODO(), orchestratorContext); err == nil {
+			networkConfigs = []cns.GetNetworkContainerResponse{*networkConfig}
+		}
 	}
 
-	log.Printf("Network config received from cns %+v", networkConfig)
+	if err != nil {
+		log.Printf("GetNetworkConfigurations failed with %v", err)
+		return nil, err
+	}
 
-	subnetPrefix := common.GetInterfaceSubnetWithSpecificIp(networkConfig.PrimaryInterfaceIdentifier)
-	if subnetPrefix == nil {
-		errBuf := fmt.Sprintf("Interface not found for this ip %v", networkConfig.PrimaryInterfaceIdentifier)
-		log.Printf(errBuf)
-		return nil, nil, net.IPNet{}, fmt.Errorf(errBuf)
+	if len(networkConfigs) == 0 {
+		return nil, fmt.Errorf("No network container found for pod %v namespace %v", podName, namespace)
 	}
 
-	return convertToCniResult(networkConfig, ifName), networkConfig, *subnetPrefix, nil
+	return networkConfigs, nil
 }
 
 func convertToCniResult(networkConfig *cns.GetNetworkContainerResponse, ifName string) *cniTypesCurr.Result {
@@ -208,13 +289,18 @@ func GetMultiTenancyCNIResult(
 	plugin *netPlugin,
 	k8sPodName string,
 	k8sNamespace string,
-	ifName string) (*cniTypesCurr.Result, *cns.GetNetworkContainerResponse, net.IPNet, *cniTypesCurr.Result, error) {
+	ifName string) (*cniTypesCurr.Result, *cns.GetNetworkContainerResponse, net.IPNet, *cniTypesCurr.Result, []cns.GetNetworkContainerResponse, error) {
 
 	if nwCfg.MultiTenancy {
-		result, cnsNetworkConfig, subnetPrefix, err := getContainerNetworkConfiguration(nwCfg, k8sPodName, k8sNamespace, ifName)
+		if err := waitForNetworkContainersProgrammed(nwCfg, k8sPodName, k8sNamespace); err != nil {
+			log.Printf("WaitForNetworkContainersProgrammed failed for podname %v namespace %v with error %v", k8sPodName, k8sNamespace, err)
+			return nil, nil, net.IPNet{}, nil, nil, err
+		}
+
+		result, cnsNetworkConfig, subnetPrefix, secondaryNetworkConfigs, err := getContainerNetworkConfiguration(nwCfg, k8sPodName, k8sNamespace, ifName)
 		if err != nil {
 			log.Printf("GetContainerNetworkConfiguration failed for podname %v namespace %v with error %v", k8sPodName, k8sNamespace, err)
-			return nil, nil, net.IPNet{}, nil, err
+			return nil, nil, net.IPNet{}, nil, nil, err
 		}
 
 		log.Printf("PrimaryInterfaceIdentifier :%v", subnetPrefix.IP.String())
@@ -223,33 +309,33 @@ func GetMultiTenancyCNIResult(
 			buf := fmt.Sprintf("InfraVnet %v overlaps with customerVnet %+v", nwCfg.InfraVnetAddressSpace, cnsNetworkConfig.CnetAddressSpace)
 			log.Printf(buf)
 			err = errors.New(buf)
-			return nil, nil, net.IPNet{}, nil, err
+			return nil, nil, net.IPNet{}, nil, nil, err
 		}
 
 		if nwCfg.EnableSnatOnHost {
 			if cnsNetworkConfig.LocalIPConfiguration.IPSubnet.IPAddress == "" {
 				log.Printf("Snat IP is not populated. Got empty string")
-				return nil, nil, net.IPNet{}, nil, fmt.Errorf("Snat IP is not populated. Got empty string")
+				return nil, nil, net.IPNet{}, nil, nil, fmt.Errorf("Snat IP is not populated. Got empty string")
 			}
 		}
 
 		if enableInfraVnet {
 			if nwCfg.InfraVnetAddressSpace == "" {
 				log.Printf("InfraVnetAddressSpace is not populated. Got empty string")
-				return nil, nil, net.IPNet{}, nil, fmt.Errorf("InfraVnetAddressSpace is not populated. Got empty string")
+				return nil, nil, net.IPNet{}, nil, nil, fmt.Errorf("InfraVnetAddressSpace is not populated. Got empty string")
 			}
 		}
 
 		azIpamResult, err := getInfraVnetIP(enableInfraVnet, subnetPrefix.String(), nwCfg, plugin)
 		if err != nil {
 			log.Printf("GetInfraVnetIP failed with error %v", err)
-			return nil, nil, net.IPNet{}, nil, err
+			return nil, nil, net.IPNet{}, nil, nil, err
 		}
 
-		return result, cnsNetworkConfig, subnetPrefix, azIpamResult, nil
+		return result, cnsNetworkConfig, subnetPrefix, azIpamResult, secondaryNetworkConfigs, nil
 	}
 
-	return nil, nil, net.IPNet{}, nil, nil
+	return nil, nil, net.IPNet{}, nil, nil, nil
 }
 
 func CleanupMultitenancyResources(enableInfraVnet bool, nwCfg *cni.NetworkConfig, azIpamResult *cniTypesCurr.Result, plugin *netPlugin) {
@@ -257,3 +343,193 @@ func CleanupMultitenancyResources(enableInfraVnet bool, nwCfg *cni.NetworkConfig
 		cleanupInfraVnetIP(enableInfraVnet, &azIpamResult.IPs[0].Address, nwCfg, plugin)
 	}
 }
+
+// getSecondaryInterfaceName returns the container interface name of a secondary network container.
+func getSecondaryInterfaceName(cnsNetworkConfig *cns.GetNetworkContainerResponse, index int) string {
+	if cnsNetworkConfig.InterfaceName != "" {
+		return cnsNetworkConfig.InterfaceName
+	}
+
+	return fmt.Sprintf("%s%d", secondaryInterfacePrefix, index)
+}
+
+// getSecondaryEndpointID returns the endpoint ID of a secondary container interface.
+func getSecondaryEndpointID(args *cniSkel.CmdArgs, ifName string) string {
+	infraEpId, _ := network.ConstructEndpointID(args.ContainerID, args.Netns, ifName)
+	return infraEpId
+}
+
+// addSecondaryNetworkContainers creates one container interface per secondary network container of a pod
+// and adds them to the ADD result. Interfaces created before a failure are deleted.
+func (plugin *netPlugin) addSecondaryNetworkContainers(
+	args *cniSkel.CmdArgs,
+	nwCfg *cni.NetworkConfig,
+	k8sPodName string,
+	k8sNamespace string,
+	secondaryNetworkConfigs []cns.GetNetworkContainerResponse,
+	addResult *cniTypesCurr.Result) error {
+
+	for i := range secondaryNetworkConfigs {
+		cnsNetworkConfig := &secondaryNetworkConfigs[i]
+		ifName := getSecondaryInterfaceName(cnsNetworkConfig, i+1)
+
+		result, err := plugin.addSecondaryNetworkContainer(args, nwCfg, k8sPodName, k8sNamespace, cnsNetworkConfig, ifName)
+		if err != nil {
+			plugin.deleteSecondaryNetworkContainers(args, nwCfg, secondaryNetworkConfigs[:i])
+			return err
+		}
+
+		// The addresses of the network container are on its interface, which follows those already in the result.
+		for _, ipConfig := range result.IPs {
+			ipConfig.Interface = cniTypesCurr.Int(len(addResult.Interfaces))
+		}
+
+		addResult.Interfaces = append(addResult.Interfaces, result.Interfaces...)
+		addResult.IPs = append(addResult.IPs, result.IPs...)
+		addResult.Routes = append(addResult.Routes, result.Routes...)
+	}
+
+	return nil
+}
+
+// addSecondaryNetworkContainer creates the network and the container interface of a secondary network container.
+func (plugin *netPlugin) addSecondaryNetworkContainer(
+	args *cniSkel.CmdArgs,
+	nwCfg *cni.NetworkConfig,
+	k8sPodName string,
+	k8sNamespace string,
+	cnsNetworkConfig *cns.GetNetworkContainerResponse,
+	ifName string) (*cniTypesCurr.Result, error) {
+
+	log.Printf("[cni-net] Adding secondary network container %v on interface %v.", cnsNetworkConfig.NetworkContainerID, ifName)
+
+	result := convertToCniResult(cnsNetworkConfig, ifName)
+
+	networkId, err := getSecondaryNetworkName(nwCfg, cnsNetworkConfig)
+	if err != nil {
+		return nil, plugin.Errorf("Failed to get network name of network container %v: %v", cnsNetworkConfig.NetworkContainerID, err)
+	}
+
+	if _, err = plugin.nm.GetNetworkInfo(networkId); err != nil {
+		// Network does not exist.
+		log.Printf("[cni-net] Creating network %v.", networkId)
+
+		subnetPrefix := common.GetInterfaceSubnetWithSpecificIp(cnsNetworkConfig.PrimaryInterfaceIdentifier)
+		if subnetPrefix == nil {
+			return nil, plugin.Errorf("Interface not found for this ip %v", cnsNetworkConfig.PrimaryInterfaceIdentifier)
+		}
+
+		subnetPrefix.IP = subnetPrefix.IP.Mask(subnetPrefix.Mask)
+		masterIfName := findInterfaceInSubnet(subnetPrefix)
+		if masterIfName == "" {
+			return nil, plugin.Errorf("Failed to find the master interface")
+		}
+
+		if err = plugin.nm.AddExternalInterface(masterIfName, subnetPrefix.String()); err != nil {
+			return nil, plugin.Errorf("Failed to add external interface: %v", err)
+		}
+
+		if err = updateSubnetPrefix(cnsNetworkConfig, subnetPrefix); err != nil {
+			return nil, plugin.Errorf("Failed to updateSubnetPrefix: %v", err)
+		}
+
+		// The SNAT interface of the pod belongs to the primary network container only.
+		nwInfo := network.NetworkInfo{
+			Id:           networkId,
+			Mode:         nwCfg.Mode,
+			MasterIfName: masterIfName,
+			Subnets: []network.SubnetInfo{
+				{
+					Family:  platform.AfINET,
+					Prefix:  *subnetPrefix,
+					Gateway: result.IPs[0].Gateway,
+				},
+			},
+			NetNs:   args.Netns,
+			Options: make(map[string]interface{}),
+		}
+
+		setNetworkOptions(cnsNetworkConfig, &nwInfo)
+
+		if err = plugin.nm.CreateNetwork(&nwInfo); err != nil {
+			return nil, plugin.Errorf("Failed to create network: %v", err)
+		}
+
+		log.Printf("[cni-net] Created network %v with subnet %v.", networkId, subnetPrefix.String())
+	}
+
+	epInfo := &network.EndpointInfo{
+		Id:                 getSecondaryEndpointID(args, ifName),
+		ContainerID:        args.ContainerID,
+		NetNsPath:          args.Netns,
+		IfName:             ifName,
+		Data:               make(map[string]interface{}),
+		EnableMultiTenancy: true,
+		PODName:            k8sPodName,
+		PODNameSpace:       k8sNamespace,
+	}
+
+	for _, ipconfig := range result.IPs {
+		epInfo.IPAddresses = append(epInfo.IPAddresses, ipconfig.Address)
+	}
+
+	for _, route := range result.Routes {
+		epInfo.Routes = append(epInfo.Routes, network.RouteInfo{Dst: route.Dst, Gw: route.GW})
+	}
+
+	setEndpointOptions(cnsNetworkConfig, epInfo, fmt.Sprintf("%s%s%s", networkId, args.ContainerID, ifName))
+
+	log.Printf("[cni-net] Creating endpoint %v.", epInfo.Id)
+	if err = plugin.nm.CreateEndpoint(networkId, epInfo); err != nil {
+		return nil, plugin.Errorf("Failed to create endpoint: %v", err)
+	}
+
+	return result, nil
+}
+
+// deleteSecondaryNetworkContainers deletes the container interfaces of secondary network containers.
+// Errors are logged so that the remaining interfaces are deleted.
+func (plugin *netPlugin) deleteSecondaryNetworkContainers(
+	args *cniSkel.CmdArgs,
+	nwCfg *cni.NetworkConfig,
+	secondaryNetworkConfigs []cns.GetNetworkContainerResponse) {
+
+	for i := range secondaryNetworkConfigs {
+		cnsNetworkConfig := &secondaryNetworkConfigs[i]
+		ifName := getSecondaryInterfaceName(cnsNetworkConfig, i+1)
+
+		networkId, err := getSecondaryNetworkName(nwCfg, cnsNetworkConfig)
+		if err != nil {
+			log.Printf("[cni-net] Failed to get network name of network container %v: %v", cnsNetworkConfig.NetworkContainerID, err)
+			continue
+		}
+
+		endpointId := getSecondaryEndpointID(args, ifName)
+		log.Printf("[cni-net] Deleting endpoint %v of secondary network container %v.", endpointId, cnsNetworkConfig.NetworkContainerID)
+		if err = plugin.nm.DeleteEndpoint(networkId, endpointId); err != nil {
+			log.Printf("[cni-net] Failed to delete endpoint %v: %v", endpointId, err)
+		}
+	}
+}
+
+// deleteSecondaryNetworkContainersOfPod deletes the container interfaces of the secondary network containers of a pod.
+// The interfaces are found in the plugin state rather than queried from CNS, since CNS may have deleted the
+// network containers already. It is called after the primary endpoint of the container is deleted.
+func (plugin *netPlugin) deleteSecondaryNetworkContainersOfPod(args *cniSkel.CmdArgs, nwCfg *cni.NetworkConfig) {
+	if !nwCfg.MultiTenancy {
+		return
+	}
+
+	for networkId, endpoints := range plugin.nm.GetAllEndpoints() {
+		for _, epInfo := range endpoints {
+			if epInfo.ContainerID != args.ContainerID || !epInfo.EnableMultiTenancy {
+				continue
+			}
+
+			log.Printf("[cni-net] Deleting endpoint %v of secondary network container %v.", epInfo.Id, epInfo.NetworkContainerID)
+			if err := plugin.nm.DeleteEndpoint(networkId, epInfo.Id); err != nil {
+				log.Printf("[cni-net] Failed to delete endpoint %v: %v", epInfo.Id, err)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@
 package network
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -62,9 +63,10 @@ const (
 // NetPlugin represents the CNI network plugin.
 type netPlugin struct {
 	*cni.Plugin
-	nm     network.NetworkManager
-	report *telemetry.CNIReport
-	tb     *telemetry.TelemetryBuffer
+	nm          network.NetworkManager
+	resultCache *cni.ResultCache
+	report      *telemetry.CNIReport
+	tb          *telemetry.TelemetryBuffer
 }
 
 // snatConfiguration contains a bool that determines whether CNI enables snat on host and snat for dns
@@ -90,8 +92,9 @@ func NewPlugin(name string, config *common.PluginConfig) (*netPlugin, error) {
 	config.NetApi = nm
 
 	return &netPlugin{
-		Plugin: plugin,
-		nm:     nm,
+		Plugin:      plugin,
+		nm:          nm,
+		resultCache: cni.NewResultCache(platform.CNIResultCachePath),
 	}, nil
 }
 
@@ -119,7 +122,17 @@ func (plugin *netPlugin) Start(config *common.PluginConfig) error {
 	err = plugin.nm.Initialize(config)
 	if err != nil {
 		log.Printf("[cni-net] Failed to initialize network manager, err:%v.", err)
-		return err
+
+		// DEL can still clean up using the cached ADD result when the state cannot be restored.
+		if os.Getenv(cni.Cmd) != cni.CmdDel {
+			return err
+		}
+
+		log.Printf("[cni-net] Continuing DEL without network manager state.")
+		plugin.nm.SetReadOnly()
+	} else if err = plugin.nm.ReconcileOutboundNAT(); err != nil {
+		// The outbound NAT is reconciled again when the network is next changed.
+		log.Printf("[cni-net] Failed to reconcile outbound NAT, err:%v.", err)
 	}
 
 	log.Printf("[cni-net] Plugin started.")
@@ -143,6 +156,11 @@ func (plugin *netPlugin) findMasterInterface(nwCfg *cni.NetworkConfig, subnetPre
 	}
 
 	// Otherwise, pick the first interface with an IP address in the given subnet.
+	return findInterfaceInSubnet(subnetPrefix)
+}
+
+// findInterfaceInSubnet returns the name of the first interface with an IP address in the given subnet.
+func findInterfaceInSubnet(subnetPrefix *net.IPNet) string {
 	subnetPrefixString := subnetPrefix.String()
 	interfaces, _ := net.Interfaces()
 	for _, iface := range interfaces {
@@ -228,16 +246,41 @@ func (plugin *netPlugin) setCNIReportDetails(nwCfg *cni.NetworkConfig, opType st
 	plugin.report.InterfaceDetails.SecondaryCAUsedCount = plugin.nm.GetNumberOfEndpoints("", nwCfg.Name)
 }
 
-func addNatIPV6SubnetInfo(nwCfg *cni.NetworkConfig,
+// getIPV6Config returns the first IPv6 configuration in an IPAM result.
+func getIPV6Config(result *cniTypesCurr.Result) *cniTypesCurr.IPConfig {
+	if result == nil {
+		return nil
+	}
+
+	for _, ipConfig := range result.IPs {
+		if ipConfig.Address.IP.To4() == nil {
+			return ipConfig
+		}
+	}
+
+	return nil
+}
+
+func addIPV6SubnetInfo(nwCfg *cni.NetworkConfig,
+	result *cniTypesCurr.Result,
 	resultV6 *cniTypesCurr.Result,
 	nwInfo *network.NetworkInfo) {
-	if nwCfg.IPV6Mode == network.IPV6Nat {
-		ipv6Subnet := resultV6.IPs[0].Address
+	var ipConfigV6 *cniTypesCurr.IPConfig
+
+	switch nwCfg.IPV6Mode {
+	case network.IPV6Nat:
+		ipConfigV6 = resultV6.IPs[0]
+	case network.IPV6DualStack:
+		ipConfigV6 = getIPV6Config(result)
+	}
+
+	if ipConfigV6 != nil {
+		ipv6Subnet := ipConfigV6.Address
 		ipv6Subnet.IP = ipv6Subnet.IP.Mask(ipv6Subnet.Mask)
 		ipv6SubnetInfo := network.SubnetInfo{
 			Family:  platform.AfINET6,
 			Prefix:  ipv6Subnet,
-			Gateway: resultV6.IPs[0].Gateway,
+			Gateway: ipConfigV6.Gateway,
 		}
 		log.Printf("[net] ipv6 subnet info:%+v", ipv6SubnetInfo)
 		nwInfo.Subnets = append(nwInfo.Subnets, ipv6SubnetInfo)
@@ -256,13 +299,18 @@ func (plugin *netPlugin) invokeIpamDel(
 			nwCfg.Ipam.Type = ipamType
 		}
 
-		_, subnet, _ := net.ParseCIDR(result.IPs[0].Address.String())
-		nwCfg.Ipam.Subnet = subnet.String()
-		nwCfg.Ipam.Address = result.IPs[0].Address.IP.String()
-		plugin.DelegateDel(ipamType, &nwCfg)
+		// Release every address, including the IPv6 address of a dual-stack allocation.
+		for _, ipConfig := range result.IPs {
+			_, subnet, _ := net.ParseCIDR(ipConfig.Address.String())
+			nwCfg.Ipam.Subnet = subnet.String()
+			nwCfg.Ipam.Address = ipConfig.Address.IP.String()
+			plugin.DelegateDel(ipamType, &nwCfg)
+		}
 
-		// Release pool
-		if isDeletePoolOnError {
+		// Release pool. A dual-stack pool releases its IPv6 pool too.
+		if isDeletePoolOnError && len(result.IPs) > 0 {
+			_, subnet, _ := net.ParseCIDR(result.IPs[0].Address.String())
+			nwCfg.Ipam.Subnet = subnet.String()
 			nwCfg.Ipam.Address = ""
 			plugin.DelegateDel(ipamType, &nwCfg)
 		}
@@ -297,7 +345,12 @@ func (plugin *netPlugin) invokeIpamAdd(
 		}
 	}()
 
-	if nwCfg.IPV6Mode != "" {
+	if nwCfg.IPV6Mode == network.IPV6DualStack {
+		// The IPAM plugin allocates the IPv6 address along with the IPv4 one.
+		if getIPV6Config(result) == nil {
+			err = plugin.Errorf("IPAM did not allocate an IPv6 address for dual-stack network")
+		}
+	} else if nwCfg.IPV6Mode != "" {
 		nwCfg6 := nwCfg
 		nwCfg6.Ipam.Environment = common.OptEnvironmentIPv6NodeIpam
 		nwCfg6.Ipam.Type = ipamV6
@@ -315,6 +368,42 @@ func (plugin *netPlugin) invokeIpamAdd(
 	return result, resultV6, err
 }
 
+// buildAddResult assembles the result of an ADD command from the IPAM results.
+// It declares the container interface and merges in the result of the previous plugin in the chain.
+func buildAddResult(
+	ifName string,
+	nwCfg *cni.NetworkConfig,
+	result *cniTypesCurr.Result,
+	resultV6 *cniTypesCurr.Result) *cniTypesCurr.Result {
+
+	addResult := &cniTypesCurr.Result{}
+	if result != nil {
+		addResult.CNIVersion = result.CNIVersion
+		addResult.Interfaces = append(addResult.Interfaces, result.Interfaces...)
+		addResult.IPs = append(addResult.IPs, result.IPs...)
+		addResult.Routes = append(addResult.Routes, result.Routes...)
+		addResult.DNS = result.DNS
+	}
+
+	// Add Interfaces to result.
+	iface := &cniTypesCurr.Interface{
+		Name: ifName,
+	}
+	addResult.Interfaces = append(addResult.Interfaces, iface)
+
+	if resultV6 != nil {
+		addResult.IPs = append(addResult.IPs, resultV6.IPs...)
+	}
+
+	addSnatInterface(nwCfg, addResult)
+
+	if nwCfg != nil {
+		addResult = mergePrevResult(nwCfg.PrevResult, addResult)
+	}
+
+	return addResult
+}
+
 //
 // CNI implementation
 // https://github.com/containernetworking/cni/blob/master/SPEC.md
@@ -326,13 +415,14 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 		result           *cniTypesCurr.Result
 		resultV6         *cniTypesCurr.Result
 		azIpamResult     *cniTypesCurr.Result
+		addResult        *cniTypesCurr.Result
 		err              error
 		vethName         string
 		nwCfg            *cni.NetworkConfig
 		epInfo           *network.EndpointInfo
-		iface            *cniTypesCurr.Interface
 		subnetPrefix     net.IPNet
 		cnsNetworkConfig *cns.GetNetworkContainerResponse
+		secondaryConfigs []cns.GetNetworkContainerResponse
 		enableInfraVnet  bool
 		enableSnatForDns bool
 		nwDNSInfo        network.DNSInfo
@@ -353,6 +443,11 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 
 	log.Printf("[cni-net] Read network configuration %+v.", nwCfg)
 
+	if _, err = getOutboundNATInfo(nwCfg); err != nil {
+		err = plugin.Errorf("Invalid outbound NAT configuration: %v", err)
+		return err
+	}
+
 	// Temporary if block to determing whether we disable SNAT on host (for multi-tenant scenario only)
 	if nwCfg.MultiTenancy {
 		if enableSnatForDns, nwCfg.EnableSnatOnHost, err = determineSnat(); err != nil {
@@ -373,23 +468,13 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 		SetCustomDimensions(&cniMetric, nwCfg, err)
 		telemetry.SendCNIMetric(&cniMetric, plugin.tb)
 
-		// Add Interfaces to result.
-		if result == nil {
-			result = &cniTypesCurr.Result{}
-		}
-
-		iface = &cniTypesCurr.Interface{
-			Name: args.IfName,
-		}
-		result.Interfaces = append(result.Interfaces, iface)
-
-		if resultV6 != nil {
-			result.IPs = append(result.IPs, resultV6.IPs...)
+		// The final result is assembled once the endpoint is created.
+		if addResult == nil {
+			addResult = buildAddResult(args.IfName, nwCfg, result, resultV6)
 		}
 
-		addSnatInterface(nwCfg, result)
 		// Convert result to the requested CNI version.
-		res, vererr := result.GetAsVersion(nwCfg.CNIVersion)
+		res, vererr := addResult.GetAsVersion(nwCfg.CNIVersion)
 		if vererr != nil {
 			log.Printf("GetAsVersion failed with error %v", vererr)
 			plugin.Error(vererr)
@@ -400,7 +485,7 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 			res.Print()
 		}
 
-		log.Printf("[cni-net] ADD command completed with result:%+v err:%v.", result, err)
+		log.Printf("[cni-net] ADD command completed with result:%+v err:%v.", addResult, err)
 	}()
 
 	// Parse Pod arguments.
@@ -438,7 +523,7 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 		}
 	}
 
-	result, cnsNetworkConfig, subnetPrefix, azIpamResult, err = GetMultiTenancyCNIResult(enableInfraVnet, nwCfg, plugin, k8sPodName, k8sNamespace, args.IfName)
+	result, cnsNetworkConfig, subnetPrefix, azIpamResult, secondaryConfigs, err = GetMultiTenancyCNIResult(enableInfraVnet, nwCfg, plugin, k8sPodName, k8sNamespace, args.IfName)
 	if err != nil {
 		log.Printf("GetMultiTenancyCNIResult failed with error %v", err)
 		return err
@@ -562,7 +647,7 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 		nwInfo.Options = make(map[string]interface{})
 		setNetworkOptions(cnsNetworkConfig, &nwInfo)
 
-		addNatIPV6SubnetInfo(nwCfg, resultV6, &nwInfo)
+		addIPV6SubnetInfo(nwCfg, result, resultV6, &nwInfo)
 
 		err = plugin.nm.CreateNetwork(&nwInfo)
 		if err != nil {
@@ -676,6 +761,33 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 		return err
 	}
 
+	if err = plugin.reconcileOutboundNAT(nwCfg, networkId); err != nil {
+		plugin.nm.DeleteEndpoint(networkId, endpointId)
+		err = plugin.Errorf("Failed to program outbound NAT: %v", err)
+		return err
+	}
+
+	addResult = buildAddResult(args.IfName, nwCfg, result, resultV6)
+
+	// Create one container interface per secondary network container of the pod.
+	if err = plugin.addSecondaryNetworkContainers(args, nwCfg, k8sPodName, k8sNamespace, secondaryConfigs, addResult); err != nil {
+		plugin.nm.DeleteEndpoint(networkId, endpointId)
+		return err
+	}
+
+	// Pass the result on to the post-plugins declared in the network configuration.
+	if len(nwCfg.PostPlugins) > 0 {
+		addResult, err = plugin.invokePostPluginsAdd(nwCfg, addResult)
+		if err != nil {
+			plugin.deleteSecondaryNetworkContainers(args, nwCfg, secondaryConfigs)
+			plugin.nm.DeleteEndpoint(networkId, endpointId)
+			return err
+		}
+	}
+
+	// Cache the result so that DEL can clean up even if the state is lost.
+	plugin.saveCachedResult(args, nwCfg, &nwInfo, epInfo, networkId, addResult)
+
 	msg := fmt.Sprintf("CNI ADD succeeded : CNI Version %+v, IP:%+v, Interfaces:%+v, vlanid: %v, podname %v, namespace %v",
 		result.CNIVersion, result.IPs, result.Interfaces, epInfo.Data[network.VlanIDKey], k8sPodName, k8sNamespace)
 	plugin.setCNIReportDetails(nwCfg, CNI_ADD, msg)
@@ -828,6 +940,20 @@ func (plugin *netPlugin) Delete(args *cniSkel.CmdArgs) error {
 		cnsclient.InitCnsClient(nwCfg.CNSUrl)
 	}
 
+	// Load the result cached by ADD. It is used if the endpoint is missing from the state.
+	cachedResult, cacheErr := plugin.resultCache.Get(nwCfg.Name, args.ContainerID, args.IfName)
+	if cacheErr != nil {
+		log.Printf("[cni-net] No cached result for container %v: %v", args.ContainerID, cacheErr)
+	}
+
+	// Post-plugins are torn down in reverse order before the endpoint they were chained to.
+	// They are passed the result of ADD, which the runtime may not provide as prevResult.
+	prevResult := nwCfg.PrevResult
+	if cachedResult != nil && cachedResult.Result != nil {
+		prevResult = cachedResult.Result
+	}
+	plugin.invokePostPluginsDel(nwCfg, prevResult, len(nwCfg.PostPlugins))
+
 	// Initialize values from network config.
 	if networkId, err = getNetworkName(k8sPodName, k8sNamespace, args.IfName, nwCfg); err != nil {
 		log.Printf("[cni-net] Failed to extract network name from network config. error: %v", err)
@@ -837,17 +963,17 @@ func (plugin *netPlugin) Delete(args *cniSkel.CmdArgs) error {
 
 	// Query the network.
 	if nwInfo, err = plugin.nm.GetNetworkInfo(networkId); err != nil {
-		// Log the error but return success if the endpoint being deleted is not found.
+		// Log the error and clean up using the cached result. Return success if there is none.
 		plugin.Errorf("[cni-net] Failed to query network: %v", err)
-		err = nil
+		err = plugin.deleteUsingCachedResult(nwCfg, cachedResult)
 		return err
 	}
 
 	// Query the endpoint.
 	if epInfo, err = plugin.nm.GetEndpointInfo(networkId, endpointId); err != nil {
-		// Log the error but return success if the endpoint being deleted is not found.
+		// Log the error and clean up using the cached result. Return success if there is none.
 		plugin.Errorf("[cni-net] Failed to query endpoint: %v", err)
-		err = nil
+		err = plugin.deleteUsingCachedResult(nwCfg, cachedResult)
 		return err
 	}
 
@@ -868,45 +994,24 @@ func (plugin *netPlugin) Delete(args *cniSkel.CmdArgs) error {
 		return err
 	}
 
-	if !nwCfg.MultiTenancy {
-		// Call into IPAM plugin to release the endpoint's addresses.
-		for _, address := range epInfo.IPAddresses {
-			nwCfg.Ipam.Address = address.IP.String()
-			if address.IP.To4() != nil {
-				nwCfg.Ipam.Subnet = nwInfo.Subnets[0].Prefix.String()
-				log.Printf("Releasing ipv4 address :%s pool: %s",
-					nwCfg.Ipam.Address, nwCfg.Ipam.Subnet)
-				if err = plugin.DelegateDel(nwCfg.Ipam.Type, nwCfg); err != nil {
-					log.Printf("Failed to release ipv4 address: %v", err)
-					err = plugin.Errorf("Failed to release ipv4 address: %v", err)
-				}
-			} else {
-				nwCfgIpv6 := *nwCfg
-				nwCfgIpv6.Ipam.Environment = common.OptEnvironmentIPv6NodeIpam
-				nwCfgIpv6.Ipam.Type = ipamV6
-				if len(nwInfo.Subnets) > 1 {
-					nwCfgIpv6.Ipam.Subnet = nwInfo.Subnets[1].Prefix.String()
-				}
+	// Delete the container interfaces of the secondary network containers.
+	plugin.deleteSecondaryNetworkContainersOfPod(args, nwCfg)
 
-				log.Printf("Releasing ipv6 address :%s pool: %s",
-					nwCfgIpv6.Ipam.Address, nwCfgIpv6.Ipam.Subnet)
-				if err = plugin.DelegateDel(nwCfgIpv6.Ipam.Type, &nwCfgIpv6); err != nil {
-					log.Printf("Failed to release ipv6 address: %v", err)
-					err = plugin.Errorf("Failed to release ipv6 address: %v", err)
-				}
-			}
-		}
-	} else if epInfo.EnableInfraVnet {
-		nwCfg.Ipam.Subnet = nwInfo.Subnets[0].Prefix.String()
-		nwCfg.Ipam.Address = epInfo.InfraVnetIP.IP.String()
-		err = plugin.DelegateDel(nwCfg.Ipam.Type, nwCfg)
-		if err != nil {
-			log.Printf("Failed to release address: %v", err)
-			err = plugin.Errorf("Failed to release address: %v", err)
+	// Remove the outbound NAT rules of the endpoint.
+	if natErr := plugin.reconcileOutboundNAT(nwCfg, networkId); natErr != nil {
+		log.Printf("[cni-net] Failed to reconcile outbound NAT: %v", natErr)
+	}
+
+	// Call into IPAM plugin to release the endpoint's addresses.
+	addresses := getAllocatedAddresses(nwCfg, &nwInfo, epInfo)
+	if err = plugin.releaseAddresses(nwCfg, addresses); err == nil {
+		// The cached result is kept if a release failed, so that a retried DEL can finish the cleanup.
+		if cacheErr = plugin.resultCache.Delete(nwCfg.Name, args.ContainerID, args.IfName); cacheErr != nil {
+			log.Printf("[cni-net] Failed to delete cached result: %v", cacheErr)
 		}
 	}
 
-	msg = fmt.Sprintf("CNI DEL succeeded : Released ip %+v podname %v namespace %v", nwCfg.Ipam.Address, k8sPodName, k8sNamespace)
+	msg = fmt.Sprintf("CNI DEL succeeded : Released ip %+v podname %v namespace %v", formatAddresses(addresses), k8sPodName, k8sNamespace)
 	plugin.setCNIReportDetails(nwCfg, CNI_DEL, msg)
 
 	return err
@@ -1027,7 +1132,7 @@ func (plugin *netPlugin) Update(args *cniSkel.CmdArgs) error {
 		return plugin.Errorf(err.Error())
 	}
 
-	if targetNetworkConfig, err = cnsClient.GetNetworkConfiguration(orchestratorContext); err != nil {
+	if targetNetworkConfig, err = cnsClient.GetNetworkConfiguration(context.TODO(), orchestratorContext); err != nil {
 		log.Printf("GetNetworkConfiguration failed with %v", err)
 		return plugin.Errorf(err.Error())
 	}
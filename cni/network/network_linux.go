@@ -1,8 +1,10 @@
 package network
 
 import (
+	"fmt"
 	"net"
 	"strconv"
+	"strings"
 
 	"github.com/Azure/azure-container-networking/cni"
 	"github.com/Azure/azure-container-networking/cns"
@@ -136,3 +138,27 @@ func updateSubnetPrefix(cnsNetworkConfig *cns.GetNetworkContainerResponse, subne
 func getNetworkName(podName, podNs, ifName string, nwCfg *cni.NetworkConfig) (string, error) {
 	return nwCfg.Name, nil
 }
+
+// getSecondaryNetworkName returns the name of the network of a secondary network container.
+// Secondary network containers are in their own network, named after the subnet of the network container.
+func getSecondaryNetworkName(nwCfg *cni.NetworkConfig, cnsNetworkConfig *cns.GetNetworkContainerResponse) (string, error) {
+	ipconfig := cnsNetworkConfig.IPConfiguration
+	ipAddr := net.ParseIP(ipconfig.IPSubnet.IPAddress)
+	if ipAddr == nil {
+		return "", fmt.Errorf("Invalid IP address %v of network container %v", ipconfig.IPSubnet.IPAddress, cnsNetworkConfig.NetworkContainerID)
+	}
+
+	bits := 128
+	if ipAddr.To4() != nil {
+		bits = 32
+	}
+
+	subnet := net.IPNet{Mask: net.CIDRMask(int(ipconfig.IPSubnet.PrefixLength), bits)}
+	subnet.IP = ipAddr.Mask(subnet.Mask)
+
+	// networkName will look like ~ azure-172-28-1-0_24
+	networkName := strings.Replace(subnet.String(), ".", "-", -1)
+	networkName = strings.Replace(networkName, ":", "-", -1)
+	networkName = strings.Replace(networkName, "/", "_", -1)
+	return fmt.Sprintf("%s-%v", nwCfg.Name, networkName), nil
+}
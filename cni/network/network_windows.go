@@ -159,23 +159,31 @@ func getNetworkName(podName, podNs, ifName string, nwCfg *cni.NetworkConfig) (ne
 			return
 		}
 
-		_, cnsNetworkConfig, _, err := getContainerNetworkConfiguration(nwCfg, podName, podNs, ifName)
+		_, cnsNetworkConfig, _, _, err := getContainerNetworkConfiguration(nwCfg, podName, podNs, ifName)
 		if err != nil {
 			log.Printf("GetContainerNetworkConfiguration failed for podname %v namespace %v with error %v", podName, podNs, err)
-		} else {
-			var subnet net.IPNet
-			if err = updateSubnetPrefix(cnsNetworkConfig, &subnet); err == nil {
-				// networkName will look like ~ azure-vlan1-172-28-1-0_24
-				networkName = strings.Replace(subnet.String(), ".", "-", -1)
-				networkName = strings.Replace(networkName, "/", "_", -1)
-				networkName = fmt.Sprintf("%s-vlan%v-%v", nwCfg.Name, cnsNetworkConfig.MultiTenancyInfo.ID, networkName)
-			}
+		} else if name, nameErr := getSecondaryNetworkName(nwCfg, cnsNetworkConfig); nameErr == nil {
+			networkName = name
 		}
 	}
 
 	return
 }
 
+// getSecondaryNetworkName returns the name of the network of a network container.
+// It is derived from the vlan and the subnet of the network container.
+func getSecondaryNetworkName(nwCfg *cni.NetworkConfig, cnsNetworkConfig *cns.GetNetworkContainerResponse) (string, error) {
+	var subnet net.IPNet
+	if err := updateSubnetPrefix(cnsNetworkConfig, &subnet); err != nil {
+		return "", err
+	}
+
+	// networkName will look like ~ azure-vlan1-172-28-1-0_24
+	networkName := strings.Replace(subnet.String(), ".", "-", -1)
+	networkName = strings.Replace(networkName, "/", "_", -1)
+	return fmt.Sprintf("%s-vlan%v-%v", nwCfg.Name, cnsNetworkConfig.MultiTenancyInfo.ID, networkName), nil
+}
+
 func setupInfraVnetRoutingForMultitenancy(
 	nwCfg *cni.NetworkConfig,
 	azIpamResult *cniTypesCurr.Result,
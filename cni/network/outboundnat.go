@@ -0,0 +1,80 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/Azure/azure-container-networking/cni"
+	"github.com/Azure/azure-container-networking/network"
+)
+
+// getOutboundNATPolicy parses the addresses of an outbound NAT policy.
+func getOutboundNATPolicy(nonMasqueradeCIDRs []string, snatIP string, disableSnat bool) (network.OutboundNATPolicy, error) {
+	policy := network.OutboundNATPolicy{DisableSnat: disableSnat}
+
+	for _, cidr := range nonMasqueradeCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return policy, fmt.Errorf("Invalid non-masquerade CIDR %q: %v", cidr, err)
+		}
+		policy.NonMasqueradeCIDRs = append(policy.NonMasqueradeCIDRs, *ipNet)
+	}
+
+	if snatIP != "" {
+		if policy.SnatIP = net.ParseIP(snatIP); policy.SnatIP == nil {
+			return policy, fmt.Errorf("Invalid SNAT IP %q", snatIP)
+		}
+	}
+
+	return policy, nil
+}
+
+// getOutboundNATInfo converts the outbound NAT network configuration to the network model.
+// It returns nil if outbound NAT is not configured.
+func getOutboundNATInfo(nwCfg *cni.NetworkConfig) (*network.OutboundNATInfo, error) {
+	var err error
+
+	if nwCfg.OutboundNAT == nil {
+		return nil, nil
+	}
+
+	natInfo := &network.OutboundNATInfo{NamespacePolicies: make(map[string]network.OutboundNATPolicy)}
+
+	natInfo.OutboundNATPolicy, err = getOutboundNATPolicy(nwCfg.OutboundNAT.NonMasqueradeCIDRs, nwCfg.OutboundNAT.SnatIP, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, override := range nwCfg.OutboundNAT.NamespaceOverrides {
+		if _, ok := natInfo.NamespacePolicies[override.Namespace]; ok {
+			return nil, fmt.Errorf("Duplicate outbound NAT override for namespace %q", override.Namespace)
+		}
+
+		natInfo.NamespacePolicies[override.Namespace], err = getOutboundNATPolicy(
+			override.NonMasqueradeCIDRs, override.SnatIP, override.DisableSnat)
+		if err != nil {
+			return nil, fmt.Errorf("Namespace %v: %v", override.Namespace, err)
+		}
+	}
+
+	return natInfo, nil
+}
+
+// reconcileOutboundNAT records the outbound NAT of the given network and programs the outbound NAT of the
+// endpoints of all networks. Multitenant networks are skipped since their outbound NAT is set up per network
+// container.
+func (plugin *netPlugin) reconcileOutboundNAT(nwCfg *cni.NetworkConfig, networkId string) error {
+	if nwCfg.MultiTenancy {
+		return nil
+	}
+
+	natInfo, err := getOutboundNATInfo(nwCfg)
+	if err != nil {
+		return err
+	}
+
+	return plugin.nm.SetOutboundNAT(networkId, natInfo)
+}
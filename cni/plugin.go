@@ -90,15 +90,21 @@ func (plugin *Plugin) Execute(api PluginApi) (err error) {
 
 // DelegateAdd calls the given plugin's ADD command and returns the result.
 func (plugin *Plugin) DelegateAdd(pluginName string, nwCfg *NetworkConfig) (*cniTypesCurr.Result, error) {
+	return plugin.DelegateAddConfig(pluginName, nwCfg.Serialize())
+}
+
+// DelegateAddConfig calls the given plugin's ADD command with a raw network configuration
+// and returns the result. It is used for plugins that do not share azure-vnet's config schema.
+func (plugin *Plugin) DelegateAddConfig(pluginName string, netConf []byte) (*cniTypesCurr.Result, error) {
 	var result *cniTypesCurr.Result
 	var err error
 
-	log.Printf("[cni] Calling plugin %v ADD nwCfg:%+v.", pluginName, nwCfg)
+	log.Printf("[cni] Calling plugin %v ADD netConf:%s.", pluginName, netConf)
 	defer func() { log.Printf("[cni] Plugin %v returned result:%+v, err:%v.", pluginName, result, err) }()
 
 	os.Setenv(Cmd, CmdAdd)
 
-	res, err := cniInvoke.DelegateAdd(context.TODO(), pluginName, nwCfg.Serialize(), nil)
+	res, err := cniInvoke.DelegateAdd(context.TODO(), pluginName, netConf, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to delegate: %v", err)
 	}
@@ -113,14 +119,19 @@ func (plugin *Plugin) DelegateAdd(pluginName string, nwCfg *NetworkConfig) (*cni
 
 // DelegateDel calls the given plugin's DEL command and returns the result.
 func (plugin *Plugin) DelegateDel(pluginName string, nwCfg *NetworkConfig) error {
+	return plugin.DelegateDelConfig(pluginName, nwCfg.Serialize())
+}
+
+// DelegateDelConfig calls the given plugin's DEL command with a raw network configuration.
+func (plugin *Plugin) DelegateDelConfig(pluginName string, netConf []byte) error {
 	var err error
 
-	log.Printf("[cni] Calling plugin %v DEL nwCfg:%+v.", pluginName, nwCfg)
+	log.Printf("[cni] Calling plugin %v DEL netConf:%s.", pluginName, netConf)
 	defer func() { log.Printf("[cni] Plugin %v returned err:%v.", pluginName, err) }()
 
 	os.Setenv(Cmd, CmdDel)
 
-	err = cniInvoke.DelegateDel(context.TODO(), pluginName, nwCfg.Serialize(), nil)
+	err = cniInvoke.DelegateDel(context.TODO(), pluginName, netConf, nil)
 	if err != nil {
 		return fmt.Errorf("Failed to delegate: %v", err)
 	}
@@ -81,6 +81,7 @@ type GetPoolInfoResponse struct {
 	Capacity           int
 	Available          int
 	UnhealthyAddresses []string
+	UnhealthyReasons   map[string]string
 }
 
 // Request sent by libnetwork when reserving an address from a pool.
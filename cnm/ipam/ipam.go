@@ -5,6 +5,7 @@ package ipam
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/Azure/azure-container-networking/cnm"
 	"github.com/Azure/azure-container-networking/common"
@@ -21,6 +22,9 @@ const (
 	requiresRequestReplay = false
 	returnCode            = 0
 	returnStr             = "Success"
+
+	// Default interval at which the address source is refreshed in the background.
+	defaultRefreshInterval = 10 * time.Second
 )
 
 // IpamPlugin represents a CNM (libnetwork) IPAM plugin.
@@ -31,6 +35,7 @@ type ipamPlugin struct {
 
 type IpamPlugin interface {
 	common.PluginApi
+	SubscribePoolChanges() (<-chan *ipam.PoolChangeEvent, func())
 }
 
 // NewPlugin creates a new IpamPlugin object.
@@ -71,6 +76,13 @@ func (plugin *ipamPlugin) Start(config *common.PluginConfig) error {
 		return err
 	}
 
+	// Refresh the address source in the background so that requests are not blocked by source queries.
+	refreshInterval := defaultRefreshInterval
+	if i, _ := plugin.GetOption(common.OptIpamQueryInterval).(int); i > 0 {
+		refreshInterval = time.Duration(i) * time.Second
+	}
+	plugin.am.StartBackgroundRefresh(refreshInterval)
+
 	// Add protocol handlers.
 	listener := plugin.Listener
 	listener.AddEndpoint(plugin.EndpointType)
@@ -102,6 +114,12 @@ func (plugin *ipamPlugin) Stop() {
 	log.Printf("[ipam] Plugin stopped.")
 }
 
+// SubscribePoolChanges returns a channel that receives the changes made to address pools by source refreshes,
+// and a function that cancels the subscription.
+func (plugin *ipamPlugin) SubscribePoolChanges() (<-chan *ipam.PoolChangeEvent, func()) {
+	return plugin.am.SubscribePoolChanges()
+}
+
 //
 // Libnetwork remote IPAM API implementation
 // https://github.com/docker/libnetwork/blob/master/docs/ipam.md
@@ -226,8 +244,9 @@ func (plugin *ipamPlugin) getPoolInfo(w http.ResponseWriter, r *http.Request) {
 
 	// Encode response.
 	resp := GetPoolInfoResponse{
-		Capacity:  apInfo.Capacity,
-		Available: apInfo.Available,
+		Capacity:         apInfo.Capacity,
+		Available:        apInfo.Available,
+		UnhealthyReasons: apInfo.UnhealthyReasons,
 	}
 
 	for _, addr := range apInfo.UnhealthyAddrs {
@@ -265,7 +284,7 @@ func (plugin *ipamPlugin) requestAddress(w http.ResponseWriter, r *http.Request)
 
 	options[ipam.OptAddressID] = req.Options[ipam.OptAddressID]
 
-	addr, err := plugin.am.RequestAddress(poolId.AsId, poolId.Subnet, req.Address, options)
+	addr, _, err := plugin.am.RequestAddress(poolId.AsId, poolId.Subnet, req.Address, options)
 	if err != nil {
 		plugin.SendErrorResponse(w, err)
 		return
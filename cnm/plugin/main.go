@@ -34,9 +34,11 @@ var args = common.ArgumentList{
 		Type:         "string",
 		DefaultValue: common.OptEnvironmentAzure,
 		ValueMap: map[string]interface{}{
-			common.OptEnvironmentAzure:    0,
-			common.OptEnvironmentMAS:      0,
-			common.OptEnvironmentFileIpam: 0,
+			common.OptEnvironmentAzure:     0,
+			common.OptEnvironmentMAS:       0,
+			common.OptEnvironmentFileIpam:  0,
+			common.OptEnvironmentRangeIpam: 0,
+			common.OptEnvironmentIMDS:      0,
 		},
 	},
 	{
@@ -90,6 +92,38 @@ var args = common.ArgumentList{
 		Type:         "int",
 		DefaultValue: "",
 	},
+	{
+		Name:         common.OptIpamAllocationStrategy,
+		Shorthand:    common.OptIpamAllocationStrategyAlias,
+		Description:  "Set the IPAM address allocation strategy",
+		Type:         "string",
+		DefaultValue: common.OptIpamAllocationLRR,
+		ValueMap: map[string]interface{}{
+			common.OptIpamAllocationLRR:        common.OptIpamAllocationLRR,
+			common.OptIpamAllocationSequential: common.OptIpamAllocationSequential,
+		},
+	},
+	{
+		Name:         common.OptIpamQuarantinePeriod,
+		Shorthand:    common.OptIpamQuarantinePeriodAlias,
+		Description:  "Set the number of seconds a released address is not reused",
+		Type:         "int",
+		DefaultValue: "",
+	},
+	{
+		Name:         common.OptIpamDAD,
+		Shorthand:    common.OptIpamDADAlias,
+		Description:  "Enable duplicate address detection before handing out addresses",
+		Type:         "bool",
+		DefaultValue: false,
+	},
+	{
+		Name:         common.OptIpamRangeFile,
+		Shorthand:    common.OptIpamRangeFileAlias,
+		Description:  "Set the range configuration file of the rangeIpam environment",
+		Type:         "string",
+		DefaultValue: "",
+	},
 	{
 		Name:         common.OptVersion,
 		Shorthand:    common.OptVersionAlias,
@@ -123,6 +157,10 @@ func main() {
 	logTarget := common.GetArg(common.OptLogTarget).(int)
 	ipamQueryUrl, _ := common.GetArg(common.OptIpamQueryUrl).(string)
 	ipamQueryInterval, _ := common.GetArg(common.OptIpamQueryInterval).(int)
+	ipamAllocationStrategy, _ := common.GetArg(common.OptIpamAllocationStrategy).(string)
+	ipamQuarantinePeriod, _ := common.GetArg(common.OptIpamQuarantinePeriod).(int)
+	ipamDAD, _ := common.GetArg(common.OptIpamDAD).(bool)
+	ipamRangeFile, _ := common.GetArg(common.OptIpamRangeFile).(string)
 	vers := common.GetArg(common.OptVersion).(bool)
 	storeFileLocation := common.GetArg(common.OptStoreFileLocation).(string)
 
@@ -187,6 +225,10 @@ func main() {
 	ipamPlugin.SetOption(common.OptAPIServerURL, url)
 	ipamPlugin.SetOption(common.OptIpamQueryUrl, ipamQueryUrl)
 	ipamPlugin.SetOption(common.OptIpamQueryInterval, ipamQueryInterval)
+	ipamPlugin.SetOption(common.OptIpamAllocationStrategy, ipamAllocationStrategy)
+	ipamPlugin.SetOption(common.OptIpamQuarantinePeriod, ipamQuarantinePeriod)
+	ipamPlugin.SetOption(common.OptIpamDAD, ipamDAD)
+	ipamPlugin.SetOption(common.OptIpamRangeFile, ipamRangeFile)
 
 	// Start plugins.
 	if netPlugin != nil {
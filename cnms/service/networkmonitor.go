@@ -8,6 +8,7 @@ import (
 	"os"
 	"time"
 
+	cninet "github.com/Azure/azure-container-networking/cni/network"
 	cnms "github.com/Azure/azure-container-networking/cnms/cnmspackage"
 	acn "github.com/Azure/azure-container-networking/common"
 	"github.com/Azure/azure-container-networking/log"
@@ -22,6 +23,7 @@ const (
 	name                            = "azure-cnimonitor"
 	pluginName                      = "azure-vnet"
 	DEFAULT_TIMEOUT_IN_SECS         = "10"
+	DEFAULT_GC_GRACE_PERIOD_IN_SECS = "300"
 	telemetryNumRetries             = 5
 	telemetryWaitTimeInMilliseconds = 200
 )
@@ -68,6 +70,34 @@ var args = acn.ArgumentList{
 		Type:         "int",
 		DefaultValue: DEFAULT_TIMEOUT_IN_SECS,
 	},
+	{
+		Name:         acn.OptGCRuntimeEndpoint,
+		Shorthand:    acn.OptGCRuntimeEndpointAlias,
+		Description:  "Collect orphaned endpoints using the pod sandboxes of this CRI endpoint",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptGCSandboxFile,
+		Shorthand:    acn.OptGCSandboxFileAlias,
+		Description:  "Collect orphaned endpoints using the pod sandboxes listed in this file (crictl pods -o json format)",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptGCGracePeriod,
+		Shorthand:    acn.OptGCGracePeriodAlias,
+		Description:  "Seconds an endpoint must be orphaned before it is collected",
+		Type:         "int",
+		DefaultValue: DEFAULT_GC_GRACE_PERIOD_IN_SECS,
+	},
+	{
+		Name:         acn.OptGCDryRun,
+		Shorthand:    acn.OptGCDryRunAlias,
+		Description:  "Only log the orphaned endpoints that would be collected",
+		Type:         "bool",
+		DefaultValue: false,
+	},
 	{
 		Name:         acn.OptVersion,
 		Shorthand:    acn.OptVersionAlias,
@@ -83,6 +113,40 @@ func printVersion() {
 	fmt.Printf("Version %v\n", version)
 }
 
+// collectGarbage deletes orphaned endpoints from the CNI plugin state.
+// It holds the CNI store lock for the duration of the collection, like a CNI command.
+func collectGarbage(gc *cninet.GarbageCollector) {
+	var config acn.PluginConfig
+	config.Version = version
+
+	netPlugin, err := cninet.NewPlugin(pluginName, &config)
+	if err != nil {
+		log.Printf("[monitor] Failed to create network plugin for garbage collection: %v", err)
+		return
+	}
+
+	if err = netPlugin.Plugin.InitializeKeyValueStore(&config); err != nil {
+		log.Printf("[monitor] Failed to lock store for garbage collection: %v", err)
+		return
+	}
+
+	defer func() {
+		if err := netPlugin.Plugin.UninitializeKeyValueStore(false); err != nil {
+			log.Errorf("[monitor] Failed to unlock store after garbage collection: %v", err)
+		}
+	}()
+
+	if err = netPlugin.Start(&config); err != nil {
+		log.Printf("[monitor] Failed to start network plugin for garbage collection: %v", err)
+		return
+	}
+	defer netPlugin.Stop()
+
+	if err = netPlugin.CollectGarbage(gc); err != nil {
+		log.Printf("[monitor] Garbage collection failed with error %v", err)
+	}
+}
+
 // Main is the entry point for CNMS.
 func main() {
 	// Initialize and parse command line arguments.
@@ -91,6 +155,10 @@ func main() {
 	logTarget := acn.GetArg(acn.OptLogTarget).(int)
 	logDirectory := acn.GetArg(acn.OptLogLocation).(string)
 	timeout := acn.GetArg(acn.OptIntervalTime).(int)
+	gcRuntimeEndpoint := acn.GetArg(acn.OptGCRuntimeEndpoint).(string)
+	gcSandboxFile := acn.GetArg(acn.OptGCSandboxFile).(string)
+	gcGracePeriod := acn.GetArg(acn.OptGCGracePeriod).(int)
+	gcDryRun := acn.GetArg(acn.OptGCDryRun).(bool)
 	vers := acn.GetArg(acn.OptVersion).(bool)
 	if vers {
 		printVersion()
@@ -135,6 +203,16 @@ func main() {
 		CNIReport:                reportManager.Report.(*telemetry.CNIReport),
 	}
 
+	// Garbage collection is enabled when a pod sandbox source is configured.
+	var gc *cninet.GarbageCollector
+	if gcRuntimeEndpoint != "" {
+		gc = cninet.NewGarbageCollector(cninet.NewCRISandboxSource(gcRuntimeEndpoint),
+			time.Duration(gcGracePeriod)*time.Second, gcDryRun)
+	} else if gcSandboxFile != "" {
+		gc = cninet.NewGarbageCollector(cninet.NewFileSandboxSource(gcSandboxFile),
+			time.Duration(gcGracePeriod)*time.Second, gcDryRun)
+	}
+
 	tb := telemetry.NewTelemetryBuffer("")
 	tb.ConnectToTelemetryService(telemetryNumRetries, telemetryWaitTimeInMilliseconds)
 	defer tb.Close()
@@ -174,6 +252,10 @@ func main() {
 			netMonitor.CNIReport.ErrorMessage = ""
 		}
 
+		if gc != nil {
+			collectGarbage(gc)
+		}
+
 		log.Printf("[monitor] Going to sleep for %v seconds", timeout)
 		time.Sleep(time.Duration(timeout) * time.Second)
 		nm = nil
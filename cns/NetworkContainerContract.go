@@ -4,20 +4,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 )
 
 // Container Network Service DNC Contract
 const (
-	SetOrchestratorType                      = "/network/setorchestratortype"
-	CreateOrUpdateNetworkContainer           = "/network/createorupdatenetworkcontainer"
-	DeleteNetworkContainer                   = "/network/deletenetworkcontainer"
-	GetNetworkContainerStatus                = "/network/getnetworkcontainerstatus"
-	PublishNetworkContainer                  = "/network/publishnetworkcontainer"
-	UnpublishNetworkContainer                = "/network/unpublishnetworkcontainer"
-	GetInterfaceForContainer                 = "/network/getinterfaceforcontainer"
-	GetNetworkContainerByOrchestratorContext = "/network/getnetworkcontainerbyorchestratorcontext"
-	AttachContainerToNetwork                 = "/network/attachcontainertonetwork"
-	DetachContainerFromNetwork               = "/network/detachcontainerfromnetwork"
+	SetOrchestratorType                       = "/network/setorchestratortype"
+	CreateOrUpdateNetworkContainer            = "/network/createorupdatenetworkcontainer"
+	DeleteNetworkContainer                    = "/network/deletenetworkcontainer"
+	GetNetworkContainerStatus                 = "/network/getnetworkcontainerstatus"
+	PublishNetworkContainer                   = "/network/publishnetworkcontainer"
+	UnpublishNetworkContainer                 = "/network/unpublishnetworkcontainer"
+	GetInterfaceForContainer                  = "/network/getinterfaceforcontainer"
+	GetNetworkContainerByOrchestratorContext  = "/network/getnetworkcontainerbyorchestratorcontext"
+	GetNetworkContainersByOrchestratorContext = "/network/getnetworkcontainersbyorchestratorcontext"
+	AttachContainerToNetwork                  = "/network/attachcontainertonetwork"
+	DetachContainerFromNetwork                = "/network/detachcontainerfromnetwork"
+	GetNetworkContainerHistory                = "/network/getnetworkcontainerhistory"
+	Watch                                     = "/network/watch"
 )
 
 // NetworkContainer Prefixes
@@ -66,6 +70,11 @@ type CreateNetworkContainerRequest struct {
 	AllowHostToNCCommunication bool
 	AllowNCToHostCommunication bool
 	EndpointPolicies           []NetworkContainerRequestPolicies
+	// Name of the container interface of the network container, when a pod joins multiple network containers.
+	// Network containers of a pod with the same interface name replace each other.
+	InterfaceName string `json:",omitempty"`
+	// Order of the container interface among the network containers of a pod. The first one is the primary interface.
+	InterfaceOrder int `json:",omitempty"`
 }
 
 // NetworkContainerRequestPolicies - specifies policies associated with create network request
@@ -135,7 +144,9 @@ type GetNetworkContainerStatusResponse struct {
 	NetworkContainerid string
 	Version            string
 	AzureHostVersion   string
-	Response           Response
+	// Programmed is true once the host has programmed the version of the network container requested by the VM.
+	Programmed bool
+	Response   Response
 }
 
 // GetNetworkContainerRequest specifies the details about the request to retrieve a specifc network container.
@@ -156,11 +167,23 @@ type GetNetworkContainerResponse struct {
 	Response                   Response
 	AllowHostToNCCommunication bool
 	AllowNCToHostCommunication bool
+	InterfaceName              string `json:",omitempty"`
+	// Programmed is true once the host has programmed the version of the network container requested by the VM.
+	Programmed bool
+}
+
+// GetNetworkContainersResponse describes the response to retrieve all network containers of an orchestrator context,
+// in interface order.
+type GetNetworkContainersResponse struct {
+	NetworkContainers []GetNetworkContainerResponse
+	Response          Response
 }
 
 // DeleteNetworkContainerRequest specifies the details about the request to delete a specifc network container.
+// If Version is set, the network container is only deleted if its goal state has that version.
 type DeleteNetworkContainerRequest struct {
 	NetworkContainerid string
+	Version            string `json:",omitempty"`
 }
 
 // DeleteNetworkContainerResponse describes the response to delete a specifc network container.
@@ -168,6 +191,79 @@ type DeleteNetworkContainerResponse struct {
 	Response Response
 }
 
+// Operations of network container goal state transitions.
+const (
+	NetworkContainerCreate = "Create"
+	NetworkContainerUpdate = "Update"
+	NetworkContainerDelete = "Delete"
+)
+
+// NetworkContainerTransition describes a change of the goal state of a network container.
+// Rejected changes have a non-zero return code.
+type NetworkContainerTransition struct {
+	Time            time.Time
+	Operation       string
+	PreviousVersion string `json:",omitempty"`
+	Version         string `json:",omitempty"`
+	ReturnCode      int    `json:",omitempty"`
+	Message         string `json:",omitempty"`
+}
+
+// GetNetworkContainerHistoryRequest specifies the network container to retrieve the goal state history of.
+type GetNetworkContainerHistoryRequest struct {
+	NetworkContainerid string
+}
+
+// GetNetworkContainerHistoryResponse describes the last goal state transitions of a network container, oldest first.
+type GetNetworkContainerHistoryResponse struct {
+	NetworkContainerid string
+	History            []NetworkContainerTransition
+	Response           Response
+}
+
+// Kinds of watch events.
+const (
+	WatchKindNetworkContainer = "NetworkContainer"
+	WatchKindIPAddress        = "IPAddress"
+)
+
+// WatchRequest waits for changes of network containers and IP address reservations after a resume token.
+// Clients that start watching take the resume token of a request without one, load the state they cache,
+// then watch from that token. Events may repeat state that was already loaded.
+type WatchRequest struct {
+	// ResumeToken of the last response. If empty, the current resume token is returned without waiting.
+	ResumeToken string
+	// Kinds of events to return. Events of all kinds are returned if empty.
+	Kinds []string `json:",omitempty"`
+	// Time to wait for events before returning an empty response. Defaults to 30 seconds, at most 5 minutes.
+	TimeoutInSeconds int `json:",omitempty"`
+}
+
+// WatchEvent describes a change of a network container or an IP address reservation.
+// Operation is NetworkContainerCreate, NetworkContainerUpdate or NetworkContainerDelete.
+type WatchEvent struct {
+	ResumeToken         string
+	Time                time.Time
+	Kind                string
+	Operation           string
+	NetworkContainerID  string                       `json:",omitempty"`
+	OrchestratorContext json.RawMessage              `json:",omitempty"`
+	NetworkContainer    *GetNetworkContainerResponse `json:",omitempty"` // Network container after a create or update.
+	ReservationID       string                       `json:",omitempty"`
+	IPAddress           string                       `json:",omitempty"`
+}
+
+// WatchResponse describes the changes after the resume token of a watch request, oldest first.
+type WatchResponse struct {
+	Response Response
+	// ResumeToken to watch from in the next request.
+	ResumeToken string
+	Events      []WatchEvent
+	// ResyncRequired is set when the changes after the requested resume token are not available anymore,
+	// e.g. after CNS restarted. Clients must load the state they cache again and watch from the returned token.
+	ResyncRequired bool
+}
+
 // GetInterfaceForContainerRequest specifies the container ID for which interface needs to be identified.
 type GetInterfaceForContainerRequest struct {
 	NetworkContainerID string
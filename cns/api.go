@@ -3,7 +3,38 @@
 
 package cns
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
+
+// Return codes of the Container Network Service remote API, in Response.ReturnCode.
+const (
+	Success                         = 0
+	UnsupportedNetworkType          = 1
+	InvalidParameter                = 2
+	UnsupportedEnvironment          = 3
+	UnreachableHost                 = 4
+	ReservationNotFound             = 5
+	MalformedSubnet                 = 8
+	UnreachableDockerDaemon         = 9
+	UnspecifiedNetworkName          = 10
+	NotFound                        = 14
+	AddressUnavailable              = 15
+	NetworkContainerNotSpecified    = 16
+	CallToHostFailed                = 17
+	UnknownContainerID              = 18
+	UnsupportedOrchestratorType     = 19
+	DockerContainerNotSpecified     = 20
+	UnsupportedVerb                 = 21
+	UnsupportedNetworkContainerType = 22
+	InvalidRequest                  = 23
+	NetworkJoinFailed               = 24
+	NetworkContainerPublishFailed   = 25
+	NetworkContainerUnpublishFailed = 26
+	NetworkContainerVersionConflict = 27
+	UnexpectedError                 = 99
+)
 
 // Container Network Service remote API Contract
 const (
@@ -21,6 +52,8 @@ const (
 	NumberOfCPUCoresPath          = "/hostcpucores"
 	CreateHostNCApipaEndpointPath = "/network/createhostncapipaendpoint"
 	DeleteHostNCApipaEndpointPath = "/network/deletehostncapipaendpoint"
+	DebugStatePath                = "/debug/state"
+	DebugIPAddressUtilizationPath = "/debug/ip/utilization"
 	V1Prefix                      = "/v0.1"
 	V2Prefix                      = "/v0.2"
 )
@@ -115,6 +148,8 @@ type IPAddressesUtilizationResponse struct {
 type GetIPAddressesResponse struct {
 	Response    Response
 	IPAddresses []string
+	// Reasons of unhealthy addresses, keyed by address.
+	UnhealthyReasons map[string]string `json:",omitempty"`
 }
 
 // HostLocalIPAddressResponse describes reponse that returns the host local IP Address.
@@ -179,3 +214,50 @@ type DeleteHostNCApipaEndpointRequest struct {
 type DeleteHostNCApipaEndpointResponse struct {
 	Response Response
 }
+
+// DebugStateResponse describes the state of CNS for troubleshooting. Authorization tokens are redacted.
+type DebugStateResponse struct {
+	Response                          Response
+	Location                          string
+	NetworkType                       string
+	OrchestratorType                  string
+	NodeID                            string
+	Initialized                       bool
+	TimeStamp                         time.Time
+	NetworkContainers                 []DebugNetworkContainer
+	ContainerIDsByOrchestratorContext map[string][]string
+	JoinedNetworks                    []string
+	Networks                          []DebugNetwork
+}
+
+// DebugNetworkContainer describes a network container in the state of CNS.
+type DebugNetworkContainer struct {
+	ID                            string
+	VMVersion                     string
+	HostVersion                   string
+	HostVersionUpdatedAt          time.Time
+	HostVersionError              string `json:",omitempty"`
+	Programmed                    bool
+	CreateNetworkContainerRequest CreateNetworkContainerRequest
+}
+
+// DebugNetwork describes a network in the state of CNS.
+type DebugNetwork struct {
+	NetworkName  string
+	Subnet       string
+	Gateway      string
+	PrimaryIP    string
+	SecondaryIPs []string
+	Options      map[string]interface{}
+}
+
+// DebugIPAddressUtilizationResponse describes the utilization of the address pool of the primary interface.
+type DebugIPAddressUtilizationResponse struct {
+	Response             Response
+	PoolID               string
+	Subnet               string
+	Capacity             int
+	Available            int
+	Reserved             int
+	UnhealthyIPAddresses []string
+}
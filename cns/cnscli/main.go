@@ -0,0 +1,192 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/cnsclient"
+)
+
+const (
+	name                 = "cns-cli"
+	stateCommand         = "state"
+	ipUtilizationCommand = "ip-utilization"
+	versionCommand       = "version"
+
+	// Output formats of the commands.
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// Version is populated by make during build.
+var version string
+
+// Main is the entry point for the CNS command line tool.
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	command := os.Args[1]
+	flags := flag.NewFlagSet(name+" "+command, flag.ContinueOnError)
+	url := flags.String("url", "", "Set the URL of CNS, e.g. http://localhost:10090 or unix:///var/run/azure-cns.sock")
+	format := flags.String("o", formatTable, "Output format {table,json}")
+	timeout := flags.Duration("timeout", 30*time.Second, "Set the timeout of the request")
+
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		os.Exit(2)
+	}
+
+	if *format != formatTable && *format != formatJSON {
+		fmt.Fprintf(os.Stderr, "Invalid output format %v\n", *format)
+		os.Exit(2)
+	}
+
+	client, err := cnsclient.NewCnsClient(&cnsclient.Config{URL: *url})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create CNS client, err:%v.\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	switch command {
+	case stateCommand:
+		var state *cns.DebugStateResponse
+		if state, err = client.GetDebugState(ctx); err == nil {
+			err = write(os.Stdout, *format, state, writeStateTable)
+		}
+
+	case ipUtilizationCommand:
+		var utilization *cns.DebugIPAddressUtilizationResponse
+		if utilization, err = client.GetDebugIPAddressUtilization(ctx); err == nil {
+			err = write(os.Stdout, *format, utilization, writeIPUtilizationTable)
+		}
+
+	case versionCommand:
+		fmt.Printf("%s %s\n", name, version)
+
+	default:
+		printUsage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Command %s failed, err:%v.\n", command, err)
+		os.Exit(1)
+	}
+}
+
+// printUsage prints the commands of the tool.
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [-url URL] [-o table|json] [-timeout DURATION]\n\n", name)
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  %-16s Print network containers, orchestrator contexts, joined networks and networks\n", stateCommand)
+	fmt.Fprintf(os.Stderr, "  %-16s Print the utilization of the address pool of the primary interface\n", ipUtilizationCommand)
+	fmt.Fprintf(os.Stderr, "  %-16s Print the version of the tool\n", versionCommand)
+}
+
+// write writes a response in the given format.
+func write(w io.Writer, format string, v interface{}, writeTable func(io.Writer, interface{}) error) error {
+	if format == formatTable {
+		return writeTable(w, v)
+	}
+
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", buf)
+	return err
+}
+
+// writeStateTable writes the CNS state as tables of network containers, orchestrator contexts and networks.
+func writeStateTable(w io.Writer, v interface{}) error {
+	state := v.(*cns.DebugStateResponse)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Orchestrator: %s  Node: %s  Network type: %s  Location: %s  Saved: %s\n",
+		orNone(state.OrchestratorType), orNone(state.NodeID), orNone(state.NetworkType), orNone(state.Location),
+		state.TimeStamp.Format(time.RFC3339))
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "NETWORK CONTAINER\tTYPE\tVM VERSION\tHOST VERSION\tPROGRAMMED\tIP ADDRESS\tINTERFACE\tHOST ERROR")
+	for _, nc := range state.NetworkContainers {
+		req := nc.CreateNetworkContainerRequest
+		ipConfig := req.IPConfiguration.IPSubnet
+		var ipAddress string
+		if ipConfig.IPAddress != "" {
+			ipAddress = fmt.Sprintf("%s/%d", ipConfig.IPAddress, ipConfig.PrefixLength)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%v\t%s\t%s\t%s\n",
+			nc.ID, orNone(req.NetworkContainerType), orNone(nc.VMVersion), orNone(nc.HostVersion), nc.Programmed,
+			orNone(ipAddress), orNone(req.InterfaceName), orNone(nc.HostVersionError))
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "ORCHESTRATOR CONTEXT\tNETWORK CONTAINERS")
+	orchestratorContexts := make([]string, 0, len(state.ContainerIDsByOrchestratorContext))
+	for orchestratorContext := range state.ContainerIDsByOrchestratorContext {
+		orchestratorContexts = append(orchestratorContexts, orchestratorContext)
+	}
+
+	sort.Strings(orchestratorContexts)
+	for _, orchestratorContext := range orchestratorContexts {
+		ids := state.ContainerIDsByOrchestratorContext[orchestratorContext]
+		fmt.Fprintf(tw, "%s\t%s\n", orchestratorContext, strings.Join(ids, ","))
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "NETWORK\tSUBNET\tGATEWAY\tPRIMARY IP")
+	for _, network := range state.Networks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+			network.NetworkName, orNone(network.Subnet), orNone(network.Gateway), orNone(network.PrimaryIP))
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "Joined networks: %s\n", orNone(strings.Join(state.JoinedNetworks, ",")))
+
+	return tw.Flush()
+}
+
+// writeIPUtilizationTable writes the utilization of the address pool and its unhealthy addresses.
+func writeIPUtilizationTable(w io.Writer, v interface{}) error {
+	utilization := v.(*cns.DebugIPAddressUtilizationResponse)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "POOL\tSUBNET\tCAPACITY\tAVAILABLE\tRESERVED\tUNHEALTHY")
+	fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\n",
+		orNone(utilization.PoolID), orNone(utilization.Subnet), utilization.Capacity, utilization.Available,
+		utilization.Reserved, len(utilization.UnhealthyIPAddresses))
+
+	if len(utilization.UnhealthyIPAddresses) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintf(tw, "Unhealthy addresses: %s\n", strings.Join(utilization.UnhealthyIPAddresses, ","))
+	}
+
+	return tw.Flush()
+}
+
+// orNone returns a placeholder for empty table cells.
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
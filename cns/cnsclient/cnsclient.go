@@ -2,21 +2,54 @@ package cnsclient
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/Azure/azure-container-networking/cns"
 	"github.com/Azure/azure-container-networking/log"
 )
 
-// CNSClient specifies a client to connect to Ipam Plugin.
+// CNSClient specifies a client to connect to CNS.
 type CNSClient struct {
 	connectionURL string
+	httpClient    *http.Client
+	retries       int
+	retryDelay    time.Duration
+}
+
+// Config specifies the configuration of a CNS client.
+type Config struct {
+	// URL of CNS. The http, https and unix schemes are supported,
+	// e.g. http://localhost:10090 or unix:///var/run/azure-cns.sock.
+	URL string
+	// Timeout of establishing a connection to CNS.
+	DialTimeout time.Duration
+	// Timeout of each attempt of a request.
+	RequestTimeout time.Duration
+	// Number of times a request is retried when the connection to CNS fails.
+	Retries int
+	// Delay between retries.
+	RetryDelay time.Duration
+	// TLS configuration of the https scheme.
+	TLSConfig *tls.Config
 }
 
 const (
 	defaultCnsURL = "http://localhost:10090"
+
+	// Base URL of requests on a unix socket. The host is ignored by the unix socket dialer.
+	unixSocketURL = "http://unix"
+
+	defaultDialTimeout    = 5 * time.Second
+	defaultRequestTimeout = 30 * time.Second
+	defaultRetries        = 3
+	defaultRetryDelay     = 500 * time.Millisecond
 )
 
 var (
@@ -26,13 +59,16 @@ var (
 // InitCnsClient initializes new cns client and returns the object
 func InitCnsClient(url string) (*CNSClient, error) {
 	if cnsClient == nil {
-		if url == "" {
-			url = defaultCnsURL
+		client, err := NewCnsClient(&Config{
+			URL:        url,
+			Retries:    defaultRetries,
+			RetryDelay: defaultRetryDelay,
+		})
+		if err != nil {
+			return nil, err
 		}
 
-		cnsClient = &CNSClient{
-			connectionURL: url,
-		}
+		cnsClient = client
 	}
 
 	return cnsClient, nil
@@ -49,152 +85,516 @@ func GetCnsClient() (*CNSClient, error) {
 	return cnsClient, err
 }
 
-// GetNetworkConfiguration Request to get network config.
-func (cnsClient *CNSClient) GetNetworkConfiguration(orchestratorContext []byte) (*cns.GetNetworkContainerResponse, error) {
-	var body bytes.Buffer
+// NewCnsClient creates a new cns client with the given configuration.
+// Unlike InitCnsClient, each call returns a new client.
+func NewCnsClient(config *Config) (*CNSClient, error) {
+	rawURL := config.URL
+	if rawURL == "" {
+		rawURL = defaultCnsURL
+	}
 
-	httpc := &http.Client{}
-	url := cnsClient.connectionURL + cns.GetNetworkContainerByOrchestratorContext
-	log.Printf("GetNetworkConfiguration url %v", url)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("[Azure CNSClient] Invalid CNS URL %s: %v", rawURL, err)
+	}
 
-	payload := &cns.GetNetworkContainerRequest{
-		OrchestratorContext: orchestratorContext,
+	dialTimeout := config.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = defaultDialTimeout
 	}
 
-	err := json.NewEncoder(&body).Encode(payload)
-	if err != nil {
-		log.Errorf("encoding json failed with %v", err)
-		return nil, err
+	requestTimeout := config.RequestTimeout
+	if requestTimeout == 0 {
+		requestTimeout = defaultRequestTimeout
+	}
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	transport := &http.Transport{DialContext: dialer.DialContext}
+	connectionURL := rawURL
+
+	switch u.Scheme {
+	case "http":
+	case "https":
+		transport.TLSClientConfig = config.TLSConfig
+	case "unix":
+		socketPath := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+		connectionURL = unixSocketURL
+	default:
+		return nil, fmt.Errorf("[Azure CNSClient] Unsupported CNS URL scheme %s", u.Scheme)
+	}
+
+	return &CNSClient{
+		connectionURL: connectionURL,
+		httpClient: &http.Client{
+			Timeout:   requestTimeout,
+			Transport: transport,
+		},
+		retries:    config.Retries,
+		retryDelay: config.RetryDelay,
+	}, nil
+}
+
+// Returns whether a request failed to connect to CNS, in which case it was not sent and can be retried.
+func isConnectionError(err error) bool {
+	urlErr, ok := err.(*url.Error)
+	if !ok {
+		return false
+	}
+
+	opErr, ok := urlErr.Err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
+// Sends a request to CNS and decodes its response.
+// Requests without a payload are sent as GET, the others as POST.
+func (cnsClient *CNSClient) call(ctx context.Context, path string, request interface{}, response interface{}) error {
+	var (
+		payload []byte
+		method  = http.MethodGet
+		res     *http.Response
+		err     error
+	)
+
+	if request != nil {
+		method = http.MethodPost
+		if payload, err = json.Marshal(request); err != nil {
+			log.Errorf("encoding json failed with %v", err)
+			return err
+		}
+	}
+
+	url := cnsClient.connectionURL + path
+	log.Printf("[Azure CNSClient] %s %v", method, url)
+
+	for attempt := 0; ; attempt++ {
+		var req *http.Request
+		if req, err = http.NewRequest(method, url, bytes.NewReader(payload)); err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+
+		res, err = cnsClient.httpClient.Do(req)
+		if err == nil || !isConnectionError(err) || attempt >= cnsClient.retries {
+			break
+		}
+
+		log.Printf("[Azure CNSClient] Failed to connect to CNS, retrying %v: %v", path, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cnsClient.retryDelay):
+		}
 	}
 
-	res, err := httpc.Post(url, "application/json", &body)
 	if err != nil {
-		log.Errorf("[Azure CNSClient] HTTP Post returned error %v", err.Error())
-		return nil, err
+		log.Errorf("[Azure CNSClient] HTTP %s returned error %v", method, err.Error())
+		return err
 	}
 
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		errMsg := fmt.Sprintf("[Azure CNSClient] GetNetworkConfiguration invalid http status code: %v", res.StatusCode)
-		log.Errorf(errMsg)
-		return nil, fmt.Errorf(errMsg)
+		err = &HTTPError{Path: path, StatusCode: res.StatusCode}
+		log.Errorf(err.Error())
+		return err
+	}
+
+	if err = json.NewDecoder(res.Body).Decode(response); err != nil {
+		log.Errorf("[Azure CNSClient] Error parsing %v response err:%v", path, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Sends a request to CNS whose response is a plain cns.Response.
+func (cnsClient *CNSClient) callWithResponse(ctx context.Context, path string, request interface{}) error {
+	var resp cns.Response
+
+	if err := cnsClient.call(ctx, path, request, &resp); err != nil {
+		return err
+	}
+
+	return newCNSError(path, resp.ReturnCode, resp.Message)
+}
+
+//
+// Network API
+//
+
+// SetEnvironment sets the environment of CNS.
+func (cnsClient *CNSClient) SetEnvironment(ctx context.Context, req *cns.SetEnvironmentRequest) error {
+	return cnsClient.callWithResponse(ctx, cns.SetEnvironmentPath, req)
+}
+
+// CreateNetwork creates a network.
+func (cnsClient *CNSClient) CreateNetwork(ctx context.Context, req *cns.CreateNetworkRequest) error {
+	return cnsClient.callWithResponse(ctx, cns.CreateNetworkPath, req)
+}
+
+// DeleteNetwork deletes a network.
+func (cnsClient *CNSClient) DeleteNetwork(ctx context.Context, req *cns.DeleteNetworkRequest) error {
+	return cnsClient.callWithResponse(ctx, cns.DeleteNetworkPath, req)
+}
+
+// CreateHnsNetwork creates an HNS network. This is windows platform specific.
+func (cnsClient *CNSClient) CreateHnsNetwork(ctx context.Context, req *cns.CreateHnsNetworkRequest) error {
+	return cnsClient.callWithResponse(ctx, cns.CreateHnsNetworkPath, req)
+}
+
+// DeleteHnsNetwork deletes an HNS network. This is windows platform specific.
+func (cnsClient *CNSClient) DeleteHnsNetwork(ctx context.Context, req *cns.DeleteHnsNetworkRequest) error {
+	return cnsClient.callWithResponse(ctx, cns.DeleteHnsNetworkPath, req)
+}
+
+// ReserveIPAddress reserves an IP address and returns it.
+func (cnsClient *CNSClient) ReserveIPAddress(ctx context.Context, reservationID string) (string, error) {
+	var resp cns.ReserveIPAddressResponse
+
+	req := &cns.ReserveIPAddressRequest{ReservationID: reservationID}
+	if err := cnsClient.call(ctx, cns.ReserveIPAddressPath, req, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.IPAddress, newCNSError(cns.ReserveIPAddressPath, resp.Response.ReturnCode, resp.Response.Message)
+}
+
+// ReleaseIPAddress releases a previously reserved IP address.
+func (cnsClient *CNSClient) ReleaseIPAddress(ctx context.Context, reservationID string) error {
+	return cnsClient.callWithResponse(ctx, cns.ReleaseIPAddressPath, &cns.ReleaseIPAddressRequest{ReservationID: reservationID})
+}
+
+// GetHostLocalIP returns the host local IP address.
+func (cnsClient *CNSClient) GetHostLocalIP(ctx context.Context) (string, error) {
+	var resp cns.HostLocalIPAddressResponse
+
+	if err := cnsClient.call(ctx, cns.GetHostLocalIPPath, nil, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.IPAddress, newCNSError(cns.GetHostLocalIPPath, resp.Response.ReturnCode, resp.Response.Message)
+}
+
+// GetIPAddressUtilization returns the number of available, reserved and unhealthy IP addresses.
+func (cnsClient *CNSClient) GetIPAddressUtilization(ctx context.Context) (*cns.IPAddressesUtilizationResponse, error) {
+	var resp cns.IPAddressesUtilizationResponse
+
+	if err := cnsClient.call(ctx, cns.GetIPAddressUtilizationPath, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	if err := newCNSError(cns.GetIPAddressUtilizationPath, resp.Response.ReturnCode, resp.Response.Message); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// GetUnhealthyIPAddresses returns the unhealthy IP addresses and the reasons they are unhealthy.
+func (cnsClient *CNSClient) GetUnhealthyIPAddresses(ctx context.Context) (*cns.GetIPAddressesResponse, error) {
+	var resp cns.GetIPAddressesResponse
+
+	if err := cnsClient.call(ctx, cns.GetUnhealthyIPAddressesPath, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	if err := newCNSError(cns.GetUnhealthyIPAddressesPath, resp.Response.ReturnCode, resp.Response.Message); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// GetHealthReport returns an error if CNS does not report itself healthy.
+func (cnsClient *CNSClient) GetHealthReport(ctx context.Context) error {
+	return cnsClient.callWithResponse(ctx, cns.GetHealthReportPath, nil)
+}
+
+// GetNumberOfCPUCores returns the number of CPU cores of the host.
+func (cnsClient *CNSClient) GetNumberOfCPUCores(ctx context.Context) (int, error) {
+	var resp cns.NumOfCPUCoresResponse
+
+	if err := cnsClient.call(ctx, cns.NumberOfCPUCoresPath, nil, &resp); err != nil {
+		return 0, err
+	}
+
+	return resp.NumOfCPUCores, newCNSError(cns.NumberOfCPUCoresPath, resp.Response.ReturnCode, resp.Response.Message)
+}
+
+//
+// Network container API
+//
+
+// SetOrchestratorType sets the orchestrator type and node ID of CNS.
+func (cnsClient *CNSClient) SetOrchestratorType(ctx context.Context, req *cns.SetOrchestratorTypeRequest) error {
+	return cnsClient.callWithResponse(ctx, cns.SetOrchestratorType, req)
+}
+
+// CreateOrUpdateNetworkContainer creates or updates a network container.
+func (cnsClient *CNSClient) CreateOrUpdateNetworkContainer(ctx context.Context, req *cns.CreateNetworkContainerRequest) error {
+	var resp cns.CreateNetworkContainerResponse
+
+	if err := cnsClient.call(ctx, cns.CreateOrUpdateNetworkContainer, req, &resp); err != nil {
+		return err
+	}
+
+	return newCNSError(cns.CreateOrUpdateNetworkContainer, resp.Response.ReturnCode, resp.Response.Message)
+}
+
+// DeleteNetworkContainer deletes a network container.
+func (cnsClient *CNSClient) DeleteNetworkContainer(ctx context.Context, networkContainerID string) error {
+	var resp cns.DeleteNetworkContainerResponse
+
+	req := &cns.DeleteNetworkContainerRequest{NetworkContainerid: networkContainerID}
+	if err := cnsClient.call(ctx, cns.DeleteNetworkContainer, req, &resp); err != nil {
+		return err
+	}
+
+	return newCNSError(cns.DeleteNetworkContainer, resp.Response.ReturnCode, resp.Response.Message)
+}
+
+// DeleteNetworkContainerVersion deletes a network container only if its goal state has the given version.
+// It returns ErrNetworkContainerVersionConflict if the version does not match.
+func (cnsClient *CNSClient) DeleteNetworkContainerVersion(ctx context.Context, networkContainerID string, version string) error {
+	var resp cns.DeleteNetworkContainerResponse
+
+	req := &cns.DeleteNetworkContainerRequest{NetworkContainerid: networkContainerID, Version: version}
+	if err := cnsClient.call(ctx, cns.DeleteNetworkContainer, req, &resp); err != nil {
+		return err
+	}
+
+	return newCNSError(cns.DeleteNetworkContainer, resp.Response.ReturnCode, resp.Response.Message)
+}
+
+// GetNetworkContainerHistory returns the last goal state transitions of a network container, oldest first.
+func (cnsClient *CNSClient) GetNetworkContainerHistory(ctx context.Context, networkContainerID string) ([]cns.NetworkContainerTransition, error) {
+	var resp cns.GetNetworkContainerHistoryResponse
+
+	req := &cns.GetNetworkContainerHistoryRequest{NetworkContainerid: networkContainerID}
+	if err := cnsClient.call(ctx, cns.GetNetworkContainerHistory, req, &resp); err != nil {
+		return nil, err
+	}
+
+	if err := newCNSError(cns.GetNetworkContainerHistory, resp.Response.ReturnCode, resp.Response.Message); err != nil {
+		return nil, err
+	}
+
+	return resp.History, nil
+}
+
+// Watch waits for changes of network containers and IP address reservations after the resume token of the request.
+// If the request has no timeout, it waits for half of the request timeout of the client.
+func (cnsClient *CNSClient) Watch(ctx context.Context, req *cns.WatchRequest) (*cns.WatchResponse, error) {
+	var resp cns.WatchResponse
+
+	if req.TimeoutInSeconds == 0 && req.ResumeToken != "" {
+		reqCopy := *req
+		reqCopy.TimeoutInSeconds = int(cnsClient.httpClient.Timeout.Seconds() / 2)
+		if reqCopy.TimeoutInSeconds < 1 {
+			reqCopy.TimeoutInSeconds = 1
+		}
+		req = &reqCopy
+	}
+
+	if err := cnsClient.call(ctx, cns.Watch, req, &resp); err != nil {
+		return nil, err
+	}
+
+	if err := newCNSError(cns.Watch, resp.Response.ReturnCode, resp.Response.Message); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// GetNetworkContainerStatus returns the status of a network container.
+func (cnsClient *CNSClient) GetNetworkContainerStatus(ctx context.Context, networkContainerID string) (*cns.GetNetworkContainerStatusResponse, error) {
+	var resp cns.GetNetworkContainerStatusResponse
+
+	req := &cns.GetNetworkContainerStatusRequest{NetworkContainerid: networkContainerID}
+	if err := cnsClient.call(ctx, cns.GetNetworkContainerStatus, req, &resp); err != nil {
+		return nil, err
 	}
 
+	if err := newCNSError(cns.GetNetworkContainerStatus, resp.Response.ReturnCode, resp.Response.Message); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// GetInterfaceForContainer returns the interface of a network container.
+func (cnsClient *CNSClient) GetInterfaceForContainer(ctx context.Context, networkContainerID string) (*cns.GetInterfaceForContainerResponse, error) {
+	var resp cns.GetInterfaceForContainerResponse
+
+	req := &cns.GetInterfaceForContainerRequest{NetworkContainerID: networkContainerID}
+	if err := cnsClient.call(ctx, cns.GetInterfaceForContainer, req, &resp); err != nil {
+		return nil, err
+	}
+
+	if err := newCNSError(cns.GetInterfaceForContainer, resp.Response.ReturnCode, resp.Response.Message); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// GetNetworkConfiguration Request to get network config.
+func (cnsClient *CNSClient) GetNetworkConfiguration(ctx context.Context, orchestratorContext []byte) (*cns.GetNetworkContainerResponse, error) {
 	var resp cns.GetNetworkContainerResponse
 
-	err = json.NewDecoder(res.Body).Decode(&resp)
-	if err != nil {
-		log.Errorf("[Azure CNSClient] Error received while parsing GetNetworkConfiguration response resp:%v err:%v", res.Body, err.Error())
+	req := &cns.GetNetworkContainerRequest{OrchestratorContext: orchestratorContext}
+	if err := cnsClient.call(ctx, cns.GetNetworkContainerByOrchestratorContext, req, &resp); err != nil {
 		return nil, err
 	}
 
-	if resp.Response.ReturnCode != 0 {
+	if err := newCNSError(cns.GetNetworkContainerByOrchestratorContext, resp.Response.ReturnCode, resp.Response.Message); err != nil {
 		log.Errorf("[Azure CNSClient] GetNetworkConfiguration received error response :%v", resp.Response.Message)
-		return nil, fmt.Errorf(resp.Response.Message)
+		return nil, err
 	}
 
 	return &resp, nil
 }
 
-// CreateHostNCApipaEndpoint creates an endpoint in APIPA network for host container connectivity.
-func (cnsClient *CNSClient) CreateHostNCApipaEndpoint(
-	networkContainerID string) (string, error) {
-	var (
-		err  error
-		body bytes.Buffer
-	)
+// GetNetworkConfigurations returns the network configurations of all network containers of an orchestrator context,
+// in interface order. The first one is the primary network container.
+func (cnsClient *CNSClient) GetNetworkConfigurations(ctx context.Context, orchestratorContext []byte) ([]cns.GetNetworkContainerResponse, error) {
+	var resp cns.GetNetworkContainersResponse
+
+	req := &cns.GetNetworkContainerRequest{OrchestratorContext: orchestratorContext}
+	if err := cnsClient.call(ctx, cns.GetNetworkContainersByOrchestratorContext, req, &resp); err != nil {
+		return nil, err
+	}
+
+	if err := newCNSError(cns.GetNetworkContainersByOrchestratorContext, resp.Response.ReturnCode, resp.Response.Message); err != nil {
+		log.Errorf("[Azure CNSClient] GetNetworkConfigurations received error response :%v", resp.Response.Message)
+		return nil, err
+	}
+
+	return resp.NetworkContainers, nil
+}
 
-	httpc := &http.Client{}
-	url := cnsClient.connectionURL + cns.CreateHostNCApipaEndpointPath
-	log.Printf("CreateHostNCApipaEndpoint url: %v for NC: %s", url, networkContainerID)
+// AttachContainerToNetwork attaches a container to the network of a network container.
+func (cnsClient *CNSClient) AttachContainerToNetwork(ctx context.Context, req *cns.ConfigureContainerNetworkingRequest) error {
+	var resp cns.AttachContainerToNetworkResponse
 
-	payload := &cns.CreateHostNCApipaEndpointRequest{
-		NetworkContainerID: networkContainerID,
+	if err := cnsClient.call(ctx, cns.AttachContainerToNetwork, req, &resp); err != nil {
+		return err
 	}
 
-	if err = json.NewEncoder(&body).Encode(payload); err != nil {
-		log.Errorf("encoding json failed with %v", err)
-		return "", err
+	return newCNSError(cns.AttachContainerToNetwork, resp.Response.ReturnCode, resp.Response.Message)
+}
+
+// DetachContainerFromNetwork detaches a container from the network of a network container.
+func (cnsClient *CNSClient) DetachContainerFromNetwork(ctx context.Context, req *cns.ConfigureContainerNetworkingRequest) error {
+	var resp cns.DetachContainerFromNetworkResponse
+
+	if err := cnsClient.call(ctx, cns.DetachContainerFromNetwork, req, &resp); err != nil {
+		return err
 	}
 
-	res, err := httpc.Post(url, "application/json", &body)
-	if err != nil {
-		log.Errorf("[Azure CNSClient] HTTP Post returned error %v", err.Error())
-		return "", err
+	return newCNSError(cns.DetachContainerFromNetwork, resp.Response.ReturnCode, resp.Response.Message)
+}
+
+// PublishNetworkContainer publishes a network container via NMAgent.
+// The response carries the status and body returned by NMAgent.
+func (cnsClient *CNSClient) PublishNetworkContainer(ctx context.Context, req *cns.PublishNetworkContainerRequest) (*cns.PublishNetworkContainerResponse, error) {
+	var resp cns.PublishNetworkContainerResponse
+
+	if err := cnsClient.call(ctx, cns.PublishNetworkContainer, req, &resp); err != nil {
+		return nil, err
 	}
 
-	defer res.Body.Close()
+	return &resp, newCNSError(cns.PublishNetworkContainer, resp.Response.ReturnCode, resp.Response.Message)
+}
 
-	if res.StatusCode != http.StatusOK {
-		errMsg := fmt.Sprintf("[Azure CNSClient] CreateHostNCApipaEndpoint: Invalid http status code: %v",
-			res.StatusCode)
-		log.Errorf(errMsg)
-		return "", fmt.Errorf(errMsg)
+// UnpublishNetworkContainer unpublishes a network container via NMAgent.
+// The response carries the status and body returned by NMAgent.
+func (cnsClient *CNSClient) UnpublishNetworkContainer(ctx context.Context, req *cns.UnpublishNetworkContainerRequest) (*cns.UnpublishNetworkContainerResponse, error) {
+	var resp cns.UnpublishNetworkContainerResponse
+
+	if err := cnsClient.call(ctx, cns.UnpublishNetworkContainer, req, &resp); err != nil {
+		return nil, err
 	}
 
+	return &resp, newCNSError(cns.UnpublishNetworkContainer, resp.Response.ReturnCode, resp.Response.Message)
+}
+
+// CreateHostNCApipaEndpoint creates an endpoint in APIPA network for host container connectivity.
+func (cnsClient *CNSClient) CreateHostNCApipaEndpoint(
+	ctx context.Context,
+	networkContainerID string) (string, error) {
 	var resp cns.CreateHostNCApipaEndpointResponse
 
-	if err = json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		log.Errorf("[Azure CNSClient] Error parsing CreateHostNCApipaEndpoint response resp: %v err: %v",
-			res.Body, err.Error())
+	log.Printf("CreateHostNCApipaEndpoint for NC: %s", networkContainerID)
+
+	req := &cns.CreateHostNCApipaEndpointRequest{NetworkContainerID: networkContainerID}
+	if err := cnsClient.call(ctx, cns.CreateHostNCApipaEndpointPath, req, &resp); err != nil {
 		return "", err
 	}
 
-	if resp.Response.ReturnCode != 0 {
+	if err := newCNSError(cns.CreateHostNCApipaEndpointPath, resp.Response.ReturnCode, resp.Response.Message); err != nil {
 		log.Errorf("[Azure CNSClient] CreateHostNCApipaEndpoint received error response :%v", resp.Response.Message)
-		return "", fmt.Errorf(resp.Response.Message)
+		return "", err
 	}
 
 	return resp.EndpointID, nil
 }
 
 // DeleteHostNCApipaEndpoint deletes the endpoint in APIPA network created for host container connectivity.
-func (cnsClient *CNSClient) DeleteHostNCApipaEndpoint(networkContainerID string) error {
-	var body bytes.Buffer
-
-	httpc := &http.Client{}
-	url := cnsClient.connectionURL + cns.DeleteHostNCApipaEndpointPath
-	log.Printf("DeleteHostNCApipaEndpoint url: %v for NC: %s", url, networkContainerID)
+func (cnsClient *CNSClient) DeleteHostNCApipaEndpoint(ctx context.Context, networkContainerID string) error {
+	var resp cns.DeleteHostNCApipaEndpointResponse
 
-	payload := &cns.DeleteHostNCApipaEndpointRequest{
-		NetworkContainerID: networkContainerID,
-	}
+	log.Printf("DeleteHostNCApipaEndpoint for NC: %s", networkContainerID)
 
-	err := json.NewEncoder(&body).Encode(payload)
-	if err != nil {
-		log.Errorf("encoding json failed with %v", err)
+	req := &cns.DeleteHostNCApipaEndpointRequest{NetworkContainerID: networkContainerID}
+	if err := cnsClient.call(ctx, cns.DeleteHostNCApipaEndpointPath, req, &resp); err != nil {
 		return err
 	}
 
-	res, err := httpc.Post(url, "application/json", &body)
-	if err != nil {
-		log.Errorf("[Azure CNSClient] HTTP Post returned error %v", err.Error())
+	if err := newCNSError(cns.DeleteHostNCApipaEndpointPath, resp.Response.ReturnCode, resp.Response.Message); err != nil {
+		log.Errorf("[Azure CNSClient] DeleteHostNCApipaEndpoint received error response :%v", resp.Response.Message)
 		return err
 	}
 
-	defer res.Body.Close()
+	return nil
+}
 
-	if res.StatusCode != http.StatusOK {
-		errMsg := fmt.Sprintf("[Azure CNSClient] DeleteHostNCApipaEndpoint: Invalid http status code: %v",
-			res.StatusCode)
-		log.Errorf(errMsg)
-		return fmt.Errorf(errMsg)
+//
+// Debug API
+//
+
+// GetDebugState returns the state of CNS with authorization tokens redacted.
+func (cnsClient *CNSClient) GetDebugState(ctx context.Context) (*cns.DebugStateResponse, error) {
+	var resp cns.DebugStateResponse
+
+	if err := cnsClient.call(ctx, cns.DebugStatePath, nil, &resp); err != nil {
+		return nil, err
 	}
 
-	var resp cns.DeleteHostNCApipaEndpointResponse
+	if err := newCNSError(cns.DebugStatePath, resp.Response.ReturnCode, resp.Response.Message); err != nil {
+		return nil, err
+	}
 
-	err = json.NewDecoder(res.Body).Decode(&resp)
-	if err != nil {
-		log.Errorf("[Azure CNSClient] Error parsing DeleteHostNCApipaEndpoint response resp: %v err: %v",
-			res.Body, err.Error())
-		return err
+	return &resp, nil
+}
+
+// GetDebugIPAddressUtilization returns the utilization of the address pool of the primary interface.
+func (cnsClient *CNSClient) GetDebugIPAddressUtilization(ctx context.Context) (*cns.DebugIPAddressUtilizationResponse, error) {
+	var resp cns.DebugIPAddressUtilizationResponse
+
+	if err := cnsClient.call(ctx, cns.DebugIPAddressUtilizationPath, nil, &resp); err != nil {
+		return nil, err
 	}
 
-	if resp.Response.ReturnCode != 0 {
-		log.Errorf("[Azure CNSClient] DeleteHostNCApipaEndpoint received error response :%v", resp.Response.Message)
-		return fmt.Errorf(resp.Response.Message)
+	if err := newCNSError(cns.DebugIPAddressUtilizationPath, resp.Response.ReturnCode, resp.Response.Message); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return &resp, nil
 }
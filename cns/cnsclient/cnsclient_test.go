@@ -0,0 +1,118 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package cnsclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+)
+
+// Returns a handler that responds to every request with the given response.
+func newTestHandler(t *testing.T, method string, path string, response interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method || r.URL.Path != path {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+}
+
+// TestTypedErrors tests if CNS return codes are returned as typed errors.
+func TestTypedErrors(t *testing.T) {
+	server := httptest.NewServer(newTestHandler(t, http.MethodPost, cns.GetNetworkContainerStatus,
+		&cns.GetNetworkContainerStatusResponse{Response: cns.Response{ReturnCode: cns.UnknownContainerID, Message: "unknown"}}))
+	defer server.Close()
+
+	client, err := NewCnsClient(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("NewCnsClient failed %+v", err)
+	}
+
+	_, err = client.GetNetworkContainerStatus(context.Background(), "nc1")
+	if !errors.Is(err, ErrUnknownContainerID) || errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error %+v", err)
+	}
+
+	var cnsErr *CNSError
+	if !errors.As(err, &cnsErr) || cnsErr.Message != "unknown" {
+		t.Errorf("unexpected error %+v", err)
+	}
+}
+
+// TestUnixSocket tests if requests are sent on a unix socket.
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cnsclient")
+	if err != nil {
+		t.Fatalf("TempDir failed %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "cns.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Listen failed %+v", err)
+	}
+
+	server := &http.Server{Handler: newTestHandler(t, http.MethodGet, cns.NumberOfCPUCoresPath,
+		&cns.NumOfCPUCoresResponse{NumOfCPUCores: 4})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	client, err := NewCnsClient(&Config{URL: "unix://" + socketPath})
+	if err != nil {
+		t.Fatalf("NewCnsClient failed %+v", err)
+	}
+
+	cores, err := client.GetNumberOfCPUCores(context.Background())
+	if err != nil || cores != 4 {
+		t.Errorf("unexpected response %d %+v", cores, err)
+	}
+}
+
+// TestRetry tests if requests are retried until CNS accepts connections.
+func TestRetry(t *testing.T) {
+	// Reserve a port, then release it so that the first attempts fail to connect.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed %+v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	client, err := NewCnsClient(&Config{URL: "http://" + address, Retries: 10, RetryDelay: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewCnsClient failed %+v", err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		if listener, err := net.Listen("tcp", address); err == nil {
+			http.Serve(listener, newTestHandler(t, http.MethodGet, cns.GetHostLocalIPPath,
+				&cns.HostLocalIPAddressResponse{IPAddress: "10.0.0.4"}))
+		}
+	}()
+
+	ip, err := client.GetHostLocalIP(context.Background())
+	if err != nil || ip != "10.0.0.4" {
+		t.Errorf("unexpected response %s %+v", ip, err)
+	}
+
+	// Cancelled requests are not retried.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = client.GetHostLocalIP(ctx); err == nil {
+		t.Errorf("cancelled request should fail")
+	}
+}
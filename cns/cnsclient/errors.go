@@ -0,0 +1,75 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package cnsclient
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-container-networking/cns"
+)
+
+// Errors that CNS return codes map to. Use errors.Is to test a CNSError against them.
+var (
+	ErrUnsupportedNetworkType          = &CNSError{ReturnCode: cns.UnsupportedNetworkType}
+	ErrInvalidParameter                = &CNSError{ReturnCode: cns.InvalidParameter}
+	ErrUnsupportedEnvironment          = &CNSError{ReturnCode: cns.UnsupportedEnvironment}
+	ErrUnreachableHost                 = &CNSError{ReturnCode: cns.UnreachableHost}
+	ErrReservationNotFound             = &CNSError{ReturnCode: cns.ReservationNotFound}
+	ErrMalformedSubnet                 = &CNSError{ReturnCode: cns.MalformedSubnet}
+	ErrUnreachableDockerDaemon         = &CNSError{ReturnCode: cns.UnreachableDockerDaemon}
+	ErrUnspecifiedNetworkName          = &CNSError{ReturnCode: cns.UnspecifiedNetworkName}
+	ErrNotFound                        = &CNSError{ReturnCode: cns.NotFound}
+	ErrAddressUnavailable              = &CNSError{ReturnCode: cns.AddressUnavailable}
+	ErrNetworkContainerNotSpecified    = &CNSError{ReturnCode: cns.NetworkContainerNotSpecified}
+	ErrCallToHostFailed                = &CNSError{ReturnCode: cns.CallToHostFailed}
+	ErrUnknownContainerID              = &CNSError{ReturnCode: cns.UnknownContainerID}
+	ErrUnsupportedOrchestratorType     = &CNSError{ReturnCode: cns.UnsupportedOrchestratorType}
+	ErrDockerContainerNotSpecified     = &CNSError{ReturnCode: cns.DockerContainerNotSpecified}
+	ErrUnsupportedVerb                 = &CNSError{ReturnCode: cns.UnsupportedVerb}
+	ErrUnsupportedNetworkContainerType = &CNSError{ReturnCode: cns.UnsupportedNetworkContainerType}
+	ErrInvalidRequest                  = &CNSError{ReturnCode: cns.InvalidRequest}
+	ErrNetworkJoinFailed               = &CNSError{ReturnCode: cns.NetworkJoinFailed}
+	ErrNetworkContainerPublishFailed   = &CNSError{ReturnCode: cns.NetworkContainerPublishFailed}
+	ErrNetworkContainerUnpublishFailed = &CNSError{ReturnCode: cns.NetworkContainerUnpublishFailed}
+	ErrNetworkContainerVersionConflict = &CNSError{ReturnCode: cns.NetworkContainerVersionConflict}
+	ErrUnexpectedError                 = &CNSError{ReturnCode: cns.UnexpectedError}
+)
+
+// CNSError is the error returned when CNS responds with a non-zero return code.
+type CNSError struct {
+	Path       string
+	ReturnCode int
+	Message    string
+}
+
+// Error returns the error message.
+func (e *CNSError) Error() string {
+	return fmt.Sprintf("[Azure CNSClient] %s failed with return code %d: %s", e.Path, e.ReturnCode, e.Message)
+}
+
+// Is returns whether the error has the same return code as the target.
+func (e *CNSError) Is(target error) bool {
+	t, ok := target.(*CNSError)
+	return ok && t.ReturnCode == e.ReturnCode
+}
+
+// HTTPError is the error returned when CNS responds with a non-OK HTTP status code.
+type HTTPError struct {
+	Path       string
+	StatusCode int
+}
+
+// Error returns the error message.
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("[Azure CNSClient] %s invalid http status code: %d", e.Path, e.StatusCode)
+}
+
+// Returns the error of a CNS response, or nil if the request succeeded.
+func newCNSError(path string, returnCode int, message string) error {
+	if returnCode == cns.Success {
+		return nil
+	}
+
+	return &CNSError{Path: path, ReturnCode: returnCode, Message: message}
+}
@@ -4,6 +4,7 @@
 package common
 
 import (
+	"crypto/tls"
 	"errors"
 
 	"github.com/Azure/azure-container-networking/cns/logger"
@@ -35,6 +36,15 @@ type ServiceConfig struct {
 	Listener *acn.Listener
 	ErrChan  chan error
 	Store    store.KeyValueStore
+	// TLS configuration and authorizer of the listener, if it is created by the service.
+	TLSConfig  *tls.Config
+	Authorizer acn.Authorizer
+	// Serve the Go profiling endpoints.
+	EnableProfiling bool
+	// Realize Docker and Basic network containers in namespaces of their own on Linux.
+	ManageNetworkContainerNamespaces bool
+	// Timeouts of the HTTP server of the listener, if it is created by the service.
+	ServerTimeouts acn.ServerTimeouts
 }
 
 // NewService creates a new Service object.
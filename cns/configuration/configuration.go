@@ -2,21 +2,136 @@
 package configuration
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/Azure/azure-container-networking/cns/logger"
 	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/platform"
 )
 
 const (
 	defaultConfigName = "cns_config.json"
+
+	// Environment variable of the config file path.
+	configPathEnv = "CNS_CONFIGURATION_PATH"
+
+	// Default CNI network config file name.
+	defaultCNINetworkConfigFileName = "10-azure.conflist"
 )
 
+// CNSConfig holds every setting of CNS. Settings are read from the config file, and are overridden by
+// environment variables, which are overridden by command line arguments.
 type CNSConfig struct {
-	TelemetrySettings TelemetrySettings
+	ServiceSettings     ServiceSettings
+	LogSettings         LogSettings
+	PluginSettings      PluginSettings
+	HTTPClientSettings  HTTPClientSettings
+	HTTPServerSettings  HTTPServerSettings
+	TelemetrySettings   TelemetrySettings
+	TLSSettings         TLSSettings
+	AuthorizationPolicy AuthorizationPolicy
+	DebugSettings       DebugSettings
+}
+
+type ServiceSettings struct {
+	// Operating environment, "azure", "mas" or "fileIpam".
+	Environment string
+	// URL for CNS to listen on.
+	CnsURL string
+	// URL of the API server of the CNM plugins.
+	APIServerURL string
+	// Directory of the store file.
+	StoreFileLocation string
+	// Flag to start the Azure CNM plugins.
+	StartAzureCNM bool
+	// Type of the default external network created on Windows, "l2bridge" or "l2tunnel".
+	CreateDefaultExtNetworkType string
+	// Flag to realize Docker and Basic network containers in namespaces of their own on Linux. Enable it only
+	// if no orchestrator configures the network containers, e.g. CNI multitenancy. It enables IP forwarding on the host.
+	ManageNetworkContainerNamespaces bool
+}
+
+type LogSettings struct {
+	// Logging level, "info" or "debug".
+	LogLevel string
+	// Logging target, "syslog", "stderr", "logfile", "stdout" or "stdoutfile".
+	LogTarget string
+	// Directory of the log files.
+	LogLocation string
+}
+
+type PluginSettings struct {
+	// Absolute path of the directory of the network plugin binaries.
+	NetPluginPath string
+	// Absolute path of the network plugin config file.
+	NetPluginConfigFile string
+	// IPAM query URL and interval of the CNM IPAM plugin.
+	IpamQueryURL            string
+	IpamQueryIntervalInSecs int
+}
+
+type HTTPClientSettings struct {
+	// Connection timeout of the HTTP clients of CNS.
+	ConnectionTimeoutInSecs int
+	// Response header timeout of the HTTP clients of CNS.
+	ResponseHeaderTimeoutInSecs int
+}
+
+type HTTPServerSettings struct {
+	// Timeouts of reading the headers and the whole of requests.
+	ReadHeaderTimeoutInSecs int
+	ReadTimeoutInSecs       int
+	// Timeout of writing responses. It must exceed the timeout of watch requests, 5 minutes at most.
+	WriteTimeoutInSecs int
+	// Timeout of idle keep-alive connections.
+	IdleTimeoutInSecs int
+	// Time given to requests in flight to complete on shutdown.
+	ShutdownTimeoutInSecs int
+}
+
+type DebugSettings struct {
+	// Flag to serve the Go profiling endpoints under /debug/pprof/.
+	EnableProfiling bool
+}
+
+type TLSSettings struct {
+	// Paths of the server certificate and key in PEM format. The API is served over TLS if both are set.
+	CertificateFile string
+	KeyFile         string
+	// Path of the CA certificates in PEM format that client certificates must be signed by.
+	// Client certificates are not required if empty.
+	ClientCAFile string
+}
+
+// AuthorizationPolicy maps CNS API paths to the identities allowed to call them.
+type AuthorizationPolicy struct {
+	// Flag to enable the authorization of API requests.
+	Enabled bool
+	// Identities allowed to call paths that only read state.
+	ReadIdentities []Identity
+	// Identities allowed to call paths that change state. They are allowed to call read paths too.
+	MutateIdentities []Identity
+	// Access, "read" or "mutate", of paths that overrides the default access of CNS API paths.
+	Paths map[string]string
+}
+
+// Identity describes callers of the CNS API. A caller matches if it matches every field that is set.
+type Identity struct {
+	// User and group IDs of the peer process of a unix socket.
+	UID *uint32
+	GID *uint32
+	// Common name of a verified client certificate.
+	CommonName string
 }
 
 type TelemetrySettings struct {
@@ -44,32 +159,275 @@ type TelemetrySettings struct {
 	SnapshotIntervalInMins int
 }
 
-// This functions reads cns config file and save it in a structure
-func ReadConfig() (CNSConfig, error) {
+// GetConfigPath returns the path of the config file, set by the environment or next to the executable.
+func GetConfigPath() (string, error) {
+	if configPath, found := os.LookupEnv(configPathEnv); found {
+		return configPath, nil
+	}
+
+	dir, err := common.GetExecutableDirectory()
+	if err != nil {
+		return "", fmt.Errorf("Failed to find exe dir: %v", err)
+	}
+
+	return filepath.Join(dir, defaultConfigName), nil
+}
+
+// ReadConfigFile reads the config file. Unknown settings and settings of the wrong type are errors.
+func ReadConfigFile(configPath string) (CNSConfig, error) {
 	var cnsConfig CNSConfig
 
-	// Check if env set for config path otherwise use default path
-	configpath, found := os.LookupEnv("CNS_CONFIGURATION_PATH")
-	if !found {
-		dir, err := common.GetExecutableDirectory()
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return cnsConfig, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&cnsConfig); err != nil {
+		return cnsConfig, fmt.Errorf("Invalid config file %s: %v", configPath, err)
+	}
+
+	return cnsConfig, nil
+}
+
+// LoadConfig reads the config file if it exists, applies the settings given by environment variables and
+// command line arguments, sets defaults and validates the result.
+func LoadConfig(configPath string, lookupEnv, lookupArg func(string) (string, bool)) (CNSConfig, error) {
+	cnsConfig, err := ReadConfigFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return cnsConfig, err
+	}
+
+	if err = applyOverrides(&cnsConfig, lookupEnv, lookupArg); err != nil {
+		return cnsConfig, err
+	}
+
+	SetCNSConfigDefaults(&cnsConfig)
+
+	return cnsConfig, cnsConfig.Validate()
+}
+
+// override binds a setting to an environment variable and a command line argument.
+type override struct {
+	env string
+	arg string
+	set func(config *CNSConfig, value string) error
+}
+
+// Settings that can be given by environment variables and command line arguments.
+var overrides = []override{
+	{"CNS_ENVIRONMENT", common.OptEnvironment, setString(func(c *CNSConfig) *string { return &c.ServiceSettings.Environment })},
+	{"CNS_URL", common.OptCnsURL, setString(func(c *CNSConfig) *string { return &c.ServiceSettings.CnsURL })},
+	{"CNS_API_SERVER_URL", common.OptAPIServerURL, setString(func(c *CNSConfig) *string { return &c.ServiceSettings.APIServerURL })},
+	{"CNS_STORE_FILE_LOCATION", common.OptStoreFileLocation, setString(func(c *CNSConfig) *string { return &c.ServiceSettings.StoreFileLocation })},
+	{"CNS_START_AZURE_CNM", common.OptStartAzureCNM, setBool(func(c *CNSConfig) *bool { return &c.ServiceSettings.StartAzureCNM })},
+	{"CNS_CREATE_DEFAULT_EXT_NETWORK_TYPE", common.OptCreateDefaultExtNetworkType, setString(func(c *CNSConfig) *string { return &c.ServiceSettings.CreateDefaultExtNetworkType })},
+	{"CNS_LOG_LEVEL", common.OptLogLevel, setString(func(c *CNSConfig) *string { return &c.LogSettings.LogLevel })},
+	{"CNS_LOG_TARGET", common.OptLogTarget, setString(func(c *CNSConfig) *string { return &c.LogSettings.LogTarget })},
+	{"CNS_LOG_LOCATION", common.OptLogLocation, setString(func(c *CNSConfig) *string { return &c.LogSettings.LogLocation })},
+	{"CNS_NET_PLUGIN_PATH", common.OptNetPluginPath, setString(func(c *CNSConfig) *string { return &c.PluginSettings.NetPluginPath })},
+	{"CNS_NET_PLUGIN_CONFIG_FILE", common.OptNetPluginConfigFile, setString(func(c *CNSConfig) *string { return &c.PluginSettings.NetPluginConfigFile })},
+	{"CNS_IPAM_QUERY_URL", common.OptIpamQueryUrl, setString(func(c *CNSConfig) *string { return &c.PluginSettings.IpamQueryURL })},
+	{"CNS_IPAM_QUERY_INTERVAL", common.OptIpamQueryInterval, setInt(func(c *CNSConfig) *int { return &c.PluginSettings.IpamQueryIntervalInSecs })},
+	{"CNS_HTTP_CONNECTION_TIMEOUT", common.OptHttpConnectionTimeout, setInt(func(c *CNSConfig) *int { return &c.HTTPClientSettings.ConnectionTimeoutInSecs })},
+	{"CNS_HTTP_RESPONSE_HEADER_TIMEOUT", common.OptHttpResponseHeaderTimeout, setInt(func(c *CNSConfig) *int { return &c.HTTPClientSettings.ResponseHeaderTimeoutInSecs })},
+}
+
+func setString(field func(*CNSConfig) *string) func(*CNSConfig, string) error {
+	return func(config *CNSConfig, value string) error {
+		*field(config) = value
+		return nil
+	}
+}
+
+func setBool(field func(*CNSConfig) *bool) func(*CNSConfig, string) error {
+	return func(config *CNSConfig, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		*field(config) = b
+		return nil
+	}
+}
+
+func setInt(field func(*CNSConfig) *int) func(*CNSConfig, string) error {
+	return func(config *CNSConfig, value string) error {
+		i, err := strconv.Atoi(value)
 		if err != nil {
-			logger.Errorf("[Configuration] Failed to find exe dir:%v", err)
-			return cnsConfig, err
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*field(config) = i
+		return nil
+	}
+}
+
+// applyOverrides applies the settings given by environment variables, then those given by command line arguments.
+func applyOverrides(config *CNSConfig, lookupEnv, lookupArg func(string) (string, bool)) error {
+	for _, o := range overrides {
+		if value, found := lookupEnv(o.env); found {
+			if err := o.set(config, value); err != nil {
+				return fmt.Errorf("Invalid environment variable %s: %v", o.env, err)
+			}
 		}
+	}
 
-		configpath = filepath.Join(dir, defaultConfigName)
+	for _, o := range overrides {
+		if value, found := lookupArg(o.arg); found {
+			if err := o.set(config, value); err != nil {
+				return fmt.Errorf("Invalid argument %s: %v", o.arg, err)
+			}
+		}
 	}
 
-	logger.Printf("[Configuration] Config path:%s", configpath)
+	return nil
+}
 
-	content, err := ioutil.ReadFile(configpath)
-	if err != nil {
-		logger.Errorf("[Configuration] Failed to read config file :%v", err)
-		return cnsConfig, err
+// GetLogLevel returns the log level of the logger.
+func (settings *LogSettings) GetLogLevel() int {
+	if settings.LogLevel == common.OptLogLevelDebug {
+		return log.LevelDebug
 	}
+	return log.LevelInfo
+}
 
-	err = json.Unmarshal(content, &cnsConfig)
-	return cnsConfig, err
+// GetLogTarget returns the log target of the logger.
+func (settings *LogSettings) GetLogTarget() int {
+	switch settings.LogTarget {
+	case common.OptLogTargetSyslog:
+		return log.TargetSyslog
+	case common.OptLogTargetStderr:
+		return log.TargetStderr
+	case common.OptLogStdout:
+		return log.TargetStdout
+	case common.OptLogMultiWrite:
+		return log.TargetStdOutAndLogFile
+	default:
+		return log.TargetLogfile
+	}
+}
+
+// Validate returns an error listing every invalid setting.
+func (config *CNSConfig) Validate() error {
+	var errs []string
+	check := func(valid bool, setting string, format string, args ...interface{}) {
+		if !valid {
+			errs = append(errs, setting+": "+fmt.Sprintf(format, args...))
+		}
+	}
+	oneOf := func(value string, values ...string) bool {
+		for _, v := range values {
+			if value == v {
+				return true
+			}
+		}
+		return false
+	}
+	validURL := func(value string) bool {
+		if value == "" {
+			return true
+		}
+		u, err := url.Parse(value)
+		return err == nil && u.Scheme != ""
+	}
+
+	service := config.ServiceSettings
+	check(oneOf(service.Environment, common.OptEnvironmentAzure, common.OptEnvironmentMAS, common.OptEnvironmentFileIpam),
+		"ServiceSettings.Environment", "%q is not one of azure, mas, fileIpam", service.Environment)
+	check(validURL(service.CnsURL), "ServiceSettings.CnsURL", "%q is not a URL", service.CnsURL)
+	check(validURL(service.APIServerURL), "ServiceSettings.APIServerURL", "%q is not a URL", service.APIServerURL)
+	check(oneOf(strings.ToLower(service.CreateDefaultExtNetworkType), "", "l2bridge", "l2tunnel"),
+		"ServiceSettings.CreateDefaultExtNetworkType", "%q is not one of l2bridge, l2tunnel", service.CreateDefaultExtNetworkType)
+
+	logSettings := config.LogSettings
+	check(oneOf(logSettings.LogLevel, common.OptLogLevelInfo, common.OptLogLevelDebug),
+		"LogSettings.LogLevel", "%q is not one of info, debug", logSettings.LogLevel)
+	check(oneOf(logSettings.LogTarget, common.OptLogTargetSyslog, common.OptLogTargetStderr, common.OptLogTargetFile,
+		common.OptLogStdout, common.OptLogMultiWrite),
+		"LogSettings.LogTarget", "%q is not one of syslog, stderr, logfile, stdout, stdoutfile", logSettings.LogTarget)
+
+	plugin := config.PluginSettings
+	check(validURL(plugin.IpamQueryURL), "PluginSettings.IpamQueryURL", "%q is not a URL", plugin.IpamQueryURL)
+	check(plugin.IpamQueryIntervalInSecs >= 0, "PluginSettings.IpamQueryIntervalInSecs", "must not be negative")
+
+	httpClient := config.HTTPClientSettings
+	check(httpClient.ConnectionTimeoutInSecs > 0, "HTTPClientSettings.ConnectionTimeoutInSecs", "must be positive")
+	check(httpClient.ResponseHeaderTimeoutInSecs > 0, "HTTPClientSettings.ResponseHeaderTimeoutInSecs", "must be positive")
+
+	httpServer := config.HTTPServerSettings
+	check(httpServer.ReadHeaderTimeoutInSecs > 0, "HTTPServerSettings.ReadHeaderTimeoutInSecs", "must be positive")
+	check(httpServer.ReadTimeoutInSecs > 0, "HTTPServerSettings.ReadTimeoutInSecs", "must be positive")
+	check(httpServer.WriteTimeoutInSecs > 0, "HTTPServerSettings.WriteTimeoutInSecs", "must be positive")
+	check(httpServer.IdleTimeoutInSecs > 0, "HTTPServerSettings.IdleTimeoutInSecs", "must be positive")
+	check(httpServer.ShutdownTimeoutInSecs > 0, "HTTPServerSettings.ShutdownTimeoutInSecs", "must be positive")
+
+	ts := config.TelemetrySettings
+	check(ts.TelemetryBatchSizeBytes > 0, "TelemetrySettings.TelemetryBatchSizeBytes", "must be positive")
+	check(ts.TelemetryBatchIntervalInSecs > 0, "TelemetrySettings.TelemetryBatchIntervalInSecs", "must be positive")
+	check(ts.HeartBeatIntervalInMins > 0, "TelemetrySettings.HeartBeatIntervalInMins", "must be positive")
+	check(ts.RefreshIntervalInSecs > 0, "TelemetrySettings.RefreshIntervalInSecs", "must be positive")
+	check(ts.SnapshotIntervalInMins > 0, "TelemetrySettings.SnapshotIntervalInMins", "must be positive")
+
+	tls := config.TLSSettings
+	check((tls.CertificateFile == "") == (tls.KeyFile == ""), "TLSSettings", "CertificateFile and KeyFile must be set together")
+	check(tls.ClientCAFile == "" || tls.CertificateFile != "", "TLSSettings.ClientCAFile", "requires CertificateFile and KeyFile")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Invalid config: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Settings that are applied without a restart when the config is reloaded.
+var reloadableSettings = map[string]bool{
+	"LogSettings.LogLevel":                      true,
+	"TelemetrySettings.DisableTrace":            true,
+	"TelemetrySettings.DisableMetric":           true,
+	"TelemetrySettings.DisableEvent":            true,
+	"TelemetrySettings.HeartBeatIntervalInMins": true,
+	"TelemetrySettings.SnapshotIntervalInMins":  true,
+}
+
+// RestartRequired returns the settings that changed between two configs and only take effect on restart.
+func RestartRequired(current, updated CNSConfig) []string {
+	var settings []string
+	currentValue := reflect.ValueOf(current)
+	updatedValue := reflect.ValueOf(updated)
+
+	for i := 0; i < currentValue.NumField(); i++ {
+		section := currentValue.Type().Field(i)
+		for j := 0; j < section.Type.NumField(); j++ {
+			name := section.Name + "." + section.Type.Field(j).Name
+			if reloadableSettings[name] {
+				continue
+			}
+
+			if !reflect.DeepEqual(currentValue.Field(i).Field(j).Interface(), updatedValue.Field(i).Field(j).Interface()) {
+				settings = append(settings, name)
+			}
+		}
+	}
+
+	return settings
+}
+
+// MergeReloadable returns the current config with the reloadable settings of the updated config.
+func MergeReloadable(current, updated CNSConfig) CNSConfig {
+	currentValue := reflect.ValueOf(&current).Elem()
+	updatedValue := reflect.ValueOf(updated)
+
+	for i := 0; i < currentValue.NumField(); i++ {
+		section := currentValue.Type().Field(i)
+		for j := 0; j < section.Type.NumField(); j++ {
+			if reloadableSettings[section.Name+"."+section.Type.Field(j).Name] {
+				currentValue.Field(i).Field(j).Set(updatedValue.Field(i).Field(j))
+			}
+		}
+	}
+
+	return current
 }
 
 // set telmetry setting defaults
@@ -101,5 +459,72 @@ func setTelemetrySettingDefaults(telemetrySettings *TelemetrySettings) {
 
 // Set Default values of CNS config if not specified
 func SetCNSConfigDefaults(config *CNSConfig) {
+	if config.ServiceSettings.Environment == "" {
+		config.ServiceSettings.Environment = common.OptEnvironmentAzure
+	}
+
+	if config.ServiceSettings.StoreFileLocation == "" {
+		config.ServiceSettings.StoreFileLocation = platform.CNMRuntimePath
+	}
+
+	if config.LogSettings.LogLevel == "" {
+		config.LogSettings.LogLevel = common.OptLogLevelInfo
+	}
+
+	if config.LogSettings.LogTarget == "" {
+		config.LogSettings.LogTarget = common.OptLogTargetFile
+	}
+
+	if config.PluginSettings.NetPluginPath == "" {
+		config.PluginSettings.NetPluginPath = platform.K8SCNIRuntimePath
+	}
+
+	if config.PluginSettings.NetPluginConfigFile == "" {
+		config.PluginSettings.NetPluginConfigFile = platform.K8SNetConfigPath + string(os.PathSeparator) + defaultCNINetworkConfigFileName
+	}
+
+	if config.HTTPClientSettings.ConnectionTimeoutInSecs == 0 {
+		config.HTTPClientSettings.ConnectionTimeoutInSecs = 5
+	}
+
+	if config.HTTPClientSettings.ResponseHeaderTimeoutInSecs == 0 {
+		config.HTTPClientSettings.ResponseHeaderTimeoutInSecs = 120
+	}
+
+	setHTTPServerSettingDefaults(&config.HTTPServerSettings)
 	setTelemetrySettingDefaults(&config.TelemetrySettings)
 }
+
+// set HTTP server setting defaults
+func setHTTPServerSettingDefaults(settings *HTTPServerSettings) {
+	if settings.ReadHeaderTimeoutInSecs == 0 {
+		settings.ReadHeaderTimeoutInSecs = 10
+	}
+
+	if settings.ReadTimeoutInSecs == 0 {
+		settings.ReadTimeoutInSecs = 60
+	}
+
+	if settings.WriteTimeoutInSecs == 0 {
+		// Long enough for watch requests of the maximum timeout.
+		settings.WriteTimeoutInSecs = 360
+	}
+
+	if settings.IdleTimeoutInSecs == 0 {
+		settings.IdleTimeoutInSecs = 120
+	}
+
+	if settings.ShutdownTimeoutInSecs == 0 {
+		settings.ShutdownTimeoutInSecs = 30
+	}
+}
+
+// GetServerTimeouts returns the timeouts of the HTTP server.
+func (settings *HTTPServerSettings) GetServerTimeouts() common.ServerTimeouts {
+	return common.ServerTimeouts{
+		ReadHeaderTimeout: time.Duration(settings.ReadHeaderTimeoutInSecs) * time.Second,
+		ReadTimeout:       time.Duration(settings.ReadTimeoutInSecs) * time.Second,
+		WriteTimeout:      time.Duration(settings.WriteTimeoutInSecs) * time.Second,
+		IdleTimeout:       time.Duration(settings.IdleTimeoutInSecs) * time.Second,
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package configuration
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/common"
+)
+
+// Returns a lookup function of the given values.
+func lookup(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, found := values[name]
+		return value, found
+	}
+}
+
+// Writes a config file to a temporary directory and returns its path.
+func writeConfigFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "cns-config")
+	if err != nil {
+		t.Fatalf("TempDir failed %v", err)
+	}
+
+	path := filepath.Join(dir, defaultConfigName)
+	if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile failed %v", err)
+	}
+
+	return path
+}
+
+// TestLoadConfigPrecedence tests if environment variables override the config file and arguments override both.
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"ServiceSettings": {"CnsURL": "tcp://localhost:10090", "APIServerURL": "tcp://localhost:48080"},
+		"LogSettings": {"LogLevel": "debug", "LogTarget": "stderr"},
+		"TelemetrySettings": {"SnapshotIntervalInMins": 10}
+	}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	env := map[string]string{"CNS_URL": "tcp://localhost:10091", "CNS_LOG_LEVEL": "info"}
+	args := map[string]string{common.OptCnsURL: "tcp://localhost:10092", common.OptHttpConnectionTimeout: "7"}
+
+	config, err := LoadConfig(path, lookup(env), lookup(args))
+	if err != nil {
+		t.Fatalf("LoadConfig failed %v", err)
+	}
+
+	if config.ServiceSettings.CnsURL != "tcp://localhost:10092" ||
+		config.ServiceSettings.APIServerURL != "tcp://localhost:48080" ||
+		config.LogSettings.LogLevel != common.OptLogLevelInfo ||
+		config.LogSettings.LogTarget != common.OptLogTargetStderr ||
+		config.HTTPClientSettings.ConnectionTimeoutInSecs != 7 ||
+		config.TelemetrySettings.SnapshotIntervalInMins != 10 {
+		t.Fatalf("Unexpected config %+v", config)
+	}
+
+	// Unset settings have defaults.
+	if config.ServiceSettings.Environment != common.OptEnvironmentAzure ||
+		config.HTTPClientSettings.ResponseHeaderTimeoutInSecs != 120 ||
+		config.TelemetrySettings.HeartBeatIntervalInMins != 30 {
+		t.Fatalf("Defaults were not set %+v", config)
+	}
+
+	// A missing config file is not an error.
+	if _, err = LoadConfig(path+".missing", lookup(nil), lookup(nil)); err != nil {
+		t.Fatalf("LoadConfig without a config file failed %v", err)
+	}
+}
+
+// TestLoadConfigInvalid tests if invalid settings are reported.
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		content string
+		env     map[string]string
+		err     string
+	}{
+		{`{"LogSettings": {"Level": "debug"}}`, nil, `unknown field "Level"`},
+		{`{"TelemetrySettings": {"DisableAll": "yes"}}`, nil, "cannot unmarshal"},
+		{`{"LogSettings": {"LogLevel": "verbose"}}`, nil, "LogSettings.LogLevel"},
+		{`{"ServiceSettings": {"CnsURL": "localhost"}}`, nil, "ServiceSettings.CnsURL"},
+		{`{"TLSSettings": {"CertificateFile": "cert.pem"}}`, nil, "TLSSettings"},
+		{`{"TelemetrySettings": {"HeartBeatIntervalInMins": -1}}`, nil, "TelemetrySettings.HeartBeatIntervalInMins"},
+		{`{}`, map[string]string{"CNS_START_AZURE_CNM": "maybe"}, "CNS_START_AZURE_CNM"},
+	}
+
+	for _, test := range tests {
+		path := writeConfigFile(t, test.content)
+		_, err := LoadConfig(path, lookup(test.env), lookup(nil))
+		os.RemoveAll(filepath.Dir(path))
+
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Config %s: expected error containing %q, got %v", test.content, test.err, err)
+		}
+	}
+}
+
+// TestReload tests if only reloadable settings are merged and other changes are reported.
+func TestReload(t *testing.T) {
+	var current CNSConfig
+	SetCNSConfigDefaults(&current)
+
+	updated := current
+	updated.LogSettings.LogLevel = common.OptLogLevelDebug
+	updated.LogSettings.LogTarget = common.OptLogTargetStderr
+	updated.TelemetrySettings.SnapshotIntervalInMins = 5
+	updated.AuthorizationPolicy.Enabled = true
+
+	settings := RestartRequired(current, updated)
+	if !reflect.DeepEqual(settings, []string{"LogSettings.LogTarget", "AuthorizationPolicy.Enabled"}) {
+		t.Fatalf("Unexpected settings requiring a restart %v", settings)
+	}
+
+	merged := MergeReloadable(current, updated)
+	if merged.LogSettings.LogLevel != common.OptLogLevelDebug ||
+		merged.TelemetrySettings.SnapshotIntervalInMins != 5 ||
+		merged.LogSettings.LogTarget != current.LogSettings.LogTarget ||
+		merged.AuthorizationPolicy.Enabled {
+		t.Fatalf("Unexpected merged config %+v", merged)
+	}
+}
@@ -28,19 +28,16 @@ type DockerClient struct {
 }
 
 // NewDockerClient create a new docker client.
-func NewDockerClient(url string) (*DockerClient, error) {
+func NewDockerClient(url string, imdsClient *imdsclient.ImdsClient) (*DockerClient, error) {
 	return &DockerClient{
 		connectionURL: url,
-		imdsClient:    &imdsclient.ImdsClient{},
+		imdsClient:    imdsClient,
 	}, nil
 }
 
 // NewDefaultDockerClient create a new docker client.
 func NewDefaultDockerClient(imdsClient *imdsclient.ImdsClient) (*DockerClient, error) {
-	return &DockerClient{
-		connectionURL: defaultDockerConnectionURL,
-		imdsClient:    imdsClient,
-	}, nil
+	return NewDockerClient(defaultDockerConnectionURL, imdsClient)
 }
 
 // NetworkExists tries to retrieve a network from docker (if it exists).
@@ -1,11 +1,84 @@
 package hnsclient
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net"
+	"sort"
+	"strings"
 
 	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/Azure/azure-container-networking/cns/networkcontainers"
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/iptables"
+	"github.com/Azure/azure-container-networking/netlink"
+	"github.com/Azure/azure-container-networking/network"
+	"github.com/Azure/azure-container-networking/network/epcommon"
 )
 
+const (
+	// hostNCApipaEndpointName indicates the prefix for the name of the apipa endpoint used for
+	// the host container connectivity
+	hostNCApipaEndpointNamePrefix = "HostNCApipaEndpoint"
+
+	// Prefixes of the names of the host and NC sides of the apipa veth pair.
+	// Interface names are limited to 15 characters, so they are suffixed with a hash of the NC ID.
+	hostNCApipaHostVethPrefix = "apipah"
+	hostNCApipaNCVethPrefix   = "apipac"
+
+	// Prefix of the name of the iptables chain that enforces the ACLs of the apipa endpoint.
+	hostNCApipaChainPrefix = "AZURECNSAPIPA-"
+
+	// Suffix of the name of the chain in which updated ACLs are built before replacing the current chain.
+	hostNCApipaNewChainSuffix = "-NEW"
+
+	// Length of the NC ID hash in the interface and chain names.
+	hostNCApipaHashLength = 8
+
+	// protocolTCP indicates the TCP protocol identifier
+	protocolTCP = "6"
+
+	// protocolUDP indicates the UDP protocol identifier
+	protocolUDP = "17"
+
+	// protocolICMPv4 indicates the ICMPv4 protocol identifier
+	protocolICMPv4 = "1"
+
+	// aclPriority2000 indicates the ACL priority of 2000
+	aclPriority2000 = 2000
+
+	// aclPriority1000 indicates the ACL priority of 1000
+	aclPriority1000 = 1000
+
+	// aclPolicyType indicates a ACL policy
+	aclPolicyType = "ACLPolicy"
+
+	//signals a APIPA endpoint type
+	apipaEndpointType = "APIPA"
+)
+
+var (
+	// Named Lock for endpoint creation/deletion
+	namedLock = common.InitNamedLock()
+
+	// Names of the iptables protocols by protocol identifier.
+	iptablesProtocols = map[string]string{
+		protocolICMPv4: "icmp",
+		protocolTCP:    "tcp",
+		protocolUDP:    "udp",
+	}
+)
+
+// aclRule is an iptables rule in the ACL chain of the apipa endpoint.
+type aclRule struct {
+	priority uint16
+	match    string
+	target   string
+}
+
 // CreateDefaultExtNetwork creates the default ext network (if it doesn't exist already)
 // to create external switch on windows platform.
 // This is windows platform specific.
@@ -31,22 +104,440 @@ func DeleteHnsNetwork(networkName string) error {
 	return fmt.Errorf("DeleteHnsNetwork shouldn't be called for linux platform")
 }
 
-// CreateHostNCApipaEndpoint creates the endpoint in the apipa network
-// for host container connectivity
-// This is windows platform specific.
+func getHostNCApipaEndpointName(
+	networkContainerID string) string {
+	return hostNCApipaEndpointNamePrefix + "-" + networkContainerID
+}
+
+// getHostNCApipaNames returns the names of the host veth, the NC veth and the ACL chain of the apipa endpoint.
+func getHostNCApipaNames(
+	networkContainerID string) (string, string, string) {
+	hash := sha1.Sum([]byte(networkContainerID))
+	suffix := hex.EncodeToString(hash[:])[:hostNCApipaHashLength]
+
+	return hostNCApipaHostVethPrefix + suffix, hostNCApipaNCVethPrefix + suffix, hostNCApipaChainPrefix + suffix
+}
+
+// getIptablesPortMatch returns the multiport match of a comma separated list of ports and port ranges.
+func getIptablesPortMatch(option string, ports string) string {
+	ports = strings.Replace(strings.Replace(ports, " ", "", -1), "-", ":", -1)
+	return fmt.Sprintf("-m multiport --%s %s", option, ports)
+}
+
+// getAclRules translates an ACL policy setting to iptables rules, one per protocol.
+// Traffic out of the NC enters the host through the host veth, and traffic into the NC leaves through it.
+func getAclRules(
+	hostVethName string,
+	acl cns.ValidAclPolicySetting) ([]aclRule, error) {
+	var (
+		rules    []aclRule
+		target   string
+		localIf  string
+		localIP  string
+		remoteIP string
+		localPt  string
+		remotePt string
+	)
+
+	switch {
+	case strings.EqualFold(acl.Action, cns.ActionTypeAllow):
+		target = iptables.Accept
+	case strings.EqualFold(acl.Action, cns.ActionTypeBlock):
+		target = iptables.Drop
+	default:
+		return nil, fmt.Errorf("Invalid ACL action: %s", acl.Action)
+	}
+
+	switch {
+	case strings.EqualFold(acl.Direction, cns.DirectionTypeOut):
+		localIf, localIP, remoteIP, localPt, remotePt = "-i", "-s", "-d", "sports", "dports"
+	case strings.EqualFold(acl.Direction, cns.DirectionTypeIn):
+		localIf, localIP, remoteIP, localPt, remotePt = "-o", "-d", "-s", "dports", "sports"
+	default:
+		return nil, fmt.Errorf("Invalid ACL direction: %s", acl.Direction)
+	}
+
+	protocols := []string{""}
+	if strings.TrimSpace(acl.Protocols) != "" {
+		protocols = strings.Split(acl.Protocols, ",")
+	}
+
+	for _, protocol := range protocols {
+		protocol = strings.TrimSpace(protocol)
+		match := fmt.Sprintf("%s %s", localIf, hostVethName)
+
+		if protocol != "" {
+			if name, ok := iptablesProtocols[protocol]; ok {
+				protocol = name
+			}
+			match += " -p " + protocol
+		} else if acl.LocalPorts != "" || acl.RemotePorts != "" {
+			return nil, fmt.Errorf("ACL ports require a protocol: %+v", acl)
+		}
+
+		if acl.LocalAddresses != "" {
+			match += fmt.Sprintf(" %s %s", localIP, strings.Replace(acl.LocalAddresses, " ", "", -1))
+		}
+
+		if acl.RemoteAddresses != "" {
+			match += fmt.Sprintf(" %s %s", remoteIP, strings.Replace(acl.RemoteAddresses, " ", "", -1))
+		}
+
+		if acl.LocalPorts != "" {
+			match += " " + getIptablesPortMatch(localPt, acl.LocalPorts)
+		}
+
+		if acl.RemotePorts != "" {
+			match += " " + getIptablesPortMatch(remotePt, acl.RemotePorts)
+		}
+
+		rules = append(rules, aclRule{priority: acl.Priority, match: match, target: target})
+	}
+
+	return rules, nil
+}
+
+// configureAclSettingHostNCApipaEndpoint returns the ACL chain rules of the apipa endpoint ordered by priority.
+// As with HNS ACLs, a lower priority value takes precedence.
+func configureAclSettingHostNCApipaEndpoint(
+	hostVethName string,
+	protocolList []string,
+	networkContainerApipaIP string,
+	hostApipaIP string,
+	allowNCToHostCommunication bool,
+	allowHostToNCCommunication bool,
+	ncRequestedPolicies []cns.NetworkContainerRequestPolicies) ([]aclRule, error) {
+	var acls []cns.ValidAclPolicySetting
+
+	if allowNCToHostCommunication {
+		logger.Printf("[Azure CNS] Allowing NC (%s) to Host (%s) connectivity", networkContainerApipaIP, hostApipaIP)
+	}
+
+	if allowHostToNCCommunication {
+		logger.Printf("[Azure CNS] Allowing Host (%s) to NC (%s) connectivity", hostApipaIP, networkContainerApipaIP)
+	}
+
+	// Iterate thru the protocol list and add ACL for each
+	for _, protocol := range protocolList {
+		// ACL to block all outbound traffic from the Apipa IP of the container
+		acls = append(acls, cns.ValidAclPolicySetting{
+			Protocols:      protocol,
+			Action:         cns.ActionTypeBlock,
+			Direction:      cns.DirectionTypeOut,
+			LocalAddresses: networkContainerApipaIP,
+			Priority:       aclPriority2000,
+		})
+
+		if allowNCToHostCommunication {
+			// ACL to allow the outbound traffic from the Apipa IP of the container to
+			// Apipa IP of the host only
+			acls = append(acls, cns.ValidAclPolicySetting{
+				Protocols:       protocol,
+				Action:          cns.ActionTypeAllow,
+				Direction:       cns.DirectionTypeOut,
+				LocalAddresses:  networkContainerApipaIP,
+				RemoteAddresses: hostApipaIP,
+				Priority:        aclPriority1000,
+			})
+		}
+
+		// ACL to block all inbound traffic to the Apipa IP of the container
+		acls = append(acls, cns.ValidAclPolicySetting{
+			Protocols:      protocol,
+			Action:         cns.ActionTypeBlock,
+			Direction:      cns.DirectionTypeIn,
+			LocalAddresses: networkContainerApipaIP,
+			Priority:       aclPriority2000,
+		})
+
+		if allowHostToNCCommunication {
+			// ACL to allow the inbound traffic from the apipa IP of the host to
+			// the apipa IP of the container only
+			acls = append(acls, cns.ValidAclPolicySetting{
+				Protocols:       protocol,
+				Action:          cns.ActionTypeAllow,
+				Direction:       cns.DirectionTypeIn,
+				LocalAddresses:  networkContainerApipaIP,
+				RemoteAddresses: hostApipaIP,
+				Priority:        aclPriority1000,
+			})
+		}
+	}
+
+	// Iterate thru the requested endpoint policies where policy type is ACL, endpoint type is APIPA
+	for _, requestedPolicy := range ncRequestedPolicies {
+		if strings.EqualFold(requestedPolicy.Type, aclPolicyType) && strings.EqualFold(requestedPolicy.EndpointType, apipaEndpointType) {
+			var requestedAclPolicy cns.ValidAclPolicySetting
+			if err := json.Unmarshal(requestedPolicy.Settings, &requestedAclPolicy); err != nil {
+				return nil, fmt.Errorf("Failed to Unmarshal requested ACL policy: %+v with error: %+v", requestedPolicy.Settings, err)
+			}
+			//Using {NetworkContainerIP} as a placeholder to signal using Network Container IP
+			if strings.EqualFold(requestedAclPolicy.LocalAddresses, "{NetworkContainerIP}") {
+				requestedAclPolicy.LocalAddresses = networkContainerApipaIP
+			}
+			//Using {HostApipaIP} as a placeholder to signal using Host Apipa IP
+			if strings.EqualFold(requestedAclPolicy.RemoteAddresses, "{HostApipaIP}") {
+				requestedAclPolicy.RemoteAddresses = hostApipaIP
+			}
+			logger.Printf("ACL Policy requested in NcGoalState %+v", requestedAclPolicy)
+			acls = append(acls, requestedAclPolicy)
+		}
+	}
+
+	var rules []aclRule
+	for _, acl := range acls {
+		aclRules, err := getAclRules(hostVethName, acl)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, aclRules...)
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].priority < rules[j].priority
+	})
+
+	return rules, nil
+}
+
+// getHostNCApipaJumpRules returns the matches of the rules that jump from the builtin chains to the ACL chain.
+func getHostNCApipaJumpRules(
+	hostVethName string) map[string][]string {
+	return map[string][]string{
+		iptables.Input:   {"-i " + hostVethName},
+		iptables.Output:  {"-o " + hostVethName},
+		iptables.Forward: {"-i " + hostVethName, "-o " + hostVethName},
+	}
+}
+
+// programHostNCApipaAcls replaces the rules of the ACL chain of the apipa endpoint
+// and hooks the chain in the builtin filter chains. The rules are built in a new chain that is hooked
+// before the current chain is unhooked, so that the traffic of the endpoint is filtered throughout.
+func programHostNCApipaAcls(
+	hostVethName string,
+	chainName string,
+	rules []aclRule) error {
+	newChainName := chainName + hostNCApipaNewChainSuffix
+
+	// Recover from an update that was interrupted.
+	if iptables.ChainExists(iptables.V4, iptables.Filter, newChainName) {
+		if iptables.ChainExists(iptables.V4, iptables.Filter, chainName) {
+			if err := deleteHostNCApipaChain(hostVethName, newChainName); err != nil {
+				return err
+			}
+		} else if err := iptables.RenameChain(iptables.V4, iptables.Filter, newChainName, chainName); err != nil {
+			return fmt.Errorf("Failed to rename chain %s to %s: %v", newChainName, chainName, err)
+		}
+	}
+
+	if err := iptables.CreateChain(iptables.V4, iptables.Filter, newChainName); err != nil {
+		return fmt.Errorf("Failed to create chain %s: %v", newChainName, err)
+	}
+
+	for _, rule := range rules {
+		if err := iptables.AppendIptableRule(iptables.V4, iptables.Filter, newChainName, rule.match, rule.target); err != nil {
+			return fmt.Errorf("Failed to add rule %+v to chain %s: %v", rule, newChainName, err)
+		}
+	}
+
+	for builtinChain, matches := range getHostNCApipaJumpRules(hostVethName) {
+		for _, match := range matches {
+			if err := iptables.InsertIptableRule(iptables.V4, iptables.Filter, builtinChain, match, newChainName); err != nil {
+				return fmt.Errorf("Failed to jump from chain %s to chain %s: %v", builtinChain, newChainName, err)
+			}
+		}
+	}
+
+	if err := deleteHostNCApipaChain(hostVethName, chainName); err != nil {
+		return err
+	}
+
+	if err := iptables.RenameChain(iptables.V4, iptables.Filter, newChainName, chainName); err != nil {
+		return fmt.Errorf("Failed to rename chain %s to %s: %v", newChainName, chainName, err)
+	}
+
+	return nil
+}
+
+// deleteHostNCApipaAcls unhooks and deletes the ACL chain of the apipa endpoint.
+func deleteHostNCApipaAcls(
+	hostVethName string,
+	chainName string) error {
+	if err := deleteHostNCApipaChain(hostVethName, chainName+hostNCApipaNewChainSuffix); err != nil {
+		return err
+	}
+
+	return deleteHostNCApipaChain(hostVethName, chainName)
+}
+
+// deleteHostNCApipaChain unhooks and deletes an ACL chain of the apipa endpoint.
+func deleteHostNCApipaChain(
+	hostVethName string,
+	chainName string) error {
+	if !iptables.ChainExists(iptables.V4, iptables.Filter, chainName) {
+		return nil
+	}
+
+	for builtinChain, matches := range getHostNCApipaJumpRules(hostVethName) {
+		for _, match := range matches {
+			if iptables.RuleExists(iptables.V4, iptables.Filter, builtinChain, match, chainName) {
+				if err := iptables.DeleteIptableRule(iptables.V4, iptables.Filter, builtinChain, match, chainName); err != nil {
+					return fmt.Errorf("Failed to delete jump from chain %s to chain %s: %v", builtinChain, chainName, err)
+				}
+			}
+		}
+	}
+
+	if err := iptables.FlushChain(iptables.V4, iptables.Filter, chainName); err != nil {
+		return fmt.Errorf("Failed to flush chain %s: %v", chainName, err)
+	}
+
+	return iptables.DeleteChain(iptables.V4, iptables.Filter, chainName)
+}
+
+// CreateHostNCApipaEndpoint creates a link-local veth pair for host container connectivity.
+// The host side is assigned the host apipa IP and its traffic is filtered by the ACL chain of the endpoint.
+// The NC side is moved to the namespace in which CNS realized the NC and assigned the NC apipa IP, so the
+// NC must have been realized by CNS. The returned endpoint ID is the name of the host side, which is derived
+// from the NC ID, so it is stable across calls and restarts.
 func CreateHostNCApipaEndpoint(
 	networkContainerID string,
 	localIPConfiguration cns.IPConfiguration,
 	allowNCToHostCommunication bool,
 	allowHostToNCCommunication bool,
 	ncPolicies []cns.NetworkContainerRequestPolicies) (string, error) {
-	return "", nil
+	var (
+		endpointName                        = getHostNCApipaEndpointName(networkContainerID)
+		hostVethName, ncVethName, chainName = getHostNCApipaNames(networkContainerID)
+		nsName                              = networkcontainers.GetNetworkContainerNamespace(networkContainerID)
+		networkContainerApipaIP             = localIPConfiguration.IPSubnet.IPAddress
+		hostApipaIP                         = localIPConfiguration.GatewayIPAddress
+		protocolList                        = []string{protocolICMPv4, protocolTCP, protocolUDP}
+	)
+
+	namedLock.LockAcquire(endpointName)
+	defer namedLock.LockRelease(endpointName)
+
+	rules, err := configureAclSettingHostNCApipaEndpoint(
+		hostVethName,
+		protocolList,
+		networkContainerApipaIP,
+		hostApipaIP,
+		allowNCToHostCommunication,
+		allowHostToNCCommunication,
+		ncPolicies)
+	if err != nil {
+		logger.Errorf("[Azure CNS] Failed to configure ACL for HostNCApipaEndpoint. Error: %v", err)
+		return "", err
+	}
+
+	// Create the veth pair unless the endpoint already exists.
+	if _, err = net.InterfaceByName(hostVethName); err == nil {
+		logger.Debugf("[Azure CNS] Found existing HostNCApipaEndpoint: %s", endpointName)
+	} else {
+		logger.Printf("[Azure CNS] Creating HostNCApipaEndpoint: %s with veth pair %s %s in namespace %s and localIPConfig: %+v",
+			endpointName, hostVethName, ncVethName, nsName, localIPConfiguration)
+
+		if err = createHostNCApipaVethPair(hostVethName, ncVethName, nsName, localIPConfiguration); err != nil {
+			netlink.DeleteLink(hostVethName)
+			err = fmt.Errorf("Failed to create HostNCApipaEndpoint: %s. Error: %v", endpointName, err)
+			logger.Errorf("[Azure CNS] %s", err.Error())
+			return "", err
+		}
+	}
+
+	// Program the ACLs on every call, so that updated NC policies take effect.
+	if err = programHostNCApipaAcls(hostVethName, chainName, rules); err != nil {
+		logger.Errorf("[Azure CNS] Failed to program ACL for HostNCApipaEndpoint: %s. Error: %v", endpointName, err)
+		return "", err
+	}
+
+	logger.Printf("[Azure CNS] Successfully created HostNCApipaEndpoint: %s", endpointName)
+
+	return hostVethName, nil
 }
 
-// DeleteHostNCApipaEndpoint deletes the endpoint in the apipa network
-// created for host container connectivity
-// This is windows platform specific.
+// createHostNCApipaVethPair creates the apipa veth pair, assigns the host apipa IP to the host side, and moves
+// the NC side to the namespace of the NC and assigns it the NC apipa IP.
+func createHostNCApipaVethPair(
+	hostVethName string,
+	ncVethName string,
+	nsName string,
+	localIPConfiguration cns.IPConfiguration) error {
+	mask := net.CIDRMask(int(localIPConfiguration.IPSubnet.PrefixLength), 32)
+
+	hostIP := net.ParseIP(localIPConfiguration.GatewayIPAddress)
+	if hostIP == nil || hostIP.To4() == nil || mask == nil {
+		return fmt.Errorf("Invalid host apipa IP: %s", localIPConfiguration.GatewayIPAddress)
+	}
+
+	ncIP := net.ParseIP(localIPConfiguration.IPSubnet.IPAddress)
+	if ncIP == nil || ncIP.To4() == nil {
+		return fmt.Errorf("Invalid NC apipa IP: %s", localIPConfiguration.IPSubnet.IPAddress)
+	}
+
+	// The namespace is created when CNS realizes the NC.
+	ns, err := network.OpenNamespace(network.GetNamedNamespacePath(nsName))
+	if err != nil {
+		return fmt.Errorf("Namespace %s of the NC is not available: %v", nsName, err)
+	}
+	defer ns.Close()
+
+	if err = epcommon.CreateEndpoint(hostVethName, ncVethName); err != nil {
+		return err
+	}
+
+	hostIPNet := &net.IPNet{IP: hostIP.To4(), Mask: mask}
+	if err = netlink.AddIpAddress(hostVethName, hostIPNet.IP, hostIPNet); err != nil {
+		return fmt.Errorf("Failed to assign IP %v to %s: %v", hostIPNet, hostVethName, err)
+	}
+
+	if err = netlink.SetLinkNetNs(ncVethName, ns.GetFd()); err != nil {
+		return err
+	}
+
+	if err = ns.Enter(); err != nil {
+		return err
+	}
+	defer ns.Exit()
+
+	ncIPNet := &net.IPNet{IP: ncIP.To4(), Mask: mask}
+	if err = netlink.AddIpAddress(ncVethName, ncIPNet.IP, ncIPNet); err != nil {
+		return fmt.Errorf("Failed to assign IP %v to %s: %v", ncIPNet, ncVethName, err)
+	}
+
+	return netlink.SetLinkState(ncVethName, true)
+}
+
+// DeleteHostNCApipaEndpoint deletes the veth pair and the ACL chain created for host container connectivity.
 func DeleteHostNCApipaEndpoint(
 	networkContainerID string) error {
+	endpointName := getHostNCApipaEndpointName(networkContainerID)
+	hostVethName, _, chainName := getHostNCApipaNames(networkContainerID)
+
+	namedLock.LockAcquire(endpointName)
+	defer namedLock.LockRelease(endpointName)
+
+	logger.Debugf("[Azure CNS] Deleting HostNCApipaEndpoint: %s", endpointName)
+
+	if err := deleteHostNCApipaAcls(hostVethName, chainName); err != nil {
+		logger.Errorf("[Azure CNS] Failed to delete ACL for HostNCApipaEndpoint: %s. Error: %v", endpointName, err)
+		return err
+	}
+
+	// Deleting the host side deletes the pair, wherever the NC side is.
+	if _, err := net.InterfaceByName(hostVethName); err != nil {
+		logger.Errorf("[Azure CNS] Delete called on the Endpoint: %s which doesn't exist. Error: %v",
+			endpointName, err)
+		return nil
+	}
+
+	if err := netlink.DeleteLink(hostVethName); err != nil {
+		logger.Errorf("[Azure CNS] Failed to delete HostNCApipaEndpoint: %s. Error: %v", endpointName, err)
+		return err
+	}
+
+	logger.Debugf("[Azure CNS] Successfully deleted HostNCApipaEndpoint: %s", endpointName)
+
 	return nil
 }
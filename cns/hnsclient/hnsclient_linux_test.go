@@ -0,0 +1,156 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package hnsclient
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/Azure/azure-container-networking/cns/networkcontainers"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/netlink"
+	"github.com/Azure/azure-container-networking/network"
+)
+
+const (
+	testNCApipaIP   = "169.254.0.4"
+	testHostApipaIP = "169.254.0.5"
+)
+
+// Wraps the test run.
+func TestMain(m *testing.M) {
+	logger.InitLogger("azure-cns-hnsclient-test", log.LevelInfo, log.TargetStderr, "")
+
+	// Run tests.
+	exitCode := m.Run()
+	os.Exit(exitCode)
+}
+
+// TestHostNCApipaNames tests if the endpoint names are stable and fit in interface names.
+func TestHostNCApipaNames(t *testing.T) {
+	hostVeth, ncVeth, chain := getHostNCApipaNames("ethWebApp")
+	hostVeth2, ncVeth2, chain2 := getHostNCApipaNames("ethWebApp")
+
+	if hostVeth != hostVeth2 || ncVeth != ncVeth2 || chain != chain2 {
+		t.Errorf("names are not stable")
+	}
+
+	if len(hostVeth) > 15 || len(ncVeth) > 15 || hostVeth == ncVeth {
+		t.Errorf("invalid veth names %s %s", hostVeth, ncVeth)
+	}
+}
+
+// TestConfigureAclSetting tests if ACL policies are translated to ordered iptables rules.
+func TestConfigureAclSetting(t *testing.T) {
+	settings, _ := json.Marshal(cns.ValidAclPolicySetting{
+		Protocols:       "6",
+		Action:          cns.ActionTypeAllow,
+		Direction:       cns.DirectionTypeIn,
+		LocalAddresses:  "{NetworkContainerIP}",
+		RemoteAddresses: "{HostApipaIP}",
+		LocalPorts:      "80,8000-8080",
+		Priority:        500,
+	})
+
+	policies := []cns.NetworkContainerRequestPolicies{
+		{Type: aclPolicyType, EndpointType: apipaEndpointType, Settings: settings},
+	}
+
+	rules, err := configureAclSettingHostNCApipaEndpoint(
+		"apipah0", []string{protocolTCP}, testNCApipaIP, testHostApipaIP, true, false, policies)
+	if err != nil {
+		t.Fatalf("configure ACL failed %+v", err)
+	}
+
+	expected := []aclRule{
+		{500, "-o apipah0 -p tcp -d 169.254.0.4 -s 169.254.0.5 -m multiport --dports 80,8000:8080", "ACCEPT"},
+		{1000, "-i apipah0 -p tcp -s 169.254.0.4 -d 169.254.0.5", "ACCEPT"},
+		{2000, "-i apipah0 -p tcp -s 169.254.0.4", "DROP"},
+		{2000, "-o apipah0 -p tcp -d 169.254.0.4", "DROP"},
+	}
+
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("unexpected rules, got %+v want %+v", rules, expected)
+	}
+}
+
+// TestConfigureAclSettingInvalid tests if invalid ACL policies are rejected.
+func TestConfigureAclSettingInvalid(t *testing.T) {
+	settings, _ := json.Marshal(cns.ValidAclPolicySetting{
+		Action:     cns.ActionTypeBlock,
+		Direction:  cns.DirectionTypeOut,
+		LocalPorts: "80",
+		Priority:   100,
+	})
+
+	policies := []cns.NetworkContainerRequestPolicies{
+		{Type: aclPolicyType, EndpointType: apipaEndpointType, Settings: settings},
+	}
+
+	if _, err := configureAclSettingHostNCApipaEndpoint(
+		"apipah0", nil, testNCApipaIP, testHostApipaIP, false, false, policies); err == nil {
+		t.Errorf("ports without a protocol should be rejected")
+	}
+}
+
+// TestCreateHostNCApipaVethPair tests if the NC side of the apipa veth pair is configured in the namespace of the NC.
+func TestCreateHostNCApipaVethPair(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Creating namespaces requires root")
+	}
+
+	ncID := "ethApipaTest"
+	hostVethName, ncVethName, _ := getHostNCApipaNames(ncID)
+	nsName := networkcontainers.GetNetworkContainerNamespace(ncID)
+	ipConfig := cns.IPConfiguration{
+		IPSubnet:         cns.IPSubnet{IPAddress: testNCApipaIP, PrefixLength: 16},
+		GatewayIPAddress: testHostApipaIP,
+	}
+
+	// The namespace of the NC is required.
+	if err := createHostNCApipaVethPair(hostVethName, ncVethName, nsName, ipConfig); err == nil {
+		t.Fatalf("Apipa veth pair was created without the namespace of the NC")
+	}
+
+	ns, err := network.NewNamedNamespace(nsName)
+	if err != nil {
+		t.Skipf("Namespaces are not supported: %v", err)
+	}
+	defer network.DeleteNamedNamespace(nsName)
+	defer ns.Close()
+	defer netlink.DeleteLink(hostVethName)
+
+	if err = createHostNCApipaVethPair(hostVethName, ncVethName, nsName, ipConfig); err != nil {
+		t.Fatalf("createHostNCApipaVethPair failed %v", err)
+	}
+
+	if _, err = net.InterfaceByName(ncVethName); err == nil {
+		t.Errorf("NC side of the apipa veth pair was left in the host namespace")
+	}
+
+	if err = ns.Enter(); err != nil {
+		t.Fatalf("Failed to enter namespace %v", err)
+	}
+	defer ns.Exit()
+
+	iface, err := net.InterfaceByName(ncVethName)
+	if err != nil {
+		t.Fatalf("NC side of the apipa veth pair is not in the namespace of the NC: %v", err)
+	}
+
+	addrs, _ := iface.Addrs()
+	found := false
+	for _, addr := range addrs {
+		found = found || addr.String() == testNCApipaIP+"/16"
+	}
+
+	if !found {
+		t.Errorf("NC apipa IP was not assigned, addresses %v", addrs)
+	}
+}
@@ -14,7 +14,12 @@ const (
 
 // ImdsClient can be used to connect to VM Host agent in Azure.
 type ImdsClient struct {
-	primaryInterface *InterfaceInfo
+	// HostQueryURL is the URL of the interface info of the host, if not the wireserver default.
+	HostQueryURL string
+	// HostQueryURLForProgrammedVersion is the format of the URL of the programmed version of a network container,
+	// if not the wireserver default.
+	HostQueryURLForProgrammedVersion string
+	primaryInterface                 *InterfaceInfo
 }
 
 // InterfaceInfo specifies the information about an interface as returned by Host Agent.
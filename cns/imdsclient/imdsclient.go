@@ -16,7 +16,12 @@ import (
 // GetNetworkContainerInfoFromHost retrieves the programmed version of network container from Host.
 func (imdsClient *ImdsClient) GetNetworkContainerInfoFromHost(networkContainerID string, primaryAddress string, authToken string, apiVersion string) (*ContainerVersion, error) {
 	logger.Printf("[Azure CNS] GetNetworkContainerInfoFromHost")
-	queryURL := fmt.Sprintf(hostQueryURLForProgrammedVersion,
+	urlFormat := hostQueryURLForProgrammedVersion
+	if imdsClient.HostQueryURLForProgrammedVersion != "" {
+		urlFormat = imdsClient.HostQueryURLForProgrammedVersion
+	}
+
+	queryURL := fmt.Sprintf(urlFormat,
 		primaryAddress, networkContainerID, authToken, apiVersion)
 
 	logger.Printf("[Azure CNS] Going to query Azure Host for container version @\n %v\n", queryURL)
@@ -47,8 +52,13 @@ func (imdsClient *ImdsClient) GetNetworkContainerInfoFromHost(networkContainerID
 func (imdsClient *ImdsClient) GetPrimaryInterfaceInfoFromHost() (*InterfaceInfo, error) {
 	logger.Printf("[Azure CNS] GetPrimaryInterfaceInfoFromHost")
 
+	queryURL := hostQueryURL
+	if imdsClient.HostQueryURL != "" {
+		queryURL = imdsClient.HostQueryURL
+	}
+
 	interfaceInfo := &InterfaceInfo{}
-	resp, err := http.Get(hostQueryURL)
+	resp, err := http.Get(queryURL)
 	if err != nil {
 		return nil, err
 	}
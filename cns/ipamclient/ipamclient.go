@@ -214,13 +214,36 @@ func (ic *IpamClient) ReleaseIPAddress(poolID string, reservationID string) erro
 
 // GetIPAddressUtilization - returns number of available, reserved and unhealthy addresses list.
 func (ic *IpamClient) GetIPAddressUtilization(poolID string) (int, int, []string, error) {
-	var body bytes.Buffer
 	log.Printf("[Azure CNS] GetIPAddressUtilization")
 
-	client, err := getClient(ic.connectionURL)
+	poolInfoResp, err := ic.getPoolInfo(poolID)
 	if err != nil {
 		return 0, 0, nil, err
 	}
+
+	return poolInfoResp.Capacity, poolInfoResp.Available, poolInfoResp.UnhealthyAddresses, nil
+}
+
+// GetUnhealthyIPAddresses - returns the unhealthy addresses list and the reason each address is unhealthy.
+func (ic *IpamClient) GetUnhealthyIPAddresses(poolID string) ([]string, map[string]string, error) {
+	log.Printf("[Azure CNS] GetUnhealthyIPAddresses")
+
+	poolInfoResp, err := ic.getPoolInfo(poolID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return poolInfoResp.UnhealthyAddresses, poolInfoResp.UnhealthyReasons, nil
+}
+
+// getPoolInfo request to get the utilization of a pool.
+func (ic *IpamClient) getPoolInfo(poolID string) (*cnmIpam.GetPoolInfoResponse, error) {
+	var body bytes.Buffer
+
+	client, err := getClient(ic.connectionURL)
+	if err != nil {
+		return nil, err
+	}
 	url := ic.connectionURL + cnmIpam.GetPoolInfoPath
 
 	payload := &cnmIpam.GetPoolInfoRequest{
@@ -232,7 +255,7 @@ func (ic *IpamClient) GetIPAddressUtilization(poolID string) (int, int, []string
 	res, err := client.Post(url, "application/json", &body)
 	if err != nil {
 		log.Printf("[Azure CNS] HTTP Post returned error %v", err.Error())
-		return 0, 0, nil, err
+		return nil, err
 	}
 
 	defer res.Body.Close()
@@ -242,17 +265,16 @@ func (ic *IpamClient) GetIPAddressUtilization(poolID string) (int, int, []string
 		err := json.NewDecoder(res.Body).Decode(&poolInfoResp)
 		if err != nil {
 			log.Printf("[Azure CNS] Error received while parsing GetIPUtilization response :%v err:%v", res.Body, err.Error())
-			return 0, 0, nil, err
+			return nil, err
 		}
 
 		if poolInfoResp.Err != "" {
 			log.Printf("[Azure CNS] GetIPUtilization received error response :%v", poolInfoResp.Err)
-			return 0, 0, nil, fmt.Errorf(poolInfoResp.Err)
+			return nil, fmt.Errorf(poolInfoResp.Err)
 		}
 
-		return poolInfoResp.Capacity, poolInfoResp.Available, poolInfoResp.UnhealthyAddresses, nil
+		return &poolInfoResp, nil
 	}
-	log.Printf("[Azure CNS] GetIPUtilization invalid http status code: %v err:%v", res.StatusCode, err.Error())
-	return 0, 0, nil, err
-
+	log.Printf("[Azure CNS] GetIPUtilization invalid http status code: %v", res.StatusCode)
+	return nil, fmt.Errorf("GetIPUtilization invalid http status code: %v", res.StatusCode)
 }
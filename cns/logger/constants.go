@@ -19,4 +19,13 @@ const (
 	AllowHostToNCCommunicationStr = "AllowHostToNCCommunication"
 	NetworkContainerTypeStr       = "NetworkContainerType"
 	OrchestratorContextStr        = "OrchestratorContext"
+	// IPAM pool change properties
+	IpamPoolChangeEventStr = "CNSIPAMPoolChange"
+	AddressSpaceStr        = "AddressSpace"
+	InterfaceNameStr       = "InterfaceName"
+	PoolAddedStr           = "PoolAdded"
+	PoolRemovedStr         = "PoolRemoved"
+	AddedAddressesStr      = "AddedAddresses"
+	RemovedAddressesStr    = "RemovedAddresses"
+	UnhealthyAddressesStr  = "UnhealthyAddresses"
 )
@@ -46,8 +46,18 @@ func InitAI(aiConfig aitelemetry.AIConfig, disableTraceLogging, disableMetricLog
 	}
 
 	Log.logger.Printf("AI Telemetry Handle created")
-	Log.DisableMetricLogging = disableMetricLogging
+	SetTelemetryLogging(disableTraceLogging, disableMetricLogging, disableEventLogging)
+}
+
+// SetLogLevel sets the log level.
+func SetLogLevel(level int) {
+	Log.logger.SetLevel(level)
+}
+
+// SetTelemetryLogging sets which logs are sent to AI telemetry.
+func SetTelemetryLogging(disableTraceLogging, disableMetricLogging, disableEventLogging bool) {
 	Log.DisableTraceLogging = disableTraceLogging
+	Log.DisableMetricLogging = disableMetricLogging
 	Log.DisableEventLogging = disableEventLogging
 }
 
@@ -36,6 +36,8 @@ const (
 // NetworkContainers can be used to perform operations on network containers.
 type NetworkContainers struct {
 	logpath string
+	// Realize Docker and Basic network containers in namespaces of their own on Linux.
+	manageNamespaces bool
 }
 
 // NetPluginConfiguration represent network plugin configuration that is used during CNI ADD/DELETE/UPDATE operation
@@ -52,6 +54,18 @@ func NewNetPluginConfiguration(binPath, configPath string) *NetPluginConfigurati
 	}
 }
 
+// NewNetworkContainers creates a new NetworkContainers. Docker and Basic network containers are realized
+// on Linux only if manageNamespaces is set, as orchestrators configure them otherwise.
+func NewNetworkContainers(manageNamespaces bool) *NetworkContainers {
+	return &NetworkContainers{manageNamespaces: manageNamespaces}
+}
+
+// IsCreatedByCNS returns whether CNS creates and deletes the interfaces of network containers of a type
+// itself on this platform.
+func (cn *NetworkContainers) IsCreatedByCNS(networkContainerType string) bool {
+	return isCreatedByCNS(networkContainerType, cn.manageNamespaces)
+}
+
 func InterfaceExists(iFaceName string) (bool, error) {
 	_, err := net.InterfaceByName(iFaceName)
 	if err != nil {
@@ -4,20 +4,391 @@
 package networkcontainers
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/Azure/azure-container-networking/cns"
 	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/Azure/azure-container-networking/netlink"
+	"github.com/Azure/azure-container-networking/network"
+	"github.com/Azure/azure-container-networking/network/epcommon"
+	"github.com/Azure/azure-container-networking/platform"
 	"github.com/containernetworking/cni/libcni"
+	"golang.org/x/sys/unix"
 )
 
+const (
+	// Prefix of the names of the network namespaces of network containers.
+	ncNamespacePrefix = "azure-nc-"
+
+	// Prefixes of the names of the host and NC sides of the veth pair of a network container.
+	// Interface names are limited to 15 characters, so they are suffixed with a hash of the NC ID.
+	ncHostVethPrefix = "aznch"
+	ncVethPrefix     = "azncc"
+
+	// Length of the NC ID hash in the namespace and interface names.
+	ncHashLength = 8
+
+	// Name of the interface of a network container in its namespace.
+	ncInterfaceName = "eth0"
+
+	// Directory of the files that "ip netns exec" uses in place of those in /etc for a named namespace.
+	namespaceConfigDir = "/etc/netns"
+)
+
+var (
+	// Host IP forwarding is enabled once, by the first network container realized in a namespace.
+	ipForwardingLock    sync.Mutex
+	ipForwardingEnabled bool
+)
+
+// isCreatedByCNS returns whether CNS realizes network containers of a type in namespaces of their own.
+func isCreatedByCNS(networkContainerType string, manageNamespaces bool) bool {
+	switch networkContainerType {
+	case cns.Docker, cns.Basic:
+		return manageNamespaces
+	default:
+		return false
+	}
+}
+
 func createOrUpdateInterface(createNetworkContainerRequest cns.CreateNetworkContainerRequest) error {
+	// Docker and Basic network containers are realized in a namespace of their own on Linux.
+	switch createNetworkContainerRequest.NetworkContainerType {
+	case cns.Docker, cns.Basic:
+		return createOrUpdateNamespace(createNetworkContainerRequest)
+	default:
+		return nil
+	}
+}
+
+// getNetworkContainerNames returns the names of the namespace, the host veth and the NC veth of a network container.
+func getNetworkContainerNames(networkContainerID string) (string, string, string) {
+	hash := sha1.Sum([]byte(networkContainerID))
+	suffix := hex.EncodeToString(hash[:])[:ncHashLength]
+
+	return ncNamespacePrefix + suffix, ncHostVethPrefix + suffix, ncVethPrefix + suffix
+}
+
+// GetNetworkContainerNamespace returns the name of the namespace in which a network container is realized.
+func GetNetworkContainerNamespace(networkContainerID string) string {
+	nsName, _, _ := getNetworkContainerNames(networkContainerID)
+	return nsName
+}
+
+// getNetworkContainerRoutes returns the address of a network container and the routes of its interface,
+// the default route through the gateway followed by the routes of the request.
+func getNetworkContainerRoutes(ipConfig cns.IPConfiguration, routes []cns.Route) (*net.IPNet, []netlink.Route, error) {
+	ip := net.ParseIP(ipConfig.IPSubnet.IPAddress)
+	if ip == nil || ip.To4() == nil || ipConfig.IPSubnet.PrefixLength > 32 {
+		return nil, nil, fmt.Errorf("Invalid IP configuration %s/%d", ipConfig.IPSubnet.IPAddress, ipConfig.IPSubnet.PrefixLength)
+	}
+
+	ipNet := &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(int(ipConfig.IPSubnet.PrefixLength), 32)}
+	var ncRoutes []netlink.Route
+
+	if ipConfig.GatewayIPAddress != "" {
+		gw := net.ParseIP(ipConfig.GatewayIPAddress)
+		if gw == nil || gw.To4() == nil {
+			return nil, nil, fmt.Errorf("Invalid gateway IP %s", ipConfig.GatewayIPAddress)
+		}
+
+		// Gateways outside of the subnet are reached on the link.
+		if !ipNet.Contains(gw) {
+			ncRoutes = append(ncRoutes, netlink.Route{
+				Dst:   &net.IPNet{IP: gw.To4(), Mask: net.CIDRMask(32, 32)},
+				Scope: unix.RT_SCOPE_LINK,
+			})
+		}
+
+		ncRoutes = append(ncRoutes, netlink.Route{
+			Dst: &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+			Gw:  gw.To4(),
+		})
+	}
+
+	for _, route := range routes {
+		dst := route.IPAddress
+		if !strings.Contains(dst, "/") {
+			dst += "/32"
+		}
+
+		_, dstNet, err := net.ParseCIDR(dst)
+		if err != nil || dstNet.IP.To4() == nil {
+			return nil, nil, fmt.Errorf("Invalid route destination %s", route.IPAddress)
+		}
+
+		ncRoute := netlink.Route{Dst: dstNet, Scope: unix.RT_SCOPE_LINK}
+		if route.GatewayIPAddress != "" {
+			gw := net.ParseIP(route.GatewayIPAddress)
+			if gw == nil || gw.To4() == nil {
+				return nil, nil, fmt.Errorf("Invalid route gateway %s", route.GatewayIPAddress)
+			}
+
+			ncRoute.Gw = gw.To4()
+			ncRoute.Scope = unix.RT_SCOPE_UNIVERSE
+		}
+
+		ncRoutes = append(ncRoutes, ncRoute)
+	}
+
+	for i := range ncRoutes {
+		ncRoutes[i].Family = unix.AF_INET
+	}
+
+	return ipNet, ncRoutes, nil
+}
+
+// getRouteKeys returns the sorted destinations and gateways of routes, to compare sets of routes.
+func getRouteKeys(routes []netlink.Route) []string {
+	var keys []string
+	for _, route := range routes {
+		dst := "0.0.0.0/0"
+		if route.Dst != nil {
+			dst = route.Dst.String()
+		}
+
+		keys = append(keys, fmt.Sprintf("%s via %v", dst, route.Gw))
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// createOrUpdateNamespace realizes a network container as a namespace, which holds an interface with the
+// IP configuration and routes of the network container. The other side of the interface is a veth
+// in the host namespace, which routes the traffic of the network container and answers ARP requests for
+// its gateway. The resolv.conf of the namespace lists the DNS servers of the network container.
+// It is idempotent, and only recreates the interface if its configuration changed.
+func createOrUpdateNamespace(req cns.CreateNetworkContainerRequest) error {
+	nsName, hostVethName, ncVethName := getNetworkContainerNames(req.NetworkContainerid)
+
+	ipNet, routes, err := getNetworkContainerRoutes(req.IPConfiguration, req.Routes)
+	if err != nil {
+		logger.Errorf("[Azure CNS] Invalid network container %s: %v", req.NetworkContainerid, err)
+		return err
+	}
+
+	ns, err := network.NewNamedNamespace(nsName)
+	if err != nil {
+		logger.Errorf("[Azure CNS] Failed to create namespace %s for NC %s: %v", nsName, req.NetworkContainerid, err)
+		return err
+	}
+	defer ns.Close()
+
+	configured := false
+	if _, err = net.InterfaceByName(hostVethName); err == nil {
+		configured, err = isNamespaceConfigured(ns, ipNet, routes)
+		if err != nil {
+			logger.Printf("[Azure CNS] Failed to read the configuration of namespace %s: %v", nsName, err)
+		}
+	}
+
+	if configured {
+		logger.Printf("[Azure CNS] Found configured namespace %s for NC %s", nsName, req.NetworkContainerid)
+	} else {
+		logger.Printf("[Azure CNS] Creating interface %s in namespace %s for NC %s with IP %v and routes %v",
+			ncInterfaceName, nsName, req.NetworkContainerid, ipNet, getRouteKeys(routes))
+
+		if err = createNamespaceInterface(ns, hostVethName, ncVethName, ipNet, routes); err != nil {
+			logger.Errorf("[Azure CNS] Failed to create interface for NC %s: %v", req.NetworkContainerid, err)
+			netlink.DeleteLink(hostVethName)
+			return err
+		}
+	}
+
+	if err = enableIPForwarding(); err != nil {
+		logger.Errorf("[Azure CNS] Failed to enable IP forwarding for NC %s: %v", req.NetworkContainerid, err)
+		return err
+	}
+
+	if err = configureHostVeth(hostVethName, ipNet.IP); err != nil {
+		logger.Errorf("[Azure CNS] Failed to configure host veth %s for NC %s: %v", hostVethName, req.NetworkContainerid, err)
+		return err
+	}
+
+	if err = writeNamespaceResolvConf(nsName, req.IPConfiguration.DNSServers); err != nil {
+		logger.Errorf("[Azure CNS] Failed to write resolv.conf of namespace %s: %v", nsName, err)
+		return err
+	}
+
+	logger.Printf("[Azure CNS] Successfully configured namespace %s for NC %s", nsName, req.NetworkContainerid)
+	return nil
+}
+
+// isNamespaceConfigured returns whether the interface of a namespace has the given address and routes.
+func isNamespaceConfigured(ns *network.Namespace, ipNet *net.IPNet, routes []netlink.Route) (bool, error) {
+	if err := ns.Enter(); err != nil {
+		return false, err
+	}
+	defer ns.Exit()
+
+	iface, err := net.InterfaceByName(ncInterfaceName)
+	if err != nil {
+		return false, nil
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false, err
+	}
+
+	var ipv4Addrs []string
+	for _, addr := range addrs {
+		if addrNet, ok := addr.(*net.IPNet); ok && addrNet.IP.To4() != nil {
+			ipv4Addrs = append(ipv4Addrs, addrNet.String())
+		}
+	}
+
+	if len(ipv4Addrs) != 1 || ipv4Addrs[0] != ipNet.String() {
+		return false, nil
+	}
+
+	existingRoutes, err := netlink.GetIpRoute(&netlink.Route{Family: unix.AF_INET, LinkIndex: iface.Index})
+	if err != nil {
+		return false, err
+	}
+
+	// Routes of the addresses of the interface are added by the kernel.
+	var added []netlink.Route
+	for _, route := range existingRoutes {
+		if route.Protocol != unix.RTPROT_KERNEL {
+			added = append(added, *route)
+		}
+	}
+
+	return strings.Join(getRouteKeys(added), ",") == strings.Join(getRouteKeys(routes), ","), nil
+}
+
+// createNamespaceInterface creates a veth pair, moves one side into the namespace and configures it.
+// An existing veth pair is deleted first.
+func createNamespaceInterface(
+	ns *network.Namespace,
+	hostVethName string,
+	ncVethName string,
+	ipNet *net.IPNet,
+	routes []netlink.Route) error {
+	if _, err := net.InterfaceByName(hostVethName); err == nil {
+		logger.Printf("[Azure CNS] Deleting outdated host veth %s", hostVethName)
+		if err = netlink.DeleteLink(hostVethName); err != nil {
+			return err
+		}
+	}
+
+	if err := epcommon.CreateEndpoint(hostVethName, ncVethName); err != nil {
+		return err
+	}
+
+	if err := netlink.SetLinkNetNs(ncVethName, ns.GetFd()); err != nil {
+		return err
+	}
+
+	if err := ns.Enter(); err != nil {
+		return err
+	}
+	defer ns.Exit()
+
+	if err := netlink.SetLinkState("lo", true); err != nil {
+		return err
+	}
+
+	if err := epcommon.SetupContainerInterface(ncVethName, ncInterfaceName); err != nil {
+		return err
+	}
+
+	if err := netlink.AddIpAddress(ncInterfaceName, ipNet.IP, ipNet); err != nil {
+		return err
+	}
+
+	iface, err := net.InterfaceByName(ncInterfaceName)
+	if err != nil {
+		return err
+	}
+
+	for _, route := range routes {
+		route.LinkIndex = iface.Index
+		if err = netlink.AddIpRoute(&route); err != nil {
+			return fmt.Errorf("Failed to add route %v via %v: %v", route.Dst, route.Gw, err)
+		}
+	}
+
 	return nil
 }
 
+// configureHostVeth routes the IP of a network container to its host veth, which answers ARP requests for the
+// gateway of the network container and forwards its traffic.
+func configureHostVeth(hostVethName string, ip net.IP) error {
+	iface, err := net.InterfaceByName(hostVethName)
+	if err != nil {
+		return err
+	}
+
+	route := &netlink.Route{
+		Family:    unix.AF_INET,
+		Dst:       &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)},
+		Scope:     unix.RT_SCOPE_LINK,
+		LinkIndex: iface.Index,
+	}
+
+	if err = netlink.AddIpRoute(route); err != nil && !strings.Contains(strings.ToLower(err.Error()), "file exists") {
+		return err
+	}
+
+	_, err = platform.ExecuteCommand(fmt.Sprintf("echo 1 > /proc/sys/net/ipv4/conf/%s/proxy_arp", hostVethName))
+	return err
+}
+
+// enableIPForwarding enables IPv4 forwarding on the host, which routes the traffic of network containers
+// between their host veths and the host interfaces.
+func enableIPForwarding() error {
+	ipForwardingLock.Lock()
+	defer ipForwardingLock.Unlock()
+
+	if ipForwardingEnabled {
+		return nil
+	}
+
+	logger.Printf("[Azure CNS] Enabling IPv4 forwarding on the host for network containers realized in namespaces")
+	if _, err := platform.ExecuteCommand("sysctl -w net.ipv4.ip_forward=1"); err != nil {
+		return err
+	}
+
+	ipForwardingEnabled = true
+	return nil
+}
+
+// writeNamespaceResolvConf writes the resolv.conf of a namespace, or deletes it if there are no DNS servers.
+func writeNamespaceResolvConf(nsName string, dnsServers []string) error {
+	configDir := filepath.Join(namespaceConfigDir, nsName)
+	if len(dnsServers) == 0 {
+		return os.RemoveAll(configDir)
+	}
+
+	var content strings.Builder
+	for _, server := range dnsServers {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("Invalid DNS server %s", server)
+		}
+		fmt.Fprintf(&content, "nameserver %s\n", server)
+	}
+
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(configDir, "resolv.conf"), []byte(content.String()), 0644)
+}
+
 func setWeakHostOnInterface(ipAddress, ncID string) error {
 	return nil
 }
@@ -83,8 +454,25 @@ func updateInterface(createNetworkContainerRequest cns.CreateNetworkContainerReq
 	return nil
 }
 
+// deleteInterface deletes the namespace, the veth pair and the resolv.conf of a network container, if they exist.
 func deleteInterface(networkContainerID string) error {
-	return nil
+	nsName, hostVethName, _ := getNetworkContainerNames(networkContainerID)
+
+	// Deleting the host side deletes the pair, and with it the routes of the network container.
+	if _, err := net.InterfaceByName(hostVethName); err == nil {
+		logger.Printf("[Azure CNS] Deleting host veth %s of NC %s", hostVethName, networkContainerID)
+		if err = netlink.DeleteLink(hostVethName); err != nil {
+			logger.Errorf("[Azure CNS] Failed to delete host veth %s: %v", hostVethName, err)
+			return err
+		}
+	}
+
+	if err := network.DeleteNamedNamespace(nsName); err != nil {
+		logger.Errorf("[Azure CNS] Failed to delete namespace %s: %v", nsName, err)
+		return err
+	}
+
+	return os.RemoveAll(filepath.Join(namespaceConfigDir, nsName))
 }
 
 func configureNetworkContainerNetworking(operation, podName, podNamespace, dockerContainerid string, netPluginConfig *NetPluginConfiguration) (err error) {
@@ -0,0 +1,165 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package networkcontainers
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/network"
+)
+
+// Wraps the test run.
+func TestMain(m *testing.M) {
+	logger.InitLogger("azure-cns-networkcontainers-test", log.LevelInfo, log.TargetStderr, "")
+	os.Exit(m.Run())
+}
+
+// TestNetworkContainerNames tests if the names are stable and fit in interface names.
+func TestNetworkContainerNames(t *testing.T) {
+	nsName, hostVeth, ncVeth := getNetworkContainerNames("ethDocker")
+	nsName2, hostVeth2, ncVeth2 := getNetworkContainerNames("ethDocker")
+
+	if nsName != nsName2 || hostVeth != hostVeth2 || ncVeth != ncVeth2 {
+		t.Errorf("names are not stable")
+	}
+
+	if len(hostVeth) > 15 || len(ncVeth) > 15 || hostVeth == ncVeth {
+		t.Errorf("invalid veth names %s %s", hostVeth, ncVeth)
+	}
+
+	if otherNsName, _, _ := getNetworkContainerNames("ethBasic"); otherNsName == nsName {
+		t.Errorf("names of different network containers collide")
+	}
+}
+
+// TestNetworkContainerRoutes tests if the routes of the interface of a network container are derived from its request.
+func TestNetworkContainerRoutes(t *testing.T) {
+	ipConfig := cns.IPConfiguration{
+		IPSubnet:         cns.IPSubnet{IPAddress: "10.0.0.4", PrefixLength: 24},
+		GatewayIPAddress: "10.0.1.1",
+	}
+	routes := []cns.Route{
+		{IPAddress: "192.168.0.0/16", GatewayIPAddress: "10.0.0.2"},
+		{IPAddress: "172.16.0.1"},
+	}
+
+	ipNet, ncRoutes, err := getNetworkContainerRoutes(ipConfig, routes)
+	if err != nil {
+		t.Fatalf("getNetworkContainerRoutes failed %v", err)
+	}
+
+	if ipNet.String() != "10.0.0.4/24" {
+		t.Errorf("Unexpected address %v", ipNet)
+	}
+
+	// The gateway outside of the subnet is reached on the link.
+	expected := []string{
+		"0.0.0.0/0 via 10.0.1.1",
+		"10.0.1.1/32 via <nil>",
+		"172.16.0.1/32 via <nil>",
+		"192.168.0.0/16 via 10.0.0.2",
+	}
+	if keys := getRouteKeys(ncRoutes); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Unexpected routes %v", keys)
+	}
+
+	for _, invalid := range []cns.IPConfiguration{
+		{IPSubnet: cns.IPSubnet{IPAddress: "fe80::1", PrefixLength: 64}},
+		{IPSubnet: cns.IPSubnet{IPAddress: "10.0.0.4", PrefixLength: 33}},
+		{IPSubnet: cns.IPSubnet{IPAddress: "10.0.0.4", PrefixLength: 24}, GatewayIPAddress: "gateway"},
+	} {
+		if _, _, err = getNetworkContainerRoutes(invalid, nil); err == nil {
+			t.Errorf("Invalid IP configuration %+v was accepted", invalid)
+		}
+	}
+
+	if _, _, err = getNetworkContainerRoutes(ipConfig, []cns.Route{{IPAddress: "10.0.0.0/40"}}); err == nil {
+		t.Errorf("Invalid route was accepted")
+	}
+}
+
+// TestCreateDeleteNamespace tests if a network container is realized in a namespace, updated and deleted.
+func TestCreateDeleteNamespace(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Creating namespaces requires root")
+	}
+
+	req := cns.CreateNetworkContainerRequest{
+		NetworkContainerid:   "ethDockerTest",
+		NetworkContainerType: cns.Docker,
+		IPConfiguration: cns.IPConfiguration{
+			IPSubnet:         cns.IPSubnet{IPAddress: "10.241.0.4", PrefixLength: 24},
+			GatewayIPAddress: "10.241.0.1",
+			DNSServers:       []string{"168.63.129.16"},
+		},
+		Routes: []cns.Route{{IPAddress: "10.242.0.0/16", GatewayIPAddress: "10.241.0.1"}},
+	}
+	nsName, hostVethName, _ := getNetworkContainerNames(req.NetworkContainerid)
+
+	// Namespaces may not be supported, e.g. in unprivileged containers.
+	ns, err := network.NewNamedNamespace(nsName)
+	if err != nil {
+		t.Skipf("Namespaces are not supported: %v", err)
+	}
+	ns.Close()
+	defer deleteInterface(req.NetworkContainerid)
+
+	if err = createOrUpdateInterface(req); err != nil {
+		t.Fatalf("createOrUpdateInterface failed %v", err)
+	}
+
+	hostVeth, err := net.InterfaceByName(hostVethName)
+	if err != nil {
+		t.Fatalf("Host veth was not created %v", err)
+	}
+
+	resolvConf, err := ioutil.ReadFile(filepath.Join(namespaceConfigDir, nsName, "resolv.conf"))
+	if err != nil || string(resolvConf) != "nameserver 168.63.129.16\n" {
+		t.Fatalf("Unexpected resolv.conf %q err %v", resolvConf, err)
+	}
+
+	// Creating the same network container again keeps its interface.
+	if err = createOrUpdateInterface(req); err != nil {
+		t.Fatalf("createOrUpdateInterface failed %v", err)
+	}
+
+	if iface, _ := net.InterfaceByName(hostVethName); iface == nil || iface.Index != hostVeth.Index {
+		t.Fatalf("Interface of an unchanged network container was recreated")
+	}
+
+	// Updating the IP configuration recreates the interface.
+	req.IPConfiguration.IPSubnet.IPAddress = "10.241.0.5"
+	if err = createOrUpdateInterface(req); err != nil {
+		t.Fatalf("createOrUpdateInterface failed %v", err)
+	}
+
+	if iface, _ := net.InterfaceByName(hostVethName); iface == nil || iface.Index == hostVeth.Index {
+		t.Fatalf("Interface of an updated network container was not recreated")
+	}
+
+	if err = deleteInterface(req.NetworkContainerid); err != nil {
+		t.Fatalf("deleteInterface failed %v", err)
+	}
+
+	if _, err = net.InterfaceByName(hostVethName); err == nil {
+		t.Fatalf("Host veth was not deleted")
+	}
+
+	if _, err = os.Stat(network.GetNamedNamespacePath(nsName)); !os.IsNotExist(err) {
+		t.Fatalf("Namespace was not deleted, err %v", err)
+	}
+
+	// Deleting a deleted network container succeeds.
+	if err = deleteInterface(req.NetworkContainerid); err != nil {
+		t.Fatalf("deleteInterface failed %v", err)
+	}
+}
@@ -27,6 +27,12 @@ const (
 
 var loopbackOperationLock = &sync.Mutex{}
 
+// isCreatedByCNS returns whether CNS creates the interfaces of network containers of a type.
+// Only WebApps network containers are created on Windows.
+func isCreatedByCNS(networkContainerType string, manageNamespaces bool) bool {
+	return networkContainerType == cns.WebApps
+}
+
 func createOrUpdateInterface(createNetworkContainerRequest cns.CreateNetworkContainerRequest) error {
 	// Create Operation is only supported for WebApps only on Windows
 	if createNetworkContainerRequest.NetworkContainerType != cns.WebApps {
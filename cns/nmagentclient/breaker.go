@@ -0,0 +1,71 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package nmagentclient
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns/logger"
+)
+
+// circuitBreaker stops requests to an endpoint after consecutive failures. Once the open timeout expires,
+// a single probe request is let through. The circuit closes again when the probe succeeds.
+type circuitBreaker struct {
+	lock             sync.Mutex
+	failureThreshold int
+	openTimeout      time.Duration
+	failures         int
+	openUntil        time.Time
+	probing          bool
+}
+
+// Creates a new closed circuit breaker.
+func newCircuitBreaker(failureThreshold int, openTimeout time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+	}
+}
+
+// Returns whether a request may be sent.
+func (breaker *circuitBreaker) allow(now time.Time) bool {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	if breaker.failures < breaker.failureThreshold {
+		return true
+	}
+
+	if now.Before(breaker.openUntil) || breaker.probing {
+		return false
+	}
+
+	breaker.probing = true
+	return true
+}
+
+// Records the result of a request that was allowed.
+func (breaker *circuitBreaker) record(now time.Time, success bool) {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	breaker.probing = false
+
+	if success {
+		if breaker.failures >= breaker.failureThreshold {
+			logger.Printf("[NMAgentClient] Circuit breaker closed")
+		}
+
+		breaker.failures = 0
+		return
+	}
+
+	breaker.failures++
+	if breaker.failures >= breaker.failureThreshold {
+		breaker.openUntil = now.Add(breaker.openTimeout)
+		logger.Printf("[NMAgentClient] Circuit breaker opened for %v after %d consecutive failures",
+			breaker.openTimeout, breaker.failures)
+	}
+}
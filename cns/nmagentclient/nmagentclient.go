@@ -1,28 +1,135 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
 package nmagentclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/Azure/azure-container-networking/cns/logger"
 	"github.com/Azure/azure-container-networking/common"
+	"github.com/google/uuid"
+)
+
+const (
+	// RequestIDHeader is the header that carries the ID of a request across its attempts.
+	RequestIDHeader = "x-ms-client-request-id"
+
+	defaultMaxRetries       = 4
+	defaultInitialBackoff   = 500 * time.Millisecond
+	defaultMaxBackoff       = 8 * time.Second
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 30 * time.Second
 )
 
+// ErrCircuitOpen is returned when requests to an endpoint are rejected after repeated failures.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Config configures the NMAgent client. Zero values select the defaults.
+type Config struct {
+	// HTTPClient sends the requests. Defaults to the shared CNS HTTP client.
+	HTTPClient *http.Client
+	// MaxRetries is the number of retries after the first attempt. Negative values disable retries.
+	MaxRetries int
+	// InitialBackoff is the delay before the first retry. It doubles on every retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// FailureThreshold is the number of consecutive failures after which an endpoint is not called anymore.
+	FailureThreshold int
+	// OpenTimeout is the time after which a single request is let through to an endpoint that failed.
+	OpenTimeout time.Duration
+}
+
+// Client calls NMAgent through wireserver. Failed requests are retried with exponential backoff and jitter,
+// and requests to endpoints that keep failing are rejected by a circuit breaker.
+type Client struct {
+	config   Config
+	lock     sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+// operation describes an NMAgent request.
+type operation struct {
+	name string
+	// idempotent operations are retried after any failure. Other operations are only retried
+	// when the request was not processed by the host.
+	idempotent bool
+}
+
+var (
+	joinNetworkOperation = operation{name: "JoinNetwork", idempotent: true}
+	unpublishNCOperation = operation{name: "UnpublishNetworkContainer", idempotent: true}
+
+	// The host may have applied a publish whose response was lost, so it is not retried blindly.
+	publishNCOperation = operation{name: "PublishNetworkContainer", idempotent: false}
+
+	// Status codes of requests that may have been processed by the host.
+	retryableStatusCodes = map[int]bool{
+		http.StatusRequestTimeout:      true,
+		http.StatusInternalServerError: true,
+		http.StatusBadGateway:          true,
+		http.StatusGatewayTimeout:      true,
+	}
+
+	// Status codes of requests that were rejected before being processed by the host.
+	notProcessedStatusCodes = map[int]bool{
+		http.StatusTooManyRequests:    true,
+		http.StatusServiceUnavailable: true,
+	}
+)
+
+// NewClient creates a new NMAgent client.
+func NewClient(config Config) *Client {
+	if config.MaxRetries == 0 {
+		config.MaxRetries = defaultMaxRetries
+	} else if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+
+	if config.InitialBackoff == 0 {
+		config.InitialBackoff = defaultInitialBackoff
+	}
+
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = defaultMaxBackoff
+	}
+
+	if config.FailureThreshold == 0 {
+		config.FailureThreshold = defaultFailureThreshold
+	}
+
+	if config.OpenTimeout == 0 {
+		config.OpenTimeout = defaultOpenTimeout
+	}
+
+	return &Client{
+		config:   config,
+		breakers: make(map[string]*circuitBreaker),
+	}
+}
+
 // JoinNetwork joins the given network
-func JoinNetwork(
+func (client *Client) JoinNetwork(
+	ctx context.Context,
 	networkID string,
 	joinNetworkURL string) (*http.Response, error) {
 	logger.Printf("[NMAgentClient] JoinNetwork: %s", networkID)
 
 	// Empty body is required as wireserver cannot handle a post without the body.
-	var body bytes.Buffer
-	json.NewEncoder(&body).Encode("")
-	response, err := common.GetHttpClient().Post(joinNetworkURL, "application/json", &body)
-
-	if err == nil && response.StatusCode == http.StatusOK {
-		defer response.Body.Close()
-	}
+	response, err := client.post(ctx, joinNetworkOperation, joinNetworkURL, emptyBody())
 
 	logger.Printf("[NMAgentClient][Response] Join network: %s. Response: %+v. Error: %v",
 		networkID, response, err)
@@ -31,14 +138,14 @@ func JoinNetwork(
 }
 
 // PublishNetworkContainer publishes given network container
-func PublishNetworkContainer(
+func (client *Client) PublishNetworkContainer(
+	ctx context.Context,
 	networkContainerID string,
 	createNetworkContainerURL string,
 	requestBodyData []byte) (*http.Response, error) {
 	logger.Printf("[NMAgentClient] PublishNetworkContainer NC: %s", networkContainerID)
 
-	requestBody := bytes.NewBuffer(requestBodyData)
-	response, err := common.GetHttpClient().Post(createNetworkContainerURL, "application/json", requestBody)
+	response, err := client.post(ctx, publishNCOperation, createNetworkContainerURL, requestBodyData)
 
 	logger.Printf("[NMAgentClient][Response] Publish NC: %s. Response: %+v. Error: %v",
 		networkContainerID, response, err)
@@ -47,18 +154,157 @@ func PublishNetworkContainer(
 }
 
 // UnpublishNetworkContainer unpublishes given network container
-func UnpublishNetworkContainer(
+func (client *Client) UnpublishNetworkContainer(
+	ctx context.Context,
 	networkContainerID string,
 	deleteNetworkContainerURL string) (*http.Response, error) {
 	logger.Printf("[NMAgentClient] UnpublishNetworkContainer NC: %s", networkContainerID)
 
 	// Empty body is required as wireserver cannot handle a post without the body.
-	var body bytes.Buffer
-	json.NewEncoder(&body).Encode("")
-	response, err := common.GetHttpClient().Post(deleteNetworkContainerURL, "application/json", &body)
+	response, err := client.post(ctx, unpublishNCOperation, deleteNetworkContainerURL, emptyBody())
 
 	logger.Printf("[NMAgentClient][Response] Unpublish NC: %s. Response: %+v. Error: %v",
 		networkContainerID, response, err)
 
 	return response, err
 }
+
+// Returns the JSON encoding of an empty string.
+func emptyBody() []byte {
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode("")
+	return body.Bytes()
+}
+
+// Posts a request until it succeeds, fails with a non retryable error or runs out of retries.
+// All attempts carry the same request ID. The response of the last attempt is returned with its body unread.
+func (client *Client) post(ctx context.Context, op operation, requestURL string, body []byte) (*http.Response, error) {
+	requestID := uuid.New().String()
+
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return nil, err
+	}
+
+	breaker := client.getCircuitBreaker(op.name + " " + u.Host)
+
+	for attempt := 0; ; attempt++ {
+		if !breaker.allow(time.Now()) {
+			return nil, fmt.Errorf("%s to %s: %w", op.name, u.Host, ErrCircuitOpen)
+		}
+
+		response, err := client.send(ctx, requestURL, requestID, body)
+		retryable, failed := classify(op, response, err)
+		breaker.record(time.Now(), !failed)
+
+		if !retryable || attempt >= client.config.MaxRetries || ctx.Err() != nil {
+			return response, err
+		}
+
+		delay := client.backoff(attempt, response)
+		logger.Printf("[NMAgentClient] %s request %s attempt %d failed, retrying in %v. Response: %+v. Error: %v",
+			op.name, requestID, attempt+1, delay, response, err)
+
+		if response != nil {
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Sends a single attempt of a request.
+func (client *Client) send(ctx context.Context, requestURL string, requestID string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(RequestIDHeader, requestID)
+
+	return client.httpClient().Do(req)
+}
+
+// Returns the HTTP client that sends the requests.
+func (client *Client) httpClient() *http.Client {
+	if client.config.HTTPClient != nil {
+		return client.config.HTTPClient
+	}
+
+	if httpClient := common.GetHttpClient(); httpClient != nil {
+		return httpClient
+	}
+
+	return http.DefaultClient
+}
+
+// Returns whether an attempt can be retried, and whether it counts as a failure of the endpoint.
+func classify(op operation, response *http.Response, err error) (retryable bool, failed bool) {
+	if err != nil {
+		// Requests that failed to connect never reached the host.
+		var opErr *net.OpError
+		notSent := errors.As(err, &opErr) && opErr.Op == "dial"
+		return op.idempotent || notSent, true
+	}
+
+	switch {
+	case notProcessedStatusCodes[response.StatusCode]:
+		return true, true
+	case retryableStatusCodes[response.StatusCode]:
+		return op.idempotent, true
+	case response.StatusCode >= http.StatusInternalServerError:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// Returns the delay before the retry after the given attempt. The delay doubles on every attempt and is
+// randomized between half and all of it, so that clients don't retry in lockstep.
+// A Retry-After header of the response takes precedence.
+func (client *Client) backoff(attempt int, response *http.Response) time.Duration {
+	if response != nil {
+		if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			delay := time.Duration(seconds) * time.Second
+			if delay > client.config.MaxBackoff {
+				delay = client.config.MaxBackoff
+			}
+
+			return delay
+		}
+	}
+
+	delay := client.config.InitialBackoff
+	for i := 0; i < attempt && delay < client.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > client.config.MaxBackoff {
+		delay = client.config.MaxBackoff
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Returns the circuit breaker of an endpoint.
+func (client *Client) getCircuitBreaker(endpoint string) *circuitBreaker {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	breaker, ok := client.breakers[endpoint]
+	if !ok {
+		breaker = newCircuitBreaker(client.config.FailureThreshold, client.config.OpenTimeout)
+		client.breakers[endpoint] = breaker
+	}
+
+	return breaker
+}
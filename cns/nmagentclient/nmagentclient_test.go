@@ -0,0 +1,187 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package nmagentclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/Azure/azure-container-networking/cns/nmagentsim"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger("azure-cns-test", log.LevelInfo, log.TargetStderr, "")
+	os.Exit(m.Run())
+}
+
+// Returns the operations of the requests received by the simulator.
+func getOperations(sim *nmagentsim.Simulator) []nmagentsim.Operation {
+	var ops []nmagentsim.Operation
+	for _, req := range sim.Requests() {
+		ops = append(ops, req.Operation)
+	}
+
+	return ops
+}
+
+// TestRetryIdempotent tests if idempotent requests are retried with the same request ID.
+func TestRetryIdempotent(t *testing.T) {
+	sim := nmagentsim.NewSimulator()
+	defer sim.Close()
+
+	client := NewClient(Config{InitialBackoff: time.Millisecond})
+	sim.InjectFaults(nmagentsim.JoinNetwork, nmagentsim.Fault{StatusCode: http.StatusInternalServerError, AfterProcessing: true}, 1)
+	sim.InjectFaults(nmagentsim.JoinNetwork, nmagentsim.Fault{Drop: true}, 1)
+
+	resp, err := client.JoinNetwork(context.Background(), "vnet1", sim.JoinNetworkURL("vnet1"))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("JoinNetwork failed %+v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	requests := sim.Requests()
+	if len(requests) != 3 || requests[0].ID == "" || requests[0].ID != requests[1].ID || requests[1].ID != requests[2].ID {
+		t.Fatalf("Unexpected requests %+v", requests)
+	}
+
+	if !sim.IsNetworkJoined("vnet1") {
+		t.Fatalf("Network was not joined")
+	}
+}
+
+// TestRetryNonIdempotent tests if publish is only retried when the host did not process it.
+func TestRetryNonIdempotent(t *testing.T) {
+	sim := nmagentsim.NewSimulator()
+	defer sim.Close()
+
+	client := NewClient(Config{InitialBackoff: time.Millisecond})
+	url := sim.PublishNetworkContainerURL("10.0.0.4", "nc1", "token")
+
+	// Throttled requests were not processed and are retried.
+	sim.InjectFaults(nmagentsim.PublishNetworkContainer, nmagentsim.Fault{StatusCode: http.StatusTooManyRequests}, 2)
+	resp, err := client.PublishNetworkContainer(context.Background(), "nc1", url, []byte(`{"version":"1"}`))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("PublishNetworkContainer failed %+v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	// Failures after the request may have been processed are returned.
+	sim.InjectFaults(nmagentsim.PublishNetworkContainer, nmagentsim.Fault{StatusCode: http.StatusBadGateway}, 1)
+	resp, err = client.PublishNetworkContainer(context.Background(), "nc1", url, []byte(`{"version":"2"}`))
+	if err != nil || resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("Unexpected response %+v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	if len(sim.Requests()) != 4 {
+		t.Fatalf("Unexpected requests %v", getOperations(sim))
+	}
+}
+
+// TestRetryConnectionRefused tests if requests that failed to connect are retried.
+func TestRetryConnectionRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	client := NewClient(Config{MaxRetries: 2, InitialBackoff: time.Millisecond})
+	_, err = client.PublishNetworkContainer(context.Background(), "nc1", "http://"+address+"/", nil)
+
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "dial" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+// TestCircuitBreaker tests if an endpoint is not called after consecutive failures until a probe succeeds.
+func TestCircuitBreaker(t *testing.T) {
+	sim := nmagentsim.NewSimulator()
+	defer sim.Close()
+
+	client := NewClient(Config{MaxRetries: -1, FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond})
+	url := sim.UnpublishNetworkContainerURL("10.0.0.4", "nc1", "token")
+	sim.InjectFaults(nmagentsim.UnpublishNetworkContainer, nmagentsim.Fault{StatusCode: http.StatusServiceUnavailable}, 3)
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.UnpublishNetworkContainer(context.Background(), "nc1", url)
+		if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("Unexpected response %+v %v", resp, err)
+		}
+		resp.Body.Close()
+	}
+
+	if _, err := client.UnpublishNetworkContainer(context.Background(), "nc1", url); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Circuit breaker did not open, err %v", err)
+	}
+
+	// Other operations on the same host are not affected.
+	resp, err := client.JoinNetwork(context.Background(), "vnet1", sim.JoinNetworkURL("vnet1"))
+	if err != nil {
+		t.Fatalf("JoinNetwork failed %v", err)
+	}
+	resp.Body.Close()
+
+	// A failed probe opens the circuit again, a successful one closes it.
+	time.Sleep(60 * time.Millisecond)
+	if resp, err = client.UnpublishNetworkContainer(context.Background(), "nc1", url); err != nil {
+		t.Fatalf("Probe was not sent, err %v", err)
+	}
+	resp.Body.Close()
+
+	if _, err = client.UnpublishNetworkContainer(context.Background(), "nc1", url); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Circuit breaker did not open again, err %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		resp, err = client.UnpublishNetworkContainer(context.Background(), "nc1", url)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected response %+v %v", resp, err)
+		}
+		resp.Body.Close()
+	}
+}
+
+// TestCancel tests if retries stop when the context is cancelled.
+func TestCancel(t *testing.T) {
+	sim := nmagentsim.NewSimulator()
+	defer sim.Close()
+
+	client := NewClient(Config{InitialBackoff: time.Hour, MaxBackoff: time.Hour})
+	sim.InjectFaults(nmagentsim.JoinNetwork, nmagentsim.Fault{StatusCode: http.StatusServiceUnavailable}, 1)
+	sim.SetLatency(nmagentsim.JoinNetwork, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.JoinNetwork(ctx, "vnet1", sim.JoinNetworkURL("vnet1")); err != context.DeadlineExceeded {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+// TestBackoff tests if the backoff grows up to the maximum with jitter.
+func TestBackoff(t *testing.T) {
+	client := NewClient(Config{InitialBackoff: time.Second, MaxBackoff: 4 * time.Second})
+
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		if delay := client.backoff(attempt, nil); delay < max/2 || delay > max {
+			t.Errorf("Attempt %d backoff %v out of range", attempt, delay)
+		}
+	}
+
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"3"}}}
+	if delay := client.backoff(0, resp); delay != 3*time.Second {
+		t.Errorf("Retry-After was not honored, backoff %v", delay)
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// Package nmagentsim simulates the NMAgent APIs that CNS calls through wireserver, so that tests run offline.
+// Failures and latency can be injected per operation. It is meant to be used by tests only.
+package nmagentsim
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operation is an NMAgent API.
+type Operation string
+
+// NMAgent APIs served by the simulator.
+const (
+	JoinNetwork                Operation = "JoinNetwork"
+	PublishNetworkContainer    Operation = "PublishNetworkContainer"
+	UnpublishNetworkContainer  Operation = "UnpublishNetworkContainer"
+	GetNetworkContainerVersion Operation = "GetNetworkContainerVersion"
+	GetInterfaceInfo           Operation = "GetInterfaceInfo"
+)
+
+const (
+	pluginsPath       = "/machine/plugins/"
+	interfaceInfoType = "getinterfaceinfov1"
+	requestIDHeader   = "x-ms-client-request-id"
+	networkManagement = "NetworkManagement/"
+)
+
+// Fault is a failure injected into a request.
+type Fault struct {
+	// StatusCode is the status code returned instead of processing the request.
+	StatusCode int
+	// RetryAfter is the value of the Retry-After header, in seconds, if not zero.
+	RetryAfter int
+	// Drop closes the connection without a response instead.
+	Drop bool
+	// AfterProcessing processes the request before failing it, as if the response was lost.
+	AfterProcessing bool
+}
+
+// Interface is a network interface of the simulated host.
+type Interface struct {
+	MacAddress   string
+	IsPrimary    bool
+	Subnet       string
+	PrimaryIP    string
+	SecondaryIPs []string
+}
+
+// Interface info document returned by getinterfaceinfov1.
+type xmlInterfaces struct {
+	XMLName   xml.Name       `xml:"Interfaces"`
+	Interface []xmlInterface `xml:"Interface"`
+}
+
+type xmlInterface struct {
+	MacAddress string      `xml:"MacAddress,attr"`
+	IsPrimary  bool        `xml:"IsPrimary,attr"`
+	IPSubnet   xmlIPSubnet `xml:"IPSubnet"`
+}
+
+type xmlIPSubnet struct {
+	Prefix    string         `xml:"Prefix,attr"`
+	IPAddress []xmlIPAddress `xml:"IPAddress"`
+}
+
+type xmlIPAddress struct {
+	Address   string `xml:"Address,attr"`
+	IsPrimary bool   `xml:"IsPrimary,attr"`
+}
+
+// Request is a request received by the simulator.
+type Request struct {
+	Operation Operation
+	// ID is the client request ID.
+	ID string
+	// Resource is the ID of the network or network container.
+	Resource string
+}
+
+// Simulator is an NMAgent simulator listening on a local HTTP server.
+type Simulator struct {
+	server            *httptest.Server
+	lock              sync.Mutex
+	joinedNetworks    map[string]bool
+	networkContainers map[string]string
+	interfaces        []Interface
+	faults            map[Operation][]Fault
+	latency           map[Operation]time.Duration
+	requests          []Request
+}
+
+// NewSimulator creates and starts a new simulator.
+func NewSimulator() *Simulator {
+	sim := &Simulator{
+		joinedNetworks:    make(map[string]bool),
+		networkContainers: make(map[string]string),
+		faults:            make(map[Operation][]Fault),
+		latency:           make(map[Operation]time.Duration),
+		interfaces: []Interface{
+			{MacAddress: "000D3A000001", IsPrimary: true, Subnet: "10.0.0.0/24", PrimaryIP: "10.0.0.4"},
+		},
+	}
+
+	sim.server = httptest.NewServer(http.HandlerFunc(sim.serveHTTP))
+	return sim
+}
+
+// Close stops the simulator.
+func (sim *Simulator) Close() {
+	sim.server.Close()
+}
+
+// URL returns the base URL of the simulator.
+func (sim *Simulator) URL() string {
+	return sim.server.URL
+}
+
+// JoinNetworkURL returns the URL that joins a network.
+func (sim *Simulator) JoinNetworkURL(networkID string) string {
+	return sim.pluginURL(fmt.Sprintf("joinedVirtualNetworks/%s/api-version/1", networkID))
+}
+
+// PublishNetworkContainerURL returns the URL that publishes a network container.
+// The same URL returns the version of a published network container on GET.
+func (sim *Simulator) PublishNetworkContainerURL(primaryAddress string, networkContainerID string, authToken string) string {
+	return sim.pluginURL(fmt.Sprintf("interfaces/%s/networkContainers/%s/authenticationToken/%s/api-version/1",
+		primaryAddress, networkContainerID, authToken))
+}
+
+// UnpublishNetworkContainerURL returns the URL that unpublishes a network container.
+func (sim *Simulator) UnpublishNetworkContainerURL(primaryAddress string, networkContainerID string, authToken string) string {
+	return sim.PublishNetworkContainerURL(primaryAddress, networkContainerID, authToken) + "/method/DELETE"
+}
+
+// InterfaceInfoURL returns the URL that returns the interfaces of the host.
+func (sim *Simulator) InterfaceInfoURL() string {
+	return sim.server.URL + strings.TrimSuffix(pluginsPath, "/") + "?comp=nmagent&type=" + interfaceInfoType
+}
+
+// ProgrammedVersionURLFormat returns the format of the URL that returns the programmed version of a network
+// container, from the primary address, network container ID, authentication token and API version.
+func (sim *Simulator) ProgrammedVersionURLFormat() string {
+	return sim.pluginURL("interfaces/%s/networkContainers/%s/authenticationToken/%s/api-version/%s")
+}
+
+// Returns the wireserver URL of an NMAgent API.
+func (sim *Simulator) pluginURL(api string) string {
+	return sim.server.URL + pluginsPath + "?comp=nmagent&type=" + networkManagement + api
+}
+
+// InjectFaults fails the next count requests of an operation with the given fault.
+func (sim *Simulator) InjectFaults(op Operation, fault Fault, count int) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+
+	for i := 0; i < count; i++ {
+		sim.faults[op] = append(sim.faults[op], fault)
+	}
+}
+
+// SetLatency delays the responses to an operation.
+func (sim *Simulator) SetLatency(op Operation, latency time.Duration) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+
+	sim.latency[op] = latency
+}
+
+// Requests returns the requests received so far.
+func (sim *Simulator) Requests() []Request {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+
+	return append([]Request(nil), sim.requests...)
+}
+
+// IsNetworkJoined returns whether a network was joined.
+func (sim *Simulator) IsNetworkJoined(networkID string) bool {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+
+	return sim.joinedNetworks[networkID]
+}
+
+// GetNetworkContainer returns the version of a published network container.
+func (sim *Simulator) GetNetworkContainer(networkContainerID string) (version string, published bool) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+
+	version, published = sim.networkContainers[networkContainerID]
+	return version, published
+}
+
+// SetInterfaces sets the interfaces of the host.
+func (sim *Simulator) SetInterfaces(interfaces ...Interface) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+
+	sim.interfaces = interfaces
+}
+
+// SetNetworkContainerVersion sets the version of a network container programmed by the host.
+func (sim *Simulator) SetNetworkContainerVersion(networkContainerID string, version string) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+
+	sim.networkContainers[networkContainerID] = version
+}
+
+// Serves a wireserver request.
+func (sim *Simulator) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	op, resource, ok := parseRequest(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+
+	sim.lock.Lock()
+	sim.requests = append(sim.requests, Request{Operation: op, ID: r.Header.Get(requestIDHeader), Resource: resource})
+	latency := sim.latency[op]
+	var fault *Fault
+	if faults := sim.faults[op]; len(faults) > 0 {
+		fault = &faults[0]
+		sim.faults[op] = faults[1:]
+	}
+	sim.lock.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if fault != nil && !fault.AfterProcessing {
+		sim.fail(w, fault)
+		return
+	}
+
+	if op == GetInterfaceInfo {
+		if fault != nil {
+			sim.fail(w, fault)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
+		xml.NewEncoder(w).Encode(sim.getInterfaceInfo())
+		return
+	}
+
+	response := sim.process(op, resource, body)
+
+	if fault != nil {
+		sim.fail(w, fault)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Returns the interface info document of the host.
+func (sim *Simulator) getInterfaceInfo() *xmlInterfaces {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+
+	doc := &xmlInterfaces{}
+	for _, iface := range sim.interfaces {
+		subnet := xmlIPSubnet{Prefix: iface.Subnet}
+		subnet.IPAddress = append(subnet.IPAddress, xmlIPAddress{Address: iface.PrimaryIP, IsPrimary: true})
+		for _, address := range iface.SecondaryIPs {
+			subnet.IPAddress = append(subnet.IPAddress, xmlIPAddress{Address: address})
+		}
+
+		doc.Interface = append(doc.Interface, xmlInterface{MacAddress: iface.MacAddress, IsPrimary: iface.IsPrimary, IPSubnet: subnet})
+	}
+
+	return doc
+}
+
+// Fails a request with a fault.
+func (sim *Simulator) fail(w http.ResponseWriter, fault *Fault) {
+	if fault.Drop {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+
+	if fault.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(fault.RetryAfter))
+	}
+
+	w.WriteHeader(fault.StatusCode)
+}
+
+// Applies a request to the simulated host state and returns the response body.
+func (sim *Simulator) process(op Operation, resource string, body []byte) map[string]string {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+
+	response := map[string]string{"httpStatusCode": "200"}
+
+	switch op {
+	case JoinNetwork:
+		sim.joinedNetworks[resource] = true
+	case PublishNetworkContainer:
+		var goalState struct {
+			Version string `json:"version"`
+		}
+		json.Unmarshal(body, &goalState)
+		sim.networkContainers[resource] = goalState.Version
+	case UnpublishNetworkContainer:
+		delete(sim.networkContainers, resource)
+	case GetNetworkContainerVersion:
+		version, ok := sim.networkContainers[resource]
+		if !ok {
+			response["httpStatusCode"] = "404"
+		}
+		response["networkContainerId"] = resource
+		response["version"] = version
+	}
+
+	return response
+}
+
+// Returns the operation of a wireserver request and the ID of the resource it applies to.
+func parseRequest(r *http.Request) (op Operation, resource string, ok bool) {
+	api := r.URL.Query().Get("type")
+	if api == interfaceInfoType && strings.TrimSuffix(r.URL.Path, "/") == strings.TrimSuffix(pluginsPath, "/") {
+		return GetInterfaceInfo, "", r.Method == http.MethodGet
+	}
+
+	if r.URL.Path != pluginsPath || !strings.HasPrefix(api, networkManagement) {
+		return "", "", false
+	}
+
+	segments := strings.Split(strings.TrimPrefix(api, networkManagement), "/")
+
+	switch {
+	case len(segments) >= 2 && segments[0] == "joinedVirtualNetworks" && r.Method == http.MethodPost:
+		return JoinNetwork, segments[1], true
+	case len(segments) >= 4 && segments[0] == "interfaces" && segments[2] == "networkContainers":
+		switch {
+		case r.Method == http.MethodGet:
+			return GetNetworkContainerVersion, segments[3], true
+		case strings.HasSuffix(api, "/method/DELETE"):
+			return UnpublishNetworkContainer, segments[3], true
+		default:
+			return PublishNetworkContainer, segments[3], true
+		}
+	}
+
+	return "", "", false
+}
@@ -3,68 +3,47 @@
 
 package restserver
 
-// Container Network Service remote API Contract.
-const (
-	Success                         = 0
-	UnsupportedNetworkType          = 1
-	InvalidParameter                = 2
-	UnsupportedEnvironment          = 3
-	UnreachableHost                 = 4
-	ReservationNotFound             = 5
-	MalformedSubnet                 = 8
-	UnreachableDockerDaemon         = 9
-	UnspecifiedNetworkName          = 10
-	NotFound                        = 14
-	AddressUnavailable              = 15
-	NetworkContainerNotSpecified    = 16
-	CallToHostFailed                = 17
-	UnknownContainerID              = 18
-	UnsupportedOrchestratorType     = 19
-	DockerContainerNotSpecified     = 20
-	UnsupportedVerb                 = 21
-	UnsupportedNetworkContainerType = 22
-	InvalidRequest                  = 23
-	NetworkJoinFailed               = 24
-	NetworkContainerPublishFailed   = 25
-	NetworkContainerUnpublishFailed = 26
-	UnexpectedError                 = 99
+import (
+	"github.com/Azure/azure-container-networking/cns"
 )
 
 // ReturnCodeToString - Converts an error code to appropriate string.
 func ReturnCodeToString(returnCode int) (s string) {
 	switch returnCode {
-	case Success:
+	case cns.Success:
 		s = "Success"
-	case UnsupportedNetworkType:
+	case cns.UnsupportedNetworkType:
 		s = "UnsupportedNetworkType"
-	case InvalidParameter:
+	case cns.InvalidParameter:
 		s = "InvalidParameter"
-	case UnreachableHost:
+	case cns.UnreachableHost:
 		s = "UnreachableHost"
-	case ReservationNotFound:
+	case cns.ReservationNotFound:
 		s = "ReservationNotFound"
-	case MalformedSubnet:
+	case cns.MalformedSubnet:
 		s = "MalformedSubnet"
-	case UnreachableDockerDaemon:
+	case cns.UnreachableDockerDaemon:
 		s = "UnreachableDockerDaemon"
-	case UnspecifiedNetworkName:
+	case cns.UnspecifiedNetworkName:
 		s = "UnspecifiedNetworkName"
-	case NotFound:
+	case cns.NotFound:
 		s = "NotFound"
-	case AddressUnavailable:
+	case cns.AddressUnavailable:
 		s = "AddressUnavailable"
-	case NetworkContainerNotSpecified:
+	case cns.NetworkContainerNotSpecified:
 		s = "NetworkContainerNotSpecified"
-	case CallToHostFailed:
+	case cns.CallToHostFailed:
 		s = "CallToHostFailed"
-	case UnknownContainerID:
+	case cns.UnknownContainerID:
 		s = "UnknownContainerID"
-	case UnsupportedOrchestratorType:
+	case cns.UnsupportedOrchestratorType:
 		s = "UnsupportedOrchestratorType"
-	case UnexpectedError:
+	case cns.UnexpectedError:
 		s = "UnexpectedError"
-	case DockerContainerNotSpecified:
+	case cns.DockerContainerNotSpecified:
 		s = "DockerContainerNotSpecified"
+	case cns.NetworkContainerVersionConflict:
+		s = "NetworkContainerVersionConflict"
 	default:
 		s = "UnknownError"
 	}
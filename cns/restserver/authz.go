@@ -0,0 +1,104 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/configuration"
+	acn "github.com/Azure/azure-container-networking/common"
+)
+
+// Access of CNS API paths in an authorization policy.
+const (
+	ReadAccess   = "read"
+	MutateAccess = "mutate"
+)
+
+// CNS API paths that only read state. All other paths, including the debug and watch
+// endpoints, require mutate access unless the policy says otherwise.
+var readOnlyPaths = map[string]bool{
+	cns.GetHostLocalIPPath:                        true,
+	cns.GetIPAddressUtilizationPath:               true,
+	cns.GetUnhealthyIPAddressesPath:               true,
+	cns.GetHealthReportPath:                       true,
+	cns.GetNetworkContainerStatus:                 true,
+	cns.GetNetworkContainerHistory:                true,
+	cns.GetInterfaceForContainer:                  true,
+	cns.GetNetworkContainerByOrchestratorContext:  true,
+	cns.GetNetworkContainersByOrchestratorContext: true,
+	cns.NumberOfCPUCoresPath:                      true,
+}
+
+// NewAuthorizer returns an authorizer that enforces an authorization policy on CNS API requests.
+// It returns nil if the policy is not enabled.
+func NewAuthorizer(policy configuration.AuthorizationPolicy) (acn.Authorizer, error) {
+	if !policy.Enabled {
+		return nil, nil
+	}
+
+	for path, access := range policy.Paths {
+		if access != ReadAccess && access != MutateAccess {
+			return nil, fmt.Errorf("Invalid access %q of path %s", access, path)
+		}
+	}
+
+	authorizer := func(r *http.Request, peer acn.PeerIdentity) error {
+		access := getPathAccess(policy, r.URL.Path)
+
+		identities := policy.MutateIdentities
+		if access == ReadAccess {
+			identities = append(identities[:len(identities):len(identities)], policy.ReadIdentities...)
+		}
+
+		for _, identity := range identities {
+			if matchIdentity(identity, peer) {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("caller is not allowed %s access to %s", access, r.URL.Path)
+	}
+
+	return authorizer, nil
+}
+
+// Returns the access of a CNS API path, ignoring its version prefix.
+func getPathAccess(policy configuration.AuthorizationPolicy, path string) string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, cns.V1Prefix), cns.V2Prefix)
+
+	if access, ok := policy.Paths[path]; ok {
+		return access
+	}
+
+	if readOnlyPaths[path] {
+		return ReadAccess
+	}
+
+	return MutateAccess
+}
+
+// Returns whether a caller matches an identity. Identities without any field set match no caller.
+func matchIdentity(identity configuration.Identity, peer acn.PeerIdentity) bool {
+	if identity.UID == nil && identity.GID == nil && identity.CommonName == "" {
+		return false
+	}
+
+	if identity.UID != nil && (!peer.HasCredentials || peer.UID != *identity.UID) {
+		return false
+	}
+
+	if identity.GID != nil && (!peer.HasCredentials || peer.GID != *identity.GID) {
+		return false
+	}
+
+	if identity.CommonName != "" && peer.CommonName != identity.CommonName {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,152 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/configuration"
+	acn "github.com/Azure/azure-container-networking/common"
+)
+
+// TestAuthorizer tests if read and mutate paths are authorized for their identities.
+func TestAuthorizer(t *testing.T) {
+	rootUID := uint32(0)
+	readerGID := uint32(1000)
+
+	authorize, err := NewAuthorizer(configuration.AuthorizationPolicy{
+		Enabled:          true,
+		ReadIdentities:   []configuration.Identity{{GID: &readerGID}},
+		MutateIdentities: []configuration.Identity{{UID: &rootUID}, {CommonName: "dnc"}},
+		Paths:            map[string]string{cns.NumberOfCPUCoresPath: MutateAccess},
+	})
+	if err != nil {
+		t.Fatalf("NewAuthorizer failed %v", err)
+	}
+
+	root := acn.PeerIdentity{HasCredentials: true, UID: 0, GID: 0}
+	reader := acn.PeerIdentity{HasCredentials: true, UID: 1000, GID: 1000}
+	dnc := acn.PeerIdentity{CommonName: "dnc"}
+	anonymous := acn.PeerIdentity{}
+
+	tests := []struct {
+		path    string
+		peer    acn.PeerIdentity
+		allowed bool
+	}{
+		{cns.GetNetworkContainerStatus, reader, true},
+		{cns.V2Prefix + cns.GetNetworkContainerStatus, root, true},
+		{cns.DeleteNetworkContainer, reader, false},
+		{cns.V2Prefix + cns.DeleteNetworkContainer, reader, false},
+		{cns.DeleteNetworkContainer, root, true},
+		{cns.UnpublishNetworkContainer, dnc, true},
+		{cns.NumberOfCPUCoresPath, reader, false},
+		{cns.GetNetworkContainerStatus, anonymous, false},
+	}
+
+	for _, test := range tests {
+		err := authorize(httptest.NewRequest("POST", test.path, nil), test.peer)
+		if (err == nil) != test.allowed {
+			t.Errorf("Path %s peer %+v allowed %v, err %v", test.path, test.peer, test.allowed, err)
+		}
+	}
+}
+
+// TestAuthorizerPolicy tests if disabled and invalid policies are handled.
+func TestAuthorizerPolicy(t *testing.T) {
+	if authorize, err := NewAuthorizer(configuration.AuthorizationPolicy{}); authorize != nil || err != nil {
+		t.Errorf("Disabled policy returned an authorizer, err %v", err)
+	}
+
+	policy := configuration.AuthorizationPolicy{Enabled: true, Paths: map[string]string{"/hostcpucores": "write"}}
+	if _, err := NewAuthorizer(policy); err == nil {
+		t.Errorf("Invalid access was accepted")
+	}
+}
+
+// TestAuthorizerHandlerPaths tests if every registered handler requires the expected access.
+// New handlers must be added here, so that read access is never granted by accident.
+func TestAuthorizerHandlerPaths(t *testing.T) {
+	readPaths := map[string]bool{
+		cns.GetHostLocalIPPath:                        true,
+		cns.GetIPAddressUtilizationPath:               true,
+		cns.GetUnhealthyIPAddressesPath:               true,
+		cns.GetHealthReportPath:                       true,
+		cns.GetNetworkContainerStatus:                 true,
+		cns.GetNetworkContainerHistory:                true,
+		cns.GetInterfaceForContainer:                  true,
+		cns.GetNetworkContainerByOrchestratorContext:  true,
+		cns.GetNetworkContainersByOrchestratorContext: true,
+		cns.NumberOfCPUCoresPath:                      true,
+	}
+
+	mutatePaths := map[string]bool{
+		cns.SetEnvironmentPath:             true,
+		cns.CreateNetworkPath:              true,
+		cns.DeleteNetworkPath:              true,
+		cns.ReserveIPAddressPath:           true,
+		cns.ReleaseIPAddressPath:           true,
+		cns.CreateOrUpdateNetworkContainer: true,
+		cns.DeleteNetworkContainer:         true,
+		cns.Watch:                          true,
+		cns.SetOrchestratorType:            true,
+		cns.AttachContainerToNetwork:       true,
+		cns.DetachContainerFromNetwork:     true,
+		cns.CreateHnsNetworkPath:           true,
+		cns.DeleteHnsNetworkPath:           true,
+		cns.CreateHostNCApipaEndpointPath:  true,
+		cns.DeleteHostNCApipaEndpointPath:  true,
+		cns.PublishNetworkContainer:        true,
+		cns.UnpublishNetworkContainer:      true,
+		cns.DebugStatePath:                 true,
+		cns.DebugIPAddressUtilizationPath:  true,
+		pprofPathPrefix:                    true,
+		pprofPathPrefix + "cmdline":        true,
+		pprofPathPrefix + "profile":        true,
+		pprofPathPrefix + "symbol":         true,
+		pprofPathPrefix + "trace":          true,
+	}
+
+	// The test service runs without profiling, register the profiling endpoints on their own listener.
+	listener, err := acn.NewListener(&url.URL{Scheme: "tcp", Host: "null"})
+	if err != nil {
+		t.Fatalf("NewListener failed %v", err)
+	}
+
+	debugService := &HTTPRestService{Service: &cns.Service{Listener: listener}}
+	debugService.addDebugHandlers(true)
+
+	paths := append(service.(*HTTPRestService).Listener.GetHandlerPaths(), listener.GetHandlerPaths()...)
+	if len(paths) == 0 {
+		t.Fatalf("No handlers are registered")
+	}
+
+	policy := configuration.AuthorizationPolicy{Enabled: true}
+	for _, path := range paths {
+		unversioned := strings.TrimPrefix(path, cns.V2Prefix)
+
+		var expected string
+		switch {
+		case readPaths[unversioned]:
+			expected = ReadAccess
+		case mutatePaths[unversioned]:
+			expected = MutateAccess
+		default:
+			t.Errorf("Handler path %s has no expected access", path)
+			continue
+		}
+
+		if access := getPathAccess(policy, path); access != expected {
+			t.Errorf("Handler path %s access %s, expected %s", path, access, expected)
+		}
+	}
+
+	if access := getPathAccess(policy, "/unknown"); access != MutateAccess {
+		t.Errorf("Unknown path access %s, expected %s", access, MutateAccess)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"sort"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/logger"
+)
+
+const (
+	// Replaces authorization tokens in debug responses.
+	redactedValue = "<redacted>"
+
+	// Path prefix of the Go profiling endpoints.
+	pprofPathPrefix = "/debug/pprof/"
+)
+
+// Registers the read-only debug endpoints, and the profiling endpoints if enabled.
+func (service *HTTPRestService) addDebugHandlers(enableProfiling bool) {
+	listener := service.Listener
+
+	listener.AddHandler(cns.DebugStatePath, service.getDebugState)
+	listener.AddHandler(cns.DebugIPAddressUtilizationPath, service.getDebugIPAddressUtilization)
+
+	if enableProfiling {
+		logger.Printf("[Azure CNS] Profiling endpoints enabled at %s", pprofPathPrefix)
+		listener.AddHandler(pprofPathPrefix, pprof.Index)
+		listener.AddHandler(pprofPathPrefix+"cmdline", pprof.Cmdline)
+		listener.AddHandler(pprofPathPrefix+"profile", pprof.Profile)
+		listener.AddHandler(pprofPathPrefix+"symbol", pprof.Symbol)
+		listener.AddHandler(pprofPathPrefix+"trace", pprof.Trace)
+	}
+}
+
+// Returns a snapshot of the service state with authorization tokens redacted.
+func (service *HTTPRestService) getDebugStateSnapshot() cns.DebugStateResponse {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	state := service.state
+	resp := cns.DebugStateResponse{
+		Location:                          state.Location,
+		NetworkType:                       state.NetworkType,
+		OrchestratorType:                  state.OrchestratorType,
+		NodeID:                            state.NodeID,
+		Initialized:                       state.Initialized,
+		TimeStamp:                         state.TimeStamp,
+		NetworkContainers:                 []cns.DebugNetworkContainer{},
+		ContainerIDsByOrchestratorContext: make(map[string][]string),
+		JoinedNetworks:                    []string{},
+		Networks:                          []cns.DebugNetwork{},
+	}
+
+	for id, status := range state.ContainerStatus {
+		req := status.CreateNetworkContainerRequest
+		if req.AuthorizationToken != "" {
+			req.AuthorizationToken = redactedValue
+		}
+
+		resp.NetworkContainers = append(resp.NetworkContainers, cns.DebugNetworkContainer{
+			ID:                            id,
+			VMVersion:                     status.VMVersion,
+			HostVersion:                   status.HostVersion,
+			HostVersionUpdatedAt:          status.HostVersionUpdatedAt,
+			HostVersionError:              status.HostVersionError,
+			Programmed:                    status.isProgrammed(),
+			CreateNetworkContainerRequest: req,
+		})
+	}
+
+	sort.Slice(resp.NetworkContainers, func(i, j int) bool {
+		return resp.NetworkContainers[i].ID < resp.NetworkContainers[j].ID
+	})
+
+	for orchestratorContext, ids := range state.ContainerIDsByOrchestratorContext {
+		resp.ContainerIDsByOrchestratorContext[orchestratorContext] = append([]string(nil), ids...)
+	}
+
+	for _, network := range state.Networks {
+		debugNetwork := cns.DebugNetwork{
+			NetworkName: network.NetworkName,
+			Options:     network.Options,
+		}
+
+		if network.NicInfo != nil {
+			debugNetwork.Subnet = network.NicInfo.Subnet
+			debugNetwork.Gateway = network.NicInfo.Gateway
+			debugNetwork.PrimaryIP = network.NicInfo.PrimaryIP
+			debugNetwork.SecondaryIPs = network.NicInfo.SecondaryIPs
+		}
+
+		resp.Networks = append(resp.Networks, debugNetwork)
+	}
+
+	sort.Slice(resp.Networks, func(i, j int) bool {
+		return resp.Networks[i].NetworkName < resp.Networks[j].NetworkName
+	})
+
+	namedLock.LockAcquire(stateJoinedNetworks)
+	for networkID := range state.joinedNetworks {
+		resp.JoinedNetworks = append(resp.JoinedNetworks, networkID)
+	}
+	namedLock.LockRelease(stateJoinedNetworks)
+
+	sort.Strings(resp.JoinedNetworks)
+
+	return resp
+}
+
+// Handles requests to dump the service state.
+func (service *HTTPRestService) getDebugState(w http.ResponseWriter, r *http.Request) {
+	logger.Printf("[Azure CNS] getDebugState")
+	logger.Request(service.Name, "getDebugState", nil)
+
+	var resp cns.DebugStateResponse
+
+	switch r.Method {
+	case "GET":
+		resp = service.getDebugStateSnapshot()
+	default:
+		resp.Response.ReturnCode = cns.UnsupportedVerb
+		resp.Response.Message = "[Azure CNS] Error. getDebugState did not receive a GET."
+	}
+
+	err := service.Listener.Encode(w, &resp)
+	logger.Response(service.Name, resp.Response, resp.Response.ReturnCode, ReturnCodeToString(resp.Response.ReturnCode), err)
+}
+
+// Handles requests to dump the utilization of the address pool of the primary interface.
+func (service *HTTPRestService) getDebugIPAddressUtilization(w http.ResponseWriter, r *http.Request) {
+	logger.Printf("[Azure CNS] getDebugIPAddressUtilization")
+	logger.Request(service.Name, "getDebugIPAddressUtilization", nil)
+
+	var resp cns.DebugIPAddressUtilizationResponse
+
+	switch r.Method {
+	case "GET":
+		poolID, subnet, capacity, available, unhealthyAddrs, err := service.getPrimaryPoolUtilization()
+		if err != nil {
+			resp.Response.ReturnCode = cns.UnexpectedError
+			resp.Response.Message = "[Azure CNS] Error. " + err.Error()
+			break
+		}
+
+		resp.PoolID = poolID
+		resp.Subnet = subnet
+		resp.Capacity = capacity
+		resp.Available = available
+		resp.Reserved = capacity - available
+		resp.UnhealthyIPAddresses = unhealthyAddrs
+	default:
+		resp.Response.ReturnCode = cns.UnsupportedVerb
+		resp.Response.Message = "[Azure CNS] Error. getDebugIPAddressUtilization did not receive a GET."
+	}
+
+	err := service.Listener.Encode(w, &resp)
+	logger.Response(service.Name, resp.Response, resp.Response.ReturnCode, ReturnCodeToString(resp.Response.ReturnCode), err)
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/logger"
+)
+
+const (
+	// Number of goal state transitions kept per network container.
+	maxNetworkContainerHistory = 16
+
+	// Number of deleted network containers whose history is kept.
+	maxDeletedNetworkContainerHistories = 64
+)
+
+// checkNetworkContainerVersion returns a version conflict if a goal state of the given version must not replace
+// the current one. Goal states are only replaced by the same or a newer version; versions that are not numbers
+// are not ordered and always replace the current goal state.
+func checkNetworkContainerVersion(current containerstatus, exists bool, version string) (int, string) {
+	if !exists {
+		return cns.Success, ""
+	}
+
+	if result, ok := compareNetworkContainerVersions(version, current.VMVersion); ok && result < 0 {
+		return cns.NetworkContainerVersionConflict, fmt.Sprintf(
+			"[Azure CNS] Error. Version %s of network container %s is older than the current version %s",
+			version, current.ID, current.VMVersion)
+	}
+
+	return cns.Success, ""
+}
+
+// checkNetworkContainerDeleteVersion returns a version conflict if a delete conditional on the given version
+// must not delete the current goal state. An empty version deletes any goal state.
+func checkNetworkContainerDeleteVersion(current containerstatus, version string) (int, string) {
+	if version == "" || version == current.VMVersion {
+		return cns.Success, ""
+	}
+
+	return cns.NetworkContainerVersionConflict, fmt.Sprintf(
+		"[Azure CNS] Error. Network container %s has version %s, not %s", current.ID, current.VMVersion, version)
+}
+
+// recordNetworkContainerTransition appends a goal state transition to the history of a network container.
+// The caller must hold the service lock.
+func (service *HTTPRestService) recordNetworkContainerTransition(networkContainerID string, transition cns.NetworkContainerTransition) {
+	if service.state.NetworkContainerHistory == nil {
+		service.state.NetworkContainerHistory = make(map[string][]cns.NetworkContainerTransition)
+	}
+
+	transition.Time = time.Now()
+	history := append(service.state.NetworkContainerHistory[networkContainerID], transition)
+	if len(history) > maxNetworkContainerHistory {
+		history = append([]cns.NetworkContainerTransition(nil), history[len(history)-maxNetworkContainerHistory:]...)
+	}
+
+	service.state.NetworkContainerHistory[networkContainerID] = history
+
+	service.pruneNetworkContainerHistory()
+}
+
+// pruneNetworkContainerHistory drops the histories of the least recently changed deleted network containers.
+// The caller must hold the service lock.
+func (service *HTTPRestService) pruneNetworkContainerHistory() {
+	var deleted []string
+	for id := range service.state.NetworkContainerHistory {
+		if _, ok := service.state.ContainerStatus[id]; !ok {
+			deleted = append(deleted, id)
+		}
+	}
+
+	if len(deleted) <= maxDeletedNetworkContainerHistories {
+		return
+	}
+
+	lastChange := func(id string) time.Time {
+		history := service.state.NetworkContainerHistory[id]
+		return history[len(history)-1].Time
+	}
+
+	sort.Slice(deleted, func(i, j int) bool { return lastChange(deleted[i]).Before(lastChange(deleted[j])) })
+
+	for _, id := range deleted[:len(deleted)-maxDeletedNetworkContainerHistories] {
+		delete(service.state.NetworkContainerHistory, id)
+	}
+}
+
+// rejectNetworkContainerDelete records a delete that was rejected because of a version conflict.
+// The caller must hold the service lock.
+func (service *HTTPRestService) rejectNetworkContainerDelete(req cns.DeleteNetworkContainerRequest, current containerstatus, returnCode int, returnMessage string) {
+	logger.Errorf(returnMessage)
+
+	service.recordNetworkContainerTransition(req.NetworkContainerid, cns.NetworkContainerTransition{
+		Operation:       cns.NetworkContainerDelete,
+		PreviousVersion: current.VMVersion,
+		Version:         req.Version,
+		ReturnCode:      returnCode,
+		Message:         returnMessage,
+	})
+
+	service.saveState()
+}
+
+// getNetworkContainerTransitions returns a copy of the goal state history of a network container.
+func (service *HTTPRestService) getNetworkContainerTransitions(networkContainerID string) ([]cns.NetworkContainerTransition, bool) {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	history, ok := service.state.NetworkContainerHistory[networkContainerID]
+	return append([]cns.NetworkContainerTransition(nil), history...), ok
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/cns"
+)
+
+// TestNetworkContainerHistoryLimits tests if histories are capped per network container and for deleted network containers.
+func TestNetworkContainerHistoryLimits(t *testing.T) {
+	service := &HTTPRestService{
+		state: &httpRestServiceState{
+			ContainerStatus: map[string]containerstatus{"nc": newTestContainerStatus("nc", "1", "")},
+		},
+	}
+
+	for i := 0; i < maxNetworkContainerHistory+5; i++ {
+		service.recordNetworkContainerTransition("nc", cns.NetworkContainerTransition{
+			Operation: cns.NetworkContainerUpdate,
+			Version:   fmt.Sprint(i),
+		})
+	}
+
+	history, _ := service.getNetworkContainerTransitions("nc")
+	if len(history) != maxNetworkContainerHistory || history[len(history)-1].Version != fmt.Sprint(maxNetworkContainerHistory+4) {
+		t.Fatalf("Unexpected history %+v", history)
+	}
+
+	for i := 0; i < maxDeletedNetworkContainerHistories+5; i++ {
+		service.recordNetworkContainerTransition(fmt.Sprintf("deleted%d", i), cns.NetworkContainerTransition{
+			Operation: cns.NetworkContainerDelete,
+		})
+	}
+
+	if len(service.state.NetworkContainerHistory) != maxDeletedNetworkContainerHistories+1 {
+		t.Fatalf("Unexpected number of histories %d", len(service.state.NetworkContainerHistory))
+	}
+
+	// The histories of existing network containers are kept.
+	if _, ok := service.getNetworkContainerTransitions("nc"); !ok {
+		t.Fatalf("History of an existing network container was dropped")
+	}
+}
+
+// TestCheckNetworkContainerVersion tests if only older numeric versions conflict.
+func TestCheckNetworkContainerVersion(t *testing.T) {
+	current := newTestContainerStatus("nc", "5", "")
+
+	tests := []struct {
+		exists     bool
+		version    string
+		returnCode int
+	}{
+		{false, "1", cns.Success},
+		{true, "4", cns.NetworkContainerVersionConflict},
+		{true, "5", cns.Success},
+		{true, "6", cns.Success},
+		{true, "0.1", cns.Success},
+	}
+
+	for _, test := range tests {
+		if returnCode, _ := checkNetworkContainerVersion(current, test.exists, test.version); returnCode != test.returnCode {
+			t.Errorf("Version %s exists %v returned %d, expected %d", test.version, test.exists, returnCode, test.returnCode)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/logger"
+)
+
+const (
+	// Interval at which the host is polled for network containers that are not programmed yet.
+	ncVersionPollInterval = 2 * time.Second
+
+	// Maximum time between host queries for a network container after consecutive failures.
+	maxNCVersionBackoff = time.Minute
+)
+
+// ncVersionCheck tracks the host queries for a network container that is not programmed yet.
+type ncVersionCheck struct {
+	vmVersion string
+	failures  int
+	next      time.Time
+}
+
+// ncVersionWatcher polls the host in the background for the versions of network containers it has programmed,
+// so that requests never wait for the host while holding the service lock.
+type ncVersionWatcher struct {
+	service        *HTTPRestService
+	getHostVersion func(networkContainerID, primaryAddress, authToken string) (string, error)
+	checks         map[string]*ncVersionCheck
+	kick           chan struct{}
+	stop           chan struct{}
+	done           chan struct{}
+}
+
+// Creates a new watcher that queries the host through the service's IMDS client.
+func newNCVersionWatcher(service *HTTPRestService) *ncVersionWatcher {
+	watcher := &ncVersionWatcher{
+		service: service,
+		checks:  make(map[string]*ncVersionCheck),
+		kick:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	watcher.getHostVersion = func(networkContainerID, primaryAddress, authToken string) (string, error) {
+		containerVersion, err := service.imdsClient.GetNetworkContainerInfoFromHost(
+			networkContainerID, primaryAddress, authToken, swiftAPIVersion)
+		if err != nil {
+			return "", err
+		}
+
+		return containerVersion.ProgrammedVersion, nil
+	}
+
+	return watcher
+}
+
+// Starts polling the host in the background.
+func (watcher *ncVersionWatcher) start() {
+	go watcher.run()
+}
+
+// Stops polling the host and waits for the worker to exit.
+func (watcher *ncVersionWatcher) close() {
+	close(watcher.stop)
+	<-watcher.done
+}
+
+// Wakes the worker up, e.g. after a network container was created or updated.
+func (watcher *ncVersionWatcher) wake() {
+	select {
+	case watcher.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Polls the host until stopped.
+func (watcher *ncVersionWatcher) run() {
+	defer close(watcher.done)
+
+	for {
+		watcher.checkNetworkContainers(time.Now())
+
+		timer := time.NewTimer(ncVersionPollInterval)
+
+		select {
+		case <-watcher.stop:
+			timer.Stop()
+			return
+		case <-watcher.kick:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Queries the host for the network containers that are not programmed yet and are due for a check.
+// Failed queries are retried with exponential backoff.
+func (watcher *ncVersionWatcher) checkNetworkContainers(now time.Time) {
+	pending := watcher.service.getUnprogrammedNetworkContainers()
+
+	for id := range watcher.checks {
+		if _, ok := pending[id]; !ok {
+			delete(watcher.checks, id)
+		}
+	}
+
+	for id, status := range pending {
+		check := watcher.checks[id]
+		if check == nil || check.vmVersion != status.VMVersion {
+			check = &ncVersionCheck{vmVersion: status.VMVersion, next: now}
+			watcher.checks[id] = check
+		}
+
+		if now.Before(check.next) {
+			continue
+		}
+
+		savedReq := status.CreateNetworkContainerRequest
+		hostVersion, err := watcher.getHostVersion(id, savedReq.PrimaryInterfaceIdentifier, savedReq.AuthorizationToken)
+		if err != nil {
+			check.failures++
+			check.next = now.Add(ncVersionBackoff(check.failures))
+			logger.Printf("[Azure CNS] Failed to get host version of network container %s, retrying in %v, err:%v",
+				id, check.next.Sub(now), err)
+		} else {
+			check.failures = 0
+			check.next = now
+		}
+
+		watcher.service.setNetworkContainerHostVersion(id, hostVersion, err)
+	}
+}
+
+// Returns the delay until the next host query after the given number of consecutive failures.
+func ncVersionBackoff(failures int) time.Duration {
+	delay := ncVersionPollInterval
+
+	for i := 0; i < failures && delay < maxNCVersionBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxNCVersionBackoff {
+		delay = maxNCVersionBackoff
+	}
+
+	return delay
+}
+
+// isProgrammed returns whether the host has programmed the version of the network container requested by the VM.
+// Network containers without a primary interface are not programmed by the host and are always considered programmed.
+func (status *containerstatus) isProgrammed() bool {
+	if status.CreateNetworkContainerRequest.PrimaryInterfaceIdentifier == "" {
+		return true
+	}
+
+	if status.HostVersion == "" {
+		return false
+	}
+
+	result, ok := compareNetworkContainerVersions(status.HostVersion, status.VMVersion)
+	if !ok {
+		return status.HostVersion == status.VMVersion
+	}
+
+	return result >= 0
+}
+
+// compareNetworkContainerVersions compares two network container versions numerically and returns -1, 0 or 1.
+// ok is false if either version is not a number, in which case the versions can only be compared for equality.
+func compareNetworkContainerVersions(a string, b string) (result int, ok bool) {
+	aVersion, aErr := strconv.ParseInt(a, 10, 64)
+	bVersion, bErr := strconv.ParseInt(b, 10, 64)
+	if aErr != nil || bErr != nil {
+		return 0, false
+	}
+
+	switch {
+	case aVersion < bVersion:
+		return -1, true
+	case aVersion > bVersion:
+		return 1, true
+	default:
+		return 0, true
+	}
+}
+
+// Returns the network containers that are not programmed yet, keyed by ID.
+func (service *HTTPRestService) getUnprogrammedNetworkContainers() map[string]containerstatus {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	pending := make(map[string]containerstatus)
+	for id, status := range service.state.ContainerStatus {
+		if !status.isProgrammed() {
+			pending[id] = status
+		}
+	}
+
+	return pending
+}
+
+// Records the result of a host query for a network container. The state is saved if the host version changed.
+func (service *HTTPRestService) setNetworkContainerHostVersion(networkContainerID string, hostVersion string, hostErr error) {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	status, ok := service.state.ContainerStatus[networkContainerID]
+	if !ok {
+		return
+	}
+
+	if hostErr != nil {
+		status.HostVersionError = hostErr.Error()
+		service.state.ContainerStatus[networkContainerID] = status
+		return
+	}
+
+	status.HostVersionError = ""
+	changed := status.HostVersion != hostVersion
+	if changed {
+		status.HostVersion = hostVersion
+		status.HostVersionUpdatedAt = time.Now()
+	}
+
+	service.state.ContainerStatus[networkContainerID] = status
+
+	if changed {
+		logger.Printf("[Azure CNS] Network container %s host version %s, VM version %s, programmed: %v",
+			networkContainerID, status.HostVersion, status.VMVersion, status.isProgrammed())
+		service.publishNetworkContainerEvent(cns.NetworkContainerUpdate, status)
+		service.saveState()
+	}
+}
+
+// Wakes the network container version watcher up if it is running.
+func (service *HTTPRestService) wakeNCVersionWatcher() {
+	if service.ncVersionWatcher != nil {
+		service.ncVersionWatcher.wake()
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+)
+
+func newTestContainerStatus(id string, vmVersion string, primaryAddress string) containerstatus {
+	return containerstatus{
+		ID:        id,
+		VMVersion: vmVersion,
+		CreateNetworkContainerRequest: cns.CreateNetworkContainerRequest{
+			NetworkContainerid:         id,
+			Version:                    vmVersion,
+			PrimaryInterfaceIdentifier: primaryAddress,
+		},
+	}
+}
+
+// TestNCVersionWatcher tests if the watcher tracks host versions with backoff until network containers are programmed.
+func TestNCVersionWatcher(t *testing.T) {
+	service := &HTTPRestService{
+		state: &httpRestServiceState{
+			ContainerStatus: map[string]containerstatus{
+				"nc1": newTestContainerStatus("nc1", "2", "10.0.0.4"),
+				"nc2": newTestContainerStatus("nc2", "1", ""),
+			},
+		},
+	}
+
+	var queries []string
+	var hostVersion string
+	var hostErr error
+
+	watcher := newNCVersionWatcher(service)
+	watcher.getHostVersion = func(networkContainerID, primaryAddress, authToken string) (string, error) {
+		queries = append(queries, networkContainerID)
+		return hostVersion, hostErr
+	}
+
+	now := time.Now()
+
+	// A failed query is reported and retried after a backoff.
+	hostErr = errors.New("unreachable")
+	watcher.checkNetworkContainers(now)
+	if len(queries) != 1 || queries[0] != "nc1" {
+		t.Fatalf("Unexpected host queries %v", queries)
+	}
+
+	status, _ := service.getNetworkContainerDetails("nc1")
+	if status.isProgrammed() || status.HostVersionError != "unreachable" {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+
+	watcher.checkNetworkContainers(now.Add(ncVersionPollInterval))
+	if len(queries) != 1 {
+		t.Fatalf("Query was not backed off %v", queries)
+	}
+
+	// An older host version is recorded, but the network container is not programmed yet.
+	hostErr = nil
+	hostVersion = "1"
+	watcher.checkNetworkContainers(now.Add(ncVersionBackoff(1)))
+	status, _ = service.getNetworkContainerDetails("nc1")
+	if len(queries) != 2 || status.HostVersion != "1" || status.HostVersionError != "" || status.isProgrammed() {
+		t.Fatalf("Unexpected status %+v queries %v", status, queries)
+	}
+
+	// The network container is programmed once the host reaches the VM version.
+	hostVersion = "2"
+	watcher.checkNetworkContainers(now.Add(ncVersionBackoff(1)))
+	status, _ = service.getNetworkContainerDetails("nc1")
+	if !status.isProgrammed() || status.HostVersionUpdatedAt.IsZero() {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+
+	// Programmed network containers are not queried anymore.
+	watcher.checkNetworkContainers(now.Add(time.Hour))
+	if len(queries) != 3 || len(watcher.checks) != 0 {
+		t.Fatalf("Unexpected host queries %v checks %v", queries, watcher.checks)
+	}
+}
+
+// TestNCVersionBackoff tests if the backoff doubles up to the maximum.
+func TestNCVersionBackoff(t *testing.T) {
+	if ncVersionBackoff(0) != ncVersionPollInterval || ncVersionBackoff(1) != 2*ncVersionPollInterval {
+		t.Errorf("Unexpected backoff %v %v", ncVersionBackoff(0), ncVersionBackoff(1))
+	}
+
+	if ncVersionBackoff(100) != maxNCVersionBackoff {
+		t.Errorf("Unexpected maximum backoff %v", ncVersionBackoff(100))
+	}
+}
@@ -4,12 +4,14 @@
 package restserver
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -43,6 +45,8 @@ const (
 	detach          = "Detach"
 	// Rest service state identifier for named lock
 	stateJoinedNetworks = "JoinedNetworks"
+	// Named lock prefix serializing the requests for a network container
+	networkContainerLockPrefix = "NetworkContainer-"
 )
 
 // HTTPRestService represents http listener for CNS - Container Networking Service.
@@ -50,6 +54,7 @@ type HTTPRestService struct {
 	*cns.Service
 	dockerClient     *dockerclient.DockerClient
 	imdsClient       *imdsclient.ImdsClient
+	nmagentClient    *nmagentclient.Client
 	ipamClient       *ipamclient.IpamClient
 	networkContainer *networkcontainers.NetworkContainers
 	routingTable     *routes.RoutingTable
@@ -57,6 +62,9 @@ type HTTPRestService struct {
 	state            *httpRestServiceState
 	lock             sync.Mutex
 	dncPartitionKey  string
+	ncVersionWatcher *ncVersionWatcher
+	watchLog         *watchLog
+	shuttingDown     chan struct{}
 }
 
 // containerstatus is used to save status of an existing container
@@ -64,21 +72,27 @@ type containerstatus struct {
 	ID                            string
 	VMVersion                     string
 	HostVersion                   string
+	HostVersionUpdatedAt          time.Time // Time the host version was last observed to change.
+	HostVersionError              string    // Error of the last failed host query, if any.
 	CreateNetworkContainerRequest cns.CreateNetworkContainerRequest
 }
 
 // httpRestServiceState contains the state we would like to persist.
 type httpRestServiceState struct {
-	Location                         string
-	NetworkType                      string
-	OrchestratorType                 string
-	NodeID                           string
-	Initialized                      bool
-	ContainerIDByOrchestratorContext map[string]string          // OrchestratorContext is key and value is NetworkContainerID.
-	ContainerStatus                  map[string]containerstatus // NetworkContainerID is key.
-	Networks                         map[string]*networkInfo
-	TimeStamp                        time.Time
-	joinedNetworks                   map[string]struct{}
+	Location                          string
+	NetworkType                       string
+	OrchestratorType                  string
+	NodeID                            string
+	Initialized                       bool
+	ContainerIDByOrchestratorContext  map[string]string          // Deprecated: migrated to ContainerIDsByOrchestratorContext on restore.
+	ContainerIDsByOrchestratorContext map[string][]string        // OrchestratorContext is key and value is NetworkContainerIDs in interface order.
+	ContainerStatus                   map[string]containerstatus // NetworkContainerID is key.
+	Networks                          map[string]*networkInfo
+	Routes                            []routes.Route                              // Routes snapshotted before the network was created.
+	NetworkContainerHistory           map[string][]cns.NetworkContainerTransition // NetworkContainerID is key, oldest transition first.
+	Revision                          uint64                                      // Incremented on every change published to watchers.
+	TimeStamp                         time.Time
+	joinedNetworks                    map[string]struct{}
 }
 
 type networkInfo struct {
@@ -91,6 +105,7 @@ type networkInfo struct {
 type HTTPService interface {
 	common.ServiceAPI
 	SendNCSnapShotPeriodically(int, chan bool)
+	Shutdown(context.Context) error
 }
 
 // NewHTTPRestService creates a new HTTP Service object.
@@ -102,7 +117,7 @@ func NewHTTPRestService(config *common.ServiceConfig) (HTTPService, error) {
 
 	imdsClient := &imdsclient.ImdsClient{}
 	routingTable := &routes.RoutingTable{}
-	nc := &networkcontainers.NetworkContainers{}
+	nc := networkcontainers.NewNetworkContainers(config.ManageNetworkContainerNamespaces)
 	dc, err := dockerclient.NewDefaultDockerClient(imdsClient)
 
 	if err != nil {
@@ -123,10 +138,13 @@ func NewHTTPRestService(config *common.ServiceConfig) (HTTPService, error) {
 		store:            service.Service.Store,
 		dockerClient:     dc,
 		imdsClient:       imdsClient,
+		nmagentClient:    nmagentclient.NewClient(nmagentclient.Config{}),
 		ipamClient:       ic,
 		networkContainer: nc,
 		routingTable:     routingTable,
 		state:            serviceState,
+		watchLog:         newWatchLog(0),
+		shuttingDown:     make(chan struct{}),
 	}, nil
 }
 
@@ -151,6 +169,16 @@ func (service *HTTPRestService) Start(config *common.ServiceConfig) error {
 		return err
 	}
 
+	service.restoreRoutes()
+	service.restoreNetworkContainers()
+
+	// Watchers resume from the restored revision. Changes before the restart are not available to them.
+	service.watchLog = newWatchLog(service.state.Revision)
+
+	// Track the versions of network containers programmed by the host in the background.
+	service.ncVersionWatcher = newNCVersionWatcher(service)
+	service.ncVersionWatcher.start()
+
 	// Add handlers.
 	listener := service.Listener
 	// default handlers
@@ -162,12 +190,16 @@ func (service *HTTPRestService) Start(config *common.ServiceConfig) error {
 	listener.AddHandler(cns.GetHostLocalIPPath, service.getHostLocalIP)
 	listener.AddHandler(cns.GetIPAddressUtilizationPath, service.getIPAddressUtilization)
 	listener.AddHandler(cns.GetUnhealthyIPAddressesPath, service.getUnhealthyIPAddresses)
+	listener.AddHandler(cns.GetHealthReportPath, service.getHealthReport)
 	listener.AddHandler(cns.CreateOrUpdateNetworkContainer, service.createOrUpdateNetworkContainer)
 	listener.AddHandler(cns.DeleteNetworkContainer, service.deleteNetworkContainer)
 	listener.AddHandler(cns.GetNetworkContainerStatus, service.getNetworkContainerStatus)
+	listener.AddHandler(cns.GetNetworkContainerHistory, service.getNetworkContainerHistory)
+	listener.AddHandler(cns.Watch, service.watch)
 	listener.AddHandler(cns.GetInterfaceForContainer, service.getInterfaceForContainer)
 	listener.AddHandler(cns.SetOrchestratorType, service.setOrchestratorType)
 	listener.AddHandler(cns.GetNetworkContainerByOrchestratorContext, service.getNetworkContainerByOrchestratorContext)
+	listener.AddHandler(cns.GetNetworkContainersByOrchestratorContext, service.getNetworkContainersByOrchestratorContext)
 	listener.AddHandler(cns.AttachContainerToNetwork, service.attachNetworkContainerToNetwork)
 	listener.AddHandler(cns.DetachContainerFromNetwork, service.detachNetworkContainerFromNetwork)
 	listener.AddHandler(cns.CreateHnsNetworkPath, service.createHnsNetwork)
@@ -187,12 +219,16 @@ func (service *HTTPRestService) Start(config *common.ServiceConfig) error {
 	listener.AddHandler(cns.V2Prefix+cns.GetHostLocalIPPath, service.getHostLocalIP)
 	listener.AddHandler(cns.V2Prefix+cns.GetIPAddressUtilizationPath, service.getIPAddressUtilization)
 	listener.AddHandler(cns.V2Prefix+cns.GetUnhealthyIPAddressesPath, service.getUnhealthyIPAddresses)
+	listener.AddHandler(cns.V2Prefix+cns.GetHealthReportPath, service.getHealthReport)
 	listener.AddHandler(cns.V2Prefix+cns.CreateOrUpdateNetworkContainer, service.createOrUpdateNetworkContainer)
 	listener.AddHandler(cns.V2Prefix+cns.DeleteNetworkContainer, service.deleteNetworkContainer)
 	listener.AddHandler(cns.V2Prefix+cns.GetNetworkContainerStatus, service.getNetworkContainerStatus)
+	listener.AddHandler(cns.V2Prefix+cns.GetNetworkContainerHistory, service.getNetworkContainerHistory)
+	listener.AddHandler(cns.V2Prefix+cns.Watch, service.watch)
 	listener.AddHandler(cns.V2Prefix+cns.GetInterfaceForContainer, service.getInterfaceForContainer)
 	listener.AddHandler(cns.V2Prefix+cns.SetOrchestratorType, service.setOrchestratorType)
 	listener.AddHandler(cns.V2Prefix+cns.GetNetworkContainerByOrchestratorContext, service.getNetworkContainerByOrchestratorContext)
+	listener.AddHandler(cns.V2Prefix+cns.GetNetworkContainersByOrchestratorContext, service.getNetworkContainersByOrchestratorContext)
 	listener.AddHandler(cns.V2Prefix+cns.AttachContainerToNetwork, service.attachNetworkContainerToNetwork)
 	listener.AddHandler(cns.V2Prefix+cns.DetachContainerFromNetwork, service.detachNetworkContainerFromNetwork)
 	listener.AddHandler(cns.V2Prefix+cns.CreateHnsNetworkPath, service.createHnsNetwork)
@@ -201,6 +237,8 @@ func (service *HTTPRestService) Start(config *common.ServiceConfig) error {
 	listener.AddHandler(cns.V2Prefix+cns.CreateHostNCApipaEndpointPath, service.createHostNCApipaEndpoint)
 	listener.AddHandler(cns.V2Prefix+cns.DeleteHostNCApipaEndpointPath, service.deleteHostNCApipaEndpoint)
 
+	service.addDebugHandlers(config.EnableProfiling)
+
 	// Initialize HTTP client to be reused in CNS
 	connectionTimeout, _ := service.GetOption(acn.OptHttpConnectionTimeout).(int)
 	responseHeaderTimeout, _ := service.GetOption(acn.OptHttpResponseHeaderTimeout).(int)
@@ -214,10 +252,40 @@ func (service *HTTPRestService) Start(config *common.ServiceConfig) error {
 
 // Stop stops the CNS.
 func (service *HTTPRestService) Stop() {
+	if service.ncVersionWatcher != nil {
+		service.ncVersionWatcher.close()
+		service.ncVersionWatcher = nil
+	}
+
 	service.Uninitialize()
 	logger.Printf("[Azure CNS]  Service stopped.")
 }
 
+// Shutdown stops accepting requests and waits for the requests in flight until the context is done.
+// It then stops the background work and flushes the service state to the store.
+func (service *HTTPRestService) Shutdown(ctx context.Context) error {
+	logger.Printf("[Azure CNS]  Shutting down service.")
+
+	// Watch requests return early so that they do not hold up the shutdown.
+	close(service.shuttingDown)
+
+	err := service.Service.Shutdown(ctx)
+
+	if service.ncVersionWatcher != nil {
+		service.ncVersionWatcher.close()
+		service.ncVersionWatcher = nil
+	}
+
+	service.lock.Lock()
+	if saveErr := service.saveState(); err == nil {
+		err = saveErr
+	}
+	service.lock.Unlock()
+
+	logger.Printf("[Azure CNS]  Service shut down.")
+	return err
+}
+
 // GetPartitionKey - Get dnc/service partition key
 func (service *HTTPRestService) GetPartitionKey() (dncPartitionKey string) {
 	service.lock.Lock()
@@ -296,7 +364,7 @@ func (service *HTTPRestService) createNetwork(w http.ResponseWriter, r *http.Req
 
 		if err != nil {
 			returnMessage = fmt.Sprintf("[Azure CNS] Error. Unable to decode input request.")
-			returnCode = InvalidParameter
+			returnCode = cns.InvalidParameter
 		} else {
 			switch r.Method {
 			case "POST":
@@ -319,19 +387,21 @@ func (service *HTTPRestService) createNetwork(w http.ResponseWriter, r *http.Req
 								// network driver is not behaving as expected.
 								// The responsibility to restore routes is with network driver.
 								logger.Printf("[Azure CNS] Unable to get routing table from node, %+v.", err.Error())
+							} else {
+								service.state.Routes = rt.Routes
 							}
 
 							nicInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromHost()
 							if err != nil {
 								returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPrimaryInterfaceInfoFromHost failed %v.", err.Error())
-								returnCode = UnexpectedError
+								returnCode = cns.UnexpectedError
 								break
 							}
 
 							err = dc.CreateNetwork(req.NetworkName, nicInfo, req.Options)
 							if err != nil {
 								returnMessage = fmt.Sprintf("[Azure CNS] Error. CreateNetwork failed %v.", err.Error())
-								returnCode = UnexpectedError
+								returnCode = cns.UnexpectedError
 							}
 
 							err = rt.RestoreRoutingTable()
@@ -349,11 +419,11 @@ func (service *HTTPRestService) createNetwork(w http.ResponseWriter, r *http.Req
 
 						case "StandAlone":
 							returnMessage = fmt.Sprintf("[Azure CNS] Error. Underlay network is not supported in StandAlone environment. %v.", err.Error())
-							returnCode = UnsupportedEnvironment
+							returnCode = cns.UnsupportedEnvironment
 						}
 					case "Overlay":
 						returnMessage = fmt.Sprintf("[Azure CNS] Error. Overlay support not yet available. %v.", err.Error())
-						returnCode = UnsupportedEnvironment
+						returnCode = cns.UnsupportedEnvironment
 					}
 				} else {
 					returnMessage = fmt.Sprintf("[Azure CNS] Received a request to create an already existing network %v", req.NetworkName)
@@ -362,13 +432,13 @@ func (service *HTTPRestService) createNetwork(w http.ResponseWriter, r *http.Req
 
 			default:
 				returnMessage = "[Azure CNS] Error. CreateNetwork did not receive a POST."
-				returnCode = InvalidParameter
+				returnCode = cns.InvalidParameter
 			}
 		}
 
 	} else {
 		returnMessage = fmt.Sprintf("[Azure CNS] Error. CNS is not yet initialized with environment.")
-		returnCode = UnsupportedEnvironment
+		returnCode = cns.UnsupportedEnvironment
 	}
 
 	resp := &cns.Response{
@@ -410,20 +480,20 @@ func (service *HTTPRestService) deleteNetwork(w http.ResponseWriter, r *http.Req
 			err := dc.DeleteNetwork(req.NetworkName)
 			if err != nil {
 				returnMessage = fmt.Sprintf("[Azure CNS] Error. DeleteNetwork failed %v.", err.Error())
-				returnCode = UnexpectedError
+				returnCode = cns.UnexpectedError
 			}
 		} else {
 			if err == fmt.Errorf("Network not found") {
 				logger.Printf("[Azure CNS] Received a request to delete network that does not exist: %v.", req.NetworkName)
 			} else {
-				returnCode = UnexpectedError
+				returnCode = cns.UnexpectedError
 				returnMessage = err.Error()
 			}
 		}
 
 	default:
 		returnMessage = "[Azure CNS] Error. DeleteNetwork did not receive a POST."
-		returnCode = InvalidParameter
+		returnCode = cns.InvalidParameter
 	}
 
 	resp := &cns.Response{
@@ -455,7 +525,7 @@ func (service *HTTPRestService) createHnsNetwork(w http.ResponseWriter, r *http.
 
 	if err != nil {
 		returnMessage = fmt.Sprintf("[Azure CNS] Error. Unable to decode input request.")
-		returnCode = InvalidParameter
+		returnCode = cns.InvalidParameter
 	} else {
 		switch r.Method {
 		case "POST":
@@ -469,11 +539,11 @@ func (service *HTTPRestService) createHnsNetwork(w http.ResponseWriter, r *http.
 				returnMessage = fmt.Sprintf("[Azure CNS] Successfully created HNS network: %s", req.NetworkName)
 			} else {
 				returnMessage = fmt.Sprintf("[Azure CNS] CreateHnsNetwork failed with error %v", err.Error())
-				returnCode = UnexpectedError
+				returnCode = cns.UnexpectedError
 			}
 		default:
 			returnMessage = "[Azure CNS] Error. CreateHnsNetwork did not receive a POST."
-			returnCode = InvalidParameter
+			returnCode = cns.InvalidParameter
 		}
 	}
 
@@ -505,7 +575,7 @@ func (service *HTTPRestService) deleteHnsNetwork(w http.ResponseWriter, r *http.
 
 	if err != nil {
 		returnMessage = fmt.Sprintf("[Azure CNS] Error. Unable to decode input request.")
-		returnCode = InvalidParameter
+		returnCode = cns.InvalidParameter
 	} else {
 		switch r.Method {
 		case "POST":
@@ -515,15 +585,15 @@ func (service *HTTPRestService) deleteHnsNetwork(w http.ResponseWriter, r *http.
 					returnMessage = fmt.Sprintf("[Azure CNS] Successfully deleted HNS network: %s", req.NetworkName)
 				} else {
 					returnMessage = fmt.Sprintf("[Azure CNS] DeleteHnsNetwork failed with error %v", err.Error())
-					returnCode = UnexpectedError
+					returnCode = cns.UnexpectedError
 				}
 			} else {
 				returnMessage = fmt.Sprintf("[Azure CNS] Network %s not found", req.NetworkName)
-				returnCode = InvalidParameter
+				returnCode = cns.InvalidParameter
 			}
 		default:
 			returnMessage = "[Azure CNS] Error. DeleteHnsNetwork did not receive a POST."
-			returnCode = InvalidParameter
+			returnCode = cns.InvalidParameter
 		}
 	}
 
@@ -560,7 +630,7 @@ func (service *HTTPRestService) reserveIPAddress(w http.ResponseWriter, r *http.
 	}
 
 	if req.ReservationID == "" {
-		returnCode = ReservationNotFound
+		returnCode = cns.ReservationNotFound
 		returnMessage = fmt.Sprintf("[Azure CNS] Error. ReservationId is empty")
 	}
 
@@ -571,42 +641,43 @@ func (service *HTTPRestService) reserveIPAddress(w http.ResponseWriter, r *http.
 		ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
 		if err != nil {
 			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPrimaryIfaceInfo failed %v", err.Error())
-			returnCode = UnexpectedError
+			returnCode = cns.UnexpectedError
 			break
 		}
 
 		asID, err := ic.GetAddressSpace()
 		if err != nil {
 			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetAddressSpace failed %v", err.Error())
-			returnCode = UnexpectedError
+			returnCode = cns.UnexpectedError
 			break
 		}
 
 		poolID, err := ic.GetPoolID(asID, ifInfo.Subnet)
 		if err != nil {
 			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPoolID failed %v", err.Error())
-			returnCode = UnexpectedError
+			returnCode = cns.UnexpectedError
 			break
 		}
 
 		addr, err = ic.ReserveIPAddress(poolID, req.ReservationID)
 		if err != nil {
 			returnMessage = fmt.Sprintf("[Azure CNS] ReserveIpAddress failed with %+v", err.Error())
-			returnCode = AddressUnavailable
+			returnCode = cns.AddressUnavailable
 			break
 		}
 
 		addressIP, _, err := net.ParseCIDR(addr)
 		if err != nil {
 			returnMessage = fmt.Sprintf("[Azure CNS] ParseCIDR failed with %+v", err.Error())
-			returnCode = UnexpectedError
+			returnCode = cns.UnexpectedError
 			break
 		}
 		address = addressIP.String()
+		service.publishIPAddressEvent(cns.NetworkContainerCreate, req.ReservationID, address)
 
 	default:
 		returnMessage = "[Azure CNS] Error. ReserveIP did not receive a POST."
-		returnCode = InvalidParameter
+		returnCode = cns.InvalidParameter
 
 	}
 
@@ -636,7 +707,7 @@ func (service *HTTPRestService) releaseIPAddress(w http.ResponseWriter, r *http.
 	}
 
 	if req.ReservationID == "" {
-		returnCode = ReservationNotFound
+		returnCode = cns.ReservationNotFound
 		returnMessage = fmt.Sprintf("[Azure CNS] Error. ReservationId is empty")
 	}
 
@@ -647,33 +718,36 @@ func (service *HTTPRestService) releaseIPAddress(w http.ResponseWriter, r *http.
 		ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
 		if err != nil {
 			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPrimaryIfaceInfo failed %v", err.Error())
-			returnCode = UnexpectedError
+			returnCode = cns.UnexpectedError
 			break
 		}
 
 		asID, err := ic.GetAddressSpace()
 		if err != nil {
 			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetAddressSpace failed %v", err.Error())
-			returnCode = UnexpectedError
+			returnCode = cns.UnexpectedError
 			break
 		}
 
 		poolID, err := ic.GetPoolID(asID, ifInfo.Subnet)
 		if err != nil {
 			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPoolID failed %v", err.Error())
-			returnCode = UnexpectedError
+			returnCode = cns.UnexpectedError
 			break
 		}
 
 		err = ic.ReleaseIPAddress(poolID, req.ReservationID)
 		if err != nil {
 			returnMessage = fmt.Sprintf("[Azure CNS] ReleaseIpAddress failed with %+v", err.Error())
-			returnCode = ReservationNotFound
+			returnCode = cns.ReservationNotFound
+			break
 		}
 
+		service.publishIPAddressEvent(cns.NetworkContainerDelete, req.ReservationID, "")
+
 	default:
 		returnMessage = "[Azure CNS] Error. ReleaseIP did not receive a POST."
-		returnCode = InvalidParameter
+		returnCode = cns.InvalidParameter
 	}
 
 	resp := cns.Response{
@@ -685,6 +759,38 @@ func (service *HTTPRestService) releaseIPAddress(w http.ResponseWriter, r *http.
 	logger.Response(service.Name, resp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
 }
 
+// Returns the ID, subnet and utilization of the address pool of the primary interface.
+func (service *HTTPRestService) getPrimaryPoolUtilization() (
+	poolID string, subnet string, capacity int, available int, unhealthyAddrs []string, err error) {
+	ic := service.ipamClient
+
+	ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
+	if err != nil {
+		err = fmt.Errorf("GetPrimaryIfaceInfo failed %v", err)
+		return
+	}
+
+	asID, err := ic.GetAddressSpace()
+	if err != nil {
+		err = fmt.Errorf("GetAddressSpace failed %v", err)
+		return
+	}
+
+	poolID, err = ic.GetPoolID(asID, ifInfo.Subnet)
+	if err != nil {
+		err = fmt.Errorf("GetPoolID failed %v", err)
+		return
+	}
+
+	capacity, available, unhealthyAddrs, err = ic.GetIPAddressUtilization(poolID)
+	if err != nil {
+		err = fmt.Errorf("GetIPUtilization failed %v", err)
+		return
+	}
+
+	return poolID, ifInfo.Subnet, capacity, available, unhealthyAddrs, nil
+}
+
 // Retrieves the host local ip address. Containers can talk to host using this IP address.
 func (service *HTTPRestService) getHostLocalIP(w http.ResponseWriter, r *http.Request) {
 	logger.Printf("[Azure CNS] getHostLocalIP")
@@ -720,7 +826,7 @@ func (service *HTTPRestService) getHostLocalIP(w http.ResponseWriter, r *http.Re
 
 	returnCode := 0
 	if !found {
-		returnCode = NotFound
+		returnCode = cns.NotFound
 		if errmsg == "" {
 			errmsg = "[Azure-CNS] Unable to get host local ip. Check if environment is initialized.."
 		}
@@ -750,40 +856,18 @@ func (service *HTTPRestService) getIPAddressUtilization(w http.ResponseWriter, r
 
 	switch r.Method {
 	case "GET":
-		ic := service.ipamClient
-
-		ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPrimaryIfaceInfo failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		asID, err := ic.GetAddressSpace()
+		var err error
+		_, _, capacity, available, unhealthyAddrs, err = service.getPrimaryPoolUtilization()
 		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetAddressSpace failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		poolID, err := ic.GetPoolID(asID, ifInfo.Subnet)
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPoolID failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		capacity, available, unhealthyAddrs, err = ic.GetIPAddressUtilization(poolID)
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetIPUtilization failed %v", err.Error())
-			returnCode = UnexpectedError
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. %v", err)
+			returnCode = cns.UnexpectedError
 			break
 		}
 		logger.Printf("[Azure CNS] Capacity %v Available %v UnhealthyAddrs %v", capacity, available, unhealthyAddrs)
 
 	default:
 		returnMessage = "[Azure CNS] Error. GetIPUtilization did not receive a GET."
-		returnCode = InvalidParameter
+		returnCode = cns.InvalidParameter
 	}
 
 	resp := cns.Response{
@@ -843,9 +927,8 @@ func (service *HTTPRestService) getUnhealthyIPAddresses(w http.ResponseWriter, r
 
 	returnMessage := ""
 	returnCode := 0
-	capacity := 0
-	available := 0
 	var unhealthyAddrs []string
+	var unhealthyReasons map[string]string
 
 	switch r.Method {
 	case "GET":
@@ -854,35 +937,35 @@ func (service *HTTPRestService) getUnhealthyIPAddresses(w http.ResponseWriter, r
 		ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
 		if err != nil {
 			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPrimaryIfaceInfo failed %v", err.Error())
-			returnCode = UnexpectedError
+			returnCode = cns.UnexpectedError
 			break
 		}
 
 		asID, err := ic.GetAddressSpace()
 		if err != nil {
 			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetAddressSpace failed %v", err.Error())
-			returnCode = UnexpectedError
+			returnCode = cns.UnexpectedError
 			break
 		}
 
 		poolID, err := ic.GetPoolID(asID, ifInfo.Subnet)
 		if err != nil {
 			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPoolID failed %v", err.Error())
-			returnCode = UnexpectedError
+			returnCode = cns.UnexpectedError
 			break
 		}
 
-		capacity, available, unhealthyAddrs, err = ic.GetIPAddressUtilization(poolID)
+		unhealthyAddrs, unhealthyReasons, err = ic.GetUnhealthyIPAddresses(poolID)
 		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetIPUtilization failed %v", err.Error())
-			returnCode = UnexpectedError
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetUnhealthyIPAddresses failed %v", err.Error())
+			returnCode = cns.UnexpectedError
 			break
 		}
-		logger.Printf("[Azure CNS] Capacity %v Available %v UnhealthyAddrs %v", capacity, available, unhealthyAddrs)
+		logger.Printf("[Azure CNS] UnhealthyAddrs %v Reasons %v", unhealthyAddrs, unhealthyReasons)
 
 	default:
 		returnMessage = "[Azure CNS] Error. GetUnhealthyIP did not receive a POST."
-		returnCode = InvalidParameter
+		returnCode = cns.InvalidParameter
 	}
 
 	resp := cns.Response{
@@ -891,8 +974,9 @@ func (service *HTTPRestService) getUnhealthyIPAddresses(w http.ResponseWriter, r
 	}
 
 	ipResp := &cns.GetIPAddressesResponse{
-		Response:    resp,
-		IPAddresses: unhealthyAddrs,
+		Response:         resp,
+		IPAddresses:      unhealthyAddrs,
+		UnhealthyReasons: unhealthyReasons,
 	}
 
 	err := service.Listener.Encode(w, &ipResp)
@@ -977,6 +1061,21 @@ func (service *HTTPRestService) restoreState() error {
 		return err
 	}
 
+	// Migrate the single network container per orchestrator context of older states.
+	if len(service.state.ContainerIDByOrchestratorContext) > 0 {
+		if service.state.ContainerIDsByOrchestratorContext == nil {
+			service.state.ContainerIDsByOrchestratorContext = make(map[string][]string)
+		}
+
+		for orchestratorContext, containerID := range service.state.ContainerIDByOrchestratorContext {
+			if _, ok := service.state.ContainerIDsByOrchestratorContext[orchestratorContext]; !ok {
+				service.state.ContainerIDsByOrchestratorContext[orchestratorContext] = []string{containerID}
+			}
+		}
+
+		service.state.ContainerIDByOrchestratorContext = nil
+	}
+
 	logger.Printf("[Azure CNS]  Restored state, %+v\n", service.state)
 	return nil
 }
@@ -1020,11 +1119,11 @@ func (service *HTTPRestService) setOrchestratorType(w http.ResponseWriter, r *ht
 			service.saveState()
 		default:
 			returnMessage = fmt.Sprintf("Invalid Orchestrator type %v", req.OrchestratorType)
-			returnCode = UnsupportedOrchestratorType
+			returnCode = cns.UnsupportedOrchestratorType
 		}
 	} else {
 		returnMessage = fmt.Sprintf("Invalid request since this node has already been registered as %s", nodeID)
-		returnCode = InvalidRequest
+		returnCode = cns.InvalidRequest
 	}
 
 	service.lock.Unlock()
@@ -1044,9 +1143,29 @@ func (service *HTTPRestService) saveNetworkContainerGoalState(req cns.CreateNetw
 	defer service.lock.Unlock()
 
 	existing, ok := service.state.ContainerStatus[req.NetworkContainerid]
+
+	// Reject goal states older than the current one, e.g. requests that were delivered late.
+	if returnCode, returnMessage := checkNetworkContainerVersion(existing, ok, req.Version); returnCode != cns.Success {
+		logger.Errorf(returnMessage)
+		service.recordNetworkContainerTransition(req.NetworkContainerid, cns.NetworkContainerTransition{
+			Operation:       cns.NetworkContainerUpdate,
+			PreviousVersion: existing.VMVersion,
+			Version:         req.Version,
+			ReturnCode:      returnCode,
+			Message:         returnMessage,
+		})
+		service.saveState()
+		return returnCode, returnMessage
+	}
+
+	transition := cns.NetworkContainerTransition{Operation: cns.NetworkContainerCreate, Version: req.Version}
 	var hostVersion string
+	var hostVersionUpdatedAt time.Time
 	if ok {
+		transition.Operation = cns.NetworkContainerUpdate
+		transition.PreviousVersion = existing.VMVersion
 		hostVersion = existing.HostVersion
+		hostVersionUpdatedAt = existing.HostVersionUpdatedAt
 	}
 
 	if service.state.ContainerStatus == nil {
@@ -1058,7 +1177,11 @@ func (service *HTTPRestService) saveNetworkContainerGoalState(req cns.CreateNetw
 			ID:                            req.NetworkContainerid,
 			VMVersion:                     req.Version,
 			CreateNetworkContainerRequest: req,
-			HostVersion:                   hostVersion}
+			HostVersion:                   hostVersion,
+			HostVersionUpdatedAt:          hostVersionUpdatedAt}
+
+	// Let the watcher query the host for the new version right away.
+	defer service.wakeNCVersionWatcher()
 
 	switch req.NetworkContainerType {
 	case cns.AzureContainerInstance:
@@ -1088,29 +1211,27 @@ func (service *HTTPRestService) saveNetworkContainerGoalState(req cns.CreateNetw
 			err := json.Unmarshal(req.OrchestratorContext, &podInfo)
 			if err != nil {
 				errBuf := fmt.Sprintf("Unmarshalling %s failed with error %v", req.NetworkContainerType, err)
-				return UnexpectedError, errBuf
+				return cns.UnexpectedError, errBuf
 			}
 
 			logger.Printf("Pod info %v", podInfo)
 
-			if service.state.ContainerIDByOrchestratorContext == nil {
-				service.state.ContainerIDByOrchestratorContext = make(map[string]string)
-			}
-
-			service.state.ContainerIDByOrchestratorContext[podInfo.PodName+podInfo.PodNamespace] = req.NetworkContainerid
+			service.addNetworkContainerToOrchestratorContext(podInfo.PodName+podInfo.PodNamespace, req)
 			break
 
 		default:
 			errMsg := fmt.Sprintf("Unsupported orchestrator type: %s", service.state.OrchestratorType)
 			logger.Errorf(errMsg)
-			return UnsupportedOrchestratorType, errMsg
+			return cns.UnsupportedOrchestratorType, errMsg
 		}
 	default:
 		errMsg := fmt.Sprintf("Unsupported network container type %s", req.NetworkContainerType)
 		logger.Errorf(errMsg)
-		return UnsupportedNetworkContainerType, errMsg
+		return cns.UnsupportedNetworkContainerType, errMsg
 	}
 
+	service.recordNetworkContainerTransition(req.NetworkContainerid, transition)
+	service.publishNetworkContainerEvent(transition.Operation, service.state.ContainerStatus[req.NetworkContainerid])
 	service.saveState()
 	return 0, ""
 }
@@ -1129,36 +1250,44 @@ func (service *HTTPRestService) createOrUpdateNetworkContainer(w http.ResponseWr
 	}
 
 	if req.NetworkContainerid == "" {
-		returnCode = NetworkContainerNotSpecified
+		returnCode = cns.NetworkContainerNotSpecified
 		returnMessage = fmt.Sprintf("[Azure CNS] Error. NetworkContainerid is empty")
 	}
 
 	switch r.Method {
 	case "POST":
-		if req.NetworkContainerType == cns.WebApps {
-			// try to get the saved nc state if it exists
-			existing, ok := service.getNetworkContainerDetails(req.NetworkContainerid)
+		// hold the nc lock so that the version check, programming and saving see the same goal state
+		ncLock := networkContainerLockPrefix + req.NetworkContainerid
+		namedLock.LockAcquire(ncLock)
+		defer namedLock.LockRelease(ncLock)
+
+		// try to get the saved nc state if it exists
+		existing, ok := service.getNetworkContainerDetails(req.NetworkContainerid)
+
+		// stale versions are not programmed, saving the goal state rejects them
+		if stale, _ := checkNetworkContainerVersion(existing, ok, req.Version); stale != cns.Success {
+			returnCode, returnMessage = service.saveNetworkContainerGoalState(req)
+			break
+		}
 
+		if service.networkContainer.IsCreatedByCNS(req.NetworkContainerType) {
 			// create/update nc only if it doesn't exist or it exists and the requested version is different from the saved version
 			if !ok || (ok && existing.VMVersion != req.Version) {
 				nc := service.networkContainer
 				if err = nc.Create(req); err != nil {
 					returnMessage = fmt.Sprintf("[Azure CNS] Error. CreateOrUpdateNetworkContainer failed %v", err.Error())
-					returnCode = UnexpectedError
+					returnCode = cns.UnexpectedError
 					break
 				}
 			}
 		} else if req.NetworkContainerType == cns.AzureContainerInstance {
-			// try to get the saved nc state if it exists
-			existing, ok := service.getNetworkContainerDetails(req.NetworkContainerid)
-
 			// create/update nc only if it doesn't exist or it exists and the requested version is different from the saved version
 			if ok && existing.VMVersion != req.Version {
 				nc := service.networkContainer
 				netPluginConfig := service.getNetPluginDetails()
 				if err = nc.Update(req, netPluginConfig); err != nil {
 					returnMessage = fmt.Sprintf("[Azure CNS] Error. CreateOrUpdateNetworkContainer failed %v", err.Error())
-					returnCode = UnexpectedError
+					returnCode = cns.UnexpectedError
 					break
 				}
 			}
@@ -1168,7 +1297,7 @@ func (service *HTTPRestService) createOrUpdateNetworkContainer(w http.ResponseWr
 
 	default:
 		returnMessage = "[Azure CNS] Error. CreateOrUpdateNetworkContainer did not receive a POST."
-		returnCode = InvalidParameter
+		returnCode = cns.InvalidParameter
 	}
 
 	resp := cns.Response{
@@ -1210,9 +1339,57 @@ func (service *HTTPRestService) getNetworkContainerByID(w http.ResponseWriter, r
 	logger.Response(service.Name, reserveResp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
 }
 
-func (service *HTTPRestService) getNetworkContainerResponse(req cns.GetNetworkContainerRequest) cns.GetNetworkContainerResponse {
-	var containerID string
-	var getNetworkContainerResponse cns.GetNetworkContainerResponse
+// addNetworkContainerToOrchestratorContext adds a network container to the network containers of an orchestrator context.
+// It replaces the network container of the context with the same interface name, and removes the network container
+// from any other context. The network containers are kept in interface order.
+func (service *HTTPRestService) addNetworkContainerToOrchestratorContext(orchestratorContext string, req cns.CreateNetworkContainerRequest) {
+	if service.state.ContainerIDsByOrchestratorContext == nil {
+		service.state.ContainerIDsByOrchestratorContext = make(map[string][]string)
+	}
+
+	service.removeNetworkContainerFromOrchestratorContexts(req.NetworkContainerid)
+
+	var containerIDs []string
+	for _, containerID := range service.state.ContainerIDsByOrchestratorContext[orchestratorContext] {
+		existing, ok := service.state.ContainerStatus[containerID]
+		if ok && existing.CreateNetworkContainerRequest.InterfaceName == req.InterfaceName {
+			logger.Printf("Network container %s replaces %s on interface %q", req.NetworkContainerid, containerID, req.InterfaceName)
+			continue
+		}
+		containerIDs = append(containerIDs, containerID)
+	}
+
+	containerIDs = append(containerIDs, req.NetworkContainerid)
+
+	sort.SliceStable(containerIDs, func(i, j int) bool {
+		return service.state.ContainerStatus[containerIDs[i]].CreateNetworkContainerRequest.InterfaceOrder <
+			service.state.ContainerStatus[containerIDs[j]].CreateNetworkContainerRequest.InterfaceOrder
+	})
+
+	service.state.ContainerIDsByOrchestratorContext[orchestratorContext] = containerIDs
+}
+
+// removeNetworkContainerFromOrchestratorContexts removes a network container from the orchestrator contexts it belongs to.
+func (service *HTTPRestService) removeNetworkContainerFromOrchestratorContexts(networkContainerID string) {
+	for orchestratorContext, containerIDs := range service.state.ContainerIDsByOrchestratorContext {
+		for i, containerID := range containerIDs {
+			if containerID == networkContainerID {
+				containerIDs = append(containerIDs[:i:i], containerIDs[i+1:]...)
+				break
+			}
+		}
+
+		if len(containerIDs) == 0 {
+			delete(service.state.ContainerIDsByOrchestratorContext, orchestratorContext)
+		} else {
+			service.state.ContainerIDsByOrchestratorContext[orchestratorContext] = containerIDs
+		}
+	}
+}
+
+// getNetworkContainerResponses returns the network containers of the orchestrator context of a request, in interface order.
+func (service *HTTPRestService) getNetworkContainerResponses(req cns.GetNetworkContainerRequest) ([]cns.GetNetworkContainerResponse, cns.Response) {
+	var containerIDs []string
 
 	service.lock.Lock()
 	defer service.lock.Unlock()
@@ -1230,32 +1407,48 @@ func (service *HTTPRestService) getNetworkContainerResponse(req cns.GetNetworkCo
 		var podInfo cns.KubernetesPodInfo
 		err := json.Unmarshal(req.OrchestratorContext, &podInfo)
 		if err != nil {
-			getNetworkContainerResponse.Response.ReturnCode = UnexpectedError
-			getNetworkContainerResponse.Response.Message = fmt.Sprintf("Unmarshalling orchestrator context failed with error %v", err)
-			return getNetworkContainerResponse
+			return nil, cns.Response{
+				ReturnCode: cns.UnexpectedError,
+				Message:    fmt.Sprintf("Unmarshalling orchestrator context failed with error %v", err),
+			}
 		}
 
 		logger.Printf("pod info %+v", podInfo)
-		containerID = service.state.ContainerIDByOrchestratorContext[podInfo.PodName+podInfo.PodNamespace]
-		logger.Printf("containerid %v", containerID)
+		containerIDs = service.state.ContainerIDsByOrchestratorContext[podInfo.PodName+podInfo.PodNamespace]
+		logger.Printf("containerids %v", containerIDs)
 		break
 
 	default:
-		getNetworkContainerResponse.Response.ReturnCode = UnsupportedOrchestratorType
-		getNetworkContainerResponse.Response.Message = fmt.Sprintf("Invalid orchestrator type %v", service.state.OrchestratorType)
-		return getNetworkContainerResponse
+		return nil, cns.Response{
+			ReturnCode: cns.UnsupportedOrchestratorType,
+			Message:    fmt.Sprintf("Invalid orchestrator type %v", service.state.OrchestratorType),
+		}
 	}
 
-	containerStatus := service.state.ContainerStatus
-	containerDetails, ok := containerStatus[containerID]
-	if !ok {
-		getNetworkContainerResponse.Response.ReturnCode = UnknownContainerID
-		getNetworkContainerResponse.Response.Message = "NetworkContainer doesn't exist."
-		return getNetworkContainerResponse
+	var responses []cns.GetNetworkContainerResponse
+	for _, containerID := range containerIDs {
+		containerDetails, ok := service.state.ContainerStatus[containerID]
+		if !ok {
+			continue
+		}
+
+		responses = append(responses, newNetworkContainerResponse(containerDetails))
+	}
+
+	if len(responses) == 0 {
+		return nil, cns.Response{
+			ReturnCode: cns.UnknownContainerID,
+			Message:    "NetworkContainer doesn't exist.",
+		}
 	}
 
+	return responses, cns.Response{}
+}
+
+// newNetworkContainerResponse returns the network configuration of a network container.
+func newNetworkContainerResponse(containerDetails containerstatus) cns.GetNetworkContainerResponse {
 	savedReq := containerDetails.CreateNetworkContainerRequest
-	getNetworkContainerResponse = cns.GetNetworkContainerResponse{
+	return cns.GetNetworkContainerResponse{
 		NetworkContainerID:         savedReq.NetworkContainerid,
 		IPConfiguration:            savedReq.IPConfiguration,
 		Routes:                     savedReq.Routes,
@@ -1265,9 +1458,19 @@ func (service *HTTPRestService) getNetworkContainerResponse(req cns.GetNetworkCo
 		LocalIPConfiguration:       savedReq.LocalIPConfiguration,
 		AllowHostToNCCommunication: savedReq.AllowHostToNCCommunication,
 		AllowNCToHostCommunication: savedReq.AllowNCToHostCommunication,
+		InterfaceName:              savedReq.InterfaceName,
+		Programmed:                 containerDetails.isProgrammed(),
+	}
+}
+
+// getNetworkContainerResponse returns the primary network container of the orchestrator context of a request.
+func (service *HTTPRestService) getNetworkContainerResponse(req cns.GetNetworkContainerRequest) cns.GetNetworkContainerResponse {
+	responses, resp := service.getNetworkContainerResponses(req)
+	if resp.ReturnCode != 0 {
+		return cns.GetNetworkContainerResponse{Response: resp}
 	}
 
-	return getNetworkContainerResponse
+	return responses[0]
 }
 
 func (service *HTTPRestService) getNetworkContainerByOrchestratorContext(w http.ResponseWriter, r *http.Request) {
@@ -1295,6 +1498,33 @@ func (service *HTTPRestService) getNetworkContainerByOrchestratorContext(w http.
 	logger.Response(service.Name, getNetworkContainerResponse, returnCode, ReturnCodeToString(returnCode), err)
 }
 
+func (service *HTTPRestService) getNetworkContainersByOrchestratorContext(w http.ResponseWriter, r *http.Request) {
+	logger.Printf("[Azure CNS] getNetworkContainersByOrchestratorContext")
+
+	var req cns.GetNetworkContainerRequest
+
+	err := service.Listener.Decode(w, r, &req)
+	logger.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	// Multitenancy requires the SDNRemoteArpMacAddress regKey on windows platform.
+	if err = platform.SetSdnRemoteArpMacAddress(); err != nil {
+		logger.Printf("[Azure CNS] SetSdnRemoteArpMacAddress failed with error: %s", err.Error())
+		return
+	}
+
+	networkContainers, resp := service.getNetworkContainerResponses(req)
+	getNetworkContainersResponse := cns.GetNetworkContainersResponse{
+		NetworkContainers: networkContainers,
+		Response:          resp,
+	}
+
+	err = service.Listener.Encode(w, &getNetworkContainersResponse)
+	logger.Response(service.Name, getNetworkContainersResponse, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
+}
+
 func (service *HTTPRestService) deleteNetworkContainer(w http.ResponseWriter, r *http.Request) {
 	logger.Printf("[Azure CNS] deleteNetworkContainer")
 
@@ -1309,12 +1539,17 @@ func (service *HTTPRestService) deleteNetworkContainer(w http.ResponseWriter, r
 	}
 
 	if req.NetworkContainerid == "" {
-		returnCode = NetworkContainerNotSpecified
+		returnCode = cns.NetworkContainerNotSpecified
 		returnMessage = fmt.Sprintf("[Azure CNS] Error. NetworkContainerid is empty")
 	}
 
 	switch r.Method {
 	case "POST":
+		// hold the nc lock so that the goal state cannot change between the version check and the delete
+		ncLock := networkContainerLockPrefix + req.NetworkContainerid
+		namedLock.LockAcquire(ncLock)
+		defer namedLock.LockRelease(ncLock)
+
 		var containerStatus containerstatus
 		var ok bool
 
@@ -1325,11 +1560,18 @@ func (service *HTTPRestService) deleteNetworkContainer(w http.ResponseWriter, r
 			break
 		}
 
-		if containerStatus.CreateNetworkContainerRequest.NetworkContainerType == cns.WebApps {
+		if returnCode, returnMessage = checkNetworkContainerDeleteVersion(containerStatus, req.Version); returnCode != cns.Success {
+			service.lock.Lock()
+			service.rejectNetworkContainerDelete(req, containerStatus, returnCode, returnMessage)
+			service.lock.Unlock()
+			break
+		}
+
+		if service.networkContainer.IsCreatedByCNS(containerStatus.CreateNetworkContainerRequest.NetworkContainerType) {
 			nc := service.networkContainer
 			if err := nc.Delete(req.NetworkContainerid); err != nil {
 				returnMessage = fmt.Sprintf("[Azure CNS] Error. DeleteNetworkContainer failed %v", err.Error())
-				returnCode = UnexpectedError
+				returnCode = cns.UnexpectedError
 				break
 			}
 		}
@@ -1337,24 +1579,25 @@ func (service *HTTPRestService) deleteNetworkContainer(w http.ResponseWriter, r
 		service.lock.Lock()
 		defer service.lock.Unlock()
 
-		if service.state.ContainerStatus != nil {
-			delete(service.state.ContainerStatus, req.NetworkContainerid)
-		}
+		delete(service.state.ContainerStatus, req.NetworkContainerid)
 
-		if service.state.ContainerIDByOrchestratorContext != nil {
-			for orchestratorContext, networkContainerID := range service.state.ContainerIDByOrchestratorContext {
-				if networkContainerID == req.NetworkContainerid {
-					delete(service.state.ContainerIDByOrchestratorContext, orchestratorContext)
-					break
-				}
-			}
-		}
+		service.removeNetworkContainerFromOrchestratorContexts(req.NetworkContainerid)
+		service.recordNetworkContainerTransition(req.NetworkContainerid, cns.NetworkContainerTransition{
+			Operation:       cns.NetworkContainerDelete,
+			PreviousVersion: containerStatus.VMVersion,
+		})
+		service.publishWatchEvent(cns.WatchEvent{
+			Kind:                cns.WatchKindNetworkContainer,
+			Operation:           cns.NetworkContainerDelete,
+			NetworkContainerID:  req.NetworkContainerid,
+			OrchestratorContext: containerStatus.CreateNetworkContainerRequest.OrchestratorContext,
+		})
 
 		service.saveState()
 		break
 	default:
 		returnMessage = "[Azure CNS] Error. DeleteNetworkContainer did not receive a POST."
-		returnCode = InvalidParameter
+		returnCode = cns.InvalidParameter
 	}
 
 	resp := cns.Response{
@@ -1380,37 +1623,25 @@ func (service *HTTPRestService) getNetworkContainerStatus(w http.ResponseWriter,
 		return
 	}
 
-	service.lock.Lock()
-	defer service.lock.Unlock()
-	var ok bool
-	var containerDetails containerstatus
-
-	containerInfo := service.state.ContainerStatus
-	if containerInfo != nil {
-		containerDetails, ok = containerInfo[req.NetworkContainerid]
-	} else {
-		ok = false
-	}
-
 	var hostVersion string
 	var vmVersion string
+	var programmed bool
 
+	// The host version is tracked by the network container version watcher.
+	containerDetails, ok := service.getNetworkContainerDetails(req.NetworkContainerid)
 	if ok {
-		savedReq := containerDetails.CreateNetworkContainerRequest
-		containerVersion, err := service.imdsClient.GetNetworkContainerInfoFromHost(
-			req.NetworkContainerid,
-			savedReq.PrimaryInterfaceIdentifier,
-			savedReq.AuthorizationToken, swiftAPIVersion)
-
-		if err != nil {
-			returnCode = CallToHostFailed
-			returnMessage = err.Error()
-		} else {
-			hostVersion = containerVersion.ProgrammedVersion
+		hostVersion = containerDetails.HostVersion
+		vmVersion = containerDetails.VMVersion
+		programmed = containerDetails.isProgrammed()
+
+		// Report host failures until the host has answered at least once.
+		if hostVersion == "" && containerDetails.HostVersionError != "" {
+			returnCode = cns.CallToHostFailed
+			returnMessage = containerDetails.HostVersionError
 		}
 	} else {
 		returnMessage = "[Azure CNS] Never received call to create this container."
-		returnCode = UnknownContainerID
+		returnCode = cns.UnknownContainerID
 	}
 
 	resp := cns.Response{
@@ -1423,12 +1654,47 @@ func (service *HTTPRestService) getNetworkContainerStatus(w http.ResponseWriter,
 		NetworkContainerid: req.NetworkContainerid,
 		AzureHostVersion:   hostVersion,
 		Version:            vmVersion,
+		Programmed:         programmed,
 	}
 
 	err = service.Listener.Encode(w, &networkContainerStatusReponse)
 	logger.Response(service.Name, networkContainerStatusReponse, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
 }
 
+func (service *HTTPRestService) getNetworkContainerHistory(w http.ResponseWriter, r *http.Request) {
+	logger.Printf("[Azure CNS] getNetworkContainerHistory")
+
+	var req cns.GetNetworkContainerHistoryRequest
+	returnMessage := ""
+	returnCode := 0
+
+	err := service.Listener.Decode(w, r, &req)
+	logger.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	history, ok := service.getNetworkContainerTransitions(req.NetworkContainerid)
+	if !ok {
+		returnMessage = "[Azure CNS] No goal state transitions recorded for this container."
+		returnCode = cns.UnknownContainerID
+	}
+
+	resp := cns.Response{
+		ReturnCode: returnCode,
+		Message:    returnMessage,
+	}
+
+	historyResponse := cns.GetNetworkContainerHistoryResponse{
+		NetworkContainerid: req.NetworkContainerid,
+		History:            history,
+		Response:           resp,
+	}
+
+	err = service.Listener.Encode(w, &historyResponse)
+	logger.Response(service.Name, historyResponse, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
+}
+
 func (service *HTTPRestService) getInterfaceForContainer(w http.ResponseWriter, r *http.Request) {
 	logger.Printf("[Azure CNS] getInterfaceForContainer")
 
@@ -1459,7 +1725,7 @@ func (service *HTTPRestService) getInterfaceForContainer(w http.ResponseWriter,
 		version = savedReq.Version
 	} else {
 		returnMessage = "[Azure CNS] Never received call to create this container."
-		returnCode = UnknownContainerID
+		returnCode = cns.UnknownContainerID
 		interfaceName = ""
 		ipaddress = ""
 		version = ""
@@ -1530,6 +1796,21 @@ func (service *HTTPRestService) restoreNetworkState() error {
 	return nil
 }
 
+// restoreRoutes restores the persisted routes that are missing on the node.
+// Failures are logged and not returned, since restoring routes is a fallback for the network driver.
+func (service *HTTPRestService) restoreRoutes() {
+	if len(service.state.Routes) == 0 {
+		return
+	}
+
+	logger.Printf("[Azure CNS] Restoring %d persisted routes", len(service.state.Routes))
+
+	rt := &routes.RoutingTable{Routes: service.state.Routes}
+	if err := rt.RestoreRoutingTable(); err != nil {
+		logger.Errorf("[Azure CNS] Unable to restore routing table on node, %+v.", err.Error())
+	}
+}
+
 func (service *HTTPRestService) attachNetworkContainerToNetwork(w http.ResponseWriter, r *http.Request) {
 	logger.Printf("[Azure CNS] attachNetworkContainerToNetwork")
 
@@ -1565,17 +1846,17 @@ func (service *HTTPRestService) detachNetworkContainerFromNetwork(w http.Respons
 func (service *HTTPRestService) attachOrDetachHelper(req cns.ConfigureContainerNetworkingRequest, operation, method string) cns.Response {
 	if method != "POST" {
 		return cns.Response{
-			ReturnCode: InvalidParameter,
+			ReturnCode: cns.InvalidParameter,
 			Message:    "[Azure CNS] Error. " + operation + "ContainerToNetwork did not receive a POST."}
 	}
 	if req.Containerid == "" {
 		return cns.Response{
-			ReturnCode: DockerContainerNotSpecified,
+			ReturnCode: cns.DockerContainerNotSpecified,
 			Message:    "[Azure CNS] Error. Containerid is empty"}
 	}
 	if req.NetworkContainerid == "" {
 		return cns.Response{
-			ReturnCode: NetworkContainerNotSpecified,
+			ReturnCode: cns.NetworkContainerNotSpecified,
 			Message:    "[Azure CNS] Error. NetworkContainerid is empty"}
 	}
 
@@ -1583,7 +1864,7 @@ func (service *HTTPRestService) attachOrDetachHelper(req cns.ConfigureContainerN
 
 	if !ok {
 		return cns.Response{
-			ReturnCode: NotFound,
+			ReturnCode: cns.NotFound,
 			Message:    fmt.Sprintf("[Azure CNS] Error. Network Container %s does not exist.", req.NetworkContainerid)}
 	}
 
@@ -1594,7 +1875,7 @@ func (service *HTTPRestService) attachOrDetachHelper(req cns.ConfigureContainerN
 		var podInfo cns.KubernetesPodInfo
 		err := json.Unmarshal(existing.CreateNetworkContainerRequest.OrchestratorContext, &podInfo)
 		if err != nil {
-			returnCode = UnexpectedError
+			returnCode = cns.UnexpectedError
 			returnMessage = fmt.Sprintf("Unmarshalling orchestrator context failed with error %+v", err)
 		} else {
 			nc := service.networkContainer
@@ -1606,14 +1887,14 @@ func (service *HTTPRestService) attachOrDetachHelper(req cns.ConfigureContainerN
 				err = nc.Detach(podInfo, req.Containerid, netPluginConfig)
 			}
 			if err != nil {
-				returnCode = UnexpectedError
+				returnCode = cns.UnexpectedError
 				returnMessage = fmt.Sprintf("[Azure CNS] Error. "+operation+"ContainerToNetwork failed %+v", err.Error())
 			}
 		}
 
 	default:
 		returnMessage = fmt.Sprintf("[Azure CNS] Invalid orchestrator type %v", service.state.OrchestratorType)
-		returnCode = UnsupportedOrchestratorType
+		returnCode = cns.UnsupportedOrchestratorType
 	}
 
 	return cns.Response{
@@ -1621,6 +1902,30 @@ func (service *HTTPRestService) attachOrDetachHelper(req cns.ConfigureContainerN
 		Message:    returnMessage}
 }
 
+// restoreNetworkContainers recreates the Docker and Basic network containers of the restored state
+// whose interfaces are missing or outdated, e.g. after a reboot. Failures are logged and do not stop CNS.
+func (service *HTTPRestService) restoreNetworkContainers() {
+	var reqs []cns.CreateNetworkContainerRequest
+
+	service.lock.Lock()
+	for _, status := range service.state.ContainerStatus {
+		switch ncType := status.CreateNetworkContainerRequest.NetworkContainerType; ncType {
+		case cns.Docker, cns.Basic:
+			if service.networkContainer.IsCreatedByCNS(ncType) {
+				reqs = append(reqs, status.CreateNetworkContainerRequest)
+			}
+		}
+	}
+	service.lock.Unlock()
+
+	for _, req := range reqs {
+		logger.Printf("[Azure CNS] Restoring network container %s", req.NetworkContainerid)
+		if err := service.networkContainer.Create(req); err != nil {
+			logger.Errorf("[Azure CNS] Failed to restore network container %s, err:%v.", req.NetworkContainerid, err)
+		}
+	}
+}
+
 func (service *HTTPRestService) getNetPluginDetails() *networkcontainers.NetPluginConfiguration {
 	pluginBinPath, _ := service.GetOption(acn.OptNetPluginPath).(string)
 	configPath, _ := service.GetOption(acn.OptNetPluginConfigFile).(string)
@@ -1644,7 +1949,7 @@ func (service *HTTPRestService) getNumberOfCPUCores(w http.ResponseWriter, r *ht
 		num = runtime.NumCPU()
 	default:
 		errMsg = "[Azure-CNS] getNumberOfCPUCores API expects a GET."
-		returnCode = UnsupportedVerb
+		returnCode = cns.UnsupportedVerb
 	}
 
 	resp := cns.Response{ReturnCode: returnCode, Message: errMsg}
@@ -1692,7 +1997,7 @@ func (service *HTTPRestService) createHostNCApipaEndpoint(w http.ResponseWriter,
 				!networkContainerDetails.CreateNetworkContainerRequest.AllowHostToNCCommunication {
 				returnMessage = fmt.Sprintf("HostNCApipaEndpoint creation is not supported unless " +
 					"AllowNCToHostCommunication or AllowHostToNCCommunication is set to true")
-				returnCode = InvalidRequest
+				returnCode = cns.InvalidRequest
 			} else {
 				if endpointID, err = hnsclient.CreateHostNCApipaEndpoint(
 					req.NetworkContainerID,
@@ -1701,17 +2006,17 @@ func (service *HTTPRestService) createHostNCApipaEndpoint(w http.ResponseWriter,
 					networkContainerDetails.CreateNetworkContainerRequest.AllowHostToNCCommunication,
 					networkContainerDetails.CreateNetworkContainerRequest.EndpointPolicies); err != nil {
 					returnMessage = fmt.Sprintf("CreateHostNCApipaEndpoint failed with error: %v", err)
-					returnCode = UnexpectedError
+					returnCode = cns.UnexpectedError
 				}
 			}
 		} else {
 			returnMessage = fmt.Sprintf("CreateHostNCApipaEndpoint failed with error: Unable to find goal state for"+
 				" the given Network Container: %s", req.NetworkContainerID)
-			returnCode = UnknownContainerID
+			returnCode = cns.UnknownContainerID
 		}
 	default:
 		returnMessage = "createHostNCApipaEndpoint API expects a POST"
-		returnCode = UnsupportedVerb
+		returnCode = cns.UnsupportedVerb
 	}
 
 	response := cns.CreateHostNCApipaEndpointResponse{
@@ -1747,11 +2052,11 @@ func (service *HTTPRestService) deleteHostNCApipaEndpoint(w http.ResponseWriter,
 		if err = hnsclient.DeleteHostNCApipaEndpoint(req.NetworkContainerID); err != nil {
 			returnMessage = fmt.Sprintf("Failed to delete endpoint for Network Container: %s "+
 				"due to error: %v", req.NetworkContainerID, err)
-			returnCode = UnexpectedError
+			returnCode = cns.UnexpectedError
 		}
 	default:
 		returnMessage = "deleteHostNCApipaEndpoint API expects a DELETE"
-		returnCode = UnsupportedVerb
+		returnCode = cns.UnsupportedVerb
 	}
 
 	response := cns.DeleteHostNCApipaEndpointResponse{
@@ -1785,15 +2090,18 @@ func (service *HTTPRestService) setNetworkStateJoined(networkID string) {
 
 // Join Network by calling nmagent
 func (service *HTTPRestService) joinNetwork(
+	ctx context.Context,
 	networkID string,
 	joinNetworkURL string) (*http.Response, error, error) {
 	var err error
-	joinResponse, joinErr := nmagentclient.JoinNetwork(
+	joinResponse, joinErr := service.nmagentClient.JoinNetwork(
+		ctx,
 		networkID,
 		joinNetworkURL)
 
 	if joinErr == nil && joinResponse.StatusCode == http.StatusOK {
-		// Network joined successfully
+		// Network joined successfully, callers only report the body of failed joins
+		joinResponse.Body.Close()
 		service.setNetworkStateJoined(networkID)
 		logger.Printf("[Azure-CNS] setNetworkStateJoined for network: %s", networkID)
 	} else {
@@ -1839,29 +2147,30 @@ func (service *HTTPRestService) publishNetworkContainer(w http.ResponseWriter, r
 	switch r.Method {
 	case "POST":
 		// Join the network
-		publishResponse, publishError, err = service.joinNetwork(req.NetworkID, req.JoinNetworkURL)
+		publishResponse, publishError, err = service.joinNetwork(r.Context(), req.NetworkID, req.JoinNetworkURL)
 		if err == nil {
 			isNetworkJoined = true
 		} else {
 			returnMessage = err.Error()
-			returnCode = NetworkJoinFailed
+			returnCode = cns.NetworkJoinFailed
 		}
 
 		if isNetworkJoined {
 			// Publish Network Container
-			publishResponse, publishError = nmagentclient.PublishNetworkContainer(
+			publishResponse, publishError = service.nmagentClient.PublishNetworkContainer(
+				r.Context(),
 				req.NetworkContainerID,
 				req.CreateNetworkContainerURL,
 				req.CreateNetworkContainerRequestBody)
 			if publishError != nil || publishResponse.StatusCode != http.StatusOK {
 				returnMessage = fmt.Sprintf("Failed to publish Network Container: %s", req.NetworkContainerID)
-				returnCode = NetworkContainerPublishFailed
+				returnCode = cns.NetworkContainerPublishFailed
 				logger.Errorf("[Azure-CNS] %s", returnMessage)
 			}
 		}
 	default:
 		returnMessage = "PublishNetworkContainer API expects a POST"
-		returnCode = UnsupportedVerb
+		returnCode = cns.UnsupportedVerb
 	}
 
 	if publishError != nil {
@@ -1875,7 +2184,7 @@ func (service *HTTPRestService) publishNetworkContainer(w http.ResponseWriter, r
 		publishResponseBody, errParse = ioutil.ReadAll(publishResponse.Body)
 		if errParse != nil {
 			returnMessage = fmt.Sprintf("Failed to parse the publish body. Error: %v", errParse)
-			returnCode = UnexpectedError
+			returnCode = cns.UnexpectedError
 			logger.Errorf("[Azure-CNS] %s", returnMessage)
 		}
 
@@ -1934,23 +2243,24 @@ func (service *HTTPRestService) unpublishNetworkContainer(w http.ResponseWriter,
 		// Join Network if not joined already
 		isNetworkJoined = service.isNetworkJoined(req.NetworkID)
 		if !isNetworkJoined {
-			unpublishResponse, unpublishError, err = service.joinNetwork(req.NetworkID, req.JoinNetworkURL)
+			unpublishResponse, unpublishError, err = service.joinNetwork(r.Context(), req.NetworkID, req.JoinNetworkURL)
 			if err == nil {
 				isNetworkJoined = true
 			} else {
 				returnMessage = err.Error()
-				returnCode = NetworkJoinFailed
+				returnCode = cns.NetworkJoinFailed
 			}
 		}
 
 		if isNetworkJoined {
 			// Unpublish Network Container
-			unpublishResponse, unpublishError = nmagentclient.UnpublishNetworkContainer(
+			unpublishResponse, unpublishError = service.nmagentClient.UnpublishNetworkContainer(
+				r.Context(),
 				req.NetworkContainerID,
 				req.DeleteNetworkContainerURL)
 			if unpublishError != nil || unpublishResponse.StatusCode != http.StatusOK {
 				returnMessage = fmt.Sprintf("Failed to unpublish Network Container: %s", req.NetworkContainerID)
-				returnCode = NetworkContainerUnpublishFailed
+				returnCode = cns.NetworkContainerUnpublishFailed
 				logger.Errorf("[Azure-CNS] %s", returnMessage)
 			}
 
@@ -1959,7 +2269,7 @@ func (service *HTTPRestService) unpublishNetworkContainer(w http.ResponseWriter,
 				unpublishResponseBody, errParse = ioutil.ReadAll(unpublishResponse.Body)
 				if errParse != nil {
 					returnMessage = fmt.Sprintf("Failed to parse the unpublish body. Error: %v", errParse)
-					returnCode = UnexpectedError
+					returnCode = cns.UnexpectedError
 					logger.Errorf("[Azure-CNS] %s", returnMessage)
 				}
 
@@ -1968,7 +2278,7 @@ func (service *HTTPRestService) unpublishNetworkContainer(w http.ResponseWriter,
 		}
 	default:
 		returnMessage = "UnpublishNetworkContainer API expects a POST"
-		returnCode = UnsupportedVerb
+		returnCode = cns.UnsupportedVerb
 	}
 
 	if unpublishError != nil {
@@ -6,84 +6,72 @@ package restserver
 import (
 	"bytes"
 	"encoding/json"
-	"encoding/xml"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
 	"os"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	cnmIpam "github.com/Azure/azure-container-networking/cnm/ipam"
 	"github.com/Azure/azure-container-networking/cns"
 	"github.com/Azure/azure-container-networking/cns/common"
-	acncommon "github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/cns/dockerclient"
+	"github.com/Azure/azure-container-networking/cns/ipamclient"
+	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/Azure/azure-container-networking/cns/nmagentclient"
+	"github.com/Azure/azure-container-networking/cns/nmagentsim"
+	"github.com/Azure/azure-container-networking/log"
 )
 
-type IPAddress struct {
-	XMLName   xml.Name `xml:"IPAddress"`
-	Address   string   `xml:"Address,attr"`
-	IsPrimary bool     `xml:"IsPrimary,attr"`
-}
-type IPSubnet struct {
-	XMLName   xml.Name `xml:"IPSubnet"`
-	Prefix    string   `xml:"Prefix,attr"`
-	IPAddress []IPAddress
-}
-
-type Interface struct {
-	XMLName    xml.Name `xml:"Interface"`
-	MacAddress string   `xml:"MacAddress,attr"`
-	IsPrimary  bool     `xml:"IsPrimary,attr"`
-	IPSubnet   []IPSubnet
-}
-
-type xmlDocument struct {
-	XMLName   xml.Name `xml:"Interfaces"`
-	Interface []Interface
-}
-
 var (
-	service                               HTTPService
-	mux                                   *http.ServeMux
-	hostQueryForProgrammedVersionResponse = `{"httpStatusCode":"200","networkContainerId":"eab2470f-test-test-test-b3cd316979d5","version":"1"}`
-	hostQueryResponse                     = xmlDocument{
-		XMLName: xml.Name{Local: "Interfaces"},
-		Interface: []Interface{Interface{
-			XMLName:    xml.Name{Local: "Interface"},
-			MacAddress: "*",
-			IsPrimary:  true,
-			IPSubnet: []IPSubnet{
-				IPSubnet{XMLName: xml.Name{Local: "IPSubnet"},
-					Prefix: "10.0.0.0/16",
-					IPAddress: []IPAddress{
-						IPAddress{
-							XMLName:   xml.Name{Local: "IPAddress"},
-							Address:   "10.0.0.4",
-							IsPrimary: true},
-					}},
-			},
-		}},
-	}
-)
-
-const (
-	nmagentEndpoint = "localhost:9000"
+	service HTTPService
+	mux     *http.ServeMux
+	nmagent *nmagentsim.Simulator
+	plugins *httptest.Server
 )
 
-func getInterfaceInfo(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/xml")
-	output, _ := xml.Marshal(hostQueryResponse)
-	w.Write(output)
-}
-
-func nmagentHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(http.StatusOK)
-
-	if strings.Contains(r.RequestURI, "networkContainers") {
-		w.Write([]byte(hostQueryForProgrammedVersionResponse))
-	}
+// Serves the docker network and IPAM plugin APIs that CNS calls, so that tests run offline.
+func newPluginServer() *httptest.Server {
+	var lock sync.Mutex
+	networks := make(map[string]bool)
+	pluginMux := http.NewServeMux()
+
+	pluginMux.HandleFunc("/networks/", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		name := strings.TrimPrefix(r.URL.Path, "/networks/")
+		switch {
+		case name == "create" && r.Method == http.MethodPost:
+			var config dockerclient.NetworkConfiguration
+			json.NewDecoder(r.Body).Decode(&config)
+			networks[config.Name] = true
+			w.WriteHeader(http.StatusCreated)
+		case !networks[name]:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodDelete:
+			delete(networks, name)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	respond := func(path string, response interface{}) {
+		pluginMux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(response)
+		})
+	}
+
+	respond(cnmIpam.GetAddressSpacesPath, &cnmIpam.GetDefaultAddressSpacesResponse{LocalDefaultAddressSpace: "local"})
+	respond(cnmIpam.RequestPoolPath, &cnmIpam.RequestPoolResponse{PoolID: "local|10.0.0.0/24", Pool: "10.0.0.0/24"})
+	respond(cnmIpam.RequestAddressPath, &cnmIpam.RequestAddressResponse{Address: "10.0.0.5/24"})
+	respond(cnmIpam.ReleaseAddressPath, &cnmIpam.ReleaseAddressResponse{})
+	respond(cnmIpam.GetPoolInfoPath, &cnmIpam.GetPoolInfoResponse{Capacity: 10, Available: 9})
+
+	return httptest.NewServer(pluginMux)
 }
 
 // Wraps the test run with service setup and teardown.
@@ -91,6 +79,8 @@ func TestMain(m *testing.M) {
 	var config common.ServiceConfig
 	var err error
 
+	logger.InitLogger("azure-cns-test", log.LevelInfo, log.TargetStderr, "")
+
 	// Create the service.
 	service, err = NewHTTPRestService(&config)
 	if err != nil {
@@ -100,11 +90,15 @@ func TestMain(m *testing.M) {
 
 	// Configure test mode.
 	service.(*HTTPRestService).Name = "cns-test-server"
-	//service.(*HTTPRestService).imdsClient.HostQueryURL = imdsclient.HostQueryURL
-	//service.(*HTTPRestService).imdsClient.HostQueryURLForProgrammedVersion = imdsclient.HostQueryURLForProgrammedVersion
-	// Following HostQueryURL and HostQueryURLForProgrammedVersion are only for mock environment.
-	// service.(*httpRestService).imdsClient.HostQueryURL = "http://localhost:9000/getInterface"
-	// service.(*httpRestService).imdsClient.HostQueryURLForProgrammedVersion = "http://localhost:9000/machine/plugins/?comp=nmagent&type=NetworkManagement/interfaces/%s/networkContainers/%s/authenticationToken/%s/api-version/%s"
+
+	// Query the NMAgent simulator instead of wireserver, and the plugin server instead of docker and the IPAM plugin.
+	nmagent = nmagentsim.NewSimulator()
+	service.(*HTTPRestService).imdsClient.HostQueryURL = nmagent.InterfaceInfoURL()
+	service.(*HTTPRestService).imdsClient.HostQueryURLForProgrammedVersion = nmagent.ProgrammedVersionURLFormat()
+
+	plugins = newPluginServer()
+	service.(*HTTPRestService).dockerClient, _ = dockerclient.NewDockerClient(plugins.URL, service.(*HTTPRestService).imdsClient)
+	service.(*HTTPRestService).ipamClient, _ = ipamclient.NewIpamClient(plugins.URL)
 
 	// Start the service.
 	err = service.Start(&config)
@@ -113,35 +107,23 @@ func TestMain(m *testing.M) {
 		os.Exit(2)
 	}
 
+	// The network container version watcher is tested on its own. Stop it so that tests don't race with host queries.
+	service.(*HTTPRestService).ncVersionWatcher.close()
+	service.(*HTTPRestService).ncVersionWatcher = nil
+
 	// Get the internal http mux as test hook.
 	mux = service.(*HTTPRestService).Listener.GetMux()
 
-	// Setup mock nmagent server
-	u, err := url.Parse("tcp://" + nmagentEndpoint)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-
-	nmAgentServer, err := acncommon.NewListener(u)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-
-	nmAgentServer.AddHandler("/getInterface", getInterfaceInfo)
-	nmAgentServer.AddHandler("/", nmagentHandler)
-
-	err = nmAgentServer.Start(make(chan error, 1))
-	if err != nil {
-		fmt.Printf("Failed to start agent, err:%v.\n", err)
-		return
-	}
+	// Setup the NMAgent client.
+	service.(*HTTPRestService).nmagentClient = nmagentclient.NewClient(nmagentclient.Config{InitialBackoff: time.Millisecond})
 
 	// Run tests.
 	exitCode := m.Run()
 
 	// Cleanup.
 	service.Stop()
-	nmAgentServer.Stop()
+	nmagent.Close()
+	plugins.Close()
 
 	os.Exit(exitCode)
 }
@@ -252,11 +234,8 @@ func TestReserveIPAddress(t *testing.T) {
 	reserveIPRequest := cns.ReserveIPAddressRequest{ReservationID: "ip01"}
 	reserveIPRequestJSON := new(bytes.Buffer)
 	json.NewEncoder(reserveIPRequestJSON).Encode(reserveIPRequest)
-	envRequest := cns.SetEnvironmentRequest{Location: "Azure", NetworkType: "Underlay"}
-	envRequestJSON := new(bytes.Buffer)
-	json.NewEncoder(envRequestJSON).Encode(envRequest)
 
-	req, err := http.NewRequest(http.MethodPost, cns.ReserveIPAddressPath, envRequestJSON)
+	req, err := http.NewRequest(http.MethodPost, cns.ReserveIPAddressPath, reserveIPRequestJSON)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -359,6 +338,24 @@ func TestGetUnhealthyIPAddresses(t *testing.T) {
 	}
 }
 
+func TestGetHealthReport(t *testing.T) {
+	fmt.Println("Test: GetHealthReport")
+
+	req, err := http.NewRequest(http.MethodGet, cns.GetHealthReportPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var resp cns.Response
+
+	err = decodeResponse(w, &resp)
+	if err != nil || resp.ReturnCode != 0 {
+		t.Errorf("GetHealthReport failed with response %+v", resp)
+	}
+}
+
 func setOrchestratorType(t *testing.T, orchestratorType string) error {
 	var body bytes.Buffer
 
@@ -505,7 +502,7 @@ func getNonExistNetworkContainerByContext(t *testing.T, name string) error {
 	mux.ServeHTTP(w, req)
 
 	err = decodeResponse(w, &resp)
-	if err != nil || resp.Response.ReturnCode != UnknownContainerID {
+	if err != nil || resp.Response.ReturnCode != cns.UnknownContainerID {
 		t.Errorf("GetNetworkContainerByContext unexpected response %+v Err:%+v", resp, err)
 		t.Fatal(err)
 	}
@@ -677,6 +674,115 @@ func TestGetNetworkContainerByOrchestratorContext(t *testing.T) {
 	}
 }
 
+func createNetworkContainerWithInterface(t *testing.T, name string, ip string, ifName string, order int) {
+	var body bytes.Buffer
+
+	podInfo := cns.KubernetesPodInfo{PodName: "testpod", PodNamespace: "testpodnamespace"}
+	context, _ := json.Marshal(podInfo)
+
+	info := &cns.CreateNetworkContainerRequest{
+		Version:              "0.1",
+		NetworkContainerType: "AzureContainerInstance",
+		NetworkContainerid:   name,
+		OrchestratorContext:  context,
+		IPConfiguration: cns.IPConfiguration{
+			IPSubnet:         cns.IPSubnet{IPAddress: ip, PrefixLength: 24},
+			GatewayIPAddress: "11.0.0.1",
+		},
+		PrimaryInterfaceIdentifier: "11.0.0.7",
+		InterfaceName:              ifName,
+		InterfaceOrder:             order,
+	}
+
+	json.NewEncoder(&body).Encode(info)
+
+	req, err := http.NewRequest(http.MethodPost, cns.CreateOrUpdateNetworkContainer, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	var resp cns.CreateNetworkContainerResponse
+	err = decodeResponse(w, &resp)
+	if err != nil || resp.Response.ReturnCode != 0 {
+		t.Fatalf("CreateNetworkContainerRequest failed with response %+v Err:%+v", resp, err)
+	}
+}
+
+func getNetworkContainersByContext(t *testing.T) cns.GetNetworkContainersResponse {
+	var body bytes.Buffer
+	var resp cns.GetNetworkContainersResponse
+
+	podInfo := cns.KubernetesPodInfo{PodName: "testpod", PodNamespace: "testpodnamespace"}
+	podInfoBytes, _ := json.Marshal(podInfo)
+	getReq := &cns.GetNetworkContainerRequest{OrchestratorContext: podInfoBytes}
+
+	json.NewEncoder(&body).Encode(getReq)
+	req, err := http.NewRequest(http.MethodPost, cns.GetNetworkContainersByOrchestratorContext, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if err = decodeResponse(w, &resp); err != nil {
+		t.Fatalf("GetNetworkContainersByContext failed with Err:%+v", err)
+	}
+
+	return resp
+}
+
+func getNetworkContainerIDs(resp cns.GetNetworkContainersResponse) []string {
+	var ids []string
+	for _, nc := range resp.NetworkContainers {
+		ids = append(ids, nc.NetworkContainerID+"/"+nc.InterfaceName)
+	}
+
+	return ids
+}
+
+func TestGetNetworkContainersByOrchestratorContext(t *testing.T) {
+	fmt.Println("Test: TestGetNetworkContainersByOrchestratorContext")
+
+	setEnv(t)
+	setOrchestratorType(t, cns.Kubernetes)
+
+	// Network containers are returned in interface order, regardless of the order they were created in.
+	createNetworkContainerWithInterface(t, "ethWebApp2", "11.0.0.6", "eth1", 1)
+	createNetworkContainerWithInterface(t, "ethWebApp1", "11.0.0.5", "eth0", 0)
+
+	resp := getNetworkContainersByContext(t)
+	ids := getNetworkContainerIDs(resp)
+	if resp.Response.ReturnCode != 0 || !reflect.DeepEqual(ids, []string{"ethWebApp1/eth0", "ethWebApp2/eth1"}) {
+		t.Fatalf("Unexpected network containers %v response %+v", ids, resp.Response)
+	}
+
+	// The primary network container is returned by the single network container API.
+	if err := getNetworkContainerByContext(t, "ethWebApp1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A network container on the same interface replaces the existing one.
+	createNetworkContainerWithInterface(t, "ethWebApp3", "11.0.0.8", "eth1", 1)
+
+	ids = getNetworkContainerIDs(getNetworkContainersByContext(t))
+	if !reflect.DeepEqual(ids, []string{"ethWebApp1/eth0", "ethWebApp3/eth1"}) {
+		t.Fatalf("Unexpected network containers %v", ids)
+	}
+
+	for _, name := range []string{"ethWebApp1", "ethWebApp2", "ethWebApp3"} {
+		deleteNetworkAdapterWithName(t, name)
+	}
+
+	resp = getNetworkContainersByContext(t)
+	if resp.Response.ReturnCode != cns.UnknownContainerID || len(resp.NetworkContainers) != 0 {
+		t.Fatalf("Unexpected response %+v", resp)
+	}
+}
+
 func TestGetNetworkContainerStatus(t *testing.T) {
 	// requires more than 30 seconds to run
 	fmt.Println("Test: TestCreateNetworkContainer")
@@ -769,8 +875,8 @@ func TestPublishNCViaCNS(t *testing.T) {
 
 	networkID := "vnet1"
 	networkContainerID := "ethWebApp"
-	joinNetworkURL := "http://" + nmagentEndpoint + "/dummyVnetURL"
-	createNetworkContainerURL := "http://" + nmagentEndpoint + "/networkContainers/dummyNCURL"
+	joinNetworkURL := nmagent.JoinNetworkURL(networkID)
+	createNetworkContainerURL := nmagent.PublishNetworkContainerURL("10.0.0.4", networkContainerID, "dummyToken")
 
 	publishNCRequest := &cns.PublishNetworkContainerRequest{
 		NetworkID:                         networkID,
@@ -808,8 +914,8 @@ func TestUnpublishNCViaCNS(t *testing.T) {
 
 	networkID := "vnet1"
 	networkContainerID := "ethWebApp"
-	joinNetworkURL := "http://" + nmagentEndpoint + "/dummyVnetURL"
-	deleteNetworkContainerURL := "http://" + nmagentEndpoint + "/networkContainers/dummyNCURL"
+	joinNetworkURL := nmagent.JoinNetworkURL(networkID)
+	deleteNetworkContainerURL := nmagent.UnpublishNetworkContainerURL("10.0.0.4", networkContainerID, "dummyToken")
 
 	unpublishNCRequest := &cns.UnpublishNetworkContainerRequest{
 		NetworkID:                 networkID,
@@ -835,3 +941,244 @@ func TestUnpublishNCViaCNS(t *testing.T) {
 
 	fmt.Printf("UnpublishNetworkContainer succeded with response %+v, raw:%+v\n", resp, w.Body)
 }
+
+// TestPublishNCRetry tests if publishing a network container is retried while the host is unavailable.
+func TestPublishNCRetry(t *testing.T) {
+	networkID := "vnet2"
+	networkContainerID := "ncRetry"
+
+	nmagent.InjectFaults(nmagentsim.JoinNetwork, nmagentsim.Fault{Drop: true}, 1)
+	nmagent.InjectFaults(nmagentsim.PublishNetworkContainer, nmagentsim.Fault{StatusCode: http.StatusServiceUnavailable}, 2)
+
+	var resp cns.PublishNetworkContainerResponse
+	postTestRequest(t, cns.PublishNetworkContainer, &cns.PublishNetworkContainerRequest{
+		NetworkID:                         networkID,
+		NetworkContainerID:                networkContainerID,
+		JoinNetworkURL:                    nmagent.JoinNetworkURL(networkID),
+		CreateNetworkContainerURL:         nmagent.PublishNetworkContainerURL("10.0.0.4", networkContainerID, "dummyToken"),
+		CreateNetworkContainerRequestBody: []byte(`{"version":"3"}`),
+	}, &resp)
+
+	if resp.Response.ReturnCode != cns.Success || resp.PublishStatusCode != http.StatusOK {
+		t.Fatalf("PublishNetworkContainer failed with response %+v", resp)
+	}
+
+	if version, published := nmagent.GetNetworkContainer(networkContainerID); !published || version != "3" {
+		t.Fatalf("Network container was not published, version %s", version)
+	}
+
+	var publishIDs []string
+	for _, req := range nmagent.Requests() {
+		if req.Operation == nmagentsim.PublishNetworkContainer && req.Resource == networkContainerID {
+			publishIDs = append(publishIDs, req.ID)
+		}
+	}
+
+	if len(publishIDs) != 3 || publishIDs[0] == "" || publishIDs[0] != publishIDs[2] {
+		t.Fatalf("Unexpected publish requests %v", publishIDs)
+	}
+}
+
+// Posts a request to the service and decodes its response.
+func postTestRequest(t *testing.T, path string, request interface{}, response interface{}) {
+	var body bytes.Buffer
+
+	json.NewEncoder(&body).Encode(request)
+	req, err := http.NewRequest(http.MethodPost, path, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if err = decodeResponse(w, response); err != nil {
+		t.Fatalf("%s failed with Err:%+v", path, err)
+	}
+}
+
+func TestNetworkContainerVersionConflict(t *testing.T) {
+	fmt.Println("Test: TestNetworkContainerVersionConflict")
+
+	setEnv(t)
+	setOrchestratorType(t, cns.Kubernetes)
+
+	podInfo, _ := json.Marshal(cns.KubernetesPodInfo{PodName: "testpod", PodNamespace: "testpodnamespace"})
+	createReq := cns.CreateNetworkContainerRequest{
+		Version:              "2",
+		NetworkContainerType: "AzureContainerInstance",
+		NetworkContainerid:   "ethVersioned",
+		OrchestratorContext:  podInfo,
+		IPConfiguration: cns.IPConfiguration{
+			IPSubnet: cns.IPSubnet{IPAddress: "11.0.0.9", PrefixLength: 24},
+		},
+	}
+
+	var createResp cns.CreateNetworkContainerResponse
+	postTestRequest(t, cns.CreateOrUpdateNetworkContainer, &createReq, &createResp)
+	if createResp.Response.ReturnCode != cns.Success {
+		t.Fatalf("CreateNetworkContainer failed with response %+v", createResp)
+	}
+
+	// An older goal state delivered late is rejected.
+	createReq.Version = "1"
+	postTestRequest(t, cns.CreateOrUpdateNetworkContainer, &createReq, &createResp)
+	if createResp.Response.ReturnCode != cns.NetworkContainerVersionConflict {
+		t.Fatalf("Stale update was not rejected, response %+v", createResp)
+	}
+
+	status, _ := service.(*HTTPRestService).getNetworkContainerDetails("ethVersioned")
+	if status.VMVersion != "2" {
+		t.Fatalf("Stale update replaced the goal state %+v", status)
+	}
+
+	// A delete conditional on another version is rejected.
+	var deleteResp cns.DeleteNetworkContainerResponse
+	postTestRequest(t, cns.DeleteNetworkContainer, &cns.DeleteNetworkContainerRequest{NetworkContainerid: "ethVersioned", Version: "1"}, &deleteResp)
+	if deleteResp.Response.ReturnCode != cns.NetworkContainerVersionConflict {
+		t.Fatalf("Conditional delete was not rejected, response %+v", deleteResp)
+	}
+
+	postTestRequest(t, cns.DeleteNetworkContainer, &cns.DeleteNetworkContainerRequest{NetworkContainerid: "ethVersioned", Version: "2"}, &deleteResp)
+	if deleteResp.Response.ReturnCode != cns.Success {
+		t.Fatalf("Conditional delete failed with response %+v", deleteResp)
+	}
+
+	// The history records accepted and rejected transitions.
+	var historyResp cns.GetNetworkContainerHistoryResponse
+	postTestRequest(t, cns.GetNetworkContainerHistory, &cns.GetNetworkContainerHistoryRequest{NetworkContainerid: "ethVersioned"}, &historyResp)
+
+	var transitions []string
+	for _, transition := range historyResp.History {
+		transitions = append(transitions, fmt.Sprintf("%s:%s->%s:%d", transition.Operation, transition.PreviousVersion, transition.Version, transition.ReturnCode))
+	}
+
+	expected := []string{
+		"Create:->2:0",
+		fmt.Sprintf("Update:2->1:%d", cns.NetworkContainerVersionConflict),
+		fmt.Sprintf("Delete:2->1:%d", cns.NetworkContainerVersionConflict),
+		"Delete:2->:0",
+	}
+
+	if historyResp.Response.ReturnCode != cns.Success || !reflect.DeepEqual(transitions, expected) {
+		t.Fatalf("Unexpected history %v response %+v", transitions, historyResp.Response)
+	}
+}
+
+func TestGetDebugState(t *testing.T) {
+	fmt.Println("Test: TestGetDebugState")
+
+	setEnv(t)
+	setOrchestratorType(t, cns.Kubernetes)
+
+	podInfo, _ := json.Marshal(cns.KubernetesPodInfo{PodName: "debugpod", PodNamespace: "testpodnamespace"})
+	createReq := cns.CreateNetworkContainerRequest{
+		Version:              "1",
+		NetworkContainerType: "AzureContainerInstance",
+		NetworkContainerid:   "ethDebug",
+		OrchestratorContext:  podInfo,
+		AuthorizationToken:   "secret",
+		IPConfiguration: cns.IPConfiguration{
+			IPSubnet: cns.IPSubnet{IPAddress: "11.0.0.10", PrefixLength: 24},
+		},
+	}
+
+	var createResp cns.CreateNetworkContainerResponse
+	postTestRequest(t, cns.CreateOrUpdateNetworkContainer, &createReq, &createResp)
+	if createResp.Response.ReturnCode != cns.Success {
+		t.Fatalf("CreateNetworkContainer failed with response %+v", createResp)
+	}
+
+	defer postTestRequest(t, cns.DeleteNetworkContainer,
+		&cns.DeleteNetworkContainerRequest{NetworkContainerid: "ethDebug"}, &cns.DeleteNetworkContainerResponse{})
+
+	req, err := http.NewRequest(http.MethodGet, cns.DebugStatePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	var resp cns.DebugStateResponse
+	if err = decodeResponse(w, &resp); err != nil || resp.Response.ReturnCode != cns.Success {
+		t.Fatalf("getDebugState failed with response %+v Err:%+v", resp.Response, err)
+	}
+
+	var found bool
+	for _, nc := range resp.NetworkContainers {
+		if nc.ID != "ethDebug" {
+			continue
+		}
+
+		found = true
+		if nc.CreateNetworkContainerRequest.AuthorizationToken != redactedValue || nc.VMVersion != "1" || !nc.Programmed {
+			t.Errorf("Unexpected network container %+v", nc)
+		}
+	}
+
+	if ids := resp.ContainerIDsByOrchestratorContext["debugpodtestpodnamespace"]; !found || len(ids) != 1 || ids[0] != "ethDebug" {
+		t.Errorf("Network container is missing from the debug state %+v", resp)
+	}
+
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("Authorization token was not redacted")
+	}
+
+	// Profiling endpoints are disabled by default.
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Profiling endpoints are served, status %d", w.Code)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	fmt.Println("Test: TestWatch")
+
+	setEnv(t)
+	setOrchestratorType(t, cns.Kubernetes)
+
+	var resp cns.WatchResponse
+	postTestRequest(t, cns.Watch, &cns.WatchRequest{}, &resp)
+	if resp.Response.ReturnCode != cns.Success || resp.ResumeToken == "" {
+		t.Fatalf("Watch failed with response %+v", resp)
+	}
+
+	// A pending watch returns once a network container is created.
+	watchReq := &cns.WatchRequest{ResumeToken: resp.ResumeToken, Kinds: []string{cns.WatchKindNetworkContainer}, TimeoutInSeconds: 10}
+	watchResp := make(chan cns.WatchResponse)
+	go func() {
+		var resp cns.WatchResponse
+		postTestRequest(t, cns.Watch, watchReq, &resp)
+		watchResp <- resp
+	}()
+
+	createNetworkContainerWithInterface(t, "ethWatch", "11.0.0.11", "", 0)
+
+	resp = <-watchResp
+	if len(resp.Events) != 1 || resp.ResyncRequired || resp.Events[0].Operation != cns.NetworkContainerCreate ||
+		resp.Events[0].NetworkContainer == nil || resp.Events[0].NetworkContainer.IPConfiguration.IPSubnet.IPAddress != "11.0.0.11" {
+		t.Fatalf("Unexpected watch response %+v", resp)
+	}
+
+	postTestRequest(t, cns.DeleteNetworkContainer,
+		&cns.DeleteNetworkContainerRequest{NetworkContainerid: "ethWatch"}, &cns.DeleteNetworkContainerResponse{})
+
+	watchReq.ResumeToken = resp.ResumeToken
+	postTestRequest(t, cns.Watch, watchReq, &resp)
+	if len(resp.Events) != 1 || resp.Events[0].Operation != cns.NetworkContainerDelete || resp.Events[0].NetworkContainerID != "ethWatch" {
+		t.Fatalf("Unexpected watch response %+v", resp)
+	}
+
+	// Watches from unknown revisions must resync.
+	postTestRequest(t, cns.Watch, &cns.WatchRequest{ResumeToken: "1000000"}, &resp)
+	if !resp.ResyncRequired || len(resp.Events) != 0 {
+		t.Fatalf("Unexpected watch response %+v", resp)
+	}
+
+	postTestRequest(t, cns.Watch, &cns.WatchRequest{ResumeToken: "invalid"}, &resp)
+	if resp.Response.ReturnCode != cns.InvalidParameter {
+		t.Fatalf("Invalid resume token was accepted %+v", resp)
+	}
+}
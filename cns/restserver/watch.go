@@ -0,0 +1,248 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/logger"
+)
+
+const (
+	// Number of events kept for watchers. Watchers resuming from older events must resync.
+	maxWatchEvents = 1024
+
+	// Maximum number of events returned by a watch request.
+	maxWatchBatch = 256
+
+	defaultWatchTimeout = 30 * time.Second
+	maxWatchTimeout     = 5 * time.Minute
+)
+
+// watchEntry is an event and the revision of the state it was published at.
+type watchEntry struct {
+	revision uint64
+	event    cns.WatchEvent
+}
+
+// watchLog keeps the latest changes of the service state in memory for watchers.
+// Every change increments the revision of the state, which is persisted, so that resume tokens
+// stay monotonic across restarts. Changes before a restart are lost and watchers of them must resync.
+type watchLog struct {
+	lock    sync.Mutex
+	entries []watchEntry
+	// Watchers can resume from revisions in [oldest, latest] without a resync.
+	oldest  uint64
+	latest  uint64
+	changed chan struct{}
+}
+
+// Creates a new watch log starting at the given revision.
+func newWatchLog(revision uint64) *watchLog {
+	return &watchLog{
+		oldest:  revision,
+		latest:  revision,
+		changed: make(chan struct{}),
+	}
+}
+
+// Appends an event published at the given revision and wakes the watchers up.
+func (log *watchLog) append(revision uint64, event cns.WatchEvent) {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+
+	event.ResumeToken = formatResumeToken(revision)
+	log.entries = append(log.entries, watchEntry{revision: revision, event: event})
+	if len(log.entries) > maxWatchEvents {
+		log.entries = append([]watchEntry(nil), log.entries[len(log.entries)-maxWatchEvents:]...)
+		log.oldest = log.entries[0].revision - 1
+	}
+
+	log.latest = revision
+
+	close(log.changed)
+	log.changed = make(chan struct{})
+}
+
+// Returns the events after a revision that match the given kinds, and the revision to resume from.
+// resync is set if the events after the revision are not available. The returned channel is closed
+// on the next change.
+func (log *watchLog) read(after uint64, kinds map[string]bool) (
+	events []cns.WatchEvent, next uint64, resync bool, changed <-chan struct{}) {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+
+	if after < log.oldest || after > log.latest {
+		return nil, log.latest, true, log.changed
+	}
+
+	next = after
+	for _, entry := range log.entries {
+		if entry.revision <= after {
+			continue
+		}
+
+		if len(events) == maxWatchBatch {
+			break
+		}
+
+		// Events that are filtered out still advance the resume token.
+		next = entry.revision
+		if len(kinds) == 0 || kinds[entry.event.Kind] {
+			events = append(events, entry.event)
+		}
+	}
+
+	return events, next, false, log.changed
+}
+
+// Returns the current revision.
+func (log *watchLog) revision() uint64 {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+
+	return log.latest
+}
+
+// Formats a revision as a resume token.
+func formatResumeToken(revision uint64) string {
+	return strconv.FormatUint(revision, 10)
+}
+
+// Parses a resume token.
+func parseResumeToken(token string) (uint64, error) {
+	revision, err := strconv.ParseUint(token, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid resume token %q", token)
+	}
+
+	return revision, nil
+}
+
+// publishWatchEvent increments the revision of the state and publishes an event to watchers.
+// The caller must hold the service lock and save the state.
+func (service *HTTPRestService) publishWatchEvent(event cns.WatchEvent) {
+	service.state.Revision++
+
+	if service.watchLog != nil {
+		event.Time = time.Now()
+		service.watchLog.append(service.state.Revision, event)
+	}
+}
+
+// Publishes a create or update event of a network container. The caller must hold the service lock.
+func (service *HTTPRestService) publishNetworkContainerEvent(operation string, status containerstatus) {
+	nc := newNetworkContainerResponse(status)
+	service.publishWatchEvent(cns.WatchEvent{
+		Kind:                cns.WatchKindNetworkContainer,
+		Operation:           operation,
+		NetworkContainerID:  status.ID,
+		OrchestratorContext: status.CreateNetworkContainerRequest.OrchestratorContext,
+		NetworkContainer:    &nc,
+	})
+}
+
+// Publishes an event of an IP address reservation and saves the new revision.
+func (service *HTTPRestService) publishIPAddressEvent(operation string, reservationID string, address string) {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	service.publishWatchEvent(cns.WatchEvent{
+		Kind:          cns.WatchKindIPAddress,
+		Operation:     operation,
+		ReservationID: reservationID,
+		IPAddress:     address,
+	})
+
+	service.saveState()
+}
+
+// Handles watch requests. Requests wait until there are events after their resume token,
+// or until they time out.
+func (service *HTTPRestService) watch(w http.ResponseWriter, r *http.Request) {
+	logger.Printf("[Azure CNS] watch")
+
+	var req cns.WatchRequest
+	err := service.Listener.Decode(w, r, &req)
+	logger.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	resp := service.waitForWatchEvents(r, req)
+
+	err = service.Listener.Encode(w, &resp)
+	logger.Response(service.Name, resp.Response, resp.Response.ReturnCode, ReturnCodeToString(resp.Response.ReturnCode), err)
+}
+
+// Waits for the events of a watch request.
+func (service *HTTPRestService) waitForWatchEvents(r *http.Request, req cns.WatchRequest) cns.WatchResponse {
+	var resp cns.WatchResponse
+
+	if r.Method != "POST" {
+		resp.Response.ReturnCode = cns.UnsupportedVerb
+		resp.Response.Message = "[Azure CNS] Error. Watch did not receive a POST."
+		return resp
+	}
+
+	if req.ResumeToken == "" {
+		resp.ResumeToken = formatResumeToken(service.watchLog.revision())
+		return resp
+	}
+
+	after, err := parseResumeToken(req.ResumeToken)
+	if err != nil {
+		resp.Response.ReturnCode = cns.InvalidParameter
+		resp.Response.Message = fmt.Sprintf("[Azure CNS] Error. %v", err)
+		return resp
+	}
+
+	timeout := time.Duration(req.TimeoutInSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultWatchTimeout
+	} else if timeout > maxWatchTimeout {
+		timeout = maxWatchTimeout
+	}
+
+	kinds := make(map[string]bool)
+	for _, kind := range req.Kinds {
+		kinds[kind] = true
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		events, next, resync, changed := service.watchLog.read(after, kinds)
+		resp.ResumeToken = formatResumeToken(next)
+
+		if resync {
+			logger.Printf("[Azure CNS] Watcher must resync, resume token %s, current %s", req.ResumeToken, resp.ResumeToken)
+			resp.ResyncRequired = true
+			return resp
+		}
+
+		if len(events) > 0 {
+			resp.Events = events
+			return resp
+		}
+
+		after = next
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return resp
+		case <-r.Context().Done():
+			return resp
+		case <-service.shuttingDown:
+			return resp
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+)
+
+// TestWatchLog tests if events are read after a revision, filtered by kind and trimmed to the maximum.
+func TestWatchLog(t *testing.T) {
+	log := newWatchLog(10)
+
+	// Nothing changed yet.
+	events, next, resync, changed := log.read(10, nil)
+	if len(events) != 0 || next != 10 || resync {
+		t.Fatalf("Unexpected read %v %d %v", events, next, resync)
+	}
+
+	log.append(11, cns.WatchEvent{Kind: cns.WatchKindNetworkContainer, NetworkContainerID: "nc1"})
+	log.append(12, cns.WatchEvent{Kind: cns.WatchKindIPAddress, ReservationID: "r1"})
+
+	select {
+	case <-changed:
+	default:
+		t.Fatalf("Watchers were not woken up")
+	}
+
+	events, next, resync, _ = log.read(10, nil)
+	if len(events) != 2 || next != 12 || resync || events[0].ResumeToken != "11" || events[1].ResumeToken != "12" {
+		t.Fatalf("Unexpected read %+v %d %v", events, next, resync)
+	}
+
+	// Filtered events advance the resume token.
+	events, next, _, _ = log.read(10, map[string]bool{cns.WatchKindNetworkContainer: true})
+	if len(events) != 1 || events[0].NetworkContainerID != "nc1" || next != 12 {
+		t.Fatalf("Unexpected filtered read %+v %d", events, next)
+	}
+
+	// Revisions from before the log or from the future require a resync.
+	if _, next, resync, _ = log.read(9, nil); !resync || next != 12 {
+		t.Fatalf("Old revision did not require a resync")
+	}
+
+	if _, _, resync, _ = log.read(13, nil); !resync {
+		t.Fatalf("Future revision did not require a resync")
+	}
+
+	// Old events are dropped.
+	for revision := uint64(13); revision < 13+maxWatchEvents; revision++ {
+		log.append(revision, cns.WatchEvent{Kind: cns.WatchKindIPAddress})
+	}
+
+	if _, _, resync, _ = log.read(11, nil); !resync {
+		t.Fatalf("Dropped events did not require a resync")
+	}
+
+	events, next, resync, _ = log.read(12, nil)
+	if resync || len(events) != maxWatchBatch || next != 12+maxWatchBatch {
+		t.Fatalf("Unexpected read of %d events, next %d, resync %v", len(events), next, resync)
+	}
+}
+
+// TestWatchShutdown tests if pending watches return when the service shuts down.
+func TestWatchShutdown(t *testing.T) {
+	service := &HTTPRestService{watchLog: newWatchLog(1), shuttingDown: make(chan struct{})}
+	r := httptest.NewRequest("POST", cns.Watch, nil)
+
+	watchResp := make(chan cns.WatchResponse, 1)
+	go func() {
+		watchResp <- service.waitForWatchEvents(r, cns.WatchRequest{ResumeToken: "1", TimeoutInSeconds: 60})
+	}()
+
+	close(service.shuttingDown)
+
+	select {
+	case resp := <-watchResp:
+		if resp.ResumeToken != "1" || len(resp.Events) != 0 || resp.ResyncRequired {
+			t.Fatalf("Unexpected watch response %+v", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Watch did not return on shutdown")
+	}
+}
@@ -4,6 +4,8 @@
 package routes
 
 import (
+	"encoding/json"
+
 	"github.com/Azure/azure-container-networking/log"
 )
 
@@ -14,6 +16,11 @@ type Route struct {
 	gateway     string
 	metric      string
 	ifaceIndex  int
+	// Linux route attributes needed to restore the route as it was.
+	ifaceName string
+	protocol  int
+	scope     int
+	table     int
 }
 
 // RoutingTable describes the routing table on the node.
@@ -21,6 +28,56 @@ type RoutingTable struct {
 	Routes []Route
 }
 
+// routeJSON is the persisted form of a route.
+type routeJSON struct {
+	Destination string
+	Mask        string
+	Gateway     string
+	Metric      string
+	IfaceIndex  int
+	IfaceName   string `json:",omitempty"`
+	Protocol    int    `json:",omitempty"`
+	Scope       int    `json:",omitempty"`
+	Table       int    `json:",omitempty"`
+}
+
+// MarshalJSON encodes a route so that it can be persisted in CNS state.
+func (route Route) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&routeJSON{
+		Destination: route.destination,
+		Mask:        route.mask,
+		Gateway:     route.gateway,
+		Metric:      route.metric,
+		IfaceIndex:  route.ifaceIndex,
+		IfaceName:   route.ifaceName,
+		Protocol:    route.protocol,
+		Scope:       route.scope,
+		Table:       route.table,
+	})
+}
+
+// UnmarshalJSON decodes a route persisted in CNS state.
+func (route *Route) UnmarshalJSON(b []byte) error {
+	var r routeJSON
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	*route = Route{
+		destination: r.Destination,
+		mask:        r.Mask,
+		gateway:     r.Gateway,
+		metric:      r.Metric,
+		ifaceIndex:  r.IfaceIndex,
+		ifaceName:   r.IfaceName,
+		protocol:    r.Protocol,
+		scope:       r.Scope,
+		table:       r.Table,
+	}
+
+	return nil
+}
+
 // GetRoutingTable retireves routing table in the node.
 func (rt *RoutingTable) GetRoutingTable() error {
 	routes, err := getRoutes()
@@ -5,12 +5,219 @@
 
 package routes
 
-const ()
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/Azure/azure-container-networking/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Gateway of on-link routes, as reported on Windows.
+	onLinkGateway = "0.0.0.0"
+
+	// Directory of the network interfaces in sysfs.
+	sysClassNet = "/sys/class/net"
+)
+
+// Returns whether a route is one CNS snapshots and restores.
+// Only unicast routes in the main table that were added statically, at boot or by DHCP are owned by CNS.
+// Kernel routes are recreated along with the interface addresses, and routes of routing daemons are theirs to restore.
+func isOwnedRoute(route *netlink.Route) bool {
+	if route.Table != unix.RT_TABLE_MAIN || route.Type != unix.RTN_UNICAST {
+		return false
+	}
+
+	switch route.Protocol {
+	case unix.RTPROT_BOOT, unix.RTPROT_STATIC, unix.RTPROT_DHCP:
+		return true
+	}
+
+	return false
+}
+
+// Converts a netlink route to a route.
+func newRoute(nlRoute *netlink.Route) Route {
+	dst := nlRoute.Dst
+	if dst == nil {
+		// Default route.
+		if nlRoute.Family == unix.AF_INET6 {
+			dst = &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+		} else {
+			dst = &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+		}
+	}
+
+	gateway := onLinkGateway
+	if nlRoute.Gw != nil {
+		gateway = nlRoute.Gw.String()
+	}
+
+	// Interface indexes are not stable across reboots, so the interface is also recorded by name.
+	var ifaceName string
+	if iface, err := net.InterfaceByIndex(nlRoute.LinkIndex); err == nil {
+		ifaceName = iface.Name
+	}
+
+	return Route{
+		destination: dst.IP.String(),
+		mask:        net.IP(dst.Mask).String(),
+		gateway:     gateway,
+		metric:      strconv.Itoa(nlRoute.Priority),
+		ifaceIndex:  nlRoute.LinkIndex,
+		ifaceName:   ifaceName,
+		protocol:    nlRoute.Protocol,
+		scope:       nlRoute.Scope,
+		table:       nlRoute.Table,
+	}
+}
+
+// Returns the index of the interface that carries a route. The routes of an interface enslaved to a bridge,
+// such as the primary interface in bridge mode, are carried by the bridge. Routes persisted without an
+// interface name keep their interface index.
+func getRouteLinkIndex(route Route) (int, error) {
+	name := route.ifaceName
+	if name == "" {
+		return route.ifaceIndex, nil
+	}
+
+	if master, err := filepath.EvalSymlinks(filepath.Join(sysClassNet, name, "master")); err == nil {
+		name = filepath.Base(master)
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return iface.Index, nil
+}
+
+// Returns whether a route with the same destination and gateway is in the given routes.
+// Interfaces are not compared since their indexes change across reboots, and the routes of an interface
+// move to the bridge it is enslaved to.
+func containsRoute(routes []Route, route Route) (bool, error) {
+	for _, existingRoute := range routes {
+		if existingRoute.destination == route.destination &&
+			existingRoute.gateway == route.gateway &&
+			existingRoute.mask == route.mask {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Converts a route to a netlink route.
+func newNetlinkRoute(route Route) (*netlink.Route, error) {
+	ip := net.ParseIP(route.destination)
+	mask := net.ParseIP(route.mask)
+	if ip == nil || mask == nil {
+		return nil, fmt.Errorf("Invalid route destination %v mask %v", route.destination, route.mask)
+	}
+
+	family := unix.AF_INET
+	if ip.To4() != nil {
+		ip = ip.To4()
+		mask = mask.To4()
+	} else {
+		family = unix.AF_INET6
+	}
+
+	linkIndex, err := getRouteLinkIndex(route)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid route interface %v: %v", route.ifaceName, err)
+	}
+
+	nlRoute := &netlink.Route{
+		Family:    family,
+		LinkIndex: linkIndex,
+		Protocol:  route.protocol,
+		Scope:     route.scope,
+		Table:     route.table,
+	}
+
+	if ones, _ := net.IPMask(mask).Size(); ones != 0 {
+		nlRoute.Dst = &net.IPNet{IP: ip, Mask: net.IPMask(mask)}
+	}
+
+	if route.gateway != onLinkGateway && route.gateway != "" {
+		nlRoute.Gw = net.ParseIP(route.gateway)
+	}
+
+	if route.metric != "" {
+		priority, err := strconv.Atoi(route.metric)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid route metric %v", route.metric)
+		}
+		nlRoute.Priority = priority
+	}
+
+	return nlRoute, nil
+}
 
 func getRoutes() ([]Route, error) {
-	return nil, nil
+	logger.Printf("[Azure CNS] getRoutes")
+
+	var localRoutes []Route
+
+	for _, family := range []int{unix.AF_INET, unix.AF_INET6} {
+		nlRoutes, err := netlink.GetIpRoute(&netlink.Route{Family: family})
+		if err != nil {
+			logger.Printf("Received error in getting routing table %v", err.Error())
+			return nil, err
+		}
+
+		for _, nlRoute := range nlRoutes {
+			if !isOwnedRoute(nlRoute) {
+				continue
+			}
+
+			rt := newRoute(nlRoute)
+			logger.Debugf("[Azure CNS] Parsed route: %+v", rt)
+			localRoutes = append(localRoutes, rt)
+		}
+	}
+
+	logger.Debugf("[Azure CNS] Recevied route count: %d", len(localRoutes))
+
+	return localRoutes, nil
 }
 
 func putRoutes(routes []Route) error {
+	logger.Printf("[Azure CNS] putRoutes")
+
+	logger.Printf("[Azure CNS] Going to get current routes")
+	currentRoutes, err := getRoutes()
+	if err != nil {
+		return err
+	}
+
+	for _, route := range routes {
+		exists, _ := containsRoute(currentRoutes, route)
+		if exists {
+			logger.Printf("[Azure CNS] Route already exists. skipping %+v", route)
+			continue
+		}
+
+		nlRoute, err := newNetlinkRoute(route)
+		if err != nil {
+			logger.Errorf("[Azure CNS] Failed to parse route %+v, err:%v", route, err)
+			continue
+		}
+
+		logger.Printf("[Azure CNS] Adding missing route: %+v", route)
+
+		if err = netlink.AddIpRoute(nlRoute); err != nil {
+			logger.Errorf("[Azure CNS] Failed to add route %+v, err:%v", route, err)
+		} else {
+			logger.Printf("[Azure CNS] Successfully added route: %+v", route)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,57 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// +build linux
+
+package routes
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/platform"
+)
+
+// The test route is an on-link route on the loopback interface.
+func addTestRoute() error {
+	cmd := fmt.Sprintf("ip route add %v/32 dev lo scope link metric %v", testDest, testMetric)
+	log.Printf("[Azure CNS] Adding test route: %v", cmd)
+
+	_, err := platform.ExecuteCommand(cmd)
+	return err
+}
+
+func deleteTestRoute() error {
+	cmd := fmt.Sprintf("ip route del %v/32 dev lo metric %v", testDest, testMetric)
+	log.Printf("[Azure CNS] Deleting test route: %v", cmd)
+
+	_, err := platform.ExecuteCommand(cmd)
+	return err
+}
+
+// TestRouteInterface tests if restored routes are matched and placed regardless of interface indexes.
+func TestRouteInterface(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Fatalf("Failed to get loopback interface %v", err)
+	}
+
+	// The interface index recorded before a reboot is stale.
+	saved := Route{destination: testDest, mask: testMask, gateway: testGateway, ifaceIndex: lo.Index + 100, ifaceName: "lo"}
+	current := Route{destination: testDest, mask: testMask, gateway: testGateway, ifaceIndex: lo.Index, ifaceName: "lo"}
+
+	if exists, _ := containsRoute([]Route{current}, saved); !exists {
+		t.Errorf("Route on a different interface index was not matched")
+	}
+
+	nlRoute, err := newNetlinkRoute(saved)
+	if err != nil {
+		t.Fatalf("newNetlinkRoute failed %v", err)
+	}
+
+	if nlRoute.LinkIndex != lo.Index {
+		t.Errorf("Route interface was not resolved by name, got index %d want %d", nlRoute.LinkIndex, lo.Index)
+	}
+}
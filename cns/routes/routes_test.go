@@ -1,15 +1,16 @@
 // Copyright 2017 Microsoft. All rights reserved.
 // MIT License
 
-// +build windows
-
 package routes
 
 import (
-	"github.com/Azure/azure-container-networking/log"
+	"encoding/json"
 	"os"
-	"os/exec"
+	"reflect"
 	"testing"
+
+	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/Azure/azure-container-networking/log"
 )
 
 const (
@@ -21,49 +22,13 @@ const (
 
 // Wraps the test run.
 func TestMain(m *testing.M) {
+	logger.InitLogger("azure-cns-routes-test", log.LevelInfo, log.TargetStderr, "")
+
 	// Run tests.
 	exitCode := m.Run()
 	os.Exit(exitCode)
 }
 
-func addTestRoute() error {
-	arg := []string{"/C", "route", "ADD", testDest,
-		"MASK", testMask, testGateway, "METRIC", testMetric}
-	log.Printf("[Azure CNS] Adding missing route: %v", arg)
-
-	c := exec.Command("cmd", arg...)
-	bytes, err := c.Output()
-	if err == nil {
-		log.Printf("[Azure CNS] Successfully executed add route: %v\n%v",
-			arg, string(bytes))
-	} else {
-		log.Printf("[Azure CNS] Failed to execute add route: %v\n%v\n%v",
-			arg, string(bytes), err.Error())
-		return err
-	}
-
-	return nil
-}
-
-func deleteTestRoute() error {
-	args := []string{"/C", "route", "DELETE", testDest, "MASK", testMask,
-		testGateway, "METRIC", testMetric}
-	log.Printf("[Azure CNS] Deleting route: %v", args)
-
-	c := exec.Command("cmd", args...)
-	bytes, err := c.Output()
-	if err == nil {
-		log.Printf("[Azure CNS] Successfully executed delete route: %v\n%v",
-			args, string(bytes))
-	} else {
-		log.Printf("[Azure CNS] Failed to execute delete route: %v\n%v\n%v",
-			args, string(bytes), err.Error())
-		return err
-	}
-
-	return nil
-}
-
 // TestPutRoutes tests if a missing route is properly restored or not.
 func TestRestoreMissingRoute(t *testing.T) {
 	log.Printf("Test: PutMissingRoutes")
@@ -113,3 +78,34 @@ func TestRestoreMissingRoute(t *testing.T) {
 		}
 	}
 }
+
+// TestRouteJSON tests if a route is preserved when CNS state is persisted.
+func TestRouteJSON(t *testing.T) {
+	rt := &RoutingTable{
+		Routes: []Route{{
+			destination: testDest,
+			mask:        testMask,
+			gateway:     testGateway,
+			metric:      testMetric,
+			ifaceIndex:  1,
+			ifaceName:   "eth0",
+			protocol:    4,
+			scope:       253,
+			table:       254,
+		}},
+	}
+
+	b, err := json.Marshal(rt)
+	if err != nil {
+		t.Fatalf("marshal failed %+v", err)
+	}
+
+	restored := &RoutingTable{}
+	if err = json.Unmarshal(b, restored); err != nil {
+		t.Fatalf("unmarshal failed %+v", err)
+	}
+
+	if !reflect.DeepEqual(rt, restored) {
+		t.Errorf("route not preserved, got %+v want %+v", restored, rt)
+	}
+}
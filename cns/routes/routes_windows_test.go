@@ -0,0 +1,50 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// +build windows
+
+package routes
+
+import (
+	"os/exec"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+func addTestRoute() error {
+	arg := []string{"/C", "route", "ADD", testDest,
+		"MASK", testMask, testGateway, "METRIC", testMetric}
+	log.Printf("[Azure CNS] Adding missing route: %v", arg)
+
+	c := exec.Command("cmd", arg...)
+	bytes, err := c.Output()
+	if err == nil {
+		log.Printf("[Azure CNS] Successfully executed add route: %v\n%v",
+			arg, string(bytes))
+	} else {
+		log.Printf("[Azure CNS] Failed to execute add route: %v\n%v\n%v",
+			arg, string(bytes), err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func deleteTestRoute() error {
+	args := []string{"/C", "route", "DELETE", testDest, "MASK", testMask,
+		testGateway, "METRIC", testMetric}
+	log.Printf("[Azure CNS] Deleting route: %v", args)
+
+	c := exec.Command("cmd", args...)
+	bytes, err := c.Output()
+	if err == nil {
+		log.Printf("[Azure CNS] Successfully executed delete route: %v\n%v",
+			args, string(bytes))
+	} else {
+		log.Printf("[Azure CNS] Failed to execute delete route: %v\n%v\n%v",
+			args, string(bytes), err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@
 package cns
 
 import (
+	"context"
 	"net/http"
 	"net/url"
 
@@ -70,6 +71,10 @@ func (service *Service) Initialize(config *common.ServiceConfig) error {
 			return err
 		}
 
+		listener.SetTLSConfig(config.TLSConfig)
+		listener.SetAuthorizer(config.Authorizer)
+		listener.SetServerTimeouts(config.ServerTimeouts)
+
 		// Start the listener.
 		err = listener.Start(config.ErrChan)
 		if err != nil {
@@ -91,6 +96,13 @@ func (service *Service) Uninitialize() {
 	service.Service.Uninitialize()
 }
 
+// Shutdown stops the listener after the requests in flight complete, and cleans up the service.
+func (service *Service) Shutdown(ctx context.Context) error {
+	err := service.Listener.Shutdown(ctx)
+	service.Service.Uninitialize()
+	return err
+}
+
 // ParseOptions returns generic options from a libnetwork request.
 func (service *Service) ParseOptions(options OptionMap) OptionMap {
 	opt, _ := options[genericData].(OptionMap)
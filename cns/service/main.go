@@ -4,11 +4,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/Azure/azure-container-networking/aitelemetry"
 	"github.com/Azure/azure-container-networking/cnm/ipam"
@@ -19,6 +21,7 @@ import (
 	"github.com/Azure/azure-container-networking/cns/logger"
 	"github.com/Azure/azure-container-networking/cns/restserver"
 	acn "github.com/Azure/azure-container-networking/common"
+	acnipam "github.com/Azure/azure-container-networking/ipam"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/platform"
 	"github.com/Azure/azure-container-networking/store"
@@ -192,34 +195,42 @@ func main() {
 	// Initialize and parse command line arguments.
 	acn.ParseArgs(&args, printVersion)
 
-	environment := acn.GetArg(acn.OptEnvironment).(string)
-	url := acn.GetArg(acn.OptAPIServerURL).(string)
-	cniPath := acn.GetArg(acn.OptNetPluginPath).(string)
-	cniConfigFile := acn.GetArg(acn.OptNetPluginConfigFile).(string)
-	cnsURL := acn.GetArg(acn.OptCnsURL).(string)
-	logLevel := acn.GetArg(acn.OptLogLevel).(int)
-	logTarget := acn.GetArg(acn.OptLogTarget).(int)
-	logDirectory := acn.GetArg(acn.OptLogLocation).(string)
-	ipamQueryUrl, _ := acn.GetArg(acn.OptIpamQueryUrl).(string)
-	ipamQueryInterval, _ := acn.GetArg(acn.OptIpamQueryInterval).(int)
-	startCNM := acn.GetArg(acn.OptStartAzureCNM).(bool)
 	vers := acn.GetArg(acn.OptVersion).(bool)
-	createDefaultExtNetworkType := acn.GetArg(acn.OptCreateDefaultExtNetworkType).(string)
 	telemetryEnabled := acn.GetArg(acn.OptTelemetry).(bool)
-	httpConnectionTimeout := acn.GetArg(acn.OptHttpConnectionTimeout).(int)
-	httpResponseHeaderTimeout := acn.GetArg(acn.OptHttpResponseHeaderTimeout).(int)
-	storeFileLocation := acn.GetArg(acn.OptStoreFileLocation).(string)
 
 	if vers {
 		printVersion()
 		os.Exit(0)
 	}
 
+	// Load the config file and apply the settings given by the environment and the command line.
+	configPath, err := configuration.GetConfigPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find config file, err:%v.\n", err)
+		os.Exit(1)
+	}
+
+	cnsconfig, err := configuration.LoadConfig(configPath, os.LookupEnv, acn.LookupArg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config, err:%v.\n", err)
+		os.Exit(1)
+	}
+
+	environment := cnsconfig.ServiceSettings.Environment
+	url := cnsconfig.ServiceSettings.APIServerURL
+	cniPath := cnsconfig.PluginSettings.NetPluginPath
+	cniConfigFile := cnsconfig.PluginSettings.NetPluginConfigFile
+	cnsURL := cnsconfig.ServiceSettings.CnsURL
+	ipamQueryUrl := cnsconfig.PluginSettings.IpamQueryURL
+	ipamQueryInterval := cnsconfig.PluginSettings.IpamQueryIntervalInSecs
+	startCNM := cnsconfig.ServiceSettings.StartAzureCNM
+	createDefaultExtNetworkType := cnsconfig.ServiceSettings.CreateDefaultExtNetworkType
+	httpConnectionTimeout := cnsconfig.HTTPClientSettings.ConnectionTimeoutInSecs
+	httpResponseHeaderTimeout := cnsconfig.HTTPClientSettings.ResponseHeaderTimeoutInSecs
+	storeFileLocation := cnsconfig.ServiceSettings.StoreFileLocation
+
 	// Initialize CNS.
-	var (
-		err    error
-		config common.ServiceConfig
-	)
+	var config common.ServiceConfig
 
 	config.Version = version
 	config.Name = name
@@ -227,18 +238,13 @@ func main() {
 	config.ErrChan = make(chan error, 1)
 
 	// Create logging provider.
-	logger.InitLogger(name, logLevel, logTarget, logDirectory)
+	logger.InitLogger(name, cnsconfig.LogSettings.GetLogLevel(), cnsconfig.LogSettings.GetLogTarget(), cnsconfig.LogSettings.LogLocation)
+	logger.Printf("[Configuration] Config path:%s", configPath)
 
 	if !telemetryEnabled {
 		logger.Errorf("[Azure CNS] Cannot disable telemetry via cmdline. Update cns_config.json to disable telemetry.")
 	}
 
-	cnsconfig, err := configuration.ReadConfig()
-	if err != nil {
-		logger.Errorf("[Azure CNS] Error reading cns config: %v", err)
-	}
-
-	configuration.SetCNSConfigDefaults(&cnsconfig)
 	logger.Printf("[Azure CNS] Read config :%+v", cnsconfig)
 
 	disableTelemetry := cnsconfig.TelemetrySettings.DisableAll
@@ -276,6 +282,25 @@ func main() {
 		return
 	}
 
+	// Secure the CNS API as configured.
+	if tlsSettings := cnsconfig.TLSSettings; tlsSettings.CertificateFile != "" && tlsSettings.KeyFile != "" {
+		config.TLSConfig, err = acn.NewServerTLSConfig(tlsSettings.CertificateFile, tlsSettings.KeyFile, tlsSettings.ClientCAFile)
+		if err != nil {
+			logger.Errorf("Failed to load TLS settings, err:%v.\n", err)
+			return
+		}
+	}
+
+	config.Authorizer, err = restserver.NewAuthorizer(cnsconfig.AuthorizationPolicy)
+	if err != nil {
+		logger.Errorf("Failed to load authorization policy, err:%v.\n", err)
+		return
+	}
+
+	config.EnableProfiling = cnsconfig.DebugSettings.EnableProfiling
+	config.ManageNetworkContainerNamespaces = cnsconfig.ServiceSettings.ManageNetworkContainerNamespaces
+	config.ServerTimeouts = cnsconfig.HTTPServerSettings.GetServerTimeouts()
+
 	// Create CNS object.
 	httpRestService, err := restserver.NewHTTPRestService(&config)
 	if err != nil {
@@ -318,6 +343,7 @@ func main() {
 
 	var netPlugin network.NetPlugin
 	var ipamPlugin ipam.IpamPlugin
+	var cancelPoolChanges func()
 
 	if startCNM {
 		var pluginConfig acn.PluginConfig
@@ -364,18 +390,45 @@ func main() {
 			logger.Errorf("Failed to create IPAM plugin, err:%v.\n", err)
 			return
 		}
+
+		// Report the changes of the address pools to telemetry.
+		var poolChanges <-chan *acnipam.PoolChangeEvent
+		poolChanges, cancelPoolChanges = ipamPlugin.SubscribePoolChanges()
+		go reportPoolChanges(poolChanges)
 	}
 
 	// Relay these incoming signals to OS signal channel.
 	osSignalChannel := make(chan os.Signal, 1)
 	signal.Notify(osSignalChannel, os.Interrupt, os.Kill, syscall.SIGTERM)
 
+	// Reload the config on SIGHUP.
+	reloadSignalChannel := make(chan os.Signal, 1)
+	signal.Notify(reloadSignalChannel, syscall.SIGHUP)
+
 	// Wait until receiving a signal.
-	select {
-	case sig := <-osSignalChannel:
-		logger.Printf("CNS Received OS signal <" + sig.String() + ">, shutting down.")
-	case err := <-config.ErrChan:
-		logger.Printf("CNS Received unhandled error %v, shutting down.", err)
+wait:
+	for {
+		select {
+		case <-reloadSignalChannel:
+			logger.Printf("CNS Received SIGHUP, reloading config.")
+			cnsconfig = reloadConfig(configPath, cnsconfig, httpRestService, !disableTelemetry)
+		case sig := <-osSignalChannel:
+			logger.Printf("CNS Received OS signal <" + sig.String() + ">, shutting down.")
+			break wait
+		case err := <-config.ErrChan:
+			logger.Printf("CNS Received unhandled error %v, shutting down.", err)
+			break wait
+		}
+	}
+
+	// Stop accepting requests, complete the requests in flight and flush the state to the store.
+	if httpRestService != nil {
+		shutdownTimeout := time.Duration(cnsconfig.HTTPServerSettings.ShutdownTimeoutInSecs) * time.Second
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := httpRestService.Shutdown(ctx); err != nil {
+			logger.Errorf("Failed to shut down CNS gracefully, err:%v.\n", err)
+		}
+		cancel()
 	}
 
 	if len(strings.TrimSpace(createDefaultExtNetworkType)) > 0 {
@@ -386,26 +439,46 @@ func main() {
 		}
 	}
 
-	if !disableTelemetry {
-		telemetryStopProcessing <- true
-		stopheartbeat <- true
-		stopSnapshots <- true
-	}
-
-	// Cleanup.
-	if httpRestService != nil {
-		httpRestService.Stop()
-	}
-
 	if startCNM {
 		if netPlugin != nil {
 			netPlugin.Stop()
 		}
 
+		if cancelPoolChanges != nil {
+			cancelPoolChanges()
+		}
+
 		if ipamPlugin != nil {
 			ipamPlugin.Stop()
 		}
 	}
 
+	// Stop the periodic reports before the telemetry they are sent to.
+	if !disableTelemetry {
+		stopheartbeat <- true
+		stopSnapshots <- true
+		telemetryStopProcessing <- true
+	}
+
+	// Close the logger and flush the telemetry handle.
 	logger.Close()
 }
+
+// Sends the changes of the address pools of the IPAM plugin to App Insights telemetry until the subscription is canceled.
+func reportPoolChanges(events <-chan *acnipam.PoolChangeEvent) {
+	for event := range events {
+		logger.LogEvent(aitelemetry.Event{
+			EventName:  logger.IpamPoolChangeEventStr,
+			ResourceID: event.PoolId,
+			Properties: map[string]string{
+				logger.AddressSpaceStr:       event.AddressSpace,
+				logger.InterfaceNameStr:      event.IfName,
+				logger.PoolAddedStr:          fmt.Sprintf("%t", event.PoolAdded),
+				logger.PoolRemovedStr:        fmt.Sprintf("%t", event.PoolRemoved),
+				logger.AddedAddressesStr:     strings.Join(event.Added, ","),
+				logger.RemovedAddressesStr:   strings.Join(event.Removed, ","),
+				logger.UnhealthyAddressesStr: strings.Join(event.Unhealthy, ","),
+			},
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/cns/configuration"
+	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/Azure/azure-container-networking/cns/restserver"
+	acn "github.com/Azure/azure-container-networking/common"
+)
+
+// reloadConfig reloads the config and applies the settings that can change while CNS runs.
+// It returns the config in effect, which is the current config if the reloaded one is invalid.
+func reloadConfig(
+	configPath string,
+	current configuration.CNSConfig,
+	service restserver.HTTPService,
+	telemetryEnabled bool) configuration.CNSConfig {
+	updated, err := configuration.LoadConfig(configPath, os.LookupEnv, acn.LookupArg)
+	if err != nil {
+		logger.Errorf("[Azure CNS] Failed to reload config, keeping the current config, err:%v.", err)
+		return current
+	}
+
+	if settings := configuration.RestartRequired(current, updated); len(settings) > 0 {
+		logger.Printf("[Azure CNS] Changes of %s take effect on restart", strings.Join(settings, ", "))
+	}
+
+	logger.SetLogLevel(updated.LogSettings.GetLogLevel())
+
+	if telemetryEnabled {
+		ts := updated.TelemetrySettings
+		logger.SetTelemetryLogging(ts.DisableTrace, ts.DisableMetric, ts.DisableEvent)
+
+		// Restart the periodic reports whose interval changed.
+		if ts.HeartBeatIntervalInMins != current.TelemetrySettings.HeartBeatIntervalInMins {
+			stopheartbeat <- true
+			go logger.SendHeartBeat(ts.HeartBeatIntervalInMins, stopheartbeat)
+		}
+
+		if ts.SnapshotIntervalInMins != current.TelemetrySettings.SnapshotIntervalInMins {
+			stopSnapshots <- true
+			go service.SendNCSnapShotPeriodically(ts.SnapshotIntervalInMins, stopSnapshots)
+		}
+	}
+
+	current = configuration.MergeReloadable(current, updated)
+	logger.Printf("[Azure CNS] Reloaded config :%+v", current)
+
+	return current
+}
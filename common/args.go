@@ -22,6 +22,7 @@ type Argument struct {
 	ValueMap     map[string]interface{}
 	strVal       string
 	boolVal      bool
+	isSet        bool
 }
 
 // ArgumentList represents a set of command line arguments.
@@ -51,6 +52,15 @@ func ParseArgs(args *ArgumentList, usage func()) {
 	flag.Usage = printHelp
 	flag.Parse()
 
+	// Record the arguments given on the command line.
+	flag.Visit(func(f *flag.Flag) {
+		for _, arg := range *args {
+			if f.Name == arg.Name || f.Name == arg.Shorthand {
+				arg.isSet = true
+			}
+		}
+	})
+
 	// Validate arguments and convert them to their mapped values.
 	for _, arg := range *args {
 		switch arg.Type {
@@ -94,6 +104,20 @@ func GetArg(name string) interface{} {
 	return nil
 }
 
+// LookupArg returns the value of the given argument as given on the command line,
+// and whether it was given at all.
+func LookupArg(name string) (string, bool) {
+	for _, arg := range *argList {
+		if arg.Name == name && arg.isSet {
+			if arg.Type == "bool" {
+				return strconv.FormatBool(arg.boolVal), true
+			}
+			return arg.strVal, true
+		}
+	}
+	return "", false
+}
+
 // printErrorForArg prints the error line for the given argument.
 func printErrorForArg(arg *Argument) {
 	fmt.Printf("Invalid value '%v' for argument '%v'.\n\n", arg.strVal, arg.Name)
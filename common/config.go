@@ -12,6 +12,8 @@ const (
 	OptEnvironmentMAS          = "mas"
 	OptEnvironmentFileIpam     = "fileIpam"
 	OptEnvironmentIPv6NodeIpam = "ipv6NodeIpam"
+	OptEnvironmentRangeIpam    = "rangeIpam"
+	OptEnvironmentIMDS         = "imds"
 
 	// API server URL.
 	OptAPIServerURL      = "api-url"
@@ -46,6 +48,24 @@ const (
 	OptIpamQueryInterval      = "ipam-query-interval"
 	OptIpamQueryIntervalAlias = "i"
 
+	// IPAM address allocation strategy.
+	OptIpamAllocationStrategy      = "ipam-allocation-strategy"
+	OptIpamAllocationStrategyAlias = "ipamstrategy"
+	OptIpamAllocationLRR           = "least-recently-released"
+	OptIpamAllocationSequential    = "sequential"
+
+	// IPAM quarantine period of released addresses, in seconds.
+	OptIpamQuarantinePeriod      = "ipam-quarantine-period"
+	OptIpamQuarantinePeriodAlias = "ipamquarantine"
+
+	// IPAM duplicate address detection.
+	OptIpamDAD      = "ipam-dad"
+	OptIpamDADAlias = "ipamdad"
+
+	// IPAM range configuration file.
+	OptIpamRangeFile      = "ipam-range-file"
+	OptIpamRangeFileAlias = "ipamrangefile"
+
 	// Start CNM
 	OptStartAzureCNM      = "start-azure-cnm"
 	OptStartAzureCNMAlias = "startcnm"
@@ -98,4 +118,14 @@ const (
 	// Store file location
 	OptStoreFileLocation      = "store-file-path"
 	OptStoreFileLocationAlias = "storefilepath"
+
+	// Garbage collection of orphaned endpoints
+	OptGCRuntimeEndpoint      = "gc-runtime-endpoint"
+	OptGCRuntimeEndpointAlias = "gcruntime"
+	OptGCSandboxFile          = "gc-sandbox-file"
+	OptGCSandboxFileAlias     = "gcfile"
+	OptGCGracePeriod          = "gc-grace-period"
+	OptGCGracePeriodAlias     = "gcgrace"
+	OptGCDryRun               = "gc-dry-run"
+	OptGCDryRunAlias          = "gcdryrun"
 )
@@ -4,12 +4,17 @@
 package common
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"time"
 
 	"github.com/Azure/azure-container-networking/log"
 )
@@ -20,11 +25,41 @@ type Listener struct {
 	protocol     string
 	localAddress string
 	endpoints    []string
+	handlers     []string
 	active       bool
 	l            net.Listener
 	mux          *http.ServeMux
+	server       *http.Server
+	tlsConfig    *tls.Config
+	authorizer   Authorizer
+	timeouts     ServerTimeouts
 }
 
+// ServerTimeouts are the timeouts of the HTTP server of a listener. Zero means no timeout.
+type ServerTimeouts struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+}
+
+// PeerIdentity describes the caller of a request as established by the transport.
+type PeerIdentity struct {
+	// Credentials of the peer process of a unix socket.
+	HasCredentials bool
+	UID            uint32
+	GID            uint32
+	PID            int32
+	// Common name of the verified client certificate of a TLS connection.
+	CommonName string
+}
+
+// Authorizer decides whether a request is allowed. It returns an error describing why a request is denied.
+type Authorizer func(r *http.Request, peer PeerIdentity) error
+
+// Context key of the peer identity of a connection.
+type peerIdentityKey struct{}
+
 // NewListener creates a new Listener.
 func NewListener(u *url.URL) (*Listener, error) {
 	listener := Listener{
@@ -53,11 +88,27 @@ func (listener *Listener) Start(errChan chan error) error {
 		return err
 	}
 
-	log.Printf("[Listener] Started listening on %s.", listener.localAddress)
+	if listener.tlsConfig != nil {
+		listener.l = tls.NewListener(listener.l, listener.tlsConfig)
+	}
+
+	log.Printf("[Listener] Started listening on %s, tls:%v.", listener.localAddress, listener.tlsConfig != nil)
+
+	server := &http.Server{
+		Handler:           listener.handler(),
+		ConnContext:       withPeerIdentity,
+		ReadHeaderTimeout: listener.timeouts.ReadHeaderTimeout,
+		ReadTimeout:       listener.timeouts.ReadTimeout,
+		WriteTimeout:      listener.timeouts.WriteTimeout,
+		IdleTimeout:       listener.timeouts.IdleTimeout,
+	}
+	listener.server = server
 
 	// Launch goroutine for servicing requests.
 	go func() {
-		errChan <- http.Serve(listener.l, listener.mux)
+		if err := server.Serve(listener.l); err != http.ErrServerClosed {
+			errChan <- err
+		}
 	}()
 
 	listener.active = true
@@ -83,6 +134,124 @@ func (listener *Listener) Stop() {
 	log.Printf("[Listener] Stopped listening on %s", listener.localAddress)
 }
 
+// Shutdown stops listening for requests and waits for the requests in flight to complete.
+// Connections are closed when the context is done before the requests complete.
+func (listener *Listener) Shutdown(ctx context.Context) error {
+	// Ignore if not active.
+	if !listener.active {
+		return nil
+	}
+	listener.active = false
+
+	err := listener.server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("[Listener] Failed to complete requests in flight on %s: %v", listener.localAddress, err)
+		listener.server.Close()
+	}
+
+	// Delete the unix socket.
+	if listener.protocol == "unix" {
+		os.Remove(listener.localAddress)
+	}
+
+	log.Printf("[Listener] Shut down listening on %s", listener.localAddress)
+	return err
+}
+
+// SetServerTimeouts sets the timeouts of the HTTP server. It must be called before Start.
+func (listener *Listener) SetServerTimeouts(timeouts ServerTimeouts) {
+	listener.timeouts = timeouts
+}
+
+// SetTLSConfig enables TLS on the listener. It must be called before Start.
+func (listener *Listener) SetTLSConfig(config *tls.Config) {
+	listener.tlsConfig = config
+}
+
+// SetAuthorizer sets the authorizer of requests. It must be called before Start.
+func (listener *Listener) SetAuthorizer(authorizer Authorizer) {
+	listener.authorizer = authorizer
+}
+
+// Returns the handler of requests, which authorizes them before passing them on to the mux.
+func (listener *Listener) handler() http.Handler {
+	if listener.authorizer == nil {
+		return listener.mux
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := GetPeerIdentity(r)
+		if err := listener.authorizer(r, peer); err != nil {
+			log.Printf("[Listener] Denied request %s %s from %+v: %v", r.Method, r.URL.Path, peer, err)
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			return
+		}
+
+		listener.mux.ServeHTTP(w, r)
+	})
+}
+
+// Stores the credentials of the peer process of a unix socket connection in the connection context.
+// Peer credentials are not available on unix sockets with TLS.
+func withPeerIdentity(ctx context.Context, conn net.Conn) context.Context {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	var peer PeerIdentity
+	var err error
+	peer.UID, peer.GID, peer.PID, err = getPeerCredentials(unixConn)
+	if err != nil {
+		log.Printf("[Listener] Failed to get peer credentials: %v", err)
+		return ctx
+	}
+
+	peer.HasCredentials = true
+	return context.WithValue(ctx, peerIdentityKey{}, peer)
+}
+
+// GetPeerIdentity returns the identity of the caller of a request.
+func GetPeerIdentity(r *http.Request) PeerIdentity {
+	peer, _ := r.Context().Value(peerIdentityKey{}).(PeerIdentity)
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		peer.CommonName = r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+
+	return peer
+}
+
+// NewServerTLSConfig returns a TLS configuration with the given certificate and key files in PEM format.
+// If a client CA file is given, clients must present a certificate signed by one of its CAs.
+func NewServerTLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		pem, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", clientCAFile)
+		}
+
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
 // GetMux returns the HTTP mux for the listener.
 func (listener *Listener) GetMux() *http.ServeMux {
 	return listener.mux
@@ -98,9 +267,15 @@ func (listener *Listener) AddEndpoint(endpoint string) {
 	listener.endpoints = append(listener.endpoints, endpoint)
 }
 
+// GetHandlerPaths returns the list of paths with a registered protocol handler.
+func (listener *Listener) GetHandlerPaths() []string {
+	return listener.handlers
+}
+
 // AddHandler registers a protocol handler.
 func (listener *Listener) AddHandler(path string, handler func(http.ResponseWriter, *http.Request)) {
 	listener.mux.HandleFunc(path, handler)
+	listener.handlers = append(listener.handlers, path)
 }
 
 // Decode receives and decodes JSON payload to a request.
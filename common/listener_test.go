@@ -0,0 +1,277 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package common
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Writes a certificate signed by the given parent, or a self-signed CA certificate, and its key in PEM format.
+func writeTestCertificate(t *testing.T, dir string, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed %v", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed %v", err)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPem, 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPem, 0600)
+
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+// Starts a listener that responds with the identity of the caller.
+func startTestListener(t *testing.T, u *url.URL, tlsConfig *tls.Config, authorizer Authorizer) *Listener {
+	listener, err := NewListener(u)
+	if err != nil {
+		t.Fatalf("NewListener failed %v", err)
+	}
+
+	listener.SetTLSConfig(tlsConfig)
+	listener.SetAuthorizer(authorizer)
+	listener.AddHandler("/identity", func(w http.ResponseWriter, r *http.Request) {
+		listener.Encode(w, GetPeerIdentity(r))
+	})
+
+	if err = listener.Start(make(chan error, 1)); err != nil {
+		t.Fatalf("Start failed %v", err)
+	}
+
+	return listener
+}
+
+// TestListenerTLS tests if client certificates are verified and identify the caller.
+func TestListenerTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "listener")
+	if err != nil {
+		t.Fatalf("TempDir failed %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeTestCertificate(t, dir, "ca", true, nil, nil)
+	writeTestCertificate(t, dir, "server", false, ca, caKey)
+	writeTestCertificate(t, dir, "client", false, ca, caKey)
+
+	tlsConfig, err := NewServerTLSConfig(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatalf("NewServerTLSConfig failed %v", err)
+	}
+
+	// Find a free port.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed %v", err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	listener := startTestListener(t, &url.URL{Scheme: "tcp", Host: address}, tlsConfig, nil)
+	defer listener.Stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatalf("LoadX509KeyPair failed %v", err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}},
+	}}
+
+	resp, err := client.Get("https://" + address + "/identity")
+	if err != nil {
+		t.Fatalf("Request failed %v", err)
+	}
+	defer resp.Body.Close()
+
+	var peer PeerIdentity
+	if err = decodeTestResponse(resp, &peer); err != nil || peer.CommonName != "client" {
+		t.Fatalf("Unexpected identity %+v err %v", peer, err)
+	}
+
+	// Clients without a certificate are rejected.
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	if resp, err = client.Get("https://" + address + "/identity"); err == nil {
+		resp.Body.Close()
+		t.Fatalf("Request without a client certificate succeeded")
+	}
+}
+
+// TestListenerPeerCredentials tests if unix socket callers are identified and authorized.
+func TestListenerPeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Peer credentials are only supported on Linux")
+	}
+
+	dir, err := ioutil.TempDir("", "listener")
+	if err != nil {
+		t.Fatalf("TempDir failed %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "test.sock")
+	authorizer := func(r *http.Request, peer PeerIdentity) error {
+		if r.URL.Path == "/identity" && peer.HasCredentials && peer.UID == uint32(os.Getuid()) {
+			return nil
+		}
+
+		return fmt.Errorf("denied")
+	}
+
+	listener := startTestListener(t, &url.URL{Scheme: "unix", Path: socketPath}, nil, authorizer)
+	defer listener.Stop()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial("unix", socketPath)
+		},
+	}}
+
+	resp, err := client.Get("http://cns/identity")
+	if err != nil {
+		t.Fatalf("Request failed %v", err)
+	}
+	defer resp.Body.Close()
+
+	var peer PeerIdentity
+	if err = decodeTestResponse(resp, &peer); err != nil || !peer.HasCredentials ||
+		peer.UID != uint32(os.Getuid()) || peer.GID != uint32(os.Getgid()) || peer.PID != int32(os.Getpid()) {
+		t.Fatalf("Unexpected identity %+v err %v", peer, err)
+	}
+
+	resp, err = client.Get("http://cns/denied")
+	if err != nil {
+		t.Fatalf("Request failed %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Unexpected status code %d", resp.StatusCode)
+	}
+}
+
+// Decodes a JSON response.
+func decodeTestResponse(resp *http.Response, v interface{}) error {
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// TestListenerShutdown tests if requests in flight complete while new connections are refused.
+func TestListenerShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed %v", err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	listener := startTestListener(t, &url.URL{Scheme: "tcp", Host: address}, nil, nil)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	listener.AddHandler("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	result := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + address + "/slow")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("unexpected status code %d", resp.StatusCode)
+			}
+		}
+		result <- err
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- listener.Shutdown(context.Background())
+	}()
+
+	// Wait until the listener stops accepting connections.
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			break
+		}
+		conn.Close()
+
+		if i == 100 {
+			t.Fatalf("Listener still accepts connections")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case err = <-shutdown:
+		t.Fatalf("Shutdown returned before the request completed, err %v", err)
+	default:
+	}
+
+	close(release)
+	if err = <-result; err != nil {
+		t.Fatalf("Request in flight failed %v", err)
+	}
+
+	if err = <-shutdown; err != nil {
+		t.Fatalf("Shutdown failed %v", err)
+	}
+}
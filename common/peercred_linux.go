@@ -0,0 +1,33 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package common
+
+import (
+	"net"
+	"syscall"
+)
+
+// Returns the credentials of the peer process of a unix socket connection.
+func getPeerCredentials(conn *net.UnixConn) (uint32, uint32, int32, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var ucred *syscall.Ucred
+	var credErr error
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if credErr != nil {
+		return 0, 0, 0, credErr
+	}
+
+	return ucred.Uid, ucred.Gid, ucred.Pid, nil
+}
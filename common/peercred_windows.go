@@ -0,0 +1,15 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package common
+
+import (
+	"fmt"
+	"net"
+)
+
+// Returns the credentials of the peer process of a unix socket connection.
+// Peer credentials are not supported on Windows.
+func getPeerCredentials(conn *net.UnixConn) (uint32, uint32, int32, error) {
+	return 0, 0, 0, fmt.Errorf("Peer credentials are not supported on Windows")
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// AllocationStrategy selects the address to hand out from the available addresses of a pool.
+type allocationStrategy interface {
+	selectAddress(ap *addressPool, now time.Time) *addressRecord
+}
+
+// Creates the allocation strategy configured in the given options.
+func newAllocationStrategy(options map[string]interface{}) (allocationStrategy, error) {
+	name, _ := options[common.OptIpamAllocationStrategy].(string)
+	i, _ := options[common.OptIpamQuarantinePeriod].(int)
+
+	switch name {
+	case common.OptIpamAllocationLRR, "":
+		return &lrrStrategy{quarantinePeriod: time.Duration(i) * time.Second}, nil
+
+	case common.OptIpamAllocationSequential:
+		return &sequentialStrategy{}, nil
+
+	default:
+		return nil, errInvalidConfiguration
+	}
+}
+
+// Returns whether an address is allocated to a container.
+func (ar *addressRecord) isAllocated() bool {
+	return ar.InUse || ar.ID != ""
+}
+
+// Returns whether an address can be handed out by any strategy.
+// Reserved addresses are only handed out to their owners.
+func (ar *addressRecord) isAvailable() bool {
+	return !ar.isAllocated() && !ar.hasConflict() && ar.ReservedFor == ""
+}
+
+// Returns whether the first address sorts before the second.
+func addressLess(ar1, ar2 *addressRecord) bool {
+	return bytes.Compare(ar1.Addr.To16(), ar2.Addr.To16()) < 0
+}
+
+// LrrStrategy hands out the least recently released address, so that a released address is reused as late
+// as possible. Addresses released within the quarantine period are not handed out.
+type lrrStrategy struct {
+	quarantinePeriod time.Duration
+}
+
+func (s *lrrStrategy) selectAddress(ap *addressPool, now time.Time) *addressRecord {
+	var selected *addressRecord
+
+	for _, ar := range ap.Addresses {
+		if !ar.isAvailable() {
+			continue
+		}
+
+		if selected == nil ||
+			ar.ReleasedAt.Before(selected.ReleasedAt) ||
+			(ar.ReleasedAt.Equal(selected.ReleasedAt) && addressLess(ar, selected)) {
+			selected = ar
+		}
+	}
+
+	// The least recently released address is the first to leave quarantine.
+	if selected != nil && now.Sub(selected.ReleasedAt) < s.quarantinePeriod {
+		log.Printf("[ipam] All available addresses in pool %v are quarantined until %v.",
+			ap.Id, selected.ReleasedAt.Add(s.quarantinePeriod))
+		return nil
+	}
+
+	return selected
+}
+
+// SequentialStrategy hands out the lowest available address.
+type sequentialStrategy struct{}
+
+func (s *sequentialStrategy) selectAddress(ap *addressPool, now time.Time) *addressRecord {
+	var selected *addressRecord
+
+	for _, ar := range ap.Addresses {
+		if ar.isAvailable() && (selected == nil || addressLess(ar, selected)) {
+			selected = ar
+		}
+	}
+
+	return selected
+}
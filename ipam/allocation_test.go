@@ -0,0 +1,116 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/azure-container-networking/common"
+)
+
+var (
+	_ = Describe("Test allocation strategies", func() {
+
+		var ap *addressPool
+
+		BeforeEach(func() {
+			as := &addressSpace{
+				Id:    LocalDefaultAddressSpaceId,
+				Scope: LocalScope,
+				Pools: make(map[string]*addressPool),
+			}
+			ap, _ = as.newAddressPool(anyInterface, anyPriority, &subnet3)
+			ap.newAddressRecord(&addr33)
+			ap.newAddressRecord(&addr31)
+			ap.newAddressRecord(&addr32)
+		})
+
+		Describe("Test newAllocationStrategy", func() {
+			Context("When no strategy is configured", func() {
+				It("Should default to least recently released", func() {
+					options := map[string]interface{}{common.OptIpamQuarantinePeriod: 30}
+					strategy, err := newAllocationStrategy(options)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(strategy).To(Equal(&lrrStrategy{quarantinePeriod: 30 * time.Second}))
+				})
+			})
+
+			Context("When the strategy is invalid", func() {
+				It("Should return an error", func() {
+					options := map[string]interface{}{common.OptIpamAllocationStrategy: "random"}
+					_, err := newAllocationStrategy(options)
+					Expect(err).To(Equal(errInvalidConfiguration))
+				})
+			})
+		})
+
+		Describe("Test sequential strategy", func() {
+			It("Should hand out the lowest available address", func() {
+				strategy := &sequentialStrategy{}
+
+				addr, err := ap.requestAddress("", nil, strategy, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addr).To(Equal("10.0.3.1/24"))
+
+				addr, err = ap.requestAddress("", nil, strategy, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addr).To(Equal("10.0.3.2/24"))
+
+				// A released address is reused first.
+				Expect(ap.releaseAddress(addr31.String(), nil)).To(Succeed())
+				addr, err = ap.requestAddress("", nil, strategy, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addr).To(Equal("10.0.3.1/24"))
+			})
+		})
+
+		Describe("Test least recently released strategy", func() {
+			It("Should reuse released addresses last", func() {
+				strategy := &lrrStrategy{}
+
+				addr, err := ap.requestAddress("", nil, strategy, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addr).To(Equal("10.0.3.1/24"))
+				Expect(ap.releaseAddress(addr31.String(), nil)).To(Succeed())
+
+				// Addresses that were never released come first.
+				addr, err = ap.requestAddress("", nil, strategy, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addr).To(Equal("10.0.3.2/24"))
+
+				ap.Addresses[addr33.String()].ReleasedAt = ap.Addresses[addr31.String()].ReleasedAt.Add(time.Second)
+				addr, err = ap.requestAddress("", nil, strategy, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addr).To(Equal("10.0.3.1/24"))
+			})
+
+			It("Should not hand out quarantined addresses", func() {
+				strategy := &lrrStrategy{quarantinePeriod: time.Minute}
+
+				for _, ar := range ap.Addresses {
+					ar.ReleasedAt = time.Now().Add(-2 * time.Minute)
+				}
+				ap.Addresses[addr31.String()].ReleasedAt = time.Now().Add(-3 * time.Minute)
+				ap.Addresses[addr32.String()].InUse = true
+				ap.Addresses[addr33.String()].InUse = true
+
+				addr, err := ap.requestAddress("", nil, strategy, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(addr).To(Equal("10.0.3.1/24"))
+				Expect(ap.releaseAddress(addr31.String(), nil)).To(Succeed())
+
+				_, err = ap.requestAddress("", nil, strategy, nil)
+				Expect(err).To(Equal(errNoAvailableAddresses))
+
+				// The release time is kept with the address record.
+				ip := net.ParseIP("10.0.3.1")
+				Expect(ap.Addresses[ip.String()].ReleasedAt).To(BeTemporally("~", time.Now(), time.Second))
+			})
+		})
+	})
+)
@@ -24,10 +24,21 @@ var (
 	errAddressInUse            = fmt.Errorf("Address already in use")
 	errAddressNotInUse         = fmt.Errorf("Address not in use")
 	errNoAvailableAddresses    = fmt.Errorf("No available addresses")
+	errAddressConflict         = fmt.Errorf("Address is in use on the network")
+	errAddressReserved         = fmt.Errorf("Address is reserved")
 
 	// Options used by AddressManager.
 	OptInterfaceName      = "azure.interface.name"
 	OptAddressID          = "azure.address.id"
 	OptAddressType        = "azure.address.type"
 	OptAddressTypeGateway = "gateway"
+	// Requests an IPv6 pool on the same interface along with an IPv4 pool.
+	OptDualStack = "azure.pool.dualstack"
+
+	// Options recorded with the lease of an address.
+	OptLeaseContainerID  = "azure.lease.containerid"
+	OptLeaseNetNs        = "azure.lease.netns"
+	OptLeaseIfName       = "azure.lease.ifname"
+	OptLeasePodName      = "azure.lease.podname"
+	OptLeasePodNamespace = "azure.lease.podnamespace"
 )
@@ -33,6 +33,7 @@ type azureSource struct {
 	queryUrl      string
 	queryInterval time.Duration
 	lastRefresh   time.Time
+	lastQuery     conditionalQuery
 }
 
 // Creates the Azure source.
@@ -94,9 +95,17 @@ func (s *azureSource) refresh() error {
 		return fmt.Errorf("Error intializing http client")
 	}
 
+	httpClient.Timeout = sourceQueryTimeout
+
+	req, err := http.NewRequest(http.MethodGet, s.queryUrl, nil)
+	if err != nil {
+		return err
+	}
+	s.lastQuery.prepare(req)
+
 	log.Printf("[ipam] Wireserver call %v to retrieve IP List", s.queryUrl)
 	// Fetch configuration.
-	resp, err := httpClient.Get(s.queryUrl)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		log.Printf("[ipam] wireserver call failed with: %v", err)
 		return err
@@ -104,6 +113,12 @@ func (s *azureSource) refresh() error {
 
 	defer resp.Body.Close()
 
+	// Skip processing if the configuration has not changed since the last query.
+	if resp.StatusCode == http.StatusNotModified {
+		log.Printf("[ipam] IP List not modified.")
+		return nil
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		log.Errorf("[ipam] http return error code for wireserver call %+v", resp)
 		return fmt.Errorf("wireserver http error %+v", resp)
@@ -177,5 +192,11 @@ func (s *azureSource) refresh() error {
 	}
 
 	// Set the local address space as active.
-	return s.sink.setAddressSpace(local)
+	if err = s.sink.setAddressSpace(local); err != nil {
+		return err
+	}
+
+	s.lastQuery.update(resp)
+
+	return nil
 }
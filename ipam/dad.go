@@ -0,0 +1,277 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Number of probes sent for an address and the time to wait for a conflicting host after each probe.
+	dadProbeCount    = 2
+	dadProbeInterval = 250 * time.Millisecond
+
+	// Maximum number of addresses probed for a single request.
+	dadMaxAttempts = 3
+
+	// Time after which a conflicting address is probed again.
+	dadConflictHoldPeriod = 5 * time.Minute
+
+	// Time after which a tentative address whose probe did not complete is deallocated.
+	dadTentativeTimeout = time.Minute
+
+	// Reason reported for in-use addresses that the address source no longer lists.
+	unhealthyReasonNotListed = "Address is no longer listed by the address source"
+
+	// Ethernet, ARP and ICMPv6 protocol constants.
+	ethHeaderLen  = 14
+	ethTypeARP    = 0x0806
+	ethTypeIPv6   = 0x86dd
+	arpPacketLen  = 28
+	arpOpRequest  = 1
+	arpOpReply    = 2
+	ipv6HeaderLen = 40
+	ipProtoICMPv6 = 58
+	icmpv6NS      = 135
+	icmpv6NA      = 136
+	icmpv6NDLen   = 24
+	ndHopLimit    = 255
+)
+
+// AddressProber detects whether an address is already in use on the network.
+// It returns the hardware address of the conflicting host, or nil if the address is free.
+type addressProber interface {
+	probe(ifName string, addr net.IP) (net.HardwareAddr, error)
+}
+
+// Creates the address prober configured in the given options. It returns nil if DAD is disabled.
+func newAddressProber(options map[string]interface{}) addressProber {
+	if enabled, _ := options[common.OptIpamDAD].(bool); !enabled {
+		return nil
+	}
+
+	return newDADProber()
+}
+
+// Returns whether the address has been found in use on the network.
+func (ar *addressRecord) hasConflict() bool {
+	return ar.UnhealthyReason != ""
+}
+
+// Returns whether the address is unhealthy, and why.
+func (ar *addressRecord) isUnhealthy() (bool, string) {
+	if ar.hasConflict() {
+		return true, ar.UnhealthyReason
+	}
+
+	if ar.unhealthy {
+		return true, unhealthyReasonNotListed
+	}
+
+	return false, ""
+}
+
+// Clears conflicts older than the hold period so that the addresses are probed again when selected.
+func (ap *addressPool) expireConflicts(now time.Time) {
+	for _, ar := range ap.Addresses {
+		if ar.hasConflict() && now.Sub(ar.UnhealthySince) >= dadConflictHoldPeriod {
+			log.Printf("[ipam] Conflict on address %v expired.", ar.Addr)
+			ar.UnhealthyReason = ""
+			ar.UnhealthySince = time.Time{}
+		}
+	}
+}
+
+// Deallocates tentative addresses whose probe did not complete, e.g. because the process probing them exited.
+func (ap *addressPool) expireTentativeAddresses(now time.Time) {
+	for _, ar := range ap.Addresses {
+		if ar.Tentative && (ar.Lease == nil || now.Sub(ar.Lease.AllocatedAt) >= dadTentativeTimeout) {
+			log.Printf("[ipam] Tentative address %v expired.", ar.Addr)
+			ap.deallocateAddress(ar)
+		}
+	}
+}
+
+// Commits a tentative address once it is probed. A conflicting address is deallocated and marked unhealthy,
+// in which case nil is returned. Probe failures are logged and the address is treated as free, since DAD is
+// best effort.
+func (ap *addressPool) commitAddress(addr net.IP, mac net.HardwareAddr, probeErr error) (*addressRecord, error) {
+	ar := ap.Addresses[addr.String()]
+	if ar == nil || !ar.Tentative {
+		// The address was removed from the pool, or expired, while it was probed.
+		log.Printf("[ipam] Tentative address %v in pool %v is no longer allocated.", addr, ap.Id)
+		return nil, errAddressNotFound
+	}
+
+	ar.Tentative = false
+
+	if probeErr != nil {
+		log.Printf("[ipam] Failed to probe address %v on interface %v, err:%v.", addr, ap.IfName, probeErr)
+		return ar, nil
+	}
+
+	if mac == nil {
+		ar.UnhealthyReason = ""
+		ar.UnhealthySince = time.Time{}
+		return ar, nil
+	}
+
+	ap.deallocateAddress(ar)
+	ar.UnhealthyReason = fmt.Sprintf("Duplicate address detected, in use by %v", mac)
+	ar.UnhealthySince = time.Now()
+
+	log.Printf("[ipam] Address %v in pool %v is unhealthy: %v.", ar.Addr, ap.Id, ar.UnhealthyReason)
+
+	return nil, nil
+}
+
+// Probe packets
+//
+
+// Builds an ARP probe frame for the target address as described in RFC 5227.
+func buildARPProbe(srcMAC net.HardwareAddr, target net.IP) []byte {
+	frame := make([]byte, ethHeaderLen+arpPacketLen)
+
+	// Ethernet header to the broadcast address.
+	copy(frame[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(frame[6:12], srcMAC)
+	binary.BigEndian.PutUint16(frame[12:14], ethTypeARP)
+
+	// ARP request with an unspecified sender IP address.
+	arp := frame[ethHeaderLen:]
+	binary.BigEndian.PutUint16(arp[0:2], 1)
+	binary.BigEndian.PutUint16(arp[2:4], 0x0800)
+	arp[4] = 6
+	arp[5] = 4
+	binary.BigEndian.PutUint16(arp[6:8], arpOpRequest)
+	copy(arp[8:14], srcMAC)
+	copy(arp[24:28], target.To4())
+
+	return frame
+}
+
+// Parses an ARP frame and returns the hardware address of its sender if it claims the target address.
+// Replies and announcements from the target address, and probes for it from other hosts, are conflicts.
+func parseARPConflict(frame []byte, srcMAC net.HardwareAddr, target net.IP) net.HardwareAddr {
+	if len(frame) < ethHeaderLen+arpPacketLen || binary.BigEndian.Uint16(frame[12:14]) != ethTypeARP {
+		return nil
+	}
+
+	arp := frame[ethHeaderLen:]
+	op := binary.BigEndian.Uint16(arp[6:8])
+	sha := net.HardwareAddr(arp[8:14])
+	spa := net.IP(arp[14:18])
+	tpa := net.IP(arp[24:28])
+
+	if bytes.Equal(sha, srcMAC) {
+		return nil
+	}
+
+	if (op == arpOpReply || op == arpOpRequest) && spa.Equal(target) {
+		return append(net.HardwareAddr(nil), sha...)
+	}
+
+	if op == arpOpRequest && spa.Equal(net.IPv4zero) && tpa.Equal(target) {
+		return append(net.HardwareAddr(nil), sha...)
+	}
+
+	return nil
+}
+
+// Returns the solicited-node multicast address of an IPv6 address.
+func solicitedNodeAddress(addr net.IP) net.IP {
+	snma := net.ParseIP("ff02::1:ff00:0")
+	copy(snma[13:16], addr.To16()[13:16])
+	return snma
+}
+
+// Builds a neighbor solicitation frame for the target address as described in RFC 4862.
+func buildNSProbe(srcMAC net.HardwareAddr, target net.IP) []byte {
+	frame := make([]byte, ethHeaderLen+ipv6HeaderLen+icmpv6NDLen)
+	dst := solicitedNodeAddress(target)
+
+	// Ethernet header to the multicast address of the solicited-node address.
+	copy(frame[0:6], []byte{0x33, 0x33, dst[12], dst[13], dst[14], dst[15]})
+	copy(frame[6:12], srcMAC)
+	binary.BigEndian.PutUint16(frame[12:14], ethTypeIPv6)
+
+	// IPv6 header with an unspecified source address.
+	ip := frame[ethHeaderLen:]
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], icmpv6NDLen)
+	ip[6] = ipProtoICMPv6
+	ip[7] = ndHopLimit
+	copy(ip[24:40], dst)
+
+	// Neighbor solicitation without a source link-layer address option.
+	icmp := ip[ipv6HeaderLen:]
+	icmp[0] = icmpv6NS
+	copy(icmp[8:24], target.To16())
+	binary.BigEndian.PutUint16(icmp[2:4], icmpv6Checksum(net.IPv6unspecified, dst, icmp))
+
+	return frame
+}
+
+// Computes the ICMPv6 checksum of a message over the IPv6 pseudo-header.
+func icmpv6Checksum(src, dst net.IP, msg []byte) uint16 {
+	var sum uint32
+
+	pseudo := make([]byte, 40)
+	copy(pseudo[0:16], src.To16())
+	copy(pseudo[16:32], dst.To16())
+	binary.BigEndian.PutUint32(pseudo[32:36], uint32(len(msg)))
+	pseudo[39] = ipProtoICMPv6
+
+	for _, b := range [][]byte{pseudo, msg} {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+
+	return ^uint16(sum)
+}
+
+// Parses an IPv6 frame and returns the hardware address of its sender if it claims the target address.
+// Neighbor advertisements for the target address, and solicitations for it from other hosts performing DAD, are conflicts.
+func parseNDConflict(frame []byte, srcMAC net.HardwareAddr, target net.IP) net.HardwareAddr {
+	if len(frame) < ethHeaderLen+ipv6HeaderLen+icmpv6NDLen || binary.BigEndian.Uint16(frame[12:14]) != ethTypeIPv6 {
+		return nil
+	}
+
+	sha := net.HardwareAddr(frame[6:12])
+	if bytes.Equal(sha, srcMAC) {
+		return nil
+	}
+
+	ip := frame[ethHeaderLen:]
+	if ip[6] != ipProtoICMPv6 {
+		return nil
+	}
+
+	icmp := ip[ipv6HeaderLen:]
+	if !net.IP(icmp[8:24]).Equal(target) {
+		return nil
+	}
+
+	src := net.IP(ip[8:24])
+	if icmp[0] == icmpv6NA || (icmp[0] == icmpv6NS && src.Equal(net.IPv6unspecified)) {
+		return append(net.HardwareAddr(nil), sha...)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// DadProber probes addresses with ARP on IPv4 and neighbor solicitations on IPv6 over a packet socket.
+type dadProber struct{}
+
+// Creates the Linux address prober.
+func newDADProber() addressProber {
+	return &dadProber{}
+}
+
+// Returns the network byte order of a 16-bit value.
+func htons(v uint16) uint16 {
+	return (v << 8) | (v >> 8)
+}
+
+// Probes an address on the given interface.
+func (p *dadProber) probe(ifName string, addr net.IP) (net.HardwareAddr, error) {
+	iface, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(iface.HardwareAddr) != 6 {
+		return nil, fmt.Errorf("Interface %v has no Ethernet address", ifName)
+	}
+
+	var ethType uint16
+	var frame []byte
+	var parse func([]byte, net.HardwareAddr, net.IP) net.HardwareAddr
+
+	if addr.To4() != nil {
+		ethType = ethTypeARP
+		frame = buildARPProbe(iface.HardwareAddr, addr)
+		parse = parseARPConflict
+	} else {
+		ethType = ethTypeIPv6
+		frame = buildNSProbe(iface.HardwareAddr, addr)
+		parse = parseNDConflict
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(ethType)))
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	sa := &unix.SockaddrLinklayer{
+		Protocol: htons(ethType),
+		Ifindex:  iface.Index,
+		Halen:    6,
+	}
+	copy(sa.Addr[:], frame[0:6])
+
+	if err = unix.Bind(fd, sa); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+
+	for i := 0; i < dadProbeCount; i++ {
+		if err = unix.Sendto(fd, frame, 0, sa); err != nil {
+			return nil, err
+		}
+
+		// Wait for a conflicting host until the probe interval elapses.
+		deadline := time.Now().Add(dadProbeInterval)
+		for {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				break
+			}
+
+			tv := unix.NsecToTimeval(remaining.Nanoseconds())
+			if err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+				return nil, err
+			}
+
+			n, from, err := unix.Recvfrom(fd, buf, 0)
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			// Skip the probes sent by this host.
+			if ll, ok := from.(*unix.SockaddrLinklayer); ok && ll.Pkttype == unix.PACKET_OUTGOING {
+				continue
+			}
+
+			if mac := parse(buf[:n], iface.HardwareAddr, addr); mac != nil {
+				return mac, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
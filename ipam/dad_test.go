@@ -0,0 +1,203 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeProber reports conflicts for a fixed set of addresses.
+type fakeProber struct {
+	conflicts map[string]net.HardwareAddr
+	probes    []string
+	onProbe   func(addr net.IP)
+}
+
+func (p *fakeProber) probe(ifName string, addr net.IP) (net.HardwareAddr, error) {
+	p.probes = append(p.probes, addr.String())
+	if p.onProbe != nil {
+		p.onProbe(addr)
+	}
+	return p.conflicts[addr.String()], nil
+}
+
+var (
+	_ = Describe("Test duplicate address detection", func() {
+
+		var (
+			ap       *addressPool
+			prober   *fakeProber
+			strategy = &sequentialStrategy{}
+			mac      = net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc}
+			ownMAC   = net.HardwareAddr{0x00, 0x0d, 0x3a, 0x00, 0x00, 0x01}
+		)
+
+		BeforeEach(func() {
+			as := &addressSpace{
+				Id:    LocalDefaultAddressSpaceId,
+				Scope: LocalScope,
+				Pools: make(map[string]*addressPool),
+			}
+			ap, _ = as.newAddressPool("eth0", anyPriority, &subnet3)
+			ap.newAddressRecord(&addr31)
+			ap.newAddressRecord(&addr32)
+			ap.newAddressRecord(&addr33)
+
+			prober = &fakeProber{conflicts: map[string]net.HardwareAddr{addr31.String(): mac}}
+		})
+
+		Describe("Test requestAddress", func() {
+			Context("When the selected address is in use on the network", func() {
+				It("Should mark it unhealthy and hand out the next address", func() {
+					addr, err := ap.requestAddress("", nil, strategy, prober)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(addr).To(Equal("10.0.3.2/24"))
+					Expect(prober.probes).To(Equal([]string{"10.0.3.1", "10.0.3.2"}))
+
+					info := ap.getInfo()
+					Expect(info.UnhealthyAddrs).To(HaveLen(1))
+					Expect(info.UnhealthyAddrs[0].Equal(addr31)).To(BeTrue())
+					Expect(info.UnhealthyReasons[addr31.String()]).To(ContainSubstring(mac.String()))
+					Expect(info.Available).To(Equal(1))
+				})
+			})
+
+			Context("When the requested address is in use on the network", func() {
+				It("Should fail", func() {
+					_, err := ap.requestAddress(addr31.String(), nil, strategy, prober)
+					Expect(err).To(Equal(errAddressConflict))
+					Expect(ap.Addresses[addr31.String()].InUse).To(BeFalse())
+				})
+			})
+
+			Context("When the address is already allocated to the caller", func() {
+				It("Should not probe it again", func() {
+					options := map[string]string{OptAddressID: "ep1"}
+					_, err := ap.requestAddress(addr32.String(), options, strategy, prober)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = ap.requestAddress("", options, strategy, prober)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(prober.probes).To(Equal([]string{"10.0.3.2"}))
+				})
+			})
+
+			Context("When the conflict hold period has passed", func() {
+				It("Should probe the address again", func() {
+					_, err := ap.requestAddress("", nil, strategy, prober)
+					Expect(err).NotTo(HaveOccurred())
+
+					ap.Addresses[addr31.String()].UnhealthySince = time.Now().Add(-dadConflictHoldPeriod)
+					delete(prober.conflicts, addr31.String())
+
+					addr, err := ap.requestAddress("", nil, strategy, prober)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(addr).To(Equal("10.0.3.1/24"))
+					Expect(ap.getInfo().UnhealthyAddrs).To(BeEmpty())
+				})
+			})
+		})
+
+		Describe("Test RequestAddress", func() {
+			Context("When the selected address is probed", func() {
+				It("Should probe the tentative address without holding the address manager lock", func() {
+					am := &addressManager{
+						AddrSpaces: map[string]*addressSpace{ap.as.Id: ap.as},
+						strategy:   strategy,
+						prober:     prober,
+					}
+
+					var tentative []bool
+					prober.onProbe = func(addr net.IP) {
+						locked := make(chan bool)
+						go func() {
+							am.Lock()
+							defer am.Unlock()
+							ar := ap.Addresses[addr.String()]
+							locked <- ar.Tentative && ar.isAllocated()
+						}()
+
+						var t bool
+						Eventually(locked).Should(Receive(&t))
+						tentative = append(tentative, t)
+					}
+
+					addr, _, err := am.RequestAddress(ap.as.Id, ap.Id, "", nil)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(addr).To(Equal("10.0.3.2/24"))
+					Expect(tentative).To(Equal([]bool{true, true}))
+
+					// The conflicting address is deallocated and the other one is committed.
+					Expect(ap.Addresses[addr31.String()].isAllocated()).To(BeFalse())
+					Expect(ap.Addresses[addr32.String()].Tentative).To(BeFalse())
+					Expect(ap.Addresses[addr32.String()].InUse).To(BeTrue())
+				})
+			})
+
+			Context("When the probe of a tentative address did not complete", func() {
+				It("Should deallocate the address after the timeout", func() {
+					ar := ap.Addresses[addr31.String()]
+					ar.InUse = true
+					ar.Tentative = true
+					ar.Lease = &AddressLease{AllocatedAt: time.Now().Add(-dadTentativeTimeout)}
+					delete(prober.conflicts, addr31.String())
+
+					addr, err := ap.requestAddress("", nil, strategy, prober)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(addr).To(Equal("10.0.3.1/24"))
+					Expect(ar.Tentative).To(BeFalse())
+				})
+			})
+		})
+
+		Describe("Test ARP probes", func() {
+			It("Should detect replies from the target address", func() {
+				probe := buildARPProbe(ownMAC, addr31)
+				Expect(probe).To(HaveLen(ethHeaderLen + arpPacketLen))
+				Expect(net.IP(probe[ethHeaderLen+14 : ethHeaderLen+18]).Equal(net.IPv4zero)).To(BeTrue())
+
+				// Our own probe is not a conflict.
+				Expect(parseARPConflict(probe, ownMAC, addr31)).To(BeNil())
+
+				// A probe for the same address from another host is a conflict.
+				other := buildARPProbe(mac, addr31)
+				Expect(parseARPConflict(other, ownMAC, addr31)).To(Equal(mac))
+
+				// A reply from the target address is a conflict.
+				reply := buildARPProbe(mac, addr32)
+				binary.BigEndian.PutUint16(reply[ethHeaderLen+6:], arpOpReply)
+				copy(reply[ethHeaderLen+14:], addr31.To4())
+				Expect(parseARPConflict(reply, ownMAC, addr31)).To(Equal(mac))
+				Expect(parseARPConflict(reply, ownMAC, addr33)).To(BeNil())
+			})
+		})
+
+		Describe("Test neighbor solicitation probes", func() {
+			It("Should detect advertisements for the target address", func() {
+				target := net.ParseIP("fd00::1:5")
+				probe := buildNSProbe(ownMAC, target)
+				Expect(probe[0:6]).To(Equal([]byte{0x33, 0x33, 0xff, 0x01, 0x00, 0x05}))
+
+				// The checksum of a valid message verifies to zero.
+				dst := net.IP(probe[ethHeaderLen+24 : ethHeaderLen+40])
+				Expect(dst.Equal(net.ParseIP("ff02::1:ff01:5"))).To(BeTrue())
+				Expect(icmpv6Checksum(net.IPv6unspecified, dst, probe[ethHeaderLen+ipv6HeaderLen:])).To(BeZero())
+
+				Expect(parseNDConflict(probe, ownMAC, target)).To(BeNil())
+				Expect(parseNDConflict(buildNSProbe(mac, target), ownMAC, target)).To(Equal(mac))
+
+				advert := buildNSProbe(mac, target)
+				advert[ethHeaderLen+ipv6HeaderLen] = icmpv6NA
+				copy(advert[ethHeaderLen+8:], target.To16())
+				Expect(parseNDConflict(advert, ownMAC, target)).To(Equal(mac))
+				Expect(parseNDConflict(advert, ownMAC, net.ParseIP("fd00::1:6"))).To(BeNil())
+			})
+		})
+	})
+)
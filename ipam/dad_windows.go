@@ -0,0 +1,14 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// Duplicate address detection is not supported on Windows, where HNS owns the container interfaces.
+func newDADProber() addressProber {
+	log.Printf("[ipam] Duplicate address detection is not supported on Windows.")
+	return nil
+}
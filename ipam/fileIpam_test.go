@@ -339,6 +339,58 @@ var (
 					Expect(pool.Priority).To(Equal(1))
 				})
 			})
+
+			Context("Dual-stack interface", func() {
+				It("Should create an IPv6 pool on the same interface", func() {
+					hardwareAddress0, _ := net.ParseMAC("00:00:00:00:00:00")
+					localInterfaces := []net.Interface{
+						{HardwareAddr: hardwareAddress0, Name: "eth0"},
+					}
+
+					local := &addressSpace{
+						Id:    LocalDefaultAddressSpaceId,
+						Scope: LocalScope,
+						Pools: make(map[string]*addressPool),
+					}
+
+					sdnInterfaces := &NetworkInterfaces{
+						Interfaces: []Interface{
+							{
+								MacAddress: "000000000000",
+								IsPrimary:  true,
+								IPSubnets: []IPSubnet{
+									{
+										Prefix: "10.0.0.0/24",
+										IPAddresses: []IPAddress{
+											{Address: "10.0.0.4", IsPrimary: true},
+											{Address: "10.0.0.5", IsPrimary: false},
+										},
+									},
+									{
+										Prefix: "fd00::/64",
+										IPAddresses: []IPAddress{
+											{Address: "fd00::4", IsPrimary: true},
+											{Address: "fd00::5", IsPrimary: false},
+										},
+									},
+								},
+							},
+						},
+					}
+
+					err := populateAddressSpace(local, sdnInterfaces, localInterfaces)
+					Expect(err).ToNot(HaveOccurred())
+
+					pool, ok := local.Pools["fd00::/64"]
+					Expect(ok).To(BeTrue())
+					Expect(pool.IsIPv6).To(BeTrue())
+					Expect(pool.IfName).To(Equal("eth0"))
+					Expect(len(pool.Addresses)).To(Equal(1))
+
+					_, ok = pool.Addresses["fd00::5"]
+					Expect(ok).To(BeTrue())
+				})
+			})
 		})
 
 		Describe("Test macAddressesEqual", func() {
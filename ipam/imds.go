@@ -0,0 +1,255 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Instance metadata service network endpoint to query.
+	imdsQueryUrl = "http://169.254.169.254/metadata/instance/network?api-version=2017-08-01&format=json"
+)
+
+// Microsoft Azure IPAM configuration source based on the instance metadata service.
+type imdsSource struct {
+	name          string
+	sink          addressConfigSink
+	queryUrl      string
+	queryInterval time.Duration
+	lastRefresh   time.Time
+	lastQuery     conditionalQuery
+	interfaces    func() ([]net.Interface, error)
+}
+
+// Instance metadata service network document format.
+type imdsNetwork struct {
+	Interface []imdsInterface `json:"interface"`
+}
+
+// Network interface of the instance.
+// The first address of each family is the primary address of the interface.
+type imdsInterface struct {
+	MacAddress string     `json:"macAddress"`
+	IPv4       imdsIPInfo `json:"ipv4"`
+	IPv6       imdsIPInfo `json:"ipv6"`
+}
+
+// Addresses and subnets of an address family on an interface.
+type imdsIPInfo struct {
+	IPAddress []struct {
+		PrivateIPAddress string `json:"privateIpAddress"`
+	} `json:"ipAddress"`
+	Subnet []struct {
+		Address string `json:"address"`
+		Prefix  string `json:"prefix"`
+	} `json:"subnet"`
+}
+
+// Creates the instance metadata service source.
+func newIMDSSource(options map[string]interface{}) (*imdsSource, error) {
+	queryUrl, _ := options[common.OptIpamQueryUrl].(string)
+	if queryUrl == "" {
+		queryUrl = imdsQueryUrl
+	}
+
+	i, _ := options[common.OptIpamQueryInterval].(int)
+	queryInterval := time.Duration(i) * time.Second
+	if queryInterval == 0 {
+		queryInterval = azureQueryInterval
+	}
+
+	return &imdsSource{
+		name:          "IMDS",
+		queryUrl:      queryUrl,
+		queryInterval: queryInterval,
+		interfaces:    net.Interfaces,
+	}, nil
+}
+
+// Starts the instance metadata service source.
+func (s *imdsSource) start(sink addressConfigSink) error {
+	s.sink = sink
+	return nil
+}
+
+// Stops the instance metadata service source.
+func (s *imdsSource) stop() {
+	s.sink = nil
+}
+
+// Refreshes configuration.
+func (s *imdsSource) refresh() error {
+	// Refresh only if enough time has passed since the last query.
+	if time.Since(s.lastRefresh) < s.queryInterval {
+		return nil
+	}
+	s.lastRefresh = time.Now()
+
+	// Query the list of local interfaces.
+	interfaces, err := s.interfaces()
+	if err != nil {
+		return err
+	}
+
+	doc, resp, err := s.query()
+	if err != nil || doc == nil {
+		return err
+	}
+
+	// Configure the local default address space.
+	local, err := s.sink.newAddressSpace(LocalDefaultAddressSpaceId, LocalScope)
+	if err != nil {
+		return err
+	}
+
+	populateIMDSAddressSpace(local, doc, interfaces)
+
+	// Set the local address space as active.
+	if err = s.sink.setAddressSpace(local); err != nil {
+		return err
+	}
+
+	s.lastQuery.update(resp)
+
+	return nil
+}
+
+// Queries the network document from the instance metadata service.
+// It returns a nil document if the document has not changed since the last query.
+func (s *imdsSource) query() (*imdsNetwork, *http.Response, error) {
+	httpClient := common.InitHttpClient(httpConnectionTimeout, responseHeaderTimeout)
+	if httpClient == nil {
+		log.Errorf("[ipam] Failed intializing http client")
+		return nil, nil, fmt.Errorf("Error intializing http client")
+	}
+	httpClient.Timeout = sourceQueryTimeout
+
+	req, err := http.NewRequest(http.MethodGet, s.queryUrl, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Metadata", "true")
+	s.lastQuery.prepare(req)
+
+	log.Printf("[ipam] IMDS call %v to retrieve IP List", s.queryUrl)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("[ipam] IMDS call failed with: %v", err)
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		log.Printf("[ipam] IP List not modified.")
+		return nil, resp, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("[ipam] http return error code for IMDS call %+v", resp)
+		return nil, nil, fmt.Errorf("IMDS http error %+v", resp)
+	}
+
+	doc := &imdsNetwork{}
+	if err = json.NewDecoder(resp.Body).Decode(doc); err != nil {
+		return nil, nil, err
+	}
+
+	return doc, resp, nil
+}
+
+// Populates an address space from the interfaces in an instance metadata service network document.
+func populateIMDSAddressSpace(local *addressSpace, doc *imdsNetwork, interfaces []net.Interface) {
+	// For each interface...
+	for index, i := range doc.Interface {
+		ifName := ""
+		macAddress := strings.ToLower(strings.Replace(i.MacAddress, "-", "", -1))
+
+		// Find the interface with the matching MacAddress.
+		for _, iface := range interfaces {
+			macAddr := strings.ToLower(strings.Replace(iface.HardwareAddr.String(), ":", "", -1))
+			if macAddr == macAddress {
+				ifName = iface.Name
+				break
+			}
+		}
+
+		// Skip if interface is not found.
+		if ifName == "" {
+			log.Printf("[ipam] Failed to find interface with MAC address:%v.", i.MacAddress)
+			continue
+		}
+
+		// Prioritize secondary interfaces. The primary interface is listed first.
+		priority := 0
+		if index > 0 {
+			priority = 1
+		}
+
+		populateIMDSAddressPools(local, ifName, priority, &i.IPv4)
+		populateIMDSAddressPools(local, ifName, priority, &i.IPv6)
+	}
+}
+
+// Populates the address pools of an address family on an interface.
+func populateIMDSAddressPools(local *addressSpace, ifName string, priority int, info *imdsIPInfo) {
+	var pools []*addressPool
+
+	// For each subnet on the interface...
+	for _, s := range info.Subnet {
+		prefix := s.Address + "/" + s.Prefix
+		_, subnet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			log.Printf("[ipam] Failed to parse subnet:%v err:%v.", prefix, err)
+			continue
+		}
+
+		ap, err := local.newAddressPool(ifName, priority, subnet)
+		if err != nil {
+			log.Printf("[ipam] Failed to create pool:%v ifName:%v err:%v.", subnet, ifName, err)
+			continue
+		}
+
+		pools = append(pools, ap)
+	}
+
+	// For each address on the interface...
+	for index, a := range info.IPAddress {
+		// The primary address is reserved for the host.
+		if index == 0 {
+			continue
+		}
+
+		address := net.ParseIP(a.PrivateIPAddress)
+		if address == nil {
+			log.Printf("[ipam] Failed to parse address:%v.", a.PrivateIPAddress)
+			continue
+		}
+
+		// Add the address to the subnet that contains it.
+		found := false
+		for _, ap := range pools {
+			if ap.Subnet.Contains(address) {
+				if _, err := ap.newAddressRecord(&address); err != nil {
+					log.Printf("[ipam] Failed to create address:%v err:%v.", address, err)
+				}
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			log.Printf("[ipam] Failed to find subnet of address:%v.", address)
+		}
+	}
+}
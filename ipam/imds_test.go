@@ -0,0 +1,169 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/azure-container-networking/common"
+)
+
+var imdsQueryResponse = `{
+	"interface": [
+		{
+			"ipv4": {
+				"ipAddress": [
+					{"privateIpAddress": "10.0.0.4", "publicIpAddress": ""},
+					{"privateIpAddress": "10.0.0.5", "publicIpAddress": ""},
+					{"privateIpAddress": "10.0.0.6", "publicIpAddress": ""}
+				],
+				"subnet": [{"address": "10.0.0.0", "prefix": "16"}]
+			},
+			"ipv6": {"ipAddress": []},
+			"macAddress": "000D3A000001"
+		},
+		{
+			"ipv4": {
+				"ipAddress": [
+					{"privateIpAddress": "10.2.0.4", "publicIpAddress": ""},
+					{"privateIpAddress": "10.2.0.5", "publicIpAddress": ""}
+				],
+				"subnet": [{"address": "10.2.0.0", "prefix": "24"}]
+			},
+			"ipv6": {"ipAddress": []},
+			"macAddress": "000D3A000002"
+		},
+		{
+			"ipv4": {
+				"ipAddress": [{"privateIpAddress": "10.3.0.4", "publicIpAddress": ""}],
+				"subnet": [{"address": "10.3.0.0", "prefix": "24"}]
+			},
+			"ipv6": {"ipAddress": []},
+			"macAddress": "000D3A000003"
+		}
+	]
+}`
+
+var (
+	_ = Describe("Test IMDS source", func() {
+
+		var (
+			server   *httptest.Server
+			response string
+			headers  http.Header
+			am       *addressManager
+			source   *imdsSource
+		)
+
+		BeforeEach(func() {
+			response = imdsQueryResponse
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				headers = r.Header
+				w.Write([]byte(response))
+			}))
+
+			var err error
+			options := map[string]interface{}{common.OptIpamQueryUrl: server.URL}
+			source, err = newIMDSSource(options)
+			Expect(err).NotTo(HaveOccurred())
+
+			source.interfaces = func() ([]net.Interface, error) {
+				return []net.Interface{
+					{Name: "eth0", HardwareAddr: net.HardwareAddr{0x00, 0x0d, 0x3a, 0x00, 0x00, 0x01}},
+					{Name: "eth1", HardwareAddr: net.HardwareAddr{0x00, 0x0d, 0x3a, 0x00, 0x00, 0x02}},
+				}, nil
+			}
+
+			am = &addressManager{AddrSpaces: make(map[string]*addressSpace)}
+			Expect(source.start(am)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		Describe("Test newIMDSSource", func() {
+			It("Should use the default query URL and interval", func() {
+				source, err := newIMDSSource(make(map[string]interface{}))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(source.name).To(Equal("IMDS"))
+				Expect(source.queryUrl).To(Equal(imdsQueryUrl))
+				Expect(source.queryInterval).To(Equal(azureQueryInterval))
+			})
+		})
+
+		Describe("Test refresh", func() {
+			Context("When the interfaces are found locally", func() {
+				It("Should populate their pools without the primary addresses", func() {
+					Expect(source.refresh()).To(Succeed())
+					Expect(headers.Get("Metadata")).To(Equal("true"))
+
+					as := am.AddrSpaces[LocalDefaultAddressSpaceId]
+					Expect(as.Pools).To(HaveLen(2))
+
+					ap := as.Pools["10.0.0.0/16"]
+					Expect(ap.IfName).To(Equal("eth0"))
+					Expect(ap.Priority).To(Equal(0))
+					Expect(ap.Addresses).To(HaveLen(2))
+					Expect(ap.Addresses).To(HaveKey("10.0.0.5"))
+					Expect(ap.Addresses).To(HaveKey("10.0.0.6"))
+
+					ap = as.Pools["10.2.0.0/24"]
+					Expect(ap.IfName).To(Equal("eth1"))
+					Expect(ap.Priority).To(Equal(1))
+					Expect(ap.Addresses).To(HaveLen(1))
+					Expect(ap.Addresses).To(HaveKey("10.2.0.5"))
+				})
+			})
+
+			Context("When an address is removed", func() {
+				It("Should merge the change and keep it while in use", func() {
+					Expect(source.refresh()).To(Succeed())
+
+					ap := am.AddrSpaces[LocalDefaultAddressSpaceId].Pools["10.0.0.0/16"]
+					_, err := ap.requestAddress("10.0.0.6", nil, &sequentialStrategy{}, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					response = `{"interface": [{"ipv4": {"ipAddress": [{"privateIpAddress": "10.0.0.4"}],
+						"subnet": [{"address": "10.0.0.0", "prefix": "16"}]}, "macAddress": "000D3A000001"}]}`
+					source.lastRefresh = time.Time{}
+					Expect(source.refresh()).To(Succeed())
+
+					as := am.AddrSpaces[LocalDefaultAddressSpaceId]
+					Expect(as.Pools).To(HaveLen(1))
+					Expect(ap.Addresses).To(HaveLen(1))
+					Expect(ap.Addresses["10.0.0.6"].unhealthy).To(BeTrue())
+				})
+			})
+
+			Context("When the service returns an error", func() {
+				It("Should fail and keep the current configuration", func() {
+					Expect(source.refresh()).To(Succeed())
+
+					server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						w.WriteHeader(http.StatusInternalServerError)
+					})
+					source.lastRefresh = time.Time{}
+					Expect(source.refresh()).To(HaveOccurred())
+					Expect(am.AddrSpaces[LocalDefaultAddressSpaceId].Pools).To(HaveLen(2))
+				})
+			})
+
+			Context("When the query interval has not passed", func() {
+				It("Should not query the service", func() {
+					Expect(source.refresh()).To(Succeed())
+					headers = nil
+					Expect(source.refresh()).To(Succeed())
+					Expect(headers).To(BeNil())
+				})
+			})
+		})
+	})
+)
@@ -4,6 +4,8 @@
 package ipam
 
 import (
+	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -25,7 +27,13 @@ type addressManager struct {
 	AddrSpaces map[string]*addressSpace `json:"AddressSpaces"`
 	store      store.KeyValueStore
 	source     addressConfigSource
+	strategy   allocationStrategy
+	prober     addressProber
 	netApi     common.NetApi
+	// Closed to stop refreshing the source in the background, and closed by the refresh loop when it exits.
+	refreshStop chan struct{}
+	refreshDone chan struct{}
+	subscribers map[chan *PoolChangeEvent]bool
 	sync.Mutex
 }
 
@@ -36,6 +44,8 @@ type AddressManager interface {
 
 	StartSource(options map[string]interface{}) error
 	StopSource()
+	StartBackgroundRefresh(interval time.Duration)
+	SubscribePoolChanges() (<-chan *PoolChangeEvent, func())
 
 	GetDefaultAddressSpaces() (string, string)
 
@@ -43,8 +53,10 @@ type AddressManager interface {
 	ReleasePool(asId, poolId string) error
 	GetPoolInfo(asId, poolId string) (*AddressPoolInfo, error)
 
-	RequestAddress(asId, poolId, address string, options map[string]string) (string, error)
+	RequestAddress(asId, poolId, address string, options map[string]string) (string, string, error)
 	ReleaseAddress(asId, poolId, address string, options map[string]string) error
+
+	GetPoolStatus() []*PoolStatus
 }
 
 // AddressConfigSource configures the address pools managed by AddressManager.
@@ -64,6 +76,7 @@ type addressConfigSink interface {
 func NewAddressManager() (AddressManager, error) {
 	am := &addressManager{
 		AddrSpaces: make(map[string]*addressSpace),
+		strategy:   &lrrStrategy{},
 	}
 
 	return am, nil
@@ -189,10 +202,21 @@ func (am *addressManager) StartSource(options map[string]interface{}) error {
 		isLoaded = true
 	}
 
+	am.strategy, err = newAllocationStrategy(options)
+	if err != nil {
+		log.Printf("[ipam] Invalid allocation strategy, err:%v.", err)
+		return err
+	}
+
+	am.prober = newAddressProber(options)
+
 	switch environment {
 	case common.OptEnvironmentAzure:
 		am.source, err = newAzureSource(options)
 
+	case common.OptEnvironmentIMDS:
+		am.source, err = newIMDSSource(options)
+
 	case common.OptEnvironmentMAS:
 		am.source, err = newFileIpamSource(options)
 
@@ -202,6 +226,9 @@ func (am *addressManager) StartSource(options map[string]interface{}) error {
 	case common.OptEnvironmentIPv6NodeIpam:
 		am.source, err = newIPv6IpamSource(options, isLoaded)
 
+	case common.OptEnvironmentRangeIpam:
+		am.source, err = newRangeIpamSource(options)
+
 	case "null":
 		am.source, err = newNullSource()
 
@@ -226,14 +253,79 @@ func (am *addressManager) StartSource(options map[string]interface{}) error {
 
 // Stops the configuration source.
 func (am *addressManager) StopSource() {
+	am.Lock()
+	stop, done := am.refreshStop, am.refreshDone
+	if stop != nil {
+		select {
+		case <-stop:
+			// Already stopped by a concurrent call.
+		default:
+			close(stop)
+		}
+	}
+	am.Unlock()
+
+	// Wait for an ongoing background refresh to complete before stopping the source. The refresh loop is
+	// considered running until then, so that requests do not refresh the source concurrently.
+	if done != nil {
+		<-done
+	}
+
+	am.Lock()
+	defer am.Unlock()
+
+	if am.refreshDone == done {
+		am.refreshStop, am.refreshDone = nil, nil
+	}
+
 	if am.source != nil {
 		am.source.stop()
 		am.source = nil
 	}
 }
 
-// Signals configuration source to refresh.
+// StartBackgroundRefresh refreshes the configuration source periodically until the source is stopped,
+// so that requests are served from memory instead of refreshing the source inline.
+// The source is queried without holding the address manager lock, so a slow source does not block requests.
+func (am *addressManager) StartBackgroundRefresh(interval time.Duration) {
+	am.Lock()
+
+	if am.refreshStop != nil {
+		am.Unlock()
+		return
+	}
+
+	log.Printf("[ipam] Refreshing address source in the background every %v.", interval)
+
+	source := am.source
+	if source != nil {
+		source.start(&lockedSink{am: am})
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	am.refreshStop, am.refreshDone = stop, done
+
+	am.Unlock()
+
+	// Populate the pools before serving the first request.
+	failures := 0
+	if refreshInBackground(source) != nil {
+		failures++
+	}
+
+	go runBackgroundRefresh(source, interval, failures, stop, done)
+}
+
+// Signals configuration source to refresh, unless it is refreshed in the background.
 func (am *addressManager) refreshSource() {
+	if am.refreshStop == nil {
+		am.refreshSourceNow()
+	}
+}
+
+// Refreshes the configuration source.
+func (am *addressManager) refreshSourceNow() {
 	if am.source != nil {
 		log.Printf("[ipam] Refreshing address source.")
 		err := am.source.refresh()
@@ -340,33 +432,99 @@ func (am *addressManager) GetPoolInfo(asId string, poolId string) (*AddressPoolI
 }
 
 // RequestAddress reserves a new address from the address pool.
-func (am *addressManager) RequestAddress(asId, poolId, address string, options map[string]string) (string, error) {
+// If the pool was reserved for dual-stack, an IPv6 address is reserved from its paired pool as well.
+func (am *addressManager) RequestAddress(asId, poolId, address string, options map[string]string) (string, string, error) {
 	am.Lock()
 	defer am.Unlock()
 
 	am.refreshSource()
 
-	as, err := am.getAddressSpace(asId)
+	as, ap, err := am.getAddressPool(asId, poolId)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	ap, err := as.getAddressPool(poolId)
+	addr, err := ap.requestProbedAddress(address, options, am.strategy, am.prober, am.unlockForProbe(asId, poolId))
 	if err != nil {
-		return "", err
+		if err == errAddressConflict {
+			// Keep the conflicts found.
+			am.save()
+		}
+		return "", "", err
 	}
 
-	addr, err := ap.requestAddress(address, options)
-	if err != nil {
-		return "", err
+	// The state may have been read again while the address was probed.
+	if as, ap, err = am.getAddressPool(asId, poolId); err != nil {
+		return "", "", err
+	}
+
+	var addrV6 string
+	if ap.PairedPoolId != "" {
+		addrV6, err = as.requestPairedAddress(ap, options, am.strategy, am.prober, am.unlockForProbe(asId, ap.PairedPoolId))
+		if err != nil {
+			if _, ap, getErr := am.getAddressPool(asId, poolId); getErr == nil {
+				ip, _, _ := net.ParseCIDR(addr)
+				ap.releaseAddress(ip.String(), options)
+			}
+			am.save()
+			return "", "", err
+		}
 	}
 
 	err = am.save()
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	return addr, nil
+	return addr, addrV6, nil
+}
+
+// Returns an address pool and its address space.
+func (am *addressManager) getAddressPool(asId, poolId string) (*addressSpace, *addressPool, error) {
+	as, err := am.getAddressSpace(asId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ap, err := as.getAddressPool(poolId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return as, ap, nil
+}
+
+// Returns a function that runs an address probe without holding the address manager lock or the store lock, so
+// that a probe does not block other requests. The tentative address is saved first, so that other requests and
+// processes skip it. The state is read again if the store was unlocked, and the pool is returned from it.
+func (am *addressManager) unlockForProbe(asId, poolId string) func(probe func()) (*addressPool, error) {
+	return func(probe func()) (*addressPool, error) {
+		if err := am.save(); err != nil {
+			return nil, err
+		}
+
+		// The store is unlocked only if this process locked it.
+		storeUnlocked := am.store != nil && am.store.Unlock(false) == nil
+
+		am.Unlock()
+		probe()
+		am.Lock()
+
+		if storeUnlocked {
+			if err := am.store.Lock(true); err != nil {
+				log.Printf("[ipam] Failed to lock store after probing address, err:%v.", err)
+				return nil, err
+			}
+
+			am.AddrSpaces = make(map[string]*addressSpace)
+			if err := am.restore(); err != nil {
+				return nil, err
+			}
+		}
+
+		_, ap, err := am.getAddressPool(asId, poolId)
+		return ap, err
+	}
 }
 
 // ReleaseAddress releases a previously reserved address.
@@ -398,3 +556,27 @@ func (am *addressManager) ReleaseAddress(asId string, poolId string, address str
 
 	return nil
 }
+
+// GetPoolStatus returns the status of all address pools, refreshed from the configuration source.
+func (am *addressManager) GetPoolStatus() []*PoolStatus {
+	am.Lock()
+	defer am.Unlock()
+
+	am.refreshSource()
+
+	var status []*PoolStatus
+	for _, as := range am.AddrSpaces {
+		for _, ap := range as.Pools {
+			status = append(status, ap.getStatus())
+		}
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		if status[i].AddressSpace != status[j].AddressSpace {
+			return status[i].AddressSpace < status[j].AddressSpace
+		}
+		return status[i].Id < status[j].Id
+	})
+
+	return status
+}
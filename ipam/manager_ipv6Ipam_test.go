@@ -73,7 +73,7 @@ func TestIPv6GetAddressPoolAndAddress(t *testing.T) {
 	}
 
 	// test with a specified address
-	address2, err := am.RequestAddress(LocalDefaultAddressSpaceId, poolID1, ipv6addr2, nil)
+	address2, _, err := am.RequestAddress(LocalDefaultAddressSpaceId, poolID1, ipv6addr2, nil)
 	if err != nil {
 		t.Errorf("RequestAddress failed, err:%v", err)
 	}
@@ -83,7 +83,7 @@ func TestIPv6GetAddressPoolAndAddress(t *testing.T) {
 	}
 
 	// test with a specified address
-	address3, err := am.RequestAddress(LocalDefaultAddressSpaceId, poolID1, "", nil)
+	address3, _, err := am.RequestAddress(LocalDefaultAddressSpaceId, poolID1, "", nil)
 	if err != nil {
 		t.Errorf("RequestAddress failed, err:%v", err)
 	}
@@ -60,6 +60,18 @@ func createAddressManager(options map[string]interface{}) (AddressManager, error
 	return am, nil
 }
 
+// countingSource is an address source that counts its refreshes.
+type countingSource struct {
+	refreshes int
+}
+
+func (s *countingSource) start(sink addressConfigSink) error { return nil }
+func (s *countingSource) stop()                              {}
+func (s *countingSource) refresh() error {
+	s.refreshes++
+	return nil
+}
+
 // dumpAddressManager dumps the contents of an address manager.
 func dumpAddressManager(am AddressManager) {
 	amImpl := am.(*addressManager)
@@ -417,5 +429,177 @@ var (
 				})
 			})
 		})
+
+		Describe("Test dual-stack RequestAddress", func() {
+			var (
+				am      *addressManager
+				localAs *addressSpace
+			)
+
+			BeforeEach(func() {
+				am = &addressManager{
+					AddrSpaces: make(map[string]*addressSpace),
+					strategy:   &lrrStrategy{},
+				}
+				localAs, _ = am.newAddressSpace(LocalDefaultAddressSpaceId, LocalScope)
+
+				ap, _ := localAs.newAddressPool("eth0", 0, &subnet1)
+				ap.newAddressRecord(&addr11)
+
+				_, subnetV6, _ := net.ParseCIDR("fd00::/64")
+				addrV6 := net.ParseIP("fd00::5")
+				apV6, _ := localAs.newAddressPool("eth0", 0, subnetV6)
+				apV6.newAddressRecord(&addrV6)
+
+				am.setAddressSpace(localAs)
+			})
+
+			Context("When the pool is reserved for dual-stack", func() {
+				It("Should return an IPv4 and IPv6 pair and release both pools", func() {
+					options := map[string]string{OptInterfaceName: "eth0", OptDualStack: "true"}
+					poolId, _, err := am.RequestPool(LocalDefaultAddressSpaceId, "", "", options, false)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(poolId).To(Equal(subnet1.String()))
+					Expect(localAs.Pools[poolId].PairedPoolId).To(Equal("fd00::/64"))
+					Expect(localAs.Pools["fd00::/64"].RefCount).To(Equal(1))
+
+					addr, addrV6, err := am.RequestAddress(LocalDefaultAddressSpaceId, poolId, "", nil)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(addr).To(Equal("10.0.1.1/24"))
+					Expect(addrV6).To(Equal("fd00::5/64"))
+
+					err = am.ReleasePool(LocalDefaultAddressSpaceId, poolId)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(localAs.Pools["fd00::/64"].RefCount).To(Equal(0))
+					Expect(localAs.Pools[poolId].PairedPoolId).To(BeEmpty())
+				})
+			})
+
+			Context("When the paired pool has no available address", func() {
+				It("Should not reserve the IPv4 address", func() {
+					options := map[string]string{OptInterfaceName: "eth0", OptDualStack: "true"}
+					poolId, _, err := am.RequestPool(LocalDefaultAddressSpaceId, "", "", options, false)
+					Expect(err).NotTo(HaveOccurred())
+
+					localAs.Pools["fd00::/64"].Addresses["fd00::5"].InUse = true
+
+					_, _, err = am.RequestAddress(LocalDefaultAddressSpaceId, poolId, "", nil)
+					Expect(err).To(Equal(errNoAvailableAddresses))
+					Expect(localAs.Pools[poolId].Addresses[addr11.String()].InUse).To(BeFalse())
+				})
+			})
+
+			Context("When there is no IPv6 pool on the interface", func() {
+				It("Should fail to reserve the pool", func() {
+					options := map[string]string{OptInterfaceName: "eth0", OptDualStack: "true"}
+					delete(localAs.Pools, "fd00::/64")
+					_, _, err := am.RequestPool(LocalDefaultAddressSpaceId, "", "", options, false)
+					Expect(err).To(Equal(errNoAvailableAddressPools))
+					Expect(localAs.Pools[subnet1.String()].RefCount).To(Equal(0))
+				})
+			})
+		})
+
+		Describe("Test leases and GetPoolStatus", func() {
+			var am *addressManager
+
+			BeforeEach(func() {
+				am = &addressManager{
+					AddrSpaces: make(map[string]*addressSpace),
+					strategy:   &sequentialStrategy{},
+				}
+				localAs, _ := am.newAddressSpace(LocalDefaultAddressSpaceId, LocalScope)
+
+				ap, _ := localAs.newAddressPool("eth0", 0, &subnet1)
+				ap.newAddressRecord(&addr11)
+				ap.newAddressRecord(&addr12)
+
+				am.setAddressSpace(localAs)
+			})
+
+			Context("When an address is requested with lease options", func() {
+				It("Should record the owner until the address is released", func() {
+					options := map[string]string{
+						OptLeaseContainerID:  "container1",
+						OptLeaseNetNs:        "/var/run/netns/ns1",
+						OptLeaseIfName:       "eth0",
+						OptLeasePodName:      "pod1",
+						OptLeasePodNamespace: "default",
+					}
+					_, _, err := am.RequestAddress(LocalDefaultAddressSpaceId, subnet1.String(), "", options)
+					Expect(err).NotTo(HaveOccurred())
+
+					status := am.GetPoolStatus()
+					Expect(status).To(HaveLen(1))
+					Expect(status[0].Subnet).To(Equal(subnet1.String()))
+					Expect(status[0].Capacity).To(Equal(2))
+					Expect(status[0].InUse).To(Equal(1))
+					Expect(status[0].Addresses).To(HaveLen(1))
+
+					addr := status[0].Addresses[0]
+					Expect(addr.Address).To(Equal(addr11.String()))
+					Expect(addr.Lease.ContainerID).To(Equal("container1"))
+					Expect(addr.Lease.NetNs).To(Equal("/var/run/netns/ns1"))
+					Expect(addr.Lease.IfName).To(Equal("eth0"))
+					Expect(addr.Lease.PodName).To(Equal("pod1"))
+					Expect(addr.Lease.PodNamespace).To(Equal("default"))
+					Expect(addr.Lease.AllocatedAt).To(BeTemporally("~", time.Now(), time.Second))
+
+					err = am.ReleaseAddress(LocalDefaultAddressSpaceId, subnet1.String(), addr11.String(), nil)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(am.AddrSpaces[LocalDefaultAddressSpaceId].Pools[subnet1.String()].Addresses[addr11.String()].Lease).To(BeNil())
+					Expect(am.GetPoolStatus()[0].Addresses).To(BeEmpty())
+				})
+			})
+
+			Context("When an address becomes unhealthy", func() {
+				It("Should report the address", func() {
+					am.AddrSpaces[LocalDefaultAddressSpaceId].Pools[subnet1.String()].Addresses[addr12.String()].unhealthy = true
+
+					status := am.GetPoolStatus()
+					Expect(status[0].Unhealthy).To(Equal(1))
+					Expect(status[0].InUse).To(Equal(0))
+					Expect(status[0].Addresses).To(HaveLen(1))
+					Expect(status[0].Addresses[0].Unhealthy).To(BeTrue())
+					Expect(status[0].Addresses[0].Lease).To(BeNil())
+				})
+			})
+		})
+
+		Describe("Test StartBackgroundRefresh", func() {
+			Context("When the source is refreshed in the background", func() {
+				It("Should not refresh the source on requests", func() {
+					source := &countingSource{}
+					am := &addressManager{
+						AddrSpaces: make(map[string]*addressSpace),
+						source:     source,
+						strategy:   &lrrStrategy{},
+					}
+
+					am.StartBackgroundRefresh(time.Hour)
+					Expect(source.refreshes).To(Equal(1))
+
+					am.GetPoolStatus()
+					Expect(source.refreshes).To(Equal(1))
+
+					am.StopSource()
+					Expect(am.refreshStop).To(BeNil())
+					Expect(am.source).To(BeNil())
+				})
+			})
+
+			Context("When the source is not refreshed in the background", func() {
+				It("Should refresh the source on requests", func() {
+					source := &countingSource{}
+					am := &addressManager{
+						AddrSpaces: make(map[string]*addressSpace),
+						source:     source,
+					}
+
+					am.GetPoolStatus()
+					Expect(source.refreshes).To(Equal(1))
+				})
+			})
+		})
 	})
 )
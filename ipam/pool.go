@@ -6,7 +6,9 @@ package ipam
 import (
 	"fmt"
 	"net"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/platform"
@@ -61,7 +63,35 @@ type addressPool struct {
 	IsIPv6    bool
 	Priority  int
 	RefCount  int
-	epoch     int
+	// IPv6 pool reserved along with this pool for dual-stack.
+	PairedPoolId string `json:",omitempty"`
+	// DNS servers configured by the address source, if any.
+	DnsServers []net.IP `json:",omitempty"`
+	epoch      int
+}
+
+// PoolStatus describes the usage of an address pool.
+type PoolStatus struct {
+	AddressSpace string
+	Id           string
+	IfName       string
+	Subnet       string
+	IsIPv6       bool
+	RefCount     int
+	Capacity     int
+	InUse        int
+	Unhealthy    int
+	Addresses    []*AddressStatus
+}
+
+// AddressStatus describes an allocated or unhealthy address and its owner.
+type AddressStatus struct {
+	Address         string
+	InUse           bool
+	Unhealthy       bool
+	UnhealthyReason string        `json:",omitempty"`
+	ID              string        `json:",omitempty"`
+	Lease           *AddressLease `json:",omitempty"`
 }
 
 // AddressPoolInfo contains information about an address pool.
@@ -70,20 +100,41 @@ type AddressPoolInfo struct {
 	Gateway        net.IP
 	DnsServers     []net.IP
 	UnhealthyAddrs []net.IP
-	IsIPv6         bool
-	Available      int
-	Capacity       int
+	// Reasons of the unhealthy addresses, keyed by address.
+	UnhealthyReasons map[string]string
+	IsIPv6           bool
+	Available        int
+	Capacity         int
 }
 
 // Represents an IP address in a pool.
 type addressRecord struct {
-	ID        string
-	Addr      net.IP
-	InUse     bool
+	ID         string
+	Addr       net.IP
+	InUse      bool
+	ReleasedAt time.Time
+	Lease      *AddressLease `json:",omitempty"`
+	// Set when the address is found in use on the network.
+	UnhealthyReason string `json:",omitempty"`
+	UnhealthySince  time.Time
+	// Address ID or pod the address is reserved for.
+	ReservedFor string `json:",omitempty"`
+	// Set while a newly allocated address is probed for duplicates.
+	Tentative bool `json:",omitempty"`
 	unhealthy bool
 	epoch     int
 }
 
+// AddressLease describes the owner of an allocated address.
+type AddressLease struct {
+	ContainerID  string `json:",omitempty"`
+	NetNs        string `json:",omitempty"`
+	IfName       string `json:",omitempty"`
+	PodName      string `json:",omitempty"`
+	PodNamespace string `json:",omitempty"`
+	AllocatedAt  time.Time
+}
+
 //
 // AddressPoolId
 //
@@ -155,13 +206,18 @@ func (am *addressManager) getAddressSpace(id string) (*addressSpace, error) {
 
 // Sets a new or updates an existing address space.
 func (am *addressManager) setAddressSpace(as *addressSpace) error {
+	var events []*PoolChangeEvent
+
 	as1, ok := am.AddrSpaces[as.Id]
 	if !ok {
 		am.AddrSpaces[as.Id] = as
+		events = as.newPoolEvents()
 	} else {
-		as1.merge(as)
+		events = as1.merge(as)
 	}
 
+	am.publishPoolChanges(events)
+
 	// Notify NetPlugin of external interfaces.
 	if am.netApi != nil {
 		for _, ap := range as.Pools {
@@ -174,8 +230,25 @@ func (am *addressManager) setAddressSpace(as *addressSpace) error {
 	return nil
 }
 
-// Merges a new address space to an existing one.
-func (as *addressSpace) merge(newas *addressSpace) {
+// Returns the events of adding all pools of a new address space.
+func (as *addressSpace) newPoolEvents() []*PoolChangeEvent {
+	events := make(map[string]*PoolChangeEvent)
+
+	for _, ap := range as.Pools {
+		event := getPoolChangeEvent(events, ap, as.Id)
+		event.PoolAdded = true
+		for ak := range ap.Addresses {
+			event.Added = append(event.Added, ak)
+		}
+	}
+
+	return sortPoolChangeEvents(events)
+}
+
+// Merges a new address space to an existing one and returns the resulting pool changes.
+func (as *addressSpace) merge(newas *addressSpace) []*PoolChangeEvent {
+	events := make(map[string]*PoolChangeEvent)
+
 	// The new epoch after the merge.
 	as.epoch++
 
@@ -189,8 +262,18 @@ func (as *addressSpace) merge(newas *addressSpace) {
 			as.Pools[pk] = pv
 			pv.as = as
 			pv.epoch = as.epoch
+
+			event := getPoolChangeEvent(events, pv, as.Id)
+			event.PoolAdded = true
+			for ak := range pv.Addresses {
+				event.Added = append(event.Added, ak)
+			}
 		} else {
 			// This pool already exists.
+			// Update the configuration set by the address source.
+			ap.Gateway = pv.Gateway
+			ap.DnsServers = pv.DnsServers
+
 			// Compare address records one by one.
 			for ak, av := range pv.Addresses {
 				ar := ap.Addresses[ak]
@@ -200,10 +283,14 @@ func (as *addressSpace) merge(newas *addressSpace) {
 					// Merge it to the existing address pool.
 					ap.Addresses[ak] = av
 					av.epoch = as.epoch
+
+					event := getPoolChangeEvent(events, ap, as.Id)
+					event.Added = append(event.Added, ak)
 				} else {
 					// This address record already exists.
 					ar.epoch = as.epoch
 					ar.unhealthy = false
+					ar.ReservedFor = av.ReservedFor
 				}
 
 				delete(pv.Addresses, ak)
@@ -227,10 +314,17 @@ func (as *addressSpace) merge(newas *addressSpace) {
 				} else if av.InUse {
 					// Address is no longer valid, but still in use.
 					pv.epoch = as.epoch
+					if !av.unhealthy {
+						event := getPoolChangeEvent(events, pv, as.Id)
+						event.Unhealthy = append(event.Unhealthy, ak)
+					}
 					av.unhealthy = true
 				} else {
 					// This address is no longer available.
 					delete(pv.Addresses, ak)
+
+					event := getPoolChangeEvent(events, pv, as.Id)
+					event.Removed = append(event.Removed, ak)
 				}
 			}
 
@@ -238,11 +332,13 @@ func (as *addressSpace) merge(newas *addressSpace) {
 			if pv.epoch < as.epoch && !pv.isInUse() {
 				pv.as = nil
 				delete(as.Pools, pk)
+
+				getPoolChangeEvent(events, pv, as.Id).PoolRemoved = true
 			}
 		}
 	}
 
-	return
+	return sortPoolChangeEvents(events)
 }
 
 // Creates a new addressPool object.
@@ -348,8 +444,27 @@ func (as *addressSpace) requestPool(poolId string, subPoolId string, options map
 		}
 	}
 
+	if ap != nil {
+		if poolId == "" && !v6 && options[OptDualStack] == "true" {
+			// Reserve an IPv6 pool on the same interface so that addresses are allocated in pairs.
+			pairedAp := as.getPairedPool(ap)
+			if pairedAp == nil {
+				log.Printf("[ipam] No IPv6 pool available on interface %v.", ap.IfName)
+				ap, err = nil, errNoAvailableAddressPools
+			} else {
+				ap.PairedPoolId = pairedAp.Id
+			}
+		}
+	}
+
 	if ap != nil {
 		ap.RefCount++
+
+		if ap.PairedPoolId != "" {
+			if pairedAp := as.Pools[ap.PairedPoolId]; pairedAp != nil {
+				pairedAp.RefCount++
+			}
+		}
 	}
 
 	log.Printf("[ipam] Pool request completed with pool:%+v err:%v.", ap, err)
@@ -377,15 +492,50 @@ func (as *addressSpace) releasePool(poolId string) error {
 		return err
 	}
 
+	as.releasePoolRef(ap)
+
+	// Release the IPv6 pool reserved along with this pool.
+	if ap.PairedPoolId != "" {
+		if pairedAp := as.Pools[ap.PairedPoolId]; pairedAp != nil && pairedAp.isInUse() {
+			as.releasePoolRef(pairedAp)
+		}
+
+		if !ap.isInUse() {
+			ap.PairedPoolId = ""
+		}
+	}
+
+	return nil
+}
+
+// Drops a reference to an address pool and deletes it if it is stale and no longer in use.
+func (as *addressSpace) releasePoolRef(ap *addressPool) {
 	ap.RefCount--
 
 	// Delete address pool if it is no longer available.
 	if ap.epoch < as.epoch && !ap.isInUse() {
-		log.Printf("[ipam] Deleting stale pool with poolId:%v.", poolId)
-		delete(as.Pools, poolId)
+		log.Printf("[ipam] Deleting stale pool with poolId:%v.", ap.Id)
+		delete(as.Pools, ap.Id)
+	}
+}
+
+// Returns an available IPv6 pool on the same interface as the given IPv4 pool.
+func (as *addressSpace) getPairedPool(ap *addressPool) *addressPool {
+	var pairedAp *addressPool
+
+	for _, pool := range as.Pools {
+		if !pool.IsIPv6 || pool.isInUse() || pool.IfName != ap.IfName {
+			continue
+		}
+
+		// Prefer the pool with the highest priority, then the highest number of addresses.
+		if pairedAp == nil || pool.Priority > pairedAp.Priority ||
+			(pool.Priority == pairedAp.Priority && len(pool.Addresses) > len(pairedAp.Addresses)) {
+			pairedAp = pool
+		}
 	}
 
-	return nil
+	return pairedAp
 }
 
 //
@@ -395,29 +545,81 @@ func (as *addressSpace) releasePool(poolId string) error {
 func (ap *addressPool) getInfo() *AddressPoolInfo {
 	var available int
 	var unhealthyAddrs []net.IP
+	unhealthyReasons := make(map[string]string)
 
 	for _, ar := range ap.Addresses {
-		if !ar.InUse {
+		if !ar.InUse && !ar.hasConflict() && ar.ReservedFor == "" {
 			available++
 		}
-		if ar.unhealthy {
+		if unhealthy, reason := ar.isUnhealthy(); unhealthy {
 			unhealthyAddrs = append(unhealthyAddrs, ar.Addr)
+			unhealthyReasons[ar.Addr.String()] = reason
 		}
 	}
 
+	dnsServers := ap.DnsServers
+	if len(dnsServers) == 0 {
+		dnsServers = []net.IP{dnsHostProxyAddress}
+	}
+
 	info := &AddressPoolInfo{
-		Subnet:         ap.Subnet,
-		Gateway:        ap.Gateway,
-		DnsServers:     []net.IP{dnsHostProxyAddress},
-		UnhealthyAddrs: unhealthyAddrs,
-		IsIPv6:         ap.IsIPv6,
-		Available:      available,
-		Capacity:       len(ap.Addresses),
+		Subnet:           ap.Subnet,
+		Gateway:          ap.Gateway,
+		DnsServers:       dnsServers,
+		UnhealthyAddrs:   unhealthyAddrs,
+		UnhealthyReasons: unhealthyReasons,
+		IsIPv6:           ap.IsIPv6,
+		Available:        available,
+		Capacity:         len(ap.Addresses),
 	}
 
 	return info
 }
 
+// Returns the status of the address pool along with its allocated and unhealthy addresses.
+func (ap *addressPool) getStatus() *PoolStatus {
+	status := &PoolStatus{
+		AddressSpace: ap.as.Id,
+		Id:           ap.Id,
+		IfName:       ap.IfName,
+		Subnet:       ap.Subnet.String(),
+		IsIPv6:       ap.IsIPv6,
+		RefCount:     ap.RefCount,
+		Capacity:     len(ap.Addresses),
+		Addresses:    []*AddressStatus{},
+	}
+
+	var records []*addressRecord
+	for _, ar := range ap.Addresses {
+		unhealthy, _ := ar.isUnhealthy()
+		if ar.isAllocated() {
+			status.InUse++
+		}
+		if unhealthy {
+			status.Unhealthy++
+		}
+		if ar.isAllocated() || unhealthy {
+			records = append(records, ar)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool { return addressLess(records[i], records[j]) })
+
+	for _, ar := range records {
+		unhealthy, reason := ar.isUnhealthy()
+		status.Addresses = append(status.Addresses, &AddressStatus{
+			Address:         ar.Addr.String(),
+			InUse:           ar.isAllocated(),
+			Unhealthy:       unhealthy,
+			UnhealthyReason: reason,
+			ID:              ar.ID,
+			Lease:           ar.Lease,
+		})
+	}
+
+	return status
+}
+
 // Returns if an address pool is currently in use.
 func (ap *addressPool) isInUse() bool {
 	return ap.RefCount > 0
@@ -447,29 +649,85 @@ func (ap *addressPool) newAddressRecord(addr *net.IP) (*addressRecord, error) {
 }
 
 // Requests a new address from the address pool.
-func (ap *addressPool) requestAddress(address string, options map[string]string) (string, error) {
+// A newly allocated address is probed for duplicates before it is handed out, if a prober is given.
+func (ap *addressPool) requestAddress(address string, options map[string]string, strategy allocationStrategy, prober addressProber) (string, error) {
+	return ap.requestProbedAddress(address, options, strategy, prober, nil)
+}
+
+// Requests a new address from the address pool, and verifies that a newly allocated address is not in use on
+// the network. The address is allocated as tentative while it is probed. The probe runs through unlock, if given,
+// which returns the pool afterwards since the state may have changed meanwhile. A conflicting address is replaced
+// by the next selection unless a specific or reserved address was requested.
+func (ap *addressPool) requestProbedAddress(address string, options map[string]string, strategy allocationStrategy, prober addressProber, unlock func(probe func()) (*addressPool, error)) (string, error) {
+	for attempt := 1; ; attempt++ {
+		ar, reserved, err := ap.allocateAddress(address, options, strategy, prober != nil)
+		if err != nil {
+			return "", err
+		}
+
+		if !ar.Tentative {
+			return ap.getAddressCIDR(ar), nil
+		}
+
+		var mac net.HardwareAddr
+		var probeErr error
+		addr, ifName := ar.Addr, ap.IfName
+		probe := func() { mac, probeErr = prober.probe(ifName, addr) }
+
+		if unlock == nil {
+			probe()
+		} else if ap, err = unlock(probe); err != nil {
+			return "", err
+		}
+
+		if ar, err = ap.commitAddress(addr, mac, probeErr); err != nil {
+			return "", err
+		}
+
+		if ar != nil {
+			return ap.getAddressCIDR(ar), nil
+		}
+
+		if address != "" || reserved || attempt >= dadMaxAttempts {
+			return "", errAddressConflict
+		}
+	}
+}
+
+// Allocates an address from the address pool. A newly allocated address is marked tentative if it is to be probed.
+// Returns whether the address is reserved for the caller.
+func (ap *addressPool) allocateAddress(address string, options map[string]string, strategy allocationStrategy, probe bool) (*addressRecord, bool, error) {
 	var ar *addressRecord
-	var addr *net.IPNet
 	var err error
 	id := options[OptAddressID]
 
 	log.Printf("[ipam] Requesting address with address:%v options:%+v.", address, options)
-	defer func() { log.Printf("[ipam] Address request completed with address:%v err:%v.", addr, err) }()
+	defer func() {
+		if ar != nil && err == nil {
+			log.Printf("[ipam] Address request completed with address:%v tentative:%v.", ar.Addr, ar.Tentative)
+		} else {
+			log.Printf("[ipam] Address request completed with err:%v.", err)
+		}
+	}()
 
 	if address != "" {
 		// Return the specific address requested.
 		ar = ap.Addresses[address]
 		if ar == nil {
 			err = errAddressNotFound
-			return "", err
+			return nil, false, err
 		}
 		if ar.InUse {
 			// Return the same address if IDs match.
 			if id == "" || id != ar.ID {
 				err = errAddressInUse
-				return "", err
+				return nil, false, err
 			}
 		}
+		if ar.ReservedFor != "" && !ar.isReservedFor(options) {
+			err = errAddressReserved
+			return nil, false, err
+		}
 	} else if options[OptAddressType] == OptAddressTypeGateway {
 		// Return the pre-assigned gateway address.
 		ar = &addressRecord{
@@ -481,20 +739,42 @@ func (ap *addressPool) requestAddress(address string, options map[string]string)
 		ar = ap.addrsByID[id]
 	}
 
-	// If no address was found, return any available address.
-	if ar == nil {
-		for _, ar = range ap.Addresses {
-			if !ar.InUse && ar.ID == "" {
-				break
+	// Return the address reserved for the caller.
+	reserved := false
+	if ar == nil && address == "" && options[OptAddressType] != OptAddressTypeGateway {
+		ar = ap.getReservedAddress(options)
+		if ar != nil {
+			if ar.isAllocated() && !ar.isLeasedTo(options) {
+				log.Printf("[ipam] Reserved address %v is allocated to %v %+v.", ar.Addr, ar.ID, ar.Lease)
+				err = errAddressInUse
+				return nil, false, err
 			}
-			ar = nil
+			reserved = true
 		}
+	}
 
+	// If no address was found, return the available address selected by the allocation strategy.
+	if ar == nil {
+		now := time.Now()
+		ap.expireConflicts(now)
+		ap.expireTentativeAddresses(now)
+		ar = strategy.selectAddress(ap, now)
 		if ar == nil {
-			return "", errNoAvailableAddresses
+			err = errNoAvailableAddresses
+			return nil, false, err
 		}
 	}
 
+	// Probe a newly allocated address before handing it out.
+	if probe && !ar.isAllocated() && options[OptAddressType] != OptAddressTypeGateway {
+		ar.Tentative = true
+	}
+
+	// Record the owner of a new lease. Repeated requests for the same address keep the original lease.
+	if !ar.isAllocated() || ar.Lease == nil {
+		ar.Lease = newAddressLease(options)
+	}
+
 	if id != "" {
 		ap.addrsByID[id] = ar
 		ar.ID = id
@@ -502,13 +782,90 @@ func (ap *addressPool) requestAddress(address string, options map[string]string)
 		ar.InUse = true
 	}
 
-	// Return address in CIDR notation.
-	addr = &net.IPNet{
+	return ar, reserved, nil
+}
+
+// Returns an address of the pool in CIDR notation.
+func (ap *addressPool) getAddressCIDR(ar *addressRecord) string {
+	addr := &net.IPNet{
 		IP:   ar.Addr,
 		Mask: ap.Subnet.Mask,
 	}
 
-	return addr.String(), nil
+	return addr.String()
+}
+
+// Removes the allocation of an address without releasing it, e.g. when it is found in use on the network.
+func (ap *addressPool) deallocateAddress(ar *addressRecord) {
+	if ar.ID != "" {
+		delete(ap.addrsByID, ar.ID)
+	}
+
+	ar.ID = ""
+	ar.InUse = false
+	ar.Lease = nil
+	ar.Tentative = false
+}
+
+// Returns whether the address is reserved for the caller of a request.
+// Reservations match the address ID or the pod in the "namespace/name" form.
+func (ar *addressRecord) isReservedFor(options map[string]string) bool {
+	if ar.ReservedFor == "" {
+		return false
+	}
+
+	if id := options[OptAddressID]; id != "" && id == ar.ReservedFor {
+		return true
+	}
+
+	podName, podNamespace := options[OptLeasePodName], options[OptLeasePodNamespace]
+	return podName != "" && ar.ReservedFor == podNamespace+"/"+podName
+}
+
+// Returns whether an allocated address is owned by the caller of a request.
+// Addresses allocated without an ID are matched by pod.
+func (ar *addressRecord) isLeasedTo(options map[string]string) bool {
+	if id := options[OptAddressID]; id != "" || ar.ID != "" {
+		return id == ar.ID
+	}
+
+	podName := options[OptLeasePodName]
+	return podName != "" && ar.Lease != nil &&
+		ar.Lease.PodName == podName && ar.Lease.PodNamespace == options[OptLeasePodNamespace]
+}
+
+// Returns the address reserved for the caller of a request, or nil if there is none.
+func (ap *addressPool) getReservedAddress(options map[string]string) *addressRecord {
+	for _, ar := range ap.Addresses {
+		if ar.isReservedFor(options) {
+			return ar
+		}
+	}
+
+	return nil
+}
+
+// Requests an address from the IPv6 pool paired with the given address pool.
+func (as *addressSpace) requestPairedAddress(ap *addressPool, options map[string]string, strategy allocationStrategy, prober addressProber, unlock func(probe func()) (*addressPool, error)) (string, error) {
+	pairedAp, err := as.getAddressPool(ap.PairedPoolId)
+	if err != nil {
+		log.Printf("[ipam] Paired pool %v of pool %v not found.", ap.PairedPoolId, ap.Id)
+		return "", err
+	}
+
+	return pairedAp.requestProbedAddress("", options, strategy, prober, unlock)
+}
+
+// Creates the lease of an address from the given request options.
+func newAddressLease(options map[string]string) *AddressLease {
+	return &AddressLease{
+		ContainerID:  options[OptLeaseContainerID],
+		NetNs:        options[OptLeaseNetNs],
+		IfName:       options[OptLeaseIfName],
+		PodName:      options[OptLeasePodName],
+		PodNamespace: options[OptLeasePodNamespace],
+		AllocatedAt:  time.Now(),
+	}
 }
 
 // Releases a previously requested address back to its address pool.
@@ -552,6 +909,8 @@ func (ap *addressPool) releaseAddress(address string, options map[string]string)
 	}
 
 	ar.InUse = false
+	ar.ReleasedAt = time.Now()
+	ar.Lease = nil
 
 	if id != "" && ar.ID == id {
 		delete(ap.addrsByID, ar.ID)
@@ -0,0 +1,367 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	defaultLinuxRangeFilePath   = "/etc/kubernetes/ipam-ranges.json"
+	defaultWindowsRangeFilePath = `c:\k\ipam-ranges.json`
+
+	// Maximum number of addresses expanded from a single subnet.
+	maxRangeAddresses = 65536
+
+	// Maximum number of addresses visited while expanding a single subnet, including excluded addresses.
+	maxRangeIterations = 4 * maxRangeAddresses
+)
+
+// Static IPAM configuration source that expands subnet and range definitions from a file.
+type rangeIpamSource struct {
+	name     string
+	sink     addressConfigSink
+	filePath string
+	modTime  time.Time
+	size     int64
+}
+
+// Range IPAM configuration file format.
+type RangeConfig struct {
+	Subnets []RangeSubnet `json:"subnets"`
+}
+
+// RangeSubnet defines an address pool.
+// Ranges and exclusions are single addresses, "first-last" address ranges or CIDR prefixes.
+type RangeSubnet struct {
+	Subnet       string             `json:"subnet"`
+	Interface    string             `json:"interface,omitempty"`
+	Gateway      string             `json:"gateway,omitempty"`
+	DnsServers   []string           `json:"dns,omitempty"`
+	Ranges       []string           `json:"ranges,omitempty"`
+	Exclude      []string           `json:"exclude,omitempty"`
+	Reservations []RangeReservation `json:"reservations,omitempty"`
+}
+
+// RangeReservation reserves an address for an address ID or a pod in the "namespace/name" form.
+type RangeReservation struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+// Creates the range IPAM source.
+func newRangeIpamSource(options map[string]interface{}) (*rangeIpamSource, error) {
+	filePath, _ := options[common.OptIpamRangeFile].(string)
+	if filePath == "" {
+		if runtime.GOOS == windows {
+			filePath = defaultWindowsRangeFilePath
+		} else {
+			filePath = defaultLinuxRangeFilePath
+		}
+	}
+
+	return &rangeIpamSource{
+		name:     "Range",
+		filePath: filePath,
+	}, nil
+}
+
+// Starts the range IPAM source.
+func (source *rangeIpamSource) start(sink addressConfigSink) error {
+	source.sink = sink
+	return nil
+}
+
+// Stops the range IPAM source.
+func (source *rangeIpamSource) stop() {
+	source.sink = nil
+}
+
+// Refreshes configuration. The file is reloaded whenever it changes.
+func (source *rangeIpamSource) refresh() error {
+	fileInfo, err := os.Stat(source.filePath)
+	if err != nil {
+		return err
+	}
+
+	if fileInfo.ModTime().Equal(source.modTime) && fileInfo.Size() == source.size {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(source.filePath)
+	if err != nil {
+		return err
+	}
+
+	config := &RangeConfig{}
+	if err = json.Unmarshal(data, config); err != nil {
+		log.Printf("[ipam] Failed to parse range config file %v, err:%v.", source.filePath, err)
+		return err
+	}
+
+	localInterfaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+
+	// Configure the local default address space.
+	local, err := source.sink.newAddressSpace(LocalDefaultAddressSpaceId, LocalScope)
+	if err != nil {
+		return err
+	}
+
+	if err = populateRangeAddressSpace(local, config, localInterfaces); err != nil {
+		log.Printf("[ipam] Invalid range config file %v, err:%v.", source.filePath, err)
+		return err
+	}
+
+	// Set the local address space as active.
+	if err = source.sink.setAddressSpace(local); err != nil {
+		return err
+	}
+
+	log.Printf("[ipam] Address space successfully populated from range config file %v.", source.filePath)
+	source.modTime = fileInfo.ModTime()
+	source.size = fileInfo.Size()
+
+	return nil
+}
+
+// Expands the subnets of a range configuration into address pools.
+func populateRangeAddressSpace(as *addressSpace, config *RangeConfig, localInterfaces []net.Interface) error {
+	for _, rs := range config.Subnets {
+		_, subnet, err := net.ParseCIDR(rs.Subnet)
+		if err != nil {
+			return fmt.Errorf("Invalid subnet %q: %v", rs.Subnet, err)
+		}
+
+		ifName := rs.Interface
+		if ifName == "" {
+			ifName = findInterfaceInSubnet(subnet, localInterfaces)
+		}
+
+		ap, err := as.newAddressPool(ifName, 0, subnet)
+		if err != nil {
+			return fmt.Errorf("Subnet %v: %v", rs.Subnet, err)
+		}
+
+		if err = ap.populateRanges(&rs); err != nil {
+			return fmt.Errorf("Subnet %v: %v", rs.Subnet, err)
+		}
+	}
+
+	return nil
+}
+
+// Populates the addresses, gateway and DNS servers of an address pool from a subnet definition.
+func (ap *addressPool) populateRanges(rs *RangeSubnet) error {
+	if rs.Gateway != "" {
+		if ap.Gateway = net.ParseIP(rs.Gateway); ap.Gateway == nil || !ap.Subnet.Contains(ap.Gateway) {
+			return fmt.Errorf("Invalid gateway %q", rs.Gateway)
+		}
+	}
+
+	for _, dns := range rs.DnsServers {
+		ip := net.ParseIP(dns)
+		if ip == nil {
+			return fmt.Errorf("Invalid DNS server %q", dns)
+		}
+		ap.DnsServers = append(ap.DnsServers, ip)
+	}
+
+	// The whole subnet is used if no ranges are given. The gateway defaults to the first host address.
+	var ranges [][2]net.IP
+	if len(rs.Ranges) == 0 {
+		ranges = append(ranges, [2]net.IP{ap.Subnet.IP, lastAddress(&ap.Subnet)})
+	}
+
+	for _, r := range rs.Ranges {
+		first, last, err := parseAddressRange(r)
+		if err != nil {
+			return err
+		}
+		if !ap.Subnet.Contains(first) || !ap.Subnet.Contains(last) {
+			return fmt.Errorf("Range %q is not in the subnet", r)
+		}
+		ranges = append(ranges, [2]net.IP{first, last})
+	}
+
+	var excluded [][2]net.IP
+	for _, r := range rs.Exclude {
+		first, last, err := parseAddressRange(r)
+		if err != nil {
+			return err
+		}
+		excluded = append(excluded, [2]net.IP{first, last})
+	}
+
+	// The network address, the gateway and the IPv4 broadcast address are never handed out.
+	excluded = append(excluded, [2]net.IP{ap.Subnet.IP, ap.Subnet.IP}, [2]net.IP{ap.Gateway, ap.Gateway})
+	if !ap.IsIPv6 {
+		broadcast := lastAddress(&ap.Subnet)
+		excluded = append(excluded, [2]net.IP{broadcast, broadcast})
+	}
+
+	count, iterations := 0, 0
+	for _, r := range ranges {
+		for ip := r[0]; ip != nil && compareAddresses(ip, r[1]) <= 0; ip = nextAddress(ip) {
+			if iterations++; iterations > maxRangeIterations {
+				return fmt.Errorf("More than %d addresses in ranges", maxRangeIterations)
+			}
+
+			if inRanges(ip, excluded) {
+				continue
+			}
+
+			if count++; count > maxRangeAddresses {
+				return fmt.Errorf("More than %d addresses", maxRangeAddresses)
+			}
+
+			addr := ip
+			if _, err := ap.newAddressRecord(&addr); err != nil && err != errAddressExists {
+				return err
+			}
+		}
+	}
+
+	// Reserved addresses are added even if they are not in the ranges.
+	reserved := make(map[string]bool)
+	for _, res := range rs.Reservations {
+		addr := net.ParseIP(res.Address)
+		if addr == nil || res.ID == "" {
+			return fmt.Errorf("Invalid reservation %+v", res)
+		}
+		if reserved[res.ID] {
+			return fmt.Errorf("Duplicate reservation for %v", res.ID)
+		}
+		reserved[res.ID] = true
+
+		ar, err := ap.newAddressRecord(&addr)
+		if err != nil && err != errAddressExists {
+			return fmt.Errorf("Invalid reservation %+v: %v", res, err)
+		}
+		if ar.ReservedFor != "" {
+			return fmt.Errorf("Address %v is reserved more than once", addr)
+		}
+		ar.ReservedFor = res.ID
+	}
+
+	return nil
+}
+
+// Returns the name of the local interface with an address in the given subnet.
+func findInterfaceInSubnet(subnet *net.IPNet, localInterfaces []net.Interface) string {
+	for _, iface := range localInterfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && subnet.Contains(ipNet.IP) {
+				return iface.Name
+			}
+		}
+	}
+
+	log.Printf("[ipam] Failed to find interface in subnet %v.", subnet)
+	return ""
+}
+
+// Parses a single address, a "first-last" address range or a CIDR prefix.
+func parseAddressRange(s string) (net.IP, net.IP, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		return normalizeAddress(ipNet.IP), lastAddress(ipNet), nil
+	}
+
+	parts := strings.SplitN(s, "-", 2)
+	first := net.ParseIP(strings.TrimSpace(parts[0]))
+	last := first
+	if len(parts) == 2 {
+		last = net.ParseIP(strings.TrimSpace(parts[1]))
+	}
+
+	if first == nil || last == nil || (first.To4() == nil) != (last.To4() == nil) || compareAddresses(first, last) > 0 {
+		return nil, nil, fmt.Errorf("Invalid address range %q", s)
+	}
+
+	return normalizeAddress(first), normalizeAddress(last), nil
+}
+
+// Returns the 4-byte form of IPv4 addresses so that addresses of a family have the same length.
+func normalizeAddress(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+// Returns the last address of a subnet.
+func lastAddress(ipNet *net.IPNet) net.IP {
+	ip := normalizeAddress(ipNet.IP)
+	last := make(net.IP, len(ip))
+	mask := ipNet.Mask
+	if len(mask) != len(ip) {
+		mask = mask[len(mask)-len(ip):]
+	}
+
+	for i := range ip {
+		last[i] = ip[i] | ^mask[i]
+	}
+
+	return last
+}
+
+// Returns the address following the given address, or nil if it is the last address of its family.
+func nextAddress(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+
+	if ip4 := next.To4(); ip4 != nil {
+		next = ip4
+	}
+
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next
+		}
+	}
+
+	// The address wrapped around.
+	return nil
+}
+
+// Compares two addresses of the same family.
+func compareAddresses(ip1, ip2 net.IP) int {
+	return bytes.Compare(ip1.To16(), ip2.To16())
+}
+
+// Returns whether an address is in any of the given ranges.
+func inRanges(ip net.IP, ranges [][2]net.IP) bool {
+	for _, r := range ranges {
+		if compareAddresses(ip, r[0]) >= 0 && compareAddresses(ip, r[1]) <= 0 {
+			return true
+		}
+	}
+
+	return false
+}
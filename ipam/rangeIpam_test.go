@@ -0,0 +1,240 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/azure-container-networking/common"
+)
+
+const rangeConfig = `{
+	"subnets": [
+		{
+			"subnet": "10.0.4.0/28",
+			"interface": "eth0",
+			"gateway": "10.0.4.1",
+			"dns": ["10.0.0.10"],
+			"exclude": ["10.0.4.5-10.0.4.10", "10.0.4.12/31"],
+			"reservations": [
+				{"id": "default/web-0", "address": "10.0.4.6"},
+				{"id": "ep-db", "address": "10.0.4.11"}
+			]
+		},
+		{
+			"subnet": "fd00::4:0/120",
+			"interface": "eth0",
+			"ranges": ["fd00::4:10-fd00::4:12"]
+		}
+	]
+}`
+
+var (
+	_ = Describe("Test range IPAM", func() {
+
+		var (
+			dir      string
+			filePath string
+			am       *addressManager
+			source   *rangeIpamSource
+			strategy = &sequentialStrategy{}
+		)
+
+		writeConfig := func(config string, modTime time.Time) {
+			Expect(ioutil.WriteFile(filePath, []byte(config), 0644)).To(Succeed())
+			Expect(os.Chtimes(filePath, modTime, modTime)).To(Succeed())
+		}
+
+		getPool := func(subnet string) *addressPool {
+			return am.AddrSpaces[LocalDefaultAddressSpaceId].Pools[subnet]
+		}
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "rangeIpam")
+			Expect(err).NotTo(HaveOccurred())
+			filePath = filepath.Join(dir, "ipam-ranges.json")
+			writeConfig(rangeConfig, time.Now().Add(-time.Hour))
+
+			am = &addressManager{AddrSpaces: make(map[string]*addressSpace)}
+			source, err = newRangeIpamSource(map[string]interface{}{common.OptIpamRangeFile: filePath})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(source.start(am)).To(Succeed())
+			Expect(source.refresh()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		Describe("Test refresh", func() {
+			Context("When the subnets are expanded", func() {
+				It("Should skip the excluded, gateway, network and broadcast addresses", func() {
+					ap := getPool("10.0.4.0/28")
+					Expect(ap).NotTo(BeNil())
+					Expect(ap.IfName).To(Equal("eth0"))
+					Expect(ap.Gateway.Equal(net.ParseIP("10.0.4.1"))).To(BeTrue())
+
+					var addrs []string
+					for addr := range ap.Addresses {
+						addrs = append(addrs, addr)
+					}
+					Expect(addrs).To(ConsistOf(
+						"10.0.4.2", "10.0.4.3", "10.0.4.4", "10.0.4.6", "10.0.4.11", "10.0.4.14"))
+
+					info := ap.getInfo()
+					Expect(info.DnsServers).To(HaveLen(1))
+					Expect(info.DnsServers[0].Equal(net.ParseIP("10.0.0.10"))).To(BeTrue())
+					Expect(info.Available).To(Equal(4))
+
+					ap6 := getPool("fd00::4:0/120")
+					Expect(ap6).NotTo(BeNil())
+					Expect(ap6.IsIPv6).To(BeTrue())
+					Expect(ap6.Addresses).To(HaveLen(3))
+					Expect(ap6.getInfo().DnsServers[0].Equal(dnsHostProxyAddress)).To(BeTrue())
+				})
+			})
+
+			Context("When the file has not changed", func() {
+				It("Should not reload it", func() {
+					getPool("10.0.4.0/28").DnsServers = nil
+					Expect(source.refresh()).To(Succeed())
+					Expect(getPool("10.0.4.0/28").DnsServers).To(BeNil())
+				})
+			})
+
+			Context("When the file changes", func() {
+				It("Should reload it and keep allocated addresses", func() {
+					ap := getPool("10.0.4.0/28")
+					_, err := ap.requestAddress("10.0.4.3", nil, strategy, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					writeConfig(`{"subnets": [{"subnet": "10.0.4.0/28", "interface": "eth0", "ranges": ["10.0.4.2"]}]}`, time.Now())
+					Expect(source.refresh()).To(Succeed())
+
+					Expect(getPool("fd00::4:0/120")).To(BeNil())
+					Expect(ap.Addresses).To(HaveLen(2))
+					Expect(ap.Addresses["10.0.4.3"].unhealthy).To(BeTrue())
+					Expect(ap.Gateway.Equal(net.ParseIP("10.0.4.1"))).To(BeTrue())
+					Expect(ap.getInfo().DnsServers[0].Equal(dnsHostProxyAddress)).To(BeTrue())
+				})
+			})
+
+			Context("When the file is invalid", func() {
+				It("Should fail and keep the current configuration", func() {
+					writeConfig(`{"subnets": [{"subnet": "10.0.4.0/28", "ranges": ["10.0.5.1-10.0.5.2"]}]}`, time.Now())
+					Expect(source.refresh()).To(HaveOccurred())
+					Expect(getPool("10.0.4.0/28").Addresses).To(HaveLen(6))
+					Expect(getPool("fd00::4:0/120")).NotTo(BeNil())
+				})
+			})
+
+			Context("When a subnet has too many addresses", func() {
+				It("Should fail", func() {
+					writeConfig(`{"subnets": [{"subnet": "10.0.0.0/8", "interface": "eth0"}]}`, time.Now())
+					Expect(source.refresh()).To(HaveOccurred())
+				})
+			})
+
+			Context("When most addresses of a large subnet are excluded", func() {
+				It("Should fail instead of visiting all of them", func() {
+					writeConfig(`{"subnets": [{"subnet": "10.0.0.0/8", "interface": "eth0", "exclude": ["10.0.0.0-10.255.255.250"]}]}`, time.Now())
+					Expect(source.refresh()).To(HaveOccurred())
+				})
+			})
+		})
+
+		Describe("Test reservations", func() {
+			Context("When the reservation owner requests an address", func() {
+				It("Should return the reserved address", func() {
+					ap := getPool("10.0.4.0/28")
+
+					addr, err := ap.requestAddress("", map[string]string{OptAddressID: "ep-db"}, strategy, nil)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(addr).To(Equal("10.0.4.11/28"))
+
+					options := map[string]string{OptLeasePodNamespace: "default", OptLeasePodName: "web-0"}
+					addr, err = ap.requestAddress("", options, strategy, nil)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(addr).To(Equal("10.0.4.6/28"))
+
+					// Repeated requests of the pod return the same address.
+					addr, err = ap.requestAddress("", options, strategy, nil)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(addr).To(Equal("10.0.4.6/28"))
+				})
+			})
+
+			Context("When other callers request addresses", func() {
+				It("Should not hand out reserved addresses", func() {
+					ap := getPool("10.0.4.0/28")
+
+					var addrs []string
+					for {
+						addr, err := ap.requestAddress("", nil, strategy, nil)
+						if err != nil {
+							Expect(err).To(Equal(errNoAvailableAddresses))
+							break
+						}
+						addrs = append(addrs, addr)
+					}
+					Expect(addrs).To(Equal([]string{"10.0.4.2/28", "10.0.4.3/28", "10.0.4.4/28", "10.0.4.14/28"}))
+
+					_, err := ap.requestAddress("10.0.4.11", nil, strategy, nil)
+					Expect(err).To(Equal(errAddressReserved))
+				})
+			})
+
+			Context("When the reserved address is allocated to another caller", func() {
+				It("Should fail", func() {
+					ap := getPool("10.0.4.0/28")
+					ap.Addresses["10.0.4.11"].ID = "ep-other"
+
+					_, err := ap.requestAddress("", map[string]string{OptAddressID: "ep-db"}, strategy, nil)
+					Expect(err).To(Equal(errAddressInUse))
+				})
+			})
+		})
+
+		Describe("Test parseAddressRange", func() {
+			It("Should parse addresses, ranges and prefixes", func() {
+				first, last, err := parseAddressRange("10.0.4.5")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(first.String()).To(Equal("10.0.4.5"))
+				Expect(last.String()).To(Equal("10.0.4.5"))
+
+				first, last, err = parseAddressRange("10.0.4.5 - 10.0.4.9")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(first.String()).To(Equal("10.0.4.5"))
+				Expect(last.String()).To(Equal("10.0.4.9"))
+
+				first, last, err = parseAddressRange("fd00::4:0/126")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(first.String()).To(Equal("fd00::4:0"))
+				Expect(last.String()).To(Equal("fd00::4:3"))
+
+				_, _, err = parseAddressRange("10.0.4.9-10.0.4.5")
+				Expect(err).To(HaveOccurred())
+
+				_, _, err = parseAddressRange("10.0.4.5-fd00::1")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Describe("Test nextAddress", func() {
+			It("Should stop at the last address of the family", func() {
+				Expect(nextAddress(net.ParseIP("10.0.4.255")).String()).To(Equal("10.0.5.0"))
+				Expect(nextAddress(net.ParseIP("255.255.255.255"))).To(BeNil())
+				Expect(nextAddress(net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"))).To(BeNil())
+			})
+		})
+	})
+)
@@ -0,0 +1,214 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Fraction of the refresh interval by which background refreshes are randomly spread.
+	refreshJitter = 0.1
+
+	// Maximum time between background refreshes after consecutive failures.
+	maxRefreshBackoff = 5 * time.Minute
+
+	// Maximum time for a query of an HTTP address source, including reading the response.
+	sourceQueryTimeout = 30 * time.Second
+
+	// Number of pool change events buffered for each subscriber.
+	poolChangeBufferSize = 64
+)
+
+// PoolChangeEvent describes the changes made to an address pool by a refresh of the address source.
+type PoolChangeEvent struct {
+	AddressSpace string
+	PoolId       string
+	IfName       string
+	PoolAdded    bool `json:",omitempty"`
+	PoolRemoved  bool `json:",omitempty"`
+	// Addresses added to and removed from the pool.
+	Added   []string `json:",omitempty"`
+	Removed []string `json:",omitempty"`
+	// In-use addresses that the address source no longer lists.
+	Unhealthy []string `json:",omitempty"`
+	Time      time.Time
+}
+
+// LockedSink lets a source refreshed in the background update the address manager
+// without holding its lock while the source is queried.
+type lockedSink struct {
+	am *addressManager
+}
+
+// Creates a new address space.
+func (sink *lockedSink) newAddressSpace(id string, scope int) (*addressSpace, error) {
+	return sink.am.newAddressSpace(id, scope)
+}
+
+// Sets a new or updates an existing address space under the address manager lock.
+func (sink *lockedSink) setAddressSpace(as *addressSpace) error {
+	sink.am.Lock()
+	defer sink.am.Unlock()
+
+	return sink.am.setAddressSpace(as)
+}
+
+// Refreshes the source periodically until stopped. The interval is jittered so that nodes
+// do not query the source in lockstep, and backs off exponentially while refreshes fail.
+func runBackgroundRefresh(source addressConfigSource, interval time.Duration, failures int, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	for {
+		timer := time.NewTimer(nextRefreshDelay(interval, failures))
+
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if refreshInBackground(source) != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+	}
+}
+
+// Refreshes a source that writes through a locked sink.
+func refreshInBackground(source addressConfigSource) error {
+	if source == nil {
+		return nil
+	}
+
+	err := source.refresh()
+	if err != nil {
+		log.Printf("[ipam] Background source refresh failed, err:%v.", err)
+	}
+
+	return err
+}
+
+// Returns the delay until the next background refresh.
+func nextRefreshDelay(interval time.Duration, failures int) time.Duration {
+	delay := interval
+
+	for i := 0; i < failures && delay < maxRefreshBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRefreshBackoff && interval < maxRefreshBackoff {
+		delay = maxRefreshBackoff
+	}
+
+	spread := time.Duration(refreshJitter * float64(delay))
+	if spread > 0 {
+		delay += time.Duration(rand.Int63n(int64(2*spread))) - spread
+	}
+
+	return delay
+}
+
+// ConditionalQuery holds the validators of the last response processed by an HTTP address source,
+// so that the source can skip configuration that has not changed since.
+type conditionalQuery struct {
+	etag         string
+	lastModified string
+}
+
+// Makes a request conditional on the configuration having changed.
+func (q *conditionalQuery) prepare(req *http.Request) {
+	if q.etag != "" {
+		req.Header.Set("If-None-Match", q.etag)
+	}
+	if q.lastModified != "" {
+		req.Header.Set("If-Modified-Since", q.lastModified)
+	}
+}
+
+// Records the validators of a response after it has been processed.
+func (q *conditionalQuery) update(resp *http.Response) {
+	q.etag = resp.Header.Get("ETag")
+	q.lastModified = resp.Header.Get("Last-Modified")
+}
+
+// Returns a pool change event for the given pool, creating it if needed.
+func getPoolChangeEvent(events map[string]*PoolChangeEvent, ap *addressPool, asId string) *PoolChangeEvent {
+	event := events[ap.Id]
+	if event == nil {
+		event = &PoolChangeEvent{
+			AddressSpace: asId,
+			PoolId:       ap.Id,
+			IfName:       ap.IfName,
+			Time:         time.Now(),
+		}
+		events[ap.Id] = event
+	}
+
+	return event
+}
+
+// Returns the events in pool order with sorted addresses.
+func sortPoolChangeEvents(events map[string]*PoolChangeEvent) []*PoolChangeEvent {
+	var list []*PoolChangeEvent
+
+	for _, event := range events {
+		sort.Strings(event.Added)
+		sort.Strings(event.Removed)
+		sort.Strings(event.Unhealthy)
+		list = append(list, event)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].PoolId < list[j].PoolId })
+
+	return list
+}
+
+// SubscribePoolChanges returns a channel that receives the changes made to address pools by source refreshes,
+// and a function that cancels the subscription. Events are dropped if the subscriber falls behind.
+func (am *addressManager) SubscribePoolChanges() (<-chan *PoolChangeEvent, func()) {
+	am.Lock()
+	defer am.Unlock()
+
+	ch := make(chan *PoolChangeEvent, poolChangeBufferSize)
+	if am.subscribers == nil {
+		am.subscribers = make(map[chan *PoolChangeEvent]bool)
+	}
+	am.subscribers[ch] = true
+
+	cancel := func() {
+		am.Lock()
+		defer am.Unlock()
+
+		if am.subscribers[ch] {
+			delete(am.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return ch, cancel
+}
+
+// Publishes pool change events to subscribers without blocking.
+func (am *addressManager) publishPoolChanges(events []*PoolChangeEvent) {
+	for _, event := range events {
+		log.Printf("[ipam] Pool %v changed, added:%v removed:%v unhealthy:%v poolAdded:%v poolRemoved:%v.",
+			event.PoolId, len(event.Added), len(event.Removed), len(event.Unhealthy), event.PoolAdded, event.PoolRemoved)
+
+		for ch := range am.subscribers {
+			select {
+			case ch <- event:
+			default:
+				log.Printf("[ipam] Dropped pool change event for a slow subscriber.")
+			}
+		}
+	}
+}
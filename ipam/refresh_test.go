@@ -0,0 +1,197 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/azure-container-networking/common"
+)
+
+// blockingSource is an address source whose refreshes wait until released.
+type blockingSource struct {
+	sink    addressConfigSink
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSource) start(sink addressConfigSink) error {
+	s.sink = sink
+	return nil
+}
+
+func (s *blockingSource) stop() {
+	s.sink = nil
+}
+
+func (s *blockingSource) refresh() error {
+	s.started <- struct{}{}
+	<-s.release
+
+	as, _ := s.sink.newAddressSpace(LocalDefaultAddressSpaceId, LocalScope)
+	ap, _ := as.newAddressPool("eth0", 0, &subnet1)
+	ap.newAddressRecord(&addr11)
+	return s.sink.setAddressSpace(as)
+}
+
+var (
+	_ = Describe("Test source refresh", func() {
+
+		Describe("Test nextRefreshDelay", func() {
+			It("Should jitter the interval and back off after failures", func() {
+				for i := 0; i < 100; i++ {
+					delay := nextRefreshDelay(10*time.Second, 0)
+					Expect(delay).To(BeNumerically(">=", 9*time.Second))
+					Expect(delay).To(BeNumerically("<=", 11*time.Second))
+
+					delay = nextRefreshDelay(10*time.Second, 2)
+					Expect(delay).To(BeNumerically(">=", 36*time.Second))
+					Expect(delay).To(BeNumerically("<=", 44*time.Second))
+
+					delay = nextRefreshDelay(10*time.Second, 20)
+					Expect(delay).To(BeNumerically(">=", maxRefreshBackoff*9/10))
+					Expect(delay).To(BeNumerically("<=", maxRefreshBackoff*11/10))
+				}
+			})
+		})
+
+		Describe("Test pool change events", func() {
+			var (
+				am *addressManager
+			)
+
+			newSpace := func(addrs ...net.IP) *addressSpace {
+				as, _ := am.newAddressSpace(LocalDefaultAddressSpaceId, LocalScope)
+				ap, _ := as.newAddressPool("eth0", 0, &subnet1)
+				for _, addr := range addrs {
+					a := addr
+					ap.newAddressRecord(&a)
+				}
+				return as
+			}
+
+			BeforeEach(func() {
+				am = &addressManager{AddrSpaces: make(map[string]*addressSpace)}
+			})
+
+			It("Should publish added, removed and unhealthy addresses", func() {
+				events, cancel := am.SubscribePoolChanges()
+
+				Expect(am.setAddressSpace(newSpace(addr11, addr12, addr13))).To(Succeed())
+				event := <-events
+				Expect(event.PoolId).To(Equal(subnet1.String()))
+				Expect(event.PoolAdded).To(BeTrue())
+				Expect(event.Added).To(Equal([]string{"10.0.1.1", "10.0.1.2", "10.0.1.3"}))
+
+				ap := am.AddrSpaces[LocalDefaultAddressSpaceId].Pools[subnet1.String()]
+				ap.Addresses[addr12.String()].InUse = true
+
+				Expect(am.setAddressSpace(newSpace(addr11))).To(Succeed())
+				event = <-events
+				Expect(event.PoolAdded).To(BeFalse())
+				Expect(event.Removed).To(Equal([]string{"10.0.1.3"}))
+				Expect(event.Unhealthy).To(Equal([]string{"10.0.1.2"}))
+
+				// Unchanged pools are not reported.
+				Expect(am.setAddressSpace(newSpace(addr11))).To(Succeed())
+				Expect(events).NotTo(Receive())
+
+				cancel()
+				Expect(events).To(BeClosed())
+				Expect(am.subscribers).To(BeEmpty())
+			})
+
+			It("Should publish removed pools", func() {
+				Expect(am.setAddressSpace(newSpace(addr11))).To(Succeed())
+				events, cancel := am.SubscribePoolChanges()
+				defer cancel()
+
+				as, _ := am.newAddressSpace(LocalDefaultAddressSpaceId, LocalScope)
+				Expect(am.setAddressSpace(as)).To(Succeed())
+
+				event := <-events
+				Expect(event.PoolRemoved).To(BeTrue())
+				Expect(event.Removed).To(Equal([]string{"10.0.1.1"}))
+			})
+
+			It("Should not block on slow subscribers", func() {
+				_, cancel := am.SubscribePoolChanges()
+				defer cancel()
+
+				for i := 0; i < poolChangeBufferSize+1; i++ {
+					addr := net.IPv4(10, 0, 1, byte(i+1))
+					Expect(am.setAddressSpace(newSpace(addr))).To(Succeed())
+				}
+			})
+		})
+
+		Describe("Test background refresh", func() {
+			It("Should not hold the lock while the source is queried", func() {
+				source := &blockingSource{started: make(chan struct{}), release: make(chan struct{})}
+				am := &addressManager{
+					AddrSpaces: make(map[string]*addressSpace),
+					source:     source,
+				}
+
+				done := make(chan struct{})
+				go func() {
+					am.StartBackgroundRefresh(time.Hour)
+					close(done)
+				}()
+
+				<-source.started
+				Expect(am.GetPoolStatus()).To(BeEmpty())
+
+				close(source.release)
+				<-done
+				Expect(am.GetPoolStatus()).To(HaveLen(1))
+
+				am.StopSource()
+				Expect(am.source).To(BeNil())
+			})
+		})
+
+		Describe("Test conditional queries", func() {
+			It("Should skip unchanged configuration", func() {
+				queries := 0
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					queries++
+					if r.Header.Get("If-None-Match") == `"v1"` {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+					w.Header().Set("ETag", `"v1"`)
+					w.Write([]byte(imdsQueryResponse))
+				}))
+				defer server.Close()
+
+				source, _ := newIMDSSource(map[string]interface{}{common.OptIpamQueryUrl: server.URL})
+				source.interfaces = func() ([]net.Interface, error) {
+					return []net.Interface{{Name: "eth0", HardwareAddr: net.HardwareAddr{0x00, 0x0d, 0x3a, 0x00, 0x00, 0x01}}}, nil
+				}
+
+				am := &addressManager{AddrSpaces: make(map[string]*addressSpace)}
+				source.start(am)
+				events, cancel := am.SubscribePoolChanges()
+				defer cancel()
+
+				Expect(source.refresh()).To(Succeed())
+				Expect(events).To(Receive())
+				Expect(source.lastQuery.etag).To(Equal(`"v1"`))
+
+				source.lastRefresh = time.Time{}
+				Expect(source.refresh()).To(Succeed())
+				Expect(queries).To(Equal(2))
+				Expect(events).NotTo(Receive())
+				Expect(am.AddrSpaces[LocalDefaultAddressSpaceId].Pools).To(HaveLen(1))
+			})
+		})
+	})
+)
@@ -4,6 +4,9 @@ package iptables
 
 import (
 	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
 
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/platform"
@@ -13,6 +16,8 @@ import (
 const (
 	CNIInputChain  = "AZURECNIINPUT"
 	CNIOutputChain = "AZURECNIOUTPUT"
+	// Outbound NAT chain in the nat table, jumped to from POSTROUTING.
+	CNIPostroutingChain = "AZURECNIPOSTROUTING"
 )
 
 // standard iptable chains
@@ -36,6 +41,8 @@ const (
 	Accept     = "ACCEPT"
 	Drop       = "DROP"
 	Masquerade = "MASQUERADE"
+	Return     = "RETURN"
+	Snat       = "SNAT"
 )
 
 // actions
@@ -52,9 +59,11 @@ const (
 )
 
 const (
-	iptables    = "iptables"
-	ip6tables   = "ip6tables"
-	lockTimeout = 60
+	iptables         = "iptables"
+	ip6tables        = "ip6tables"
+	iptablesRestore  = "iptables-restore"
+	ip6tablesRestore = "ip6tables-restore"
+	lockTimeout      = 60
 )
 
 const (
@@ -68,6 +77,12 @@ var (
 
 // Run iptables command
 func runCmd(version, params string) error {
+	_, err := runCmdWithOutput(version, params)
+	return err
+}
+
+// Run iptables command and return its output
+func runCmdWithOutput(version, params string) (string, error) {
 	var cmd string
 
 	iptCmd := iptables
@@ -81,11 +96,7 @@ func runCmd(version, params string) error {
 		cmd = fmt.Sprintf("%s -w %d %s", iptCmd, lockTimeout, params)
 	}
 
-	if _, err := platform.ExecuteCommand(cmd); err != nil {
-		return err
-	}
-
-	return nil
+	return platform.ExecuteCommand(cmd)
 }
 
 // check if iptable chain alreay exists
@@ -112,6 +123,65 @@ func CreateChain(version, tableName, chainName string) error {
 	return err
 }
 
+// delete all rules in iptable chain
+func FlushChain(version, tableName, chainName string) error {
+	params := fmt.Sprintf("-t %s -F %s", tableName, chainName)
+	return runCmd(version, params)
+}
+
+// delete empty iptable chain
+func DeleteChain(version, tableName, chainName string) error {
+	params := fmt.Sprintf("-t %s -X %s", tableName, chainName)
+	return runCmd(version, params)
+}
+
+// rename iptable chain, jumps to the chain follow it
+func RenameChain(version, tableName, chainName, newChainName string) error {
+	params := fmt.Sprintf("-t %s -E %s %s", tableName, chainName, newChainName)
+	return runCmd(version, params)
+}
+
+// list rules of all chains of iptable table, in the form printed by "iptables -S"
+func ListRules(version, tableName string) ([]string, error) {
+	params := fmt.Sprintf("-t %s -S", tableName)
+	out, err := runCmdWithOutput(version, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []string
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			rules = append(rules, line)
+		}
+	}
+
+	return rules, nil
+}
+
+// apply iptables-restore input atomically, leaving chains that the input does not declare untouched
+func Restore(version, input string) error {
+	restoreCmd := iptablesRestore
+	if version == V6 {
+		restoreCmd = ip6tablesRestore
+	}
+
+	args := []string{"--noflush"}
+	if !DisableIPTableLock {
+		args = append(args, "-w", strconv.Itoa(lockTimeout))
+	}
+
+	cmd := exec.Command(restoreCmd, args...)
+	cmd.Stdin = strings.NewReader(input)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("%s failed with error %v: %s", restoreCmd, err, string(out))
+		return fmt.Errorf("%s failed: %v", restoreCmd, err)
+	}
+
+	return nil
+}
+
 // check if iptable rule alreay exists
 func RuleExists(version, tableName, chainName, match, target string) bool {
 	params := fmt.Sprintf("-t %s -C %s %s -j %s", tableName, chainName, match, target)
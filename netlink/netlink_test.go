@@ -6,8 +6,12 @@
 package netlink
 
 import (
+	"fmt"
 	"net"
+	"runtime"
 	"testing"
+
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -259,3 +263,64 @@ func TestAddRemoveStaticArp(t *testing.T) {
 		t.Errorf("DeleteLink failed: %+v", err)
 	}
 }
+
+// TestThreadSocket tests if a thread in another network namespace uses a socket of its own, while other
+// goroutines keep using the default socket.
+func TestThreadSocket(t *testing.T) {
+	link := BridgeLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_BRIDGE,
+			Name: ifName2,
+		},
+	}
+
+	entered := make(chan error)
+	added := make(chan struct{})
+	done := make(chan error, 1)
+
+	go func() {
+		// The thread is left locked, so that it exits with the goroutine instead of returning to the host namespace.
+		runtime.LockOSThread()
+
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			entered <- err
+			return
+		}
+
+		if err := OpenThreadSocket(); err != nil {
+			entered <- err
+			return
+		}
+		defer CloseThreadSocket()
+
+		entered <- nil
+		<-added
+
+		// The interface added in the host namespace is not visible here.
+		if _, err := net.InterfaceByName(ifName2); err == nil {
+			done <- fmt.Errorf("interface %s of the host namespace is visible", ifName2)
+			return
+		}
+
+		done <- AddLink(&link)
+	}()
+
+	if err := <-entered; err != nil {
+		t.Skipf("Failed to enter a network namespace: %v", err)
+	}
+
+	err := AddLink(&link)
+	close(added)
+	if err != nil {
+		t.Fatalf("AddLink failed in the host namespace: %v", err)
+	}
+	defer DeleteLink(ifName2)
+
+	if err = <-done; err != nil {
+		t.Errorf("AddLink failed in the network namespace: %v", err)
+	}
+
+	if _, err = net.InterfaceByName(ifName2); err != nil {
+		t.Errorf("Interface %s not found in the host namespace: %v", ifName2, err)
+	}
+}
@@ -28,13 +28,21 @@ type socket struct {
 var s *socket
 var m sync.Mutex
 
-// Returns a reference to the default netlink socket.
+// Netlink sockets of threads that entered another network namespace, by thread ID. Goroutines that enter
+// a namespace are locked to their thread, so no other goroutine uses these sockets.
+var threadSockets = make(map[int][]*socket)
+
+// Returns a reference to the netlink socket of the calling thread, or the default netlink socket.
 func getSocket() (*socket, error) {
 	var err error
 
 	m.Lock()
 	defer m.Unlock()
 
+	if sockets := threadSockets[unix.Gettid()]; len(sockets) > 0 {
+		return sockets[len(sockets)-1], nil
+	}
+
 	if s == nil {
 		s, err = newSocket()
 	}
@@ -42,11 +50,57 @@ func getSocket() (*socket, error) {
 	return s, err
 }
 
+// OpenThreadSocket creates a netlink socket in the current network namespace of the calling thread, which
+// the thread uses until CloseThreadSocket is called. The caller must be locked to its thread.
+func OpenThreadSocket() error {
+	ts, err := newSocket()
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	tid := unix.Gettid()
+	threadSockets[tid] = append(threadSockets[tid], ts)
+
+	return nil
+}
+
+// CloseThreadSocket closes the netlink socket of the calling thread opened last by OpenThreadSocket.
+func CloseThreadSocket() {
+	m.Lock()
+	defer m.Unlock()
+
+	tid := unix.Gettid()
+	sockets := threadSockets[tid]
+	if len(sockets) == 0 {
+		return
+	}
+
+	sockets[len(sockets)-1].close()
+
+	if len(sockets) == 1 {
+		delete(threadSockets, tid)
+	} else {
+		threadSockets[tid] = sockets[:len(sockets)-1]
+	}
+}
+
 // ResetSocket deletes the default netlink socket.
 func ResetSocket() {
 	m.Lock()
 	defer m.Unlock()
 
+	if s != nil {
+		// Wait for pending requests before closing. Callers still holding the socket fail instead of
+		// using a reused file descriptor.
+		s.Lock()
+		s.close()
+		s.fd = -1
+		s.Unlock()
+	}
+
 	s = nil
 }
 
@@ -73,6 +127,18 @@ func newSocket() (*socket, error) {
 		return nil, err
 	}
 
+	// The kernel assigns a different port ID than the process ID if another socket already uses it.
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		unix.Close(fd)
+		log.Debugf("[netlink] Failed to get socket name, err=%v\n", err)
+		return nil, err
+	}
+
+	if nlsa, ok := sa.(*unix.SockaddrNetlink); ok {
+		s.pid = nlsa.Pid
+	}
+
 	log.Debugf("[netlink] Socket created.\n")
 	return s, nil
 }
@@ -86,6 +152,7 @@ func (s *socket) close() {
 // Sends a netlink message.
 func (s *socket) send(msg *message) error {
 	msg.Seq = atomic.AddUint32(&s.seq, 1)
+	msg.Pid = s.pid
 	err := unix.Sendto(s.fd, msg.serialize(), 0, &s.sa)
 	log.Debugf("[netlink] Sent %+v, err=%v\n", *msg, err)
 	return err
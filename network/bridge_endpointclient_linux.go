@@ -88,7 +88,7 @@ func (client *LinuxBridgeEndpointClient) AddEndpointRules(epInfo *EndpointInfo)
 			return err
 		}
 
-		if client.mode != opModeTunnel && ipAddr.IP.To4() != nil {
+		if client.mode != opModeTunnel && (ipAddr.IP.To4() != nil || epInfo.IPV6Mode == IPV6DualStack) {
 			log.Printf("[net] Adding static arp for IP address %v and MAC %v in VM", ipAddr.String(), client.containerMac.String())
 			if err := netlink.AddOrRemoveStaticArp(netlink.ADD, client.bridgeName, ipAddr.IP, client.containerMac, false); err != nil {
 				log.Printf("Failed setting arp in vm: %v", err)
@@ -126,7 +126,8 @@ func (client *LinuxBridgeEndpointClient) DeleteEndpointRules(ep *endpoint) {
 			log.Printf("[net] Failed to delete MAC DNAT rule for IP address %v: %v.", ipAddr.String(), err)
 		}
 
-		if client.mode != opModeTunnel && ipAddr.IP.To4() != nil {
+		// Static neighbor entries are added for IPv6 addresses in dual-stack mode only, so a missing one is not an error.
+		if client.mode != opModeTunnel {
 			log.Printf("[net] Removing static arp for IP address %v and MAC %v from VM", ipAddr.String(), ep.MacAddress.String())
 			err := netlink.AddOrRemoveStaticArp(netlink.REMOVE, client.bridgeName, ipAddr.IP, ep.MacAddress, false)
 			if err != nil {
@@ -240,6 +241,20 @@ func addRuleToRouteViaHost(epInfo *EndpointInfo) error {
 }
 
 func (client *LinuxBridgeEndpointClient) setupIPV6Routes(epInfo *EndpointInfo) error {
+	if epInfo.IPV6Mode == IPV6DualStack {
+		// The subnet is on-link through the bridge. Everything else goes through the Azure IPv6 gateway.
+		_, defIPNet, _ := net.ParseCIDR("::/0")
+		routes := []RouteInfo{
+			{
+				Dst: *defIPNet,
+				Gw:  net.ParseIP(defaultV6HostGw),
+			},
+		}
+
+		log.Printf("[net] Adding dual-stack ipv6 routes in container %+v", routes)
+		return addRoutes(client.containerVethName, routes)
+	}
+
 	if epInfo.IPV6Mode != "" {
 		if epInfo.VnetCidrs == "" {
 			epInfo.VnetCidrs = defaultV6VnetCidr
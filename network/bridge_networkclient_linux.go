@@ -89,7 +89,8 @@ func (client *LinuxBridgeClient) AddL2Rules(extIf *externalInterface) error {
 		return err
 	}
 
-	if client.nwInfo.IPV6Mode != "" {
+	// Native dual-stack IPv6 is bridged like IPv4 and needs no routing through the host.
+	if client.nwInfo.IPV6Mode != "" && client.nwInfo.IPV6Mode != IPV6DualStack {
 		// for ipv6 node cidr set broute accept
 		if err := ebtables.SetBrouteAcceptByCidr(&client.nwInfo.Subnets[1].Prefix, ebtables.IPV6, ebtables.Append, ebtables.Accept); err != nil {
 			return err
@@ -129,7 +130,7 @@ func (client *LinuxBridgeClient) DeleteL2Rules(extIf *externalInterface) {
 	ebtables.SetDnatForArpReplies(extIf.Name, ebtables.Delete)
 	ebtables.SetArpReply(extIf.IPAddresses[0].IP, extIf.MacAddress, ebtables.Delete)
 	ebtables.SetSnatForInterface(extIf.Name, extIf.MacAddress, ebtables.Delete)
-	if client.nwInfo.IPV6Mode != "" {
+	if client.nwInfo.IPV6Mode != "" && client.nwInfo.IPV6Mode != IPV6DualStack {
 		if len(extIf.IPAddresses) > 1 {
 			ebtables.SetBrouteAcceptByCidr(extIf.IPAddresses[1], ebtables.IPV6, ebtables.Delete, ebtables.Accept)
 		}
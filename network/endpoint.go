@@ -77,6 +77,15 @@ type EndpointInfo struct {
 	ServiceCidrs             string
 }
 
+// EndpointState is a self-contained snapshot of an endpoint and the parts of its network and
+// external interface needed to delete it. It can be persisted outside of the network manager state.
+type EndpointState struct {
+	NetworkID string
+	Mode      string
+	ExtIf     *externalInterface
+	Endpoint  *endpoint
+}
+
 // RouteInfo contains information about an IP route.
 type RouteInfo struct {
 	Dst      net.IPNet
@@ -144,6 +153,24 @@ func (nw *network) deleteEndpoint(endpointId string) error {
 	return nil
 }
 
+// getEndpointState returns a snapshot of the given endpoint of the network.
+func (nw *network) getEndpointState(ep *endpoint) *EndpointState {
+	epState := &EndpointState{
+		NetworkID: nw.Id,
+		Mode:      nw.Mode,
+		Endpoint:  ep,
+	}
+
+	// Copy the external interface without the networks it hosts.
+	if nw.extIf != nil {
+		extIf := *nw.extIf
+		extIf.Networks = nil
+		epState.ExtIf = &extIf
+	}
+
+	return epState
+}
+
 // GetEndpoint returns the endpoint with the given ID.
 func (nw *network) getEndpoint(endpointId string) (*endpoint, error) {
 	log.Printf("Trying to retrieve endpoint id %v", endpointId)
@@ -4,6 +4,7 @@
 package network
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -259,7 +260,7 @@ func (nw *network) deleteHostNCApipaEndpoint(networkContainerID string) error {
 	}
 
 	log.Printf("[net] Deleting HostNCApipaEndpoint for network container: %s", networkContainerID)
-	err = cnsClient.DeleteHostNCApipaEndpoint(networkContainerID)
+	err = cnsClient.DeleteHostNCApipaEndpoint(context.TODO(), networkContainerID)
 	log.Printf("[net] Completed HostNCApipaEndpoint deletion for network container: %s"+
 		" with error: %v", networkContainerID, err)
 
@@ -290,7 +291,7 @@ func (nw *network) createHostNCApipaEndpoint(epInfo *EndpointInfo) error {
 		epInfo.NetworkContainerID)
 
 	if hostNCApipaEndpointID, err =
-		cnsClient.CreateHostNCApipaEndpoint(epInfo.NetworkContainerID); err != nil {
+		cnsClient.CreateHostNCApipaEndpoint(context.TODO(), epInfo.NetworkContainerID); err != nil {
 		return err
 	}
 
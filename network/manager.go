@@ -4,11 +4,13 @@
 package network
 
 import (
+	"sort"
 	"sync"
 	"time"
 
 	cnms "github.com/Azure/azure-container-networking/cnms/cnmspackage"
 	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/iptables"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/platform"
 	"github.com/Azure/azure-container-networking/store"
@@ -46,6 +48,7 @@ type networkManager struct {
 	TimeStamp          time.Time
 	ExternalInterfaces map[string]*externalInterface
 	store              store.KeyValueStore
+	readOnly           bool
 	sync.Mutex
 }
 
@@ -53,6 +56,7 @@ type networkManager struct {
 type NetworkManager interface {
 	Initialize(config *common.PluginConfig) error
 	Uninitialize()
+	SetReadOnly()
 
 	AddExternalInterface(ifName string, subnet string) error
 
@@ -64,10 +68,15 @@ type NetworkManager interface {
 	DeleteEndpoint(networkId string, endpointId string) error
 	GetEndpointInfo(networkId string, endpointId string) (*EndpointInfo, error)
 	GetEndpointInfoBasedOnPODDetails(networkId string, podName string, podNameSpace string, doExactMatchForPodName bool) (*EndpointInfo, error)
+	GetAllEndpoints() map[string][]*EndpointInfo
 	AttachEndpoint(networkId string, endpointId string, sandboxKey string) (*endpoint, error)
 	DetachEndpoint(networkId string, endpointId string) error
 	UpdateEndpoint(networkId string, existingEpInfo *EndpointInfo, targetEpInfo *EndpointInfo) error
+	GetEndpointState(networkId string, endpointId string) (*EndpointState, error)
+	DeleteEndpointUsingState(epState *EndpointState) error
 	GetNumberOfEndpoints(ifName string, networkId string) int
+	SetOutboundNAT(networkId string, natInfo *OutboundNATInfo) error
+	ReconcileOutboundNAT() error
 	SetupNetworkUsingState(networkMonitor *cnms.NetworkMonitor) error
 }
 
@@ -94,6 +103,15 @@ func (nm *networkManager) Initialize(config *common.PluginConfig) error {
 func (nm *networkManager) Uninitialize() {
 }
 
+// SetReadOnly stops network manager from persisting its state. It is used when the state could not be
+// restored, so that partial state does not overwrite the persisted one.
+func (nm *networkManager) SetReadOnly() {
+	nm.Lock()
+	defer nm.Unlock()
+
+	nm.readOnly = true
+}
+
 // Restore reads network manager state from persistent store.
 func (nm *networkManager) restore() error {
 	// Skip if a store is not provided.
@@ -201,6 +219,11 @@ func (nm *networkManager) save() error {
 		return nil
 	}
 
+	if nm.readOnly {
+		log.Printf("[net] Skipping save in read-only mode.\n")
+		return nil
+	}
+
 	// Update time stamp.
 	nm.TimeStamp = time.Now()
 
@@ -391,6 +414,23 @@ func (nm *networkManager) GetEndpointInfoBasedOnPODDetails(networkID string, pod
 	return ep.getInfo(), nil
 }
 
+// GetAllEndpoints returns information about all endpoints, keyed by network ID.
+func (nm *networkManager) GetAllEndpoints() map[string][]*EndpointInfo {
+	nm.Lock()
+	defer nm.Unlock()
+
+	endpoints := make(map[string][]*EndpointInfo)
+	for _, extIf := range nm.ExternalInterfaces {
+		for _, nw := range extIf.Networks {
+			for _, ep := range nw.Endpoints {
+				endpoints[nw.Id] = append(endpoints[nw.Id], ep.getInfo())
+			}
+		}
+	}
+
+	return endpoints
+}
+
 // AttachEndpoint attaches an endpoint to a sandbox.
 func (nm *networkManager) AttachEndpoint(networkId string, endpointId string, sandboxKey string) (*endpoint, error) {
 	nm.Lock()
@@ -470,6 +510,144 @@ func (nm *networkManager) UpdateEndpoint(networkID string, existingEpInfo *Endpo
 	return nil
 }
 
+// GetEndpointState returns a self-contained snapshot of the given endpoint.
+func (nm *networkManager) GetEndpointState(networkId string, endpointId string) (*EndpointState, error) {
+	nm.Lock()
+	defer nm.Unlock()
+
+	nw, err := nm.getNetwork(networkId)
+	if err != nil {
+		return nil, err
+	}
+
+	ep, err := nw.getEndpoint(endpointId)
+	if err != nil {
+		return nil, err
+	}
+
+	return nw.getEndpointState(ep), nil
+}
+
+// DeleteEndpointUsingState deletes an endpoint described by a snapshot taken with GetEndpointState.
+// If the endpoint is still in the network manager state, it is deleted as usual. Otherwise only the
+// host-side resources recorded in the snapshot are removed.
+func (nm *networkManager) DeleteEndpointUsingState(epState *EndpointState) error {
+	nm.Lock()
+	defer nm.Unlock()
+
+	if epState == nil || epState.Endpoint == nil {
+		return errEndpointNotFound
+	}
+
+	if nw, err := nm.getNetwork(epState.NetworkID); err == nil {
+		if _, err = nw.getEndpoint(epState.Endpoint.Id); err == nil {
+			if err = nw.deleteEndpoint(epState.Endpoint.Id); err != nil {
+				return err
+			}
+
+			return nm.save()
+		}
+	}
+
+	log.Printf("[net] Endpoint %v not found in state, deleting it using snapshot.", epState.Endpoint.Id)
+
+	extIf := epState.ExtIf
+	if extIf == nil {
+		extIf = &externalInterface{}
+	}
+
+	nw := &network{
+		Id:        epState.NetworkID,
+		Mode:      epState.Mode,
+		Endpoints: make(map[string]*endpoint),
+		extIf:     extIf,
+	}
+
+	return nw.deleteEndpointImpl(epState.Endpoint)
+}
+
+// SetOutboundNAT sets the outbound NAT of a network and reprograms the outbound NAT of all networks.
+// A nil natInfo removes the outbound NAT of the network.
+func (nm *networkManager) SetOutboundNAT(networkId string, natInfo *OutboundNATInfo) error {
+	nm.Lock()
+	defer nm.Unlock()
+
+	wasEnabled := len(nm.getOutboundNATNetworks()) != 0
+
+	if nw, err := nm.getNetwork(networkId); err == nil {
+		nw.OutboundNAT = natInfo
+		if err = nm.save(); err != nil {
+			return err
+		}
+	}
+
+	return nm.reconcileOutboundNAT(wasEnabled)
+}
+
+// ReconcileOutboundNAT reprograms the outbound NAT of all networks from the persisted state.
+// Nothing is done if no network has outbound NAT.
+func (nm *networkManager) ReconcileOutboundNAT() error {
+	nm.Lock()
+	defer nm.Unlock()
+
+	return nm.reconcileOutboundNAT(false)
+}
+
+// reconcileOutboundNAT programs the outbound NAT rules of the endpoints of all networks. The chains are removed
+// if outbound NAT was enabled and no network has it anymore.
+func (nm *networkManager) reconcileOutboundNAT(wasEnabled bool) error {
+	rules, enabled := nm.getOutboundNATRules()
+	if !enabled && !wasEnabled {
+		return nil
+	}
+
+	return programOutboundNAT(rules, enabled)
+}
+
+// getOutboundNATNetworks returns the networks with outbound NAT, sorted by ID.
+func (nm *networkManager) getOutboundNATNetworks() []*network {
+	var networks []*network
+	for _, extIf := range nm.ExternalInterfaces {
+		for _, nw := range extIf.Networks {
+			if nw.OutboundNAT != nil {
+				networks = append(networks, nw)
+			}
+		}
+	}
+
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Id < networks[j].Id })
+
+	return networks
+}
+
+// getOutboundNATRules compiles the outbound NAT chains of all networks per iptables version, and returns whether any
+// network has outbound NAT. Networks are compiled in a stable order, so that unchanged rules are not rewritten.
+func (nm *networkManager) getOutboundNATRules() (map[string]natChains, bool) {
+	networks := nm.getOutboundNATNetworks()
+
+	rules := make(map[string]natChains)
+	for _, nw := range networks {
+		var endpoints []*EndpointInfo
+		for _, ep := range nw.Endpoints {
+			endpoints = append(endpoints, ep.getInfo())
+		}
+
+		for version, nwChains := range compileOutboundNATRules(nw.Id, nw.OutboundNAT, nw.Subnets, endpoints) {
+			chains := rules[version]
+			if chains == nil {
+				chains = natChains{iptables.CNIPostroutingChain: getOutboundNATHeaderRules()}
+				rules[version] = chains
+			}
+
+			for chainName, chainRules := range nwChains {
+				chains.add(chainName, chainRules...)
+			}
+		}
+	}
+
+	return rules, len(networks) != 0
+}
+
 func (nm *networkManager) GetNumberOfEndpoints(ifName string, networkId string) int {
 	if ifName == "" {
 		for key := range nm.ExternalInterfaces {
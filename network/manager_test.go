@@ -0,0 +1,39 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/store"
+)
+
+// TestSaveReadOnly tests if a read-only network manager keeps the persisted state.
+func TestSaveReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "network-manager")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	kvs, err := store.NewJsonFileStore(filepath.Join(dir, "azure-vnet.json"))
+	if err != nil {
+		t.Fatalf("Failed to create store %v", err)
+	}
+
+	nm := &networkManager{ExternalInterfaces: make(map[string]*externalInterface), store: kvs}
+	nm.SetReadOnly()
+
+	if err = nm.save(); err != nil {
+		t.Fatalf("save failed %v", err)
+	}
+
+	var restored networkManager
+	if err = kvs.Read(storeKey, &restored); err != store.ErrKeyNotFound {
+		t.Fatalf("Read-only network manager saved its state, err %v", err)
+	}
+}
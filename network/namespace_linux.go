@@ -6,6 +6,7 @@ package network
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 
 	"github.com/Azure/azure-container-networking/netlink"
@@ -13,6 +14,9 @@ import (
 	"golang.org/x/sys/unix"
 )
 
+// Directory of named network namespaces, as used by iproute2.
+const namedNamespaceDir = "/var/run/netns"
+
 // Namespace represents a network namespace.
 type Namespace struct {
 	file   *os.File
@@ -83,8 +87,14 @@ func (ns *Namespace) Enter() error {
 		return err
 	}
 
-	// Recycle the netlink socket for the new network namespace.
-	netlink.ResetSocket()
+	// Use a netlink socket of the new network namespace on this thread. Other goroutines keep using
+	// the default socket.
+	err = netlink.OpenThreadSocket()
+	if err != nil {
+		ns.prevNs.set()
+		runtime.UnlockOSThread()
+		return err
+	}
 
 	return nil
 }
@@ -99,10 +109,95 @@ func (ns *Namespace) Exit() error {
 	ns.prevNs.Close()
 	ns.prevNs = nil
 
+	// Return to the netlink socket of the previous network namespace.
+	netlink.CloseThreadSocket()
+
 	runtime.UnlockOSThread()
 
-	// Recycle the netlink socket for the new network namespace.
-	netlink.ResetSocket()
+	return nil
+}
+
+// GetNamedNamespacePath returns the path of a named network namespace.
+func GetNamedNamespacePath(name string) string {
+	return filepath.Join(namedNamespaceDir, name)
+}
+
+// NewNamedNamespace creates a network namespace that persists without processes. It is bind mounted
+// under /var/run/netns, where "ip netns" finds it. The existing namespace is opened if there is one.
+func NewNamedNamespace(name string) (*Namespace, error) {
+	nsPath := GetNamedNamespacePath(name)
+
+	var stat unix.Statfs_t
+	if err := unix.Statfs(nsPath, &stat); err == nil && stat.Type == unix.NSFS_MAGIC {
+		return OpenNamespace(nsPath)
+	}
+
+	if err := os.MkdirAll(namedNamespaceDir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Create the mount point. A file left by an earlier failed attempt is reused.
+	file, err := os.OpenFile(nsPath, os.O_RDONLY|os.O_CREATE, 0444)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	// Create the namespace on a thread of its own, which is left locked if it fails to return
+	// to its namespace, so that it exits instead of serving other goroutines.
+	errChan := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		prevNs, err := GetCurrentThreadNamespace()
+		if err != nil {
+			runtime.UnlockOSThread()
+			errChan <- err
+			return
+		}
+		defer prevNs.Close()
+
+		if err = unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			errChan <- fmt.Errorf("Failed to create namespace %v, err:%v", name, err)
+			return
+		}
+
+		threadNsPath := fmt.Sprintf("/proc/%d/task/%d/ns/net", os.Getpid(), unix.Gettid())
+		err = unix.Mount(threadNsPath, nsPath, "none", unix.MS_BIND, "")
+		if err != nil {
+			err = fmt.Errorf("Failed to mount namespace %v, err:%v", name, err)
+		}
+
+		if setErr := prevNs.set(); setErr != nil {
+			errChan <- setErr
+			return
+		}
+
+		runtime.UnlockOSThread()
+		errChan <- err
+	}()
+
+	if err = <-errChan; err != nil {
+		os.Remove(nsPath)
+		return nil, err
+	}
+
+	return OpenNamespace(nsPath)
+}
+
+// DeleteNamedNamespace deletes a named network namespace. The namespace is destroyed once
+// it has no interfaces in use, processes and open handles left.
+func DeleteNamedNamespace(name string) error {
+	nsPath := GetNamedNamespacePath(name)
+
+	if err := unix.Unmount(nsPath, unix.MNT_DETACH); err != nil && err != unix.EINVAL && err != unix.ENOENT {
+		return fmt.Errorf("Failed to unmount namespace %v, err:%v", name, err)
+	}
+
+	if err := os.Remove(nsPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 
 	return nil
 }
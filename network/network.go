@@ -23,6 +23,8 @@ const (
 const (
 	// ipv6 modes
 	IPV6Nat = "ipv6nat"
+	// Native IPv6 addresses from the VNET, allocated along with IPv4 by the same IPAM.
+	IPV6DualStack = "dualstack"
 )
 
 // ExternalInterface is a host network interface that bridges containers to external networks.
@@ -52,6 +54,7 @@ type network struct {
 	EnableSnatOnHost bool
 	NetNs            string
 	SnatBridgeIP     string
+	OutboundNAT      *OutboundNATInfo `json:",omitempty"`
 }
 
 // NetworkInfo contains read-only information about a container network.
@@ -0,0 +1,307 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/iptables"
+)
+
+const (
+	// Prefix of the chains that hold the outbound NAT rules of a network or of a namespace of a network.
+	outboundNATPolicyChainPrefix = "AZURECNI-NAT-"
+
+	// Length of the hash of the network and namespace in the name of a policy chain.
+	outboundNATPolicyChainHashLength = 8
+
+	// Mark of the packets that kube-proxy masquerades in KUBE-POSTROUTING, e.g. hairpin and service traffic.
+	kubeMasqueradeMark = "0x4000/0x4000"
+)
+
+// OutboundNATPolicy describes how traffic leaving a pod is translated.
+type OutboundNATPolicy struct {
+	// Destinations that are reached without NAT.
+	NonMasqueradeCIDRs []net.IPNet
+	// Fixed source address to SNAT to. Traffic is masqueraded if nil.
+	SnatIP net.IP
+	// Skips NAT entirely.
+	DisableSnat bool
+}
+
+// OutboundNATInfo describes the outbound NAT of the endpoints in a network.
+// Namespace policies add non-masquerade CIDRs to the network policy and override its SNAT target.
+type OutboundNATInfo struct {
+	OutboundNATPolicy
+	NamespacePolicies map[string]OutboundNATPolicy
+}
+
+// natRule is a rule in an outbound NAT chain.
+type natRule struct {
+	match  string
+	target string
+}
+
+// String returns the rule in the form printed by "iptables -S".
+func (rule natRule) String() string {
+	if rule.match == "" {
+		return fmt.Sprintf("-j %s", rule.target)
+	}
+
+	return fmt.Sprintf("%s -j %s", rule.match, rule.target)
+}
+
+// natChains holds the rules of the outbound NAT chains of an iptables version by chain name.
+// The main chain is jumped to from POSTROUTING, and jumps to the policy chains by source address.
+type natChains map[string][]natRule
+
+// add appends rules to a chain, creating the chain if needed.
+func (chains natChains) add(chainName string, rules ...natRule) {
+	chains[chainName] = append(chains[chainName], rules...)
+}
+
+// getIPTablesVersion returns the iptables version handling the given address.
+func getIPTablesVersion(ip net.IP) string {
+	if ip.To4() != nil {
+		return iptables.V4
+	}
+
+	return iptables.V6
+}
+
+// getHostCIDR returns the single address CIDR of the given address, as printed by iptables.
+func getHostCIDR(ip net.IP) string {
+	if ip.To4() != nil {
+		return fmt.Sprintf("%s/32", ip.String())
+	}
+
+	return fmt.Sprintf("%s/128", ip.String())
+}
+
+// getNetworkCIDR returns the CIDR of the network of the given prefix, as printed by iptables.
+func getNetworkCIDR(prefix net.IPNet) string {
+	return (&net.IPNet{IP: prefix.IP.Mask(prefix.Mask), Mask: prefix.Mask}).String()
+}
+
+// getOutboundNATPolicyChainName returns the name of the policy chain of a network, or of a namespace of a network.
+func getOutboundNATPolicyChainName(networkId string, namespace string) string {
+	hash := sha1.Sum([]byte(networkId + "/" + namespace))
+	return outboundNATPolicyChainPrefix + hex.EncodeToString(hash[:])[:outboundNATPolicyChainHashLength]
+}
+
+// isOutboundNATChain returns whether a chain is managed by the outbound NAT.
+func isOutboundNATChain(chainName string) bool {
+	return chainName == iptables.CNIPostroutingChain || strings.HasPrefix(chainName, outboundNATPolicyChainPrefix)
+}
+
+// getOutboundNATHeaderRules returns the rules at the start of the main chain. Packets marked for masquerade by
+// kube-proxy return to POSTROUTING, so that KUBE-POSTROUTING still translates them. Packets of the host itself
+// are left alone.
+func getOutboundNATHeaderRules() []natRule {
+	return []natRule{
+		{match: fmt.Sprintf("-m mark --mark %s", kubeMasqueradeMark), target: iptables.Return},
+		{match: "-m addrtype --src-type LOCAL", target: iptables.Return},
+	}
+}
+
+// compileOutboundNATPolicy compiles a policy into the rules of its chain for the given iptables version.
+// Exclusions come before the SNAT target so that the first matching rule decides. Excluded traffic is
+// accepted, which ends the POSTROUTING chain before any later masquerade rule of the host.
+func compileOutboundNATPolicy(policy OutboundNATPolicy, version string) []natRule {
+	if policy.DisableSnat {
+		return []natRule{{target: iptables.Accept}}
+	}
+
+	// Traffic to the node itself and to the non-masquerade CIDRs is never translated.
+	rules := []natRule{{match: "-m addrtype --dst-type LOCAL", target: iptables.Accept}}
+
+	for _, cidr := range policy.NonMasqueradeCIDRs {
+		if getIPTablesVersion(cidr.IP) == version {
+			rules = append(rules, natRule{match: fmt.Sprintf("-d %s", getNetworkCIDR(cidr)), target: iptables.Accept})
+		}
+	}
+
+	if policy.SnatIP != nil && getIPTablesVersion(policy.SnatIP) == version {
+		rules = append(rules, natRule{target: fmt.Sprintf("%s --to-source %s", iptables.Snat, policy.SnatIP.String())})
+	} else {
+		rules = append(rules, natRule{target: iptables.Masquerade})
+	}
+
+	return rules
+}
+
+// getNamespacePolicy returns the effective policy of a namespace, which adds non-masquerade CIDRs to the policy
+// of the network and overrides its SNAT target.
+func getNamespacePolicy(natInfo *OutboundNATInfo, nsPolicy OutboundNATPolicy) OutboundNATPolicy {
+	policy := OutboundNATPolicy{
+		SnatIP:      natInfo.SnatIP,
+		DisableSnat: nsPolicy.DisableSnat,
+	}
+
+	if nsPolicy.SnatIP != nil {
+		policy.SnatIP = nsPolicy.SnatIP
+	}
+
+	policy.NonMasqueradeCIDRs = append(policy.NonMasqueradeCIDRs, nsPolicy.NonMasqueradeCIDRs...)
+	policy.NonMasqueradeCIDRs = append(policy.NonMasqueradeCIDRs, natInfo.NonMasqueradeCIDRs...)
+
+	return policy
+}
+
+// compileOutboundNATRules compiles the outbound NAT of a network into chains per iptables version. The main chain
+// jumps to the policy chain of the network by subnet, so that the number of rules does not grow with the number
+// of endpoints. Only endpoints in namespaces with a policy of their own get a rule, which comes first and jumps to
+// the policy chain of their namespace. The main chain rules of a version are returned without the header rules.
+func compileOutboundNATRules(networkId string, natInfo *OutboundNATInfo, subnets []SubnetInfo, endpoints []*EndpointInfo) map[string]natChains {
+	rules := make(map[string]natChains)
+	getChains := func(version string) natChains {
+		if rules[version] == nil {
+			rules[version] = make(natChains)
+		}
+		return rules[version]
+	}
+
+	sorted := make([]*EndpointInfo, len(endpoints))
+	copy(sorted, endpoints)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id < sorted[j].Id })
+
+	for _, epInfo := range sorted {
+		nsPolicy, ok := natInfo.NamespacePolicies[epInfo.PODNameSpace]
+		if !ok {
+			continue
+		}
+
+		chainName := getOutboundNATPolicyChainName(networkId, epInfo.PODNameSpace)
+		for _, address := range epInfo.IPAddresses {
+			version := getIPTablesVersion(address.IP)
+			chains := getChains(version)
+
+			if _, ok := chains[chainName]; !ok {
+				chains.add(chainName, compileOutboundNATPolicy(getNamespacePolicy(natInfo, nsPolicy), version)...)
+			}
+
+			chains.add(iptables.CNIPostroutingChain, natRule{match: fmt.Sprintf("-s %s", getHostCIDR(address.IP)), target: chainName})
+		}
+	}
+
+	chainName := getOutboundNATPolicyChainName(networkId, "")
+	for _, subnet := range subnets {
+		version := getIPTablesVersion(subnet.Prefix.IP)
+		chains := getChains(version)
+
+		if _, ok := chains[chainName]; !ok {
+			chains.add(chainName, compileOutboundNATPolicy(natInfo.OutboundNATPolicy, version)...)
+		}
+
+		chains.add(iptables.CNIPostroutingChain, natRule{match: fmt.Sprintf("-s %s", getNetworkCIDR(subnet.Prefix)), target: chainName})
+	}
+
+	return rules
+}
+
+// outboundNATState is the state of the outbound NAT chains of an iptables version, as listed by "iptables -S".
+type outboundNATState struct {
+	chains  map[string][]string
+	hasJump bool
+}
+
+// parseOutboundNATState parses the outbound NAT chains and the jump to them from the rules of the nat table.
+func parseOutboundNATState(lines []string) outboundNATState {
+	state := outboundNATState{chains: make(map[string][]string)}
+	jump := fmt.Sprintf("-A %s -j %s", iptables.Postrouting, iptables.CNIPostroutingChain)
+
+	for _, line := range lines {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch {
+		case strings.TrimSpace(line) == jump:
+			state.hasJump = true
+		case fields[0] == "-N" && isOutboundNATChain(fields[1]):
+			if _, ok := state.chains[fields[1]]; !ok {
+				state.chains[fields[1]] = []string{}
+			}
+		case fields[0] == "-A" && isOutboundNATChain(fields[1]) && len(fields) == 3:
+			state.chains[fields[1]] = append(state.chains[fields[1]], fields[2])
+		}
+	}
+
+	return state
+}
+
+// equals returns whether the state holds exactly the given chains, and the jump to them if there are any.
+func (state outboundNATState) equals(chains natChains) bool {
+	if len(state.chains) != len(chains) || state.hasJump != (len(chains) != 0) {
+		return false
+	}
+
+	for chainName, rules := range chains {
+		current, ok := state.chains[chainName]
+		if !ok || len(current) != len(rules) {
+			return false
+		}
+
+		for i, rule := range rules {
+			if current[i] != rule.String() {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// getOutboundNATRestore returns the iptables-restore input that turns the given state into the given chains,
+// all in a single transaction of the nat table. Declaring a chain creates it, or flushes it if it exists.
+// Chains that are no longer needed are deleted, and without chains the jump from POSTROUTING is removed.
+func getOutboundNATRestore(state outboundNATState, chains natChains) string {
+	var names []string
+	for chainName := range chains {
+		names = append(names, chainName)
+	}
+
+	var stale []string
+	for chainName := range state.chains {
+		if _, ok := chains[chainName]; !ok {
+			stale = append(stale, chainName)
+		}
+	}
+
+	sort.Strings(names)
+	sort.Strings(stale)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s\n", iptables.Nat)
+
+	for _, chainName := range append(append([]string{}, names...), stale...) {
+		fmt.Fprintf(&b, ":%s - [0:0]\n", chainName)
+	}
+
+	if len(chains) != 0 && !state.hasJump {
+		fmt.Fprintf(&b, "-I %s 1 -j %s\n", iptables.Postrouting, iptables.CNIPostroutingChain)
+	} else if len(chains) == 0 && state.hasJump {
+		fmt.Fprintf(&b, "-D %s -j %s\n", iptables.Postrouting, iptables.CNIPostroutingChain)
+	}
+
+	for _, chainName := range names {
+		for _, rule := range chains[chainName] {
+			fmt.Fprintf(&b, "-A %s %s\n", chainName, rule.String())
+		}
+	}
+
+	for _, chainName := range stale {
+		fmt.Fprintf(&b, "-X %s\n", chainName)
+	}
+
+	b.WriteString("COMMIT\n")
+
+	return b.String()
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"github.com/Azure/azure-container-networking/iptables"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// programOutboundNAT makes the outbound NAT chains of each iptables version hold the given rules.
+// The chains are rewritten in one iptables-restore transaction, and only if their rules differ. The chains of both
+// versions are removed if outbound NAT is not enabled. ip6tables is left alone on nodes without IPv6 rules.
+func programOutboundNAT(rules map[string]natChains, enabled bool) error {
+	for _, version := range []string{iptables.V4, iptables.V6} {
+		chains := rules[version]
+		if enabled && len(chains) == 0 && version == iptables.V6 {
+			continue
+		}
+
+		if err := reconcileOutboundNATChains(version, chains); err != nil {
+			if !enabled {
+				log.Printf("[net] Failed to remove outbound NAT chains for IPv%s: %v", version, err)
+				continue
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reconcileOutboundNATChains makes the outbound NAT chains of the given iptables version hold exactly the given rules.
+func reconcileOutboundNATChains(version string, chains natChains) error {
+	lines, err := iptables.ListRules(version, iptables.Nat)
+	if err != nil {
+		log.Printf("[net] Failed to list nat rules for IPv%s: %v", version, err)
+		return err
+	}
+
+	state := parseOutboundNATState(lines)
+	if state.equals(chains) {
+		return nil
+	}
+
+	log.Printf("[net] Reconciling outbound NAT for IPv%s with %d chains.", version, len(chains))
+
+	if err = iptables.Restore(version, getOutboundNATRestore(state, chains)); err != nil {
+		log.Printf("[net] Failed to restore outbound NAT chains for IPv%s: %v", version, err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/iptables"
+)
+
+func parseCIDRs(cidrs ...string) []net.IPNet {
+	var ipNets []net.IPNet
+	for _, cidr := range cidrs {
+		ip, ipNet, _ := net.ParseCIDR(cidr)
+		ipNet.IP = ip
+		ipNets = append(ipNets, *ipNet)
+	}
+	return ipNets
+}
+
+func parseSubnets(cidrs ...string) []SubnetInfo {
+	var subnets []SubnetInfo
+	for _, ipNet := range parseCIDRs(cidrs...) {
+		subnets = append(subnets, SubnetInfo{Prefix: ipNet})
+	}
+	return subnets
+}
+
+func getRuleSpecs(rules []natRule) []string {
+	var specs []string
+	for _, rule := range rules {
+		specs = append(specs, rule.String())
+	}
+	return specs
+}
+
+func TestCompileOutboundNATRules(t *testing.T) {
+	_, peered, _ := net.ParseCIDR("10.1.0.0/16")
+	_, onprem, _ := net.ParseCIDR("172.16.0.0/12")
+
+	natInfo := &OutboundNATInfo{
+		OutboundNATPolicy: OutboundNATPolicy{NonMasqueradeCIDRs: []net.IPNet{*peered}},
+		NamespacePolicies: map[string]OutboundNATPolicy{
+			"infra":  {NonMasqueradeCIDRs: []net.IPNet{*onprem}, SnatIP: net.ParseIP("10.0.0.100")},
+			"system": {DisableSnat: true},
+		},
+	}
+
+	endpoints := []*EndpointInfo{
+		{Id: "ep3", PODNameSpace: "system", IPAddresses: parseCIDRs("10.0.0.7/24")},
+		{Id: "ep1", PODNameSpace: "default", IPAddresses: parseCIDRs("10.0.0.5/24")},
+		{Id: "ep2", PODNameSpace: "infra", IPAddresses: parseCIDRs("10.0.0.6/24")},
+		{Id: "ep4", PODNameSpace: "default", IPAddresses: parseCIDRs("10.0.0.8/24")},
+	}
+
+	nwChain := getOutboundNATPolicyChainName("nw1", "")
+	infraChain := getOutboundNATPolicyChainName("nw1", "infra")
+	systemChain := getOutboundNATPolicyChainName("nw1", "system")
+
+	// Only endpoints in namespaces with a policy get a rule of their own. The others match the subnet rule.
+	expected := map[string][]string{
+		iptables.CNIPostroutingChain: {
+			"-s 10.0.0.6/32 -j " + infraChain,
+			"-s 10.0.0.7/32 -j " + systemChain,
+			"-s 10.0.0.0/24 -j " + nwChain,
+		},
+		nwChain: {
+			"-m addrtype --dst-type LOCAL -j ACCEPT",
+			"-d 10.1.0.0/16 -j ACCEPT",
+			"-j MASQUERADE",
+		},
+		infraChain: {
+			"-m addrtype --dst-type LOCAL -j ACCEPT",
+			"-d 172.16.0.0/12 -j ACCEPT",
+			"-d 10.1.0.0/16 -j ACCEPT",
+			"-j SNAT --to-source 10.0.0.100",
+		},
+		systemChain: {
+			"-j ACCEPT",
+		},
+	}
+
+	rules := compileOutboundNATRules("nw1", natInfo, parseSubnets("10.0.0.1/24"), endpoints)
+
+	specs := make(map[string][]string)
+	for chainName, chainRules := range rules[iptables.V4] {
+		specs[chainName] = getRuleSpecs(chainRules)
+	}
+
+	if !reflect.DeepEqual(specs, expected) {
+		t.Errorf("Unexpected rules\n got: %q\nwant: %q", specs, expected)
+	}
+
+	if len(rules[iptables.V6]) != 0 {
+		t.Errorf("Expected no IPv6 rules but got %v", rules[iptables.V6])
+	}
+}
+
+func TestOutboundNATRulesOfAllNetworks(t *testing.T) {
+	_, peered, _ := net.ParseCIDR("10.1.0.0/16")
+
+	newNetwork := func(id string, natInfo *OutboundNATInfo, subnet string, epId string, address string) *network {
+		return &network{
+			Id:          id,
+			Subnets:     parseSubnets(subnet),
+			OutboundNAT: natInfo,
+			Endpoints:   map[string]*endpoint{epId: {Id: epId, IPAddresses: parseCIDRs(address)}},
+		}
+	}
+
+	nm := &networkManager{
+		ExternalInterfaces: map[string]*externalInterface{
+			"eth0": {
+				Networks: map[string]*network{
+					"nw2": newNetwork("nw2", &OutboundNATInfo{OutboundNATPolicy: OutboundNATPolicy{DisableSnat: true}}, "10.0.1.0/24", "ep2", "10.0.1.5/24"),
+					"nw1": newNetwork("nw1", &OutboundNATInfo{OutboundNATPolicy: OutboundNATPolicy{NonMasqueradeCIDRs: []net.IPNet{*peered}}}, "10.0.0.0/24", "ep1", "10.0.0.5/24"),
+					"nw3": newNetwork("nw3", nil, "10.0.2.0/24", "ep3", "10.0.2.5/24"),
+				},
+			},
+		},
+	}
+
+	// Packets marked for masquerade by kube-proxy and packets of the host return to POSTROUTING first.
+	// The rules of a network do not apply to the subnets of other networks.
+	expected := []string{
+		"-m mark --mark 0x4000/0x4000 -j RETURN",
+		"-m addrtype --src-type LOCAL -j RETURN",
+		"-s 10.0.0.0/24 -j " + getOutboundNATPolicyChainName("nw1", ""),
+		"-s 10.0.1.0/24 -j " + getOutboundNATPolicyChainName("nw2", ""),
+	}
+
+	rules, enabled := nm.getOutboundNATRules()
+	if specs := getRuleSpecs(rules[iptables.V4][iptables.CNIPostroutingChain]); !enabled || !reflect.DeepEqual(specs, expected) {
+		t.Errorf("Unexpected rules %q enabled %v", specs, enabled)
+	}
+
+	if len(rules[iptables.V4]) != 3 || len(rules[iptables.V6]) != 0 {
+		t.Errorf("Unexpected chains %v", rules)
+	}
+
+	nm.ExternalInterfaces["eth0"].Networks["nw1"].OutboundNAT = nil
+	nm.ExternalInterfaces["eth0"].Networks["nw2"].OutboundNAT = nil
+
+	if _, enabled = nm.getOutboundNATRules(); enabled {
+		t.Errorf("Outbound NAT is enabled without networks with outbound NAT")
+	}
+}
+
+func TestCompileOutboundNATRulesDualStack(t *testing.T) {
+	natInfo := &OutboundNATInfo{
+		OutboundNATPolicy: OutboundNATPolicy{SnatIP: net.ParseIP("10.0.0.100")},
+	}
+
+	endpoints := []*EndpointInfo{
+		{Id: "ep1", IPAddresses: parseCIDRs("10.0.0.5/24", "fd00::5/64")},
+	}
+
+	chainName := getOutboundNATPolicyChainName("nw1", "")
+	rules := compileOutboundNATRules("nw1", natInfo, parseSubnets("10.0.0.1/24", "fd00::1/64"), endpoints)
+
+	// The IPv4 SNAT IP does not apply to IPv6 traffic, which is masqueraded.
+	v4Rules := rules[iptables.V4][chainName]
+	if last := v4Rules[len(v4Rules)-1].String(); last != "-j SNAT --to-source 10.0.0.100" {
+		t.Errorf("Unexpected IPv4 target %v", last)
+	}
+
+	v6Rules := rules[iptables.V6][chainName]
+	if last := v6Rules[len(v6Rules)-1].String(); last != "-j MASQUERADE" {
+		t.Errorf("Unexpected IPv6 target %v", last)
+	}
+
+	if specs := getRuleSpecs(rules[iptables.V6][iptables.CNIPostroutingChain]); !reflect.DeepEqual(specs, []string{"-s fd00::/64 -j " + chainName}) {
+		t.Errorf("Unexpected IPv6 rules %q", specs)
+	}
+}
+
+func TestOutboundNATRestore(t *testing.T) {
+	nwChain := getOutboundNATPolicyChainName("nw1", "")
+	staleChain := getOutboundNATPolicyChainName("nw2", "")
+
+	chains := natChains{
+		iptables.CNIPostroutingChain: {{match: "-s 10.0.0.0/24", target: nwChain}},
+		nwChain:                      {{target: iptables.Masquerade}},
+	}
+
+	lines := []string{
+		"-P POSTROUTING ACCEPT",
+		"-N KUBE-POSTROUTING",
+		"-N " + iptables.CNIPostroutingChain,
+		"-N " + nwChain,
+		"-N " + staleChain,
+		"-A POSTROUTING -m comment --comment \"kubernetes postrouting rules\" -j KUBE-POSTROUTING",
+		"-A POSTROUTING -j " + iptables.CNIPostroutingChain,
+		"-A " + iptables.CNIPostroutingChain + " -s 10.0.0.0/24 -j " + nwChain,
+		"-A " + nwChain + " -j MASQUERADE",
+	}
+
+	state := parseOutboundNATState(lines)
+	if state.equals(chains) {
+		t.Errorf("State with a stale chain equals the desired chains")
+	}
+
+	// The stale chain is declared, which flushes it, and deleted in the same transaction.
+	expected := strings.Join([]string{
+		"*nat",
+		":" + nwChain + " - [0:0]",
+		":" + iptables.CNIPostroutingChain + " - [0:0]",
+		":" + staleChain + " - [0:0]",
+		"-A " + nwChain + " -j MASQUERADE",
+		"-A " + iptables.CNIPostroutingChain + " -s 10.0.0.0/24 -j " + nwChain,
+		"-X " + staleChain,
+		"COMMIT",
+		"",
+	}, "\n")
+
+	if input := getOutboundNATRestore(state, chains); input != expected {
+		t.Errorf("Unexpected restore input\n got: %q\nwant: %q", input, expected)
+	}
+
+	if state = parseOutboundNATState(lines[:len(lines)-1]); state.equals(chains) {
+		t.Errorf("State with a missing rule equals the desired chains")
+	}
+
+	state = parseOutboundNATState(append(lines[:4], lines[5:]...))
+	if !state.equals(chains) {
+		t.Errorf("Programmed state does not equal the desired chains")
+	}
+
+	// Removing the chains also removes the jump to them.
+	input := getOutboundNATRestore(state, nil)
+	if !strings.Contains(input, "-D POSTROUTING -j "+iptables.CNIPostroutingChain+"\n") || !strings.Contains(input, "-X "+nwChain+"\n") {
+		t.Errorf("Unexpected restore input %q", input)
+	}
+
+	if input = getOutboundNATRestore(parseOutboundNATState(nil), chains); !strings.Contains(input, "-I POSTROUTING 1 -j "+iptables.CNIPostroutingChain+"\n") {
+		t.Errorf("Restore input %q does not add the jump", input)
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// programOutboundNAT is not supported on Windows, where outbound NAT is an HNS endpoint policy.
+func programOutboundNAT(rules map[string]natChains, enabled bool) error {
+	if enabled {
+		log.Printf("[net] Outbound NAT configuration is not supported on Windows, ignoring.")
+	}
+
+	return nil
+}
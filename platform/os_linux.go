@@ -21,6 +21,8 @@ const (
 	CNMRuntimePath = "/var/lib/azure-network/"
 	// CNIRuntimePath is the path where CNI state files are stored.
 	CNIRuntimePath = "/var/run/"
+	// CNIResultCachePath is the path where CNI ADD results are cached for DEL.
+	CNIResultCachePath = "/var/lib/azure-network/results/"
 	// CNSRuntimePath is the path where CNS state files are stored.
 	CNSRuntimePath = "/var/run/"
 	// CNI runtime path on a Kubernetes cluster
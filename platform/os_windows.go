@@ -21,6 +21,10 @@ const (
 	// CNIRuntimePath is the path where CNI state files are stored.
 	CNIRuntimePath = ""
 
+	// CNIResultCachePath is the path where CNI ADD results are cached for DEL.
+	// It is absolute since the CNI plugin runs in the working directory of its caller.
+	CNIResultCachePath = "C:\\k\\azurecni\\results\\"
+
 	// CNI runtime path on a Kubernetes cluster
 	K8SCNIRuntimePath = "C:\\k\\azurecni\\bin"
 
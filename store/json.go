@@ -20,6 +20,9 @@ const (
 	// Extension added to the file name for lock.
 	lockExtension = ".lock"
 
+	// Extension added to the file name while it is written.
+	tempExtension = ".tmp"
+
 	// Maximum number of retries before failing a lock call.
 	lockMaxRetries = 200
 
@@ -108,22 +111,34 @@ func (kvs *jsonFileStore) Flush() error {
 }
 
 // Lock-free flush for internal callers.
+// The file is replaced atomically, so that it is never left truncated if the process stops while writing.
 func (kvs *jsonFileStore) flush() error {
-	file, err := os.Create(kvs.fileName)
+	buf, err := json.MarshalIndent(&kvs.data, "", "\t")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	buf, err := json.MarshalIndent(&kvs.data, "", "\t")
+	tempName := kvs.fileName + tempExtension
+	file, err := os.Create(tempName)
 	if err != nil {
 		return err
 	}
 
-	if _, err := file.Write(buf); err != nil {
+	_, err = file.Write(buf)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tempName)
 		return err
 	}
-	return nil
+
+	return os.Rename(tempName, kvs.fileName)
 }
 
 // Lock locks the store for exclusive access.
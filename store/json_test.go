@@ -4,6 +4,7 @@
 package store
 
 import (
+	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
@@ -206,3 +207,32 @@ func TestLockingStoreGivesExclusiveAccess(t *testing.T) {
 	// Cleanup.
 	os.Remove(testFileName)
 }
+
+// Tests that flushing replaces the file without leaving a temporary file behind.
+func TestFlushReplacesFileAtomically(t *testing.T) {
+	// Create a stale temporary file, as left by a process that stopped while writing.
+	if err := ioutil.WriteFile(testFileName+tempExtension, []byte(`{"key1":`), 0644); err != nil {
+		t.Fatalf("Failed to create file %v", err)
+	}
+	defer os.Remove(testFileName)
+
+	kvs, err := NewJsonFileStore(testFileName)
+	if err != nil {
+		t.Fatalf("Failed to create KeyValueStore %v\n", err)
+	}
+
+	if err = kvs.Write(testKey1, &testType1{"test", 42}); err != nil {
+		t.Fatalf("Failed to write to store %v", err)
+	}
+
+	if _, err = os.Stat(testFileName + tempExtension); !os.IsNotExist(err) {
+		t.Fatalf("Temporary file was not renamed, err %v", err)
+	}
+
+	// A new store reads the complete file.
+	var value testType1
+	kvs, _ = NewJsonFileStore(testFileName)
+	if err = kvs.Read(testKey1, &value); err != nil || value != (testType1{"test", 42}) {
+		t.Fatalf("Unexpected value %+v err %v", value, err)
+	}
+}